@@ -7,15 +7,218 @@ CREATE TABLE IF NOT EXISTS users (
     deleted BOOLEAN DEFAULT false,
     "group" TEXT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    admin BOOLEAN DEFAULT false
+    admin BOOLEAN DEFAULT false,
+    totp_secret TEXT,
+    totp_enabled BOOLEAN DEFAULT false,
+    totp_last_step BIGINT,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    oidc_subject TEXT,
+    email VARCHAR(255),
+    must_change_password BOOLEAN DEFAULT false,
+    email_verified BOOLEAN DEFAULT true
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject
+ON users (oidc_subject)
+WHERE oidc_subject IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email
+ON users (lower(email))
+WHERE email IS NOT NULL;
+
 CREATE TABLE IF NOT EXISTS groups (
     group_id SERIAL PRIMARY KEY,
     name TEXT UNIQUE NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS sessions (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    ip_address VARCHAR(64),
+    user_agent TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id
+ON sessions (user_id);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at
+ON sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    code_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    purpose VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS failed_logins (
+    attempt_id SERIAL PRIMARY KEY,
+    username VARCHAR(50) NOT NULL,
+    user_id INT REFERENCES users(user_id) ON DELETE SET NULL,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    reason VARCHAR(32) NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_failed_logins_attempted_at
+ON failed_logins (attempted_at);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    token_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id
+ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    reset_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id
+ON password_resets (user_id);
+
+CREATE TABLE IF NOT EXISTS password_history (
+    history_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    password_hash VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id
+ON password_history (user_id);
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_verifications_user_id
+ON email_verifications (user_id);
+
+CREATE TABLE IF NOT EXISTS invitations (
+    invitation_id SERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255),
+    group_name TEXT,
+    equipment_ids INT[] NOT NULL DEFAULT '{}',
+    created_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    used_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Audit trail
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor_id INT,
+    actor_name VARCHAR(50) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id TEXT NOT NULL DEFAULT '',
+    target_name TEXT NOT NULL DEFAULT '',
+    before_state JSONB,
+    after_state JSONB,
+    ip_address VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at
+ON audit_log (created_at);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id
+ON audit_log (actor_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_log_append_only') THEN
+        CREATE TRIGGER audit_log_append_only
+        BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+        FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+    END IF;
+END;
+$$;
+
+-- Roles and permissions
+CREATE TABLE IF NOT EXISTS roles (
+    role_id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    role_id INT NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_roles (
+    group_id INT NOT NULL REFERENCES groups(group_id) ON DELETE CASCADE,
+    role_id INT NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, role_id)
+);
+
+-- Storage locations, from buildings down to boxes
+CREATE TABLE IF NOT EXISTS storage_locations (
+    location_id SERIAL PRIMARY KEY,
+    parent_id INT REFERENCES storage_locations(location_id) ON DELETE RESTRICT,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('building', 'room', 'freezer', 'cabinet', 'rack', 'box')),
+    name VARCHAR(100) NOT NULL,
+    box_rows INT NOT NULL DEFAULT 0,
+    box_columns INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_storage_locations_name
+ON storage_locations ((coalesce(parent_id, 0)), lower(name));
+
 -- Samples and attachments
 CREATE TABLE IF NOT EXISTS samples (
     sample_id SERIAL PRIMARY KEY,
@@ -24,9 +227,81 @@ CREATE TABLE IF NOT EXISTS samples (
     sample_prep TEXT,
     sample_keywords VARCHAR(255),
     sample_owner VARCHAR(100),
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    owner_id INT REFERENCES users(user_id) ON DELETE SET NULL,
+    visibility VARCHAR(10) NOT NULL DEFAULT 'lab' CHECK (visibility IN ('private', 'group', 'lab')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    deleted_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    deleted_by_name VARCHAR(50) NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'in-use', 'consumed', 'shipped', 'discarded')),
+    location_id INT REFERENCES storage_locations(location_id) ON DELETE SET NULL,
+    box_position VARCHAR(4),
+    search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(sample_name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(sample_keywords, '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(sample_owner, '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(sample_description, '')), 'C') ||
+        setweight(to_tsvector('simple', coalesce(sample_prep, '')), 'D')
+    ) STORED
 );
 
+CREATE INDEX IF NOT EXISTS idx_samples_search_vector
+ON samples USING GIN (search_vector);
+
+CREATE OR REPLACE FUNCTION sample_prefix_query(query TEXT) RETURNS tsquery AS $$
+    SELECT to_tsquery('simple', string_agg(
+        '''' || replace(replace(lexeme, '\', '\\'), '''', '''''') || ''':*', ' & '))
+    FROM unnest(to_tsvector('simple', query))
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS idx_samples_name
+ON samples (sample_name, sample_id);
+
+CREATE INDEX IF NOT EXISTS idx_samples_created_at
+ON samples ((coalesce(created_at, timestamp '1970-01-01')), sample_id);
+
+CREATE INDEX IF NOT EXISTS idx_samples_owner_id
+ON samples (owner_id);
+
+CREATE INDEX IF NOT EXISTS idx_samples_deleted_at
+ON samples (deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_samples_location_id
+ON samples (location_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_samples_box_position
+ON samples (location_id, box_position) WHERE box_position IS NOT NULL AND deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS sample_revisions (
+    revision_id SERIAL PRIMARY KEY,
+    sample_id INT NOT NULL REFERENCES samples(sample_id) ON DELETE CASCADE,
+    revision_number INT NOT NULL,
+    sample_name VARCHAR(100) NOT NULL,
+    sample_description TEXT NOT NULL DEFAULT '',
+    sample_keywords VARCHAR(255) NOT NULL DEFAULT '',
+    sample_owner VARCHAR(100) NOT NULL DEFAULT '',
+    sample_prep TEXT NOT NULL DEFAULT '',
+    changed_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    changed_by_name VARCHAR(50) NOT NULL DEFAULT '',
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    restored_from INT,
+    UNIQUE (sample_id, revision_number)
+);
+
+CREATE TABLE IF NOT EXISTS sample_relations (
+    parent_id INT NOT NULL REFERENCES samples(sample_id) ON DELETE CASCADE,
+    child_id INT NOT NULL REFERENCES samples(sample_id) ON DELETE CASCADE,
+    relation VARCHAR(20) NOT NULL CHECK (relation IN ('derived-from', 'split-from', 'piece-of')),
+    created_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (parent_id, child_id),
+    CHECK (parent_id <> child_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sample_relations_child_id
+ON sample_relations (child_id);
+
 CREATE TABLE IF NOT EXISTS attachments (
     attachment_id SERIAL PRIMARY KEY,
     sample_id INT REFERENCES samples(sample_id) ON DELETE CASCADE,
@@ -99,3 +374,27 @@ FROM users
 WHERE "group" IS NOT NULL
   AND btrim("group") <> ''
 ON CONFLICT (name) DO NOTHING;
+
+WITH builtin (name, description, permissions) AS (
+    VALUES
+        ('viewer', 'Read-only access to samples, the wiki and the booking calendar',
+            ARRAY[]::TEXT[]),
+        ('member', 'Register and edit samples, write wiki articles, book permitted equipment',
+            ARRAY['samples.create', 'samples.edit', 'samples.delete.own', 'wiki.edit', 'wiki.delete.own', 'bookings.create']),
+        ('equipment_manager', 'Member rights plus managing equipment, equipment access and all bookings',
+            ARRAY['samples.create', 'samples.edit', 'samples.delete.own', 'wiki.edit', 'wiki.delete.own', 'bookings.create',
+                  'bookings.manage', 'equipment.manage', 'locations.manage']),
+        ('admin', 'Full access to every feature and the admin panel',
+            ARRAY['samples.create', 'samples.edit', 'samples.manage', 'samples.delete', 'samples.purge',
+                  'wiki.edit', 'wiki.delete', 'bookings.create', 'bookings.manage', 'equipment.manage',
+                  'locations.manage', 'users.manage', 'roles.manage'])
+), inserted AS (
+    INSERT INTO roles (name, description)
+    SELECT name, description FROM builtin
+    ON CONFLICT (name) DO NOTHING
+    RETURNING role_id, name
+)
+INSERT INTO role_permissions (role_id, permission)
+SELECT inserted.role_id, unnest(builtin.permissions)
+FROM inserted
+JOIN builtin ON builtin.name = inserted.name;
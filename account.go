@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/audit"
+	"sampleDB/internal/auth"
+	"sampleDB/internal/mail"
+)
+
+type APITokensPageData struct {
+	BasePageData
+	Tokens   []auth.APIToken
+	Scopes   []auth.APIScope
+	NewToken string
+	Now      time.Time
+	Error    string
+	Success  string
+}
+
+type SessionsPageData struct {
+	BasePageData
+	Sessions  []auth.Session
+	CurrentID string
+	Error     string
+	Success   string
+}
+
+type TwoFactorPageData struct {
+	BasePageData
+	Enabled       bool
+	Required      bool
+	Secret        string
+	QRCode        template.URL
+	RecoveryCodes []string
+	Error         string
+	Success       string
+}
+
+// handleTwoFactorSettings lets users enrol, disable, or refresh recovery codes
+// for TOTP two-factor authentication.
+func handleTwoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	session := auth.MustSessionFromContext(r.Context())
+
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	data := TwoFactorPageData{BasePageData: baseData}
+
+	data.Required, err = authManagerInstance.TwoFactorRequired(r.Context(), session.UserID)
+	if err != nil {
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			data.Error = "Invalid form submission"
+			break
+		}
+
+		code := r.FormValue("code")
+		switch r.FormValue("action") {
+		case "enable":
+			codes, err := authManagerInstance.ConfirmTOTPEnrollment(r.Context(), session.UserID, code)
+			switch {
+			case errors.Is(err, auth.ErrInvalidTOTPCode):
+				data.Error = "That code did not match. Check the time on your device and try again."
+			case err != nil:
+				log.Printf("account: unable to enable 2FA for user %d: %v", session.UserID, err)
+				data.Error = "Unable to enable two-factor authentication"
+			default:
+				data.RecoveryCodes = codes
+				data.Success = "Two-factor authentication enabled"
+				recordAudit(r, audit.Entry{
+					Action:     audit.ActionTwoFactorOn,
+					TargetType: audit.TargetUser,
+					TargetID:   strconv.Itoa(session.UserID),
+					TargetName: session.Username,
+				})
+			}
+		case "disable", "recovery":
+			ok, err := authManagerInstance.VerifySecondFactor(r.Context(), session.UserID, code)
+			if err != nil {
+				log.Printf("account: unable to verify 2FA for user %d: %v", session.UserID, err)
+				data.Error = "Unable to verify code"
+				break
+			}
+			if !ok {
+				data.Error = "Invalid verification code"
+				break
+			}
+
+			if r.FormValue("action") == "recovery" {
+				codes, err := authManagerInstance.RegenerateRecoveryCodes(r.Context(), session.UserID)
+				if err != nil {
+					data.Error = "Unable to generate new recovery codes"
+					break
+				}
+				data.RecoveryCodes = codes
+				data.Success = "New recovery codes generated. Previous codes no longer work."
+				break
+			}
+
+			if data.Required {
+				data.Error = "Administrators are required to keep two-factor authentication enabled"
+				break
+			}
+			if err := authManagerInstance.DisableTOTP(r.Context(), session.UserID); err != nil {
+				data.Error = "Unable to disable two-factor authentication"
+				break
+			}
+			data.Success = "Two-factor authentication disabled"
+			recordAudit(r, audit.Entry{
+				Action:     audit.ActionTwoFactorOff,
+				TargetType: audit.TargetUser,
+				TargetID:   strconv.Itoa(session.UserID),
+				TargetName: session.Username,
+			})
+		default:
+			data.Error = "Unknown action"
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data.Enabled, err = authManagerInstance.TwoFactorEnabled(r.Context(), session.UserID)
+	if err != nil {
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	if !data.Enabled {
+		data.Secret, err = authManagerInstance.BeginTOTPEnrollment(r.Context(), session.UserID)
+		if err != nil {
+			http.Error(w, "Unable to start two-factor enrolment", http.StatusInternalServerError)
+			return
+		}
+		data.QRCode, err = auth.QRCodeDataURI(auth.TOTPURI("Sample Tracker", session.Username, data.Secret))
+		if err != nil {
+			http.Error(w, "Unable to render QR code", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if data.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	tmpl, err := parseTemplates(r, "templates/two_factor.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, "Template execution error", http.StatusInternalServerError)
+	}
+}
+
+// apiTokenLifetimes are the expiry choices offered when creating a token, in
+// days. Zero means the token never expires.
+var apiTokenLifetimes = map[string]int{"30": 30, "90": 90, "365": 365, "never": 0}
+
+// handleAPITokens lets users create and revoke their personal API tokens.
+func handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	session := auth.MustSessionFromContext(r.Context())
+
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	data := APITokensPageData{
+		BasePageData: baseData,
+		Scopes:       auth.APIScopes,
+		Now:          time.Now(),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			data.Error = "Invalid form submission"
+			break
+		}
+
+		switch r.FormValue("action") {
+		case "create":
+			name := strings.TrimSpace(r.FormValue("name"))
+			scopes := r.Form["scopes"]
+			days, ok := apiTokenLifetimes[r.FormValue("expires_in")]
+			switch {
+			case name == "" || len(name) > 100:
+				data.Error = "Token name must be between 1 and 100 characters"
+			case len(scopes) == 0:
+				data.Error = "Select at least one scope"
+			case !ok:
+				data.Error = "Invalid expiry"
+			}
+			if data.Error != "" {
+				break
+			}
+
+			var expiresAt *time.Time
+			if days > 0 {
+				t := time.Now().AddDate(0, 0, days)
+				expiresAt = &t
+			}
+
+			token, err := authManagerInstance.CreateAPIToken(r.Context(), session.UserID, name, scopes, expiresAt)
+			if err != nil {
+				if errors.Is(err, auth.ErrUnknownScope) {
+					data.Error = "Unknown scope selected"
+					break
+				}
+				log.Printf("account: unable to create api token for user %d: %v", session.UserID, err)
+				data.Error = "Unable to create token"
+				break
+			}
+			data.NewToken = token
+			data.Success = "Token created"
+			recordAudit(r, audit.Entry{
+				Action:     audit.ActionTokenCreate,
+				TargetType: audit.TargetToken,
+				TargetName: name,
+				After:      audit.State(map[string]interface{}{"scopes": scopes, "expires_at": expiresAt}),
+			})
+		case "revoke":
+			tokenID, err := strconv.Atoi(r.FormValue("token_id"))
+			if err != nil {
+				data.Error = "Invalid token"
+				break
+			}
+			if err := authManagerInstance.RevokeAPIToken(r.Context(), tokenID, session.UserID); err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					data.Error = "Token not found"
+					break
+				}
+				data.Error = "Unable to revoke token"
+				break
+			}
+			data.Success = "Token revoked"
+			recordAudit(r, audit.Entry{
+				Action:     audit.ActionTokenRevoke,
+				TargetType: audit.TargetToken,
+				TargetID:   strconv.Itoa(tokenID),
+			})
+		default:
+			data.Error = "Unknown action"
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data.Tokens, err = authManagerInstance.ListAPITokens(r.Context(), session.UserID)
+	if err != nil {
+		http.Error(w, "Unable to load tokens", http.StatusInternalServerError)
+		return
+	}
+
+	if data.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	tmpl, err := parseTemplates(r, "templates/api_tokens.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, "Template execution error", http.StatusInternalServerError)
+	}
+}
+
+// handleSessions lists the user's signed-in browsers and lets them sign out
+// of any of them.
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	session := auth.MustSessionFromContext(r.Context())
+
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	data := SessionsPageData{
+		BasePageData: baseData,
+		CurrentID:    session.ID,
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			data.Error = "Invalid form submission"
+			break
+		}
+
+		switch r.FormValue("action") {
+		case "revoke":
+			id := r.FormValue("session_id")
+			if id == "" || id == session.ID {
+				data.Error = "Use Sign out to end the session you are using"
+				break
+			}
+			ok, err := authManagerInstance.RevokeSession(r.Context(), id, session.UserID)
+			if err != nil {
+				log.Printf("account: unable to revoke session for user %d: %v", session.UserID, err)
+				data.Error = "Unable to revoke session"
+				break
+			}
+			if !ok {
+				data.Error = "Session not found"
+				break
+			}
+			data.Success = "Session revoked"
+			recordAudit(r, audit.Entry{
+				Action:     audit.ActionSessionRevoke,
+				TargetType: audit.TargetSession,
+				TargetID:   id,
+			})
+		case "revoke_others":
+			count, err := authManagerInstance.RevokeOtherSessions(r.Context(), session)
+			if err != nil {
+				log.Printf("account: unable to revoke sessions for user %d: %v", session.UserID, err)
+				data.Error = "Unable to revoke sessions"
+				break
+			}
+			data.Success = fmt.Sprintf("Signed out of %d other session(s)", count)
+			recordAudit(r, audit.Entry{
+				Action:     audit.ActionSessionRevoke,
+				TargetType: audit.TargetSession,
+				TargetName: "all other sessions",
+				After:      audit.State(map[string]int{"revoked": count}),
+			})
+		default:
+			data.Error = "Unknown action"
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data.Sessions, err = authManagerInstance.ListSessions(r.Context(), session.UserID)
+	if err != nil {
+		http.Error(w, "Unable to load sessions", http.StatusInternalServerError)
+		return
+	}
+
+	if data.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	tmpl, err := parseTemplates(r, "templates/sessions.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, "Template execution error", http.StatusInternalServerError)
+	}
+}
+
+// handleAccountEmail stores the address used for password reset emails.
+// Submitting an empty address removes it.
+func handleAccountEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.MustSessionFromContext(r.Context())
+
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	data := ChangePasswordPageData{
+		BasePageData: baseData,
+		MinLength:    authManagerInstance.PasswordPolicy().MinLength,
+	}
+	data.Email, err = loadAccountEmail(session.UserID)
+	if err != nil {
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	var email *string
+	if raw := strings.TrimSpace(r.FormValue("email")); raw != "" {
+		normalized, err := mail.NormalizeAddress(raw)
+		if err != nil {
+			data.Error = "Enter a valid email address"
+			w.WriteHeader(http.StatusBadRequest)
+			renderChangePasswordTemplate(w, r, data)
+			return
+		}
+
+		var taken bool
+		err = dbPool.QueryRow(r.Context(),
+			"SELECT EXISTS(SELECT 1 FROM users WHERE lower(email) = lower($1) AND user_id <> $2)",
+			normalized, session.UserID).Scan(&taken)
+		if err != nil {
+			http.Error(w, "Unable to update email address", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			data.Error = "That email address is already used by another account"
+			w.WriteHeader(http.StatusBadRequest)
+			renderChangePasswordTemplate(w, r, data)
+			return
+		}
+		email = &normalized
+	}
+
+	if _, err := dbPool.Exec(r.Context(),
+		"UPDATE users SET email = $1 WHERE user_id = $2",
+		email, session.UserID); err != nil {
+		log.Printf("account: unable to update email for user %d: %v", session.UserID, err)
+		http.Error(w, "Unable to update email address", http.StatusInternalServerError)
+		return
+	}
+
+	after := ""
+	if email != nil {
+		after = *email
+	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionEmailChange,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.Itoa(session.UserID),
+		TargetName: session.Username,
+		Before:     audit.State(map[string]string{"email": data.Email}),
+		After:      audit.State(map[string]string{"email": after}),
+	})
+
+	data.Email = ""
+	data.Success = "Email address removed"
+	if email != nil {
+		data.Email = *email
+		data.Success = "Email address updated"
+	}
+	renderChangePasswordTemplate(w, r, data)
+}
+
+func loadAccountEmail(userID int) (string, error) {
+	var email string
+	err := dbPool.QueryRow(context.Background(),
+		"SELECT COALESCE(email, '') FROM users WHERE user_id = $1",
+		userID).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return email, err
+}
@@ -6,16 +6,21 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"math/big"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jackc/pgx/v5"
 
+	"sampleDB/internal/audit"
 	"sampleDB/internal/auth"
+	"sampleDB/internal/mail"
+	"sampleDB/internal/rbac"
 )
 
 type UserAccess struct {
@@ -24,39 +29,114 @@ type UserAccess struct {
 	Approved        bool        `json:"approved"`
 	Admin           bool        `json:"admin"`
 	GroupName       string      `json:"group_name"`
+	TwoFactor       bool        `json:"two_factor"`
+	Locked          bool        `json:"locked"`
 	CreatedAt       string      `json:"created_at"`
 	EquipmentAccess []Equipment `json:"equipment_access"`
+	RoleIDs         []int       `json:"role_ids"`
 }
 
 type Group struct {
-	ID   int
-	Name string
+	ID      int
+	Name    string
+	RoleIDs []int
+}
+
+type FailedLogin struct {
+	Username    string
+	IPAddress   string
+	Reason      string
+	AttemptedAt time.Time
 }
 
 type AdminPageData struct {
 	BasePageData
-	Users     []UserAccess
-	Equipment []Equipment
-	Groups    []Group
-	Error     string
-	Success   string
+	Users        []UserAccess
+	Equipment    []Equipment
+	Groups       []Group
+	FailedLogins []FailedLogin
+	APITokens    []auth.APIToken
+	Sessions     []auth.Session
+	Invitations  []auth.Invitation
+	MailEnabled  bool
+	Roles        []rbac.Role
+	Permissions  []rbac.PermissionInfo
+	Now          time.Time
+	Error        string
+	Success      string
 }
 
+// getBasePageData loads the current user's grants. It returns pgx.ErrNoRows
+// when the account no longer exists or has been deleted.
 func getBasePageData(session auth.Session) (BasePageData, error) {
-	var isAdmin bool
-	err := dbPool.QueryRow(context.Background(),
-		"SELECT admin FROM users WHERE user_id = $1 AND COALESCE(deleted, false) = false",
-		session.UserID).Scan(&isAdmin)
+	grants, err := authorizer.Grants(context.Background(), session.UserID)
 	if err != nil {
 		return BasePageData{}, err
 	}
+	if len(grants.Roles) == 0 {
+		return BasePageData{}, pgx.ErrNoRows
+	}
 
 	return BasePageData{
 		Username: session.Username,
 		UserID:   session.UserID,
-		IsAdmin:  isAdmin,
+		IsAdmin:  canOpenAdminPanel(grants),
+		grants:   grants,
 	}, nil
 }
+
+// adminPanelPermissions lists the permissions that each unlock a part of the
+// admin panel.
+var adminPanelPermissions = []rbac.Permission{rbac.UsersManage, rbac.EquipmentManage, rbac.RolesManage}
+
+func canOpenAdminPanel(grants rbac.Grants) bool {
+	for _, perm := range adminPanelPermissions {
+		if grants.Has(perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// can reports whether the request's user may perform perm on res.
+func can(r *http.Request, perm rbac.Permission, res rbac.Resource) bool {
+	return authorizer.Can(auth.MustSessionFromContext(r.Context()), perm, res)
+}
+
+// forbidden rejects a request the user is not allowed to make. Page loads are
+// sent back to the start page, everything else gets a 403.
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && !isHTMXRequest(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "You do not have permission to do that", http.StatusForbidden)
+}
+
+// requirePermission only lets requests through when the user holds perm.
+func requirePermission(perm rbac.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !can(r, perm, rbac.Resource{}) {
+			forbidden(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdminPanel lets through users who manage at least one admin panel
+// section. Handlers behind it check the specific permission themselves.
+func requireAdminPanel(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := auth.MustSessionFromContext(r.Context())
+		grants, err := authorizer.Grants(r.Context(), session.UserID)
+		if err != nil || !canOpenAdminPanel(grants) {
+			forbidden(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
 func handleAdminPage(w http.ResponseWriter, r *http.Request) {
 	session := auth.MustSessionFromContext(r.Context())
 
@@ -85,6 +165,8 @@ func handleAdminPage(w http.ResponseWriter, r *http.Request) {
             u.is_approved, 
             u.admin,
             btrim(u."group") AS group_name,
+            COALESCE(u.totp_enabled, false) AS two_factor,
+            COALESCE(u.locked_until > now(), false) AS locked,
             COALESCE(
                 array_agg(uep.equipment_id) FILTER (WHERE uep.equipment_id IS NOT NULL),
                 '{}'::int[]
@@ -92,7 +174,8 @@ func handleAdminPage(w http.ResponseWriter, r *http.Request) {
         FROM users u
         LEFT JOIN user_equipment_permissions uep ON u.user_id = uep.user_id
         WHERE COALESCE(u.deleted, false) = false
-        GROUP BY u.user_id, u.username, u.is_approved, u.admin, group_name
+          AND COALESCE(u.email_verified, true)
+        GROUP BY u.user_id, u.username, u.is_approved, u.admin, group_name, two_factor, locked
         ORDER BY u.username`)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -109,7 +192,7 @@ func handleAdminPage(w http.ResponseWriter, r *http.Request) {
 		)
 
 		err := rows.Scan(&u.UserID, &u.Username, &u.Approved,
-			&u.Admin, &groupName, &equipmentIDs)
+			&u.Admin, &groupName, &u.TwoFactor, &u.Locked, &equipmentIDs)
 		if err != nil {
 			fmt.Printf("error scanning user row: %v\n", err)
 			continue
@@ -133,19 +216,78 @@ func handleAdminPage(w http.ResponseWriter, r *http.Request) {
 		users = append(users, u)
 	}
 
+	failedLogins, err := getRecentFailedLogins(25)
+	if err != nil {
+		http.Error(w, "Error getting failed logins", http.StatusInternalServerError)
+		return
+	}
+
+	apiTokens, err := authManagerInstance.ListAPITokens(r.Context(), 0)
+	if err != nil {
+		http.Error(w, "Error getting API tokens", http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := authManagerInstance.ListSessions(r.Context(), 0)
+	if err != nil {
+		http.Error(w, "Error getting sessions", http.StatusInternalServerError)
+		return
+	}
+
+	invitations, err := authManagerInstance.ListInvitations(r.Context())
+	if err != nil {
+		http.Error(w, "Error getting invitations", http.StatusInternalServerError)
+		return
+	}
+
+	roles, err := authorizer.ListRoles(r.Context())
+	if err != nil {
+		http.Error(w, "Error getting roles", http.StatusInternalServerError)
+		return
+	}
+	userRoles, err := authorizer.UserRoleIDs(r.Context())
+	if err != nil {
+		http.Error(w, "Error getting user roles", http.StatusInternalServerError)
+		return
+	}
+	groupRoles, err := authorizer.GroupRoleIDs(r.Context())
+	if err != nil {
+		http.Error(w, "Error getting group roles", http.StatusInternalServerError)
+		return
+	}
+	for i := range users {
+		users[i].RoleIDs = userRoles[users[i].UserID]
+	}
+	for i := range groups {
+		groups[i].RoleIDs = groupRoles[groups[i].ID]
+	}
+
 	data := AdminPageData{
 		BasePageData: baseData,
 		Users:        users,
 		Equipment:    equipment,
 		Groups:       groups,
+		FailedLogins: failedLogins,
+		APITokens:    apiTokens,
+		Sessions:     sessions,
+		Invitations:  invitations,
+		MailEnabled:  authManagerInstance.MailEnabled(),
+		Roles:        roles,
+		Permissions:  rbac.Permissions,
+		Now:          time.Now(),
 		Error:        r.URL.Query().Get("error"),
 		Success:      r.URL.Query().Get("success"),
 	}
 
-	tmpl, err := parseTemplates(
+	tmpl, err := parseTemplates(r,
 		"templates/admin.html",
 		"templates/admin/groups.html",
 		"templates/admin/users.html",
+		"templates/admin/failed_logins.html",
+		"templates/admin/tokens.html",
+		"templates/admin/sessions.html",
+		"templates/admin/invitations.html",
+		"templates/admin/roles.html",
 	)
 	if err != nil {
 		http.Error(w, "Template error", http.StatusInternalServerError)
@@ -176,6 +318,8 @@ func handleUpdateAccess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// fmt.Println(data)
+	before := snapshotUser(r.Context(), data.UserID)
+
 	// Start a transaction
 	tx, err := dbPool.Begin(context.Background())
 	if err != nil {
@@ -185,41 +329,47 @@ func handleUpdateAccess(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback(context.Background())
 
-	var groupParam interface{}
-	if data.GroupName != nil {
-		name := strings.TrimSpace(*data.GroupName)
-		if name != "" {
-			groupParam = name
+	// Account fields and equipment access belong to different roles; apply
+	// only the parts the caller is allowed to change.
+	if can(r, rbac.UsersManage, rbac.Resource{}) {
+		var groupParam interface{}
+		if data.GroupName != nil {
+			name := strings.TrimSpace(*data.GroupName)
+			if name != "" {
+				groupParam = name
+			}
 		}
-	}
 
-	_, err = tx.Exec(context.Background(),
-		`UPDATE users SET is_approved = $1, "group" = $2 WHERE user_id = $3 AND COALESCE(deleted, false) = false`,
-		data.Approved, groupParam, data.UserID)
-	if err != nil {
-		fmt.Printf("error updating approval status: %v\n", err)
-		http.Error(w, "Error updating approval status", http.StatusInternalServerError)
-		return
-	}
-
-	// Remove all existing equipment permissions
-	_, err = tx.Exec(context.Background(),
-		"DELETE FROM user_equipment_permissions WHERE user_id = $1",
-		data.UserID)
-	if err != nil {
-		fmt.Printf("error deleting user permissions: %v\n", err)
-		http.Error(w, "Error updating equipment permissions", http.StatusInternalServerError)
-		return
+		_, err = tx.Exec(context.Background(),
+			`UPDATE users SET is_approved = $1, "group" = $2 WHERE user_id = $3 AND COALESCE(deleted, false) = false`,
+			data.Approved, groupParam, data.UserID)
+		if err != nil {
+			fmt.Printf("error updating approval status: %v\n", err)
+			http.Error(w, "Error updating approval status", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Add new equipment permissions
-	for _, equipID := range data.Equipment {
+	if can(r, rbac.EquipmentManage, rbac.Resource{}) {
+		// Remove all existing equipment permissions
 		_, err = tx.Exec(context.Background(),
-			"INSERT INTO user_equipment_permissions (user_id, equipment_id) VALUES ($1, $2)",
-			data.UserID, equipID)
+			"DELETE FROM user_equipment_permissions WHERE user_id = $1",
+			data.UserID)
 		if err != nil {
-			fmt.Printf("error inserting permission: %v\n", err)
-			continue
+			fmt.Printf("error deleting user permissions: %v\n", err)
+			http.Error(w, "Error updating equipment permissions", http.StatusInternalServerError)
+			return
+		}
+
+		// Add new equipment permissions
+		for _, equipID := range data.Equipment {
+			_, err = tx.Exec(context.Background(),
+				"INSERT INTO user_equipment_permissions (user_id, equipment_id) VALUES ($1, $2)",
+				data.UserID, equipID)
+			if err != nil {
+				fmt.Printf("error inserting permission: %v\n", err)
+				continue
+			}
 		}
 	}
 
@@ -229,6 +379,7 @@ func handleUpdateAccess(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error committing changes", http.StatusInternalServerError)
 		return
 	}
+	recordUserChange(r, audit.ActionUserAccess, data.UserID, before, snapshotUser(r.Context(), data.UserID))
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -253,23 +404,6 @@ func getAllGroups() ([]Group, error) {
 	}
 	return groups, nil
 }
-func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		session := auth.MustSessionFromContext(r.Context())
-
-		var isAdmin bool
-		err := dbPool.QueryRow(context.Background(),
-			"SELECT admin FROM users WHERE user_id = $1 AND COALESCE(deleted, false) = false",
-			session.UserID).Scan(&isAdmin)
-
-		if err != nil || !isAdmin {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		}
-
-		next(w, r)
-	}
-}
 
 // Handler for setting admin status
 func handleSetAdmin(w http.ResponseWriter, r *http.Request) {
@@ -278,10 +412,15 @@ func handleSetAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.FormValue("user_id")
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
 	isAdmin := r.FormValue("is_admin") == "true"
+	before := snapshotUser(r.Context(), userID)
 
-	_, err := dbPool.Exec(context.Background(),
+	_, err = dbPool.Exec(context.Background(),
 		"UPDATE users SET admin = $1 WHERE user_id = $2 AND COALESCE(deleted, false) = false",
 		isAdmin, userID)
 
@@ -289,6 +428,7 @@ func handleSetAdmin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	recordUserChange(r, audit.ActionUserAdmin, userID, before, snapshotUser(r.Context(), userID))
 
 	http.Redirect(w, r, "/admin?success=Admin+status+updated", http.StatusSeeOther)
 }
@@ -335,6 +475,7 @@ func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "You cannot remove your own account", http.StatusBadRequest)
 		return
 	}
+	before := snapshotUser(r.Context(), userID)
 
 	cmdTag, err := dbPool.Exec(context.Background(),
 		`UPDATE users
@@ -356,6 +497,7 @@ func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	if authManagerInstance != nil {
 		authManagerInstance.RevokeUserSessions(userID)
 	}
+	recordUserChange(r, audit.ActionUserDelete, userID, before, nil)
 
 	if strings.Contains(contentType, "application/json") {
 		w.Header().Set("Content-Type", "application/json")
@@ -392,34 +534,412 @@ func handleResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := authManagerInstance.SetTemporaryPassword(r.Context(), payload.UserID, password); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if authManagerInstance != nil {
+		authManagerInstance.RevokeUserSessions(payload.UserID)
+	}
+	// The temporary password itself is never logged.
+	recordUserChange(r, audit.ActionUserPasswordReset, payload.UserID, nil, snapshotUser(r.Context(), payload.UserID))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"password": password})
+}
+
+func handleResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.UserID <= 0 {
+		http.Error(w, "User id required", http.StatusBadRequest)
+		return
+	}
+
+	if err := authManagerInstance.DisableTOTP(r.Context(), payload.UserID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	authManagerInstance.RevokeUserSessions(payload.UserID)
+	recordUserChange(r, audit.ActionUserTwoFactorOff, payload.UserID, nil, snapshotUser(r.Context(), payload.UserID))
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+func handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.UserID <= 0 {
+		http.Error(w, "User id required", http.StatusBadRequest)
+		return
+	}
+
+	if err := authManagerInstance.UnlockAccount(r.Context(), payload.UserID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	recordUserChange(r, audit.ActionUserUnlock, payload.UserID, nil, snapshotUser(r.Context(), payload.UserID))
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+func handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		TokenID int `json:"token_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.TokenID <= 0 {
+		http.Error(w, "Token id required", http.StatusBadRequest)
+		return
+	}
+
+	if err := authManagerInstance.RevokeAPIToken(r.Context(), payload.TokenID, 0); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionTokenRevokeAdmin,
+		TargetType: audit.TargetToken,
+		TargetID:   strconv.Itoa(payload.TokenID),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+// inviteLifetimes are the invitation validity periods offered in the admin
+// panel, in days.
+var inviteLifetimes = map[int]bool{1: true, 7: true, 30: true}
+
+// handleCreateInvite issues a registration link, optionally emailing it.
+func handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		Email       string `json:"email"`
+		GroupName   string `json:"group_name"`
+		Equipment   []int  `json:"equipment"`
+		ExpiresDays int    `json:"expires_days"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.ExpiresDays == 0 {
+		payload.ExpiresDays = int(auth.DefaultInvitationTTL.Hours() / 24)
+	}
+	if !inviteLifetimes[payload.ExpiresDays] {
+		http.Error(w, "Invalid expiry", http.StatusBadRequest)
+		return
+	}
+
+	inv := auth.Invitation{
+		GroupName: strings.TrimSpace(payload.GroupName),
+		ExpiresAt: time.Now().AddDate(0, 0, payload.ExpiresDays),
+	}
+	if raw := strings.TrimSpace(payload.Email); raw != "" {
+		email, err := mail.NormalizeAddress(raw)
+		if err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		inv.Email = email
+	}
+	if inv.GroupName != "" {
+		var exists bool
+		if err := dbPool.QueryRow(r.Context(),
+			"SELECT EXISTS(SELECT 1 FROM groups WHERE name = $1)",
+			inv.GroupName).Scan(&exists); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Unknown group", http.StatusBadRequest)
+			return
+		}
+	}
+	// Equipment access is granted by equipment managers only.
+	if can(r, rbac.EquipmentManage, rbac.Resource{}) {
+		inv.EquipmentIDs = payload.Equipment
+	}
+
+	session := auth.MustSessionFromContext(r.Context())
+	link, err := authManagerInstance.CreateInvitation(r.Context(), session.UserID, inv)
 	if err != nil {
-		http.Error(w, "Failed to secure password", http.StatusInternalServerError)
+		log.Printf("admin: unable to create invitation: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	cmdTag, err := dbPool.Exec(context.Background(),
-		`UPDATE users
-         SET password_hash = $1
-         WHERE user_id = $2
-           AND COALESCE(deleted, false) = false`,
-		string(hashed), payload.UserID)
+	emailed := false
+	if inv.Email != "" && authManagerInstance.MailEnabled() {
+		if err := authManagerInstance.SendInvitation(r.Context(), inv, link, session.Username); err != nil {
+			log.Printf("admin: unable to email invitation: %v", err)
+		} else {
+			emailed = true
+		}
+	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionInviteCreate,
+		TargetType: audit.TargetInvitation,
+		TargetName: inv.Email,
+		After: audit.State(map[string]interface{}{
+			"email":      inv.Email,
+			"group":      inv.GroupName,
+			"equipment":  inv.EquipmentIDs,
+			"expires_at": inv.ExpiresAt,
+			"emailed":    emailed,
+		}),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"link":    link,
+		"emailed": emailed,
+	})
+}
+
+func handleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		InvitationID int `json:"invitation_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.InvitationID <= 0 {
+		http.Error(w, "Invitation id required", http.StatusBadRequest)
+		return
+	}
+
+	if err := authManagerInstance.RevokeInvitation(r.Context(), payload.InvitationID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionInviteRevoke,
+		TargetType: audit.TargetInvitation,
+		TargetID:   strconv.Itoa(payload.InvitationID),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+// handleRevokeSession signs a user out of one browser session.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		SessionID string `json:"session_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.SessionID == "" {
+		http.Error(w, "Session id required", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := authManagerInstance.RevokeSession(r.Context(), payload.SessionID, 0)
 	if err != nil {
+		log.Printf("admin: unable to revoke session: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionSessionRevokeAny,
+		TargetType: audit.TargetSession,
+		TargetID:   payload.SessionID,
+	})
 
-	if cmdTag.RowsAffected() == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+func handleSetUserRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if authManagerInstance != nil {
-		authManagerInstance.RevokeUserSessions(payload.UserID)
+	var payload struct {
+		UserID  int   `json:"user_id"`
+		RoleIDs []int `json:"role_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.UserID <= 0 {
+		http.Error(w, "User id required", http.StatusBadRequest)
+		return
+	}
+
+	err := authorizer.SetUserRoles(r.Context(), payload.UserID, payload.RoleIDs)
+	if err == nil {
+		recordAudit(r, audit.Entry{
+			Action:     audit.ActionUserRoles,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.Itoa(payload.UserID),
+			After:      audit.State(map[string]interface{}{"role_ids": payload.RoleIDs}),
+		})
+	}
+	writeRoleAssignmentResult(w, err)
+}
+
+func handleSetGroupRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		GroupID int   `json:"group_id"`
+		RoleIDs []int `json:"role_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if payload.GroupID <= 0 {
+		http.Error(w, "Group id required", http.StatusBadRequest)
+		return
+	}
+
+	err := authorizer.SetGroupRoles(r.Context(), payload.GroupID, payload.RoleIDs)
+	if err == nil {
+		recordAudit(r, audit.Entry{
+			Action:     audit.ActionGroupRoles,
+			TargetType: audit.TargetGroup,
+			TargetID:   strconv.Itoa(payload.GroupID),
+			After:      audit.State(map[string]interface{}{"role_ids": payload.RoleIDs}),
+		})
+	}
+	writeRoleAssignmentResult(w, err)
+}
+
+func writeRoleAssignmentResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		if errors.Is(err, rbac.ErrUnknownRole) {
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+		log.Printf("admin: unable to update role assignment: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{"password": password})
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+func getRecentFailedLogins(limit int) ([]FailedLogin, error) {
+	rows, err := dbPool.Query(context.Background(), `
+        SELECT username, COALESCE(ip_address, ''), reason, attempted_at
+        FROM failed_logins
+        ORDER BY attempted_at DESC
+        LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []FailedLogin
+	for rows.Next() {
+		var a FailedLogin
+		if err := rows.Scan(&a.Username, &a.IPAddress, &a.Reason, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		a.AttemptedAt = a.AttemptedAt.In(loc)
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
 }
 
 func generateNumericPassword(length int) (string, error) {
@@ -455,14 +975,21 @@ func handleAddEquipment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert new equipment
-	_, err := dbPool.Exec(context.Background(),
-		"INSERT INTO equipment (name) VALUES ($1)",
-		equipmentName)
+	var equipmentID int
+	err := dbPool.QueryRow(context.Background(),
+		"INSERT INTO equipment (name) VALUES ($1) RETURNING equipment_id",
+		equipmentName).Scan(&equipmentID)
 
 	if err != nil {
 		http.Redirect(w, r, "/admin?error=Failed+to+add+equipment", http.StatusSeeOther)
 		return
 	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionEquipmentAdd,
+		TargetType: audit.TargetEquipment,
+		TargetID:   strconv.Itoa(equipmentID),
+		TargetName: equipmentName,
+	})
 
 	http.Redirect(w, r, "/admin?success=Equipment+added+successfully", http.StatusSeeOther)
 }
@@ -486,6 +1013,18 @@ func handleDeleteEquipment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var equipmentName string
+	if err := dbPool.QueryRow(context.Background(),
+		"SELECT name FROM equipment WHERE equipment_id = $1",
+		equipmentID).Scan(&equipmentName); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Equipment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
 	// Start a transaction
 	tx, err := dbPool.Begin(context.Background())
 	if err != nil {
@@ -526,6 +1065,12 @@ func handleDeleteEquipment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionEquipmentDelete,
+		TargetType: audit.TargetEquipment,
+		TargetID:   strconv.Itoa(equipmentID),
+		TargetName: equipmentName,
+	})
 
 	// Return success
 	w.WriteHeader(http.StatusOK)
@@ -543,13 +1088,20 @@ func handleAddGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := dbPool.Exec(context.Background(),
+	tag, err := dbPool.Exec(context.Background(),
 		"INSERT INTO groups (name) VALUES ($1) ON CONFLICT (name) DO NOTHING",
 		name)
 	if err != nil {
 		http.Redirect(w, r, "/admin?error=Failed+to+add+group", http.StatusSeeOther)
 		return
 	}
+	if tag.RowsAffected() > 0 {
+		recordAudit(r, audit.Entry{
+			Action:     audit.ActionGroupAdd,
+			TargetType: audit.TargetGroup,
+			TargetName: name,
+		})
+	}
 
 	http.Redirect(w, r, "/admin?success=Group+added", http.StatusSeeOther)
 }
@@ -591,6 +1143,12 @@ func handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/admin?error=Failed+to+delete+group", http.StatusSeeOther)
 		return
 	}
+	recordAudit(r, audit.Entry{
+		Action:     audit.ActionGroupDelete,
+		TargetType: audit.TargetGroup,
+		TargetID:   groupID,
+		TargetName: groupName,
+	})
 
 	http.Redirect(w, r, "/admin?success=Group+removed", http.StatusSeeOther)
 }
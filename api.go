@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/rbac"
+)
+
+// handleAPISampleAttachments accepts multipart uploads at
+// /api/samples/{id}/attachments from scripts and instrument PCs. The file is
+// expected in the "file" field.
+func handleAPISampleAttachments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/samples/"), "/"), "/")
+	if len(pathParts) != 2 || pathParts[1] != "attachments" {
+		http.Error(w, "Invalid URL", http.StatusNotFound)
+		return
+	}
+
+	sampleID, err := strconv.Atoi(pathParts[0])
+	if err != nil {
+		http.Error(w, "Invalid sample ID", http.StatusBadRequest)
+		return
+	}
+
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		http.Error(w, "You do not have permission to edit samples", http.StatusForbidden)
+		return
+	}
+
+	err = checkSampleVisible(r.Context(), sampleViewerFor(r), strconv.Itoa(sampleID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	path, err := saveUploadedFile(file, header.Filename)
+	if err != nil {
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+
+	if err := addAttachment(strconv.Itoa(sampleID), path); err != nil {
+		http.Error(w, "Error storing attachment info", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"sample_id": sampleID,
+		"filename":  originalFilenameFromPath(path),
+	})
+}
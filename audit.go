@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/audit"
+	"sampleDB/internal/auth"
+)
+
+// auditPageSize is the number of entries shown per page of the audit viewer;
+// auditExportLimit caps a CSV export.
+const (
+	auditPageSize    = 100
+	auditExportLimit = 50000
+)
+
+type AuditPageData struct {
+	BasePageData
+	Entries   []audit.Entry
+	Actions   []string
+	Actor     string
+	Action    string
+	Target    string
+	DateFrom  string
+	DateTo    string
+	ExportURL string
+	OlderURL  string
+	Error     string
+}
+
+// recordAudit writes e on behalf of the user signed in on r.
+func recordAudit(r *http.Request, e audit.Entry) {
+	if authManagerInstance != nil {
+		authManagerInstance.RecordAudit(r, e)
+	}
+}
+
+// userSnapshot is the part of an account that administrators can change,
+// stored as the before and after state of user audit entries.
+type userSnapshot struct {
+	Username  string `json:"username"`
+	Approved  bool   `json:"approved"`
+	Admin     bool   `json:"admin"`
+	Group     string `json:"group,omitempty"`
+	Equipment []int  `json:"equipment"`
+}
+
+// snapshotUser reads the current state of userID. It returns nil when the
+// audit log is disabled or the account cannot be read.
+func snapshotUser(ctx context.Context, userID int) *userSnapshot {
+	if auditLog == nil {
+		return nil
+	}
+	var s userSnapshot
+	err := dbPool.QueryRow(ctx,
+		`SELECT username, COALESCE(is_approved, false), COALESCE(admin, false), COALESCE(btrim("group"), '')
+         FROM users
+         WHERE user_id = $1`,
+		userID).Scan(&s.Username, &s.Approved, &s.Admin, &s.Group)
+	if err != nil {
+		log.Printf("audit: unable to read user %d: %v", userID, err)
+		return nil
+	}
+
+	rows, err := dbPool.Query(ctx,
+		"SELECT equipment_id FROM user_equipment_permissions WHERE user_id = $1 ORDER BY equipment_id",
+		userID)
+	if err != nil {
+		log.Printf("audit: unable to read equipment access of user %d: %v", userID, err)
+		return nil
+	}
+	defer rows.Close()
+	s.Equipment = []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil
+		}
+		s.Equipment = append(s.Equipment, id)
+	}
+	if rows.Err() != nil {
+		return nil
+	}
+	return &s
+}
+
+// recordUserChange writes an entry for an administrative change to userID.
+// before and after may be nil.
+func recordUserChange(r *http.Request, action string, userID int, before, after *userSnapshot) {
+	e := audit.Entry{
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.Itoa(userID),
+	}
+	if before != nil {
+		e.TargetName = before.Username
+		e.Before = audit.State(before)
+	}
+	if after != nil {
+		e.TargetName = after.Username
+		e.After = audit.State(after)
+	}
+	recordAudit(r, e)
+}
+
+// handleAuditLog shows the audit log with filters, or exports the filtered
+// entries as CSV when format=csv.
+func handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if auditLog == nil {
+		http.Error(w, "Audit log is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	session := auth.MustSessionFromContext(r.Context())
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	data := AuditPageData{
+		BasePageData: baseData,
+		Actor:        strings.TrimSpace(query.Get("actor")),
+		Action:       strings.TrimSpace(query.Get("action")),
+		Target:       strings.TrimSpace(query.Get("target")),
+		DateFrom:     query.Get("from"),
+		DateTo:       query.Get("to"),
+	}
+
+	filter := audit.Filter{
+		Actor:  data.Actor,
+		Action: data.Action,
+		Target: data.Target,
+		Limit:  auditPageSize,
+	}
+	if data.DateFrom != "" {
+		if filter.From, err = time.ParseInLocation("2006-01-02", data.DateFrom, loc); err != nil {
+			data.Error = "Invalid start date"
+		}
+	}
+	if data.DateTo != "" {
+		to, err := time.ParseInLocation("2006-01-02", data.DateTo, loc)
+		if err != nil {
+			data.Error = "Invalid end date"
+		}
+		// The end date is inclusive.
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	if raw := query.Get("before"); raw != "" {
+		if filter.BeforeID, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			data.Error = "Invalid page"
+		}
+	}
+
+	if query.Get("format") == "csv" {
+		if data.Error != "" {
+			http.Error(w, data.Error, http.StatusBadRequest)
+			return
+		}
+		filter.BeforeID = 0
+		filter.Limit = auditExportLimit
+		entries, err := auditLog.List(r.Context(), filter)
+		if err != nil {
+			log.Printf("audit: unable to export entries: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		setDownloadHeaders(w, fmt.Sprintf("audit_log_%s.csv", time.Now().In(loc).Format("20060102")))
+		if err := audit.WriteCSV(w, entries); err != nil {
+			log.Printf("audit: unable to write export: %v", err)
+		}
+		return
+	}
+
+	if data.Error == "" {
+		data.Entries, err = auditLog.List(r.Context(), filter)
+		if err != nil {
+			log.Printf("audit: unable to list entries: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		for i := range data.Entries {
+			data.Entries[i].CreatedAt = data.Entries[i].CreatedAt.In(loc)
+		}
+	}
+	data.Actions, err = auditLog.Actions(r.Context())
+	if err != nil {
+		log.Printf("audit: unable to list actions: %v", err)
+	}
+
+	params := r.URL.Query()
+	params.Del("before")
+	params.Set("format", "csv")
+	data.ExportURL = "/admin/audit?" + params.Encode()
+	if len(data.Entries) == auditPageSize {
+		params.Del("format")
+		params.Set("before", strconv.FormatInt(data.Entries[len(data.Entries)-1].ID, 10))
+		data.OlderURL = "/admin/audit?" + params.Encode()
+	}
+
+	if data.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	tmpl, err := parseTemplates(r, "templates/admin_audit.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, "Template execution error", http.StatusInternalServerError)
+	}
+}
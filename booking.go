@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
 )
 
 type Equipment struct {
@@ -61,7 +62,7 @@ func handleBooking(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
-func parseTemplatesBooking(files ...string) (*template.Template, error) {
+func parseTemplatesBooking(r *http.Request, files ...string) (*template.Template, error) {
 	// Create a new template with base name and include all functions
 	funcMap := template.FuncMap{
 		"toJSON": func(value interface{}) template.JS {
@@ -136,7 +137,7 @@ func parseTemplatesBooking(files ...string) (*template.Template, error) {
 	}
 
 	// Create new template with function map
-	tmpl := template.New("").Funcs(funcMap)
+	tmpl := template.New("").Funcs(funcMap).Funcs(csrfFuncs(r))
 
 	// Always include base and header templates
 	baseTemplates := []string{"templates/base.html", "templates/header.html"}
@@ -194,17 +195,22 @@ func showBookingCalendar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isAdmin, err := isUserAdmin(session.UserID)
+	baseData, err := getBasePageData(session)
 	if err != nil {
-		log.Printf("booking: unable to determine admin status for user %d: %v", session.UserID, err)
+		log.Printf("booking: unable to load permissions for user %d: %v", session.UserID, err)
 	}
 
+	// Users who may not book at all see every lane as locked.
+	canBook := baseData.Can(string(rbac.BookingsCreate))
 	hasPermission := make(map[int]bool)
 	for _, eq := range equipment {
 		// if isAdmin {
 		// 	hasPermission[eq.ID] = true
 		// 	continue
 		// }
+		if !canBook {
+			continue
+		}
 		permitted, err := checkUserPermission(session.UserID, eq.ID)
 		if err != nil {
 			continue
@@ -231,7 +237,7 @@ func showBookingCalendar(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := BookingPageData{
-		BasePageData:  BasePageData{Username: session.Username, UserID: session.UserID, IsAdmin: isAdmin},
+		BasePageData:  baseData,
 		Equipment:     equipment,
 		Bookings:      bookings,
 		UserBookings:  userBookings,
@@ -246,7 +252,7 @@ func showBookingCalendar(w http.ResponseWriter, r *http.Request) {
 		Success:       r.URL.Query().Get("success"),
 	}
 
-	tmpl, err := parseTemplatesBooking("templates/booking.html")
+	tmpl, err := parseTemplatesBooking(r, "templates/booking.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
@@ -288,11 +294,11 @@ func handleBookingSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isAdmin, err := isUserAdmin(session.UserID)
-	if err != nil {
-		http.Redirect(w, r, "/booking?error=Unable+to+verify+permissions", http.StatusSeeOther)
+	if !can(r, rbac.BookingsCreate, rbac.Resource{}) {
+		http.Redirect(w, r, "/booking?error=You+do+not+have+permission+to+book+equipment", http.StatusSeeOther)
 		return
 	}
+	manageBookings := can(r, rbac.BookingsManage, rbac.Resource{})
 
 	// Basic validation
 	if endTime.Before(startTime) {
@@ -322,7 +328,7 @@ func handleBookingSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !isAdmin {
+	if !manageBookings {
 		permitted, err := checkUserPermission(session.UserID, equipmentID)
 		if err != nil {
 			http.Redirect(w, r, "/booking?error=Unable+to+verify+permissions", http.StatusSeeOther)
@@ -387,14 +393,6 @@ func checkUserPermission(userID, equipmentID int) (bool, error) {
 	return exists, err
 }
 
-func isUserAdmin(userID int) (bool, error) {
-	var isAdmin bool
-	err := dbPool.QueryRow(context.Background(),
-		`SELECT admin FROM users WHERE user_id = $1 AND COALESCE(deleted, false) = false`,
-		userID).Scan(&isAdmin)
-	return isAdmin, err
-}
-
 func getBookingsForWeek(start, end time.Time) ([]Booking, error) {
 	// Force timezone to local for query
 	start = start.In(loc)
@@ -444,7 +442,7 @@ func handleDeleteBooking(w http.ResponseWriter, r *http.Request) {
 	session := auth.MustSessionFromContext(r.Context())
 	bookingID := r.FormValue("booking_id")
 
-	// Verify the booking belongs to the user
+	// Only the booking owner or a booking manager may delete it
 	var userID int
 	err := dbPool.QueryRow(context.Background(),
 		"SELECT user_id FROM bookings WHERE booking_id = $1", bookingID).Scan(&userID)
@@ -453,7 +451,7 @@ func handleDeleteBooking(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if userID != session.UserID {
+	if userID != session.UserID && !can(r, rbac.BookingsManage, rbac.Resource{}) {
 		http.Error(w, "Not authorized to delete this booking", http.StatusForbidden)
 		return
 	}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"sampleDB/internal/audit"
+	"sampleDB/internal/auth"
+)
+
+// runCreateAdmin implements the create-admin subcommand, which adds an
+// approved administrator without going through the web setup page. The
+// password is prompted for on a terminal or read from the first line of
+// standard input.
+func runCreateAdmin(ctx context.Context, cfg AppConfig, args []string) {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := flags.String("username", "", "name of the administrator account to create")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s create-admin -username <name>\n\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Creates an approved administrator. The password is read from the terminal or from standard input.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if strings.TrimSpace(*username) == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	password, err := readNewPassword()
+	if err != nil {
+		log.Fatalf("Unable to read password: %v\n", err)
+	}
+
+	manager := auth.NewManager(dbPool)
+	manager.SetPasswordPolicy(loadPasswordPolicy(cfg))
+	manager.SetPasswordHasher(cfg.PasswordHasher)
+
+	name := strings.TrimSpace(*username)
+	userID, err := manager.CreateAdmin(ctx, name, password)
+	var policyErr *auth.PasswordPolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		log.Fatalf("Password rejected: %v\n", policyErr)
+	case errors.Is(err, auth.ErrUsernameTaken):
+		log.Fatalf("User %q already exists\n", name)
+	case err != nil:
+		log.Fatalf("Unable to create administrator: %v\n", err)
+	}
+
+	if err := audit.New(dbPool).Record(ctx, audit.Entry{
+		ActorID:    userID,
+		ActorName:  name,
+		Action:     audit.ActionSetup,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.Itoa(userID),
+		TargetName: name,
+		After:      audit.State(map[string]string{"via": "create-admin"}),
+	}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	log.Printf("Created administrator %q", name)
+}
+
+// readNewPassword asks twice for a password on a terminal, or reads one
+// line from standard input when it is not a terminal.
+func readNewPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(first), nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/samplequery"
+)
+
+// exportBatchSize is the number of samples read per query while exporting.
+const exportBatchSize = 200
+
+// exportWriteTimeout is how long an export may wait between writes. The
+// server's write timeout would cut off exports that take longer as a whole,
+// so the deadline moves on with every write instead.
+const exportWriteTimeout = 30 * time.Second
+
+// exportTimeLayout formats creation dates in exports. It matches a layout
+// accepted by the sample import.
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// exportColumns are the CSV columns of an export, named so that the file can
+// be imported again.
+var exportColumns = []string{"id", "name", "description", "keywords", "owner", "prep", "created"}
+
+// sampleExport is a sample in a JSON export and in sample.json of an archive.
+type sampleExport struct {
+	ID          int                `json:"id"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Keywords    string             `json:"keywords"`
+	Owner       string             `json:"owner"`
+	Prep        string             `json:"prep"`
+	Created     string             `json:"created"`
+	Attachments []attachmentExport `json:"attachments,omitempty"`
+}
+
+// attachmentExport lists an attachment in sample.json. File is its path in
+// the archive; Missing is set when the file was not found in the uploads
+// directory.
+type attachmentExport struct {
+	Name       string `json:"name"`
+	File       string `json:"file,omitempty"`
+	UploadedAt string `json:"uploaded_at"`
+	Missing    bool   `json:"missing,omitempty"`
+}
+
+func newSampleExport(s Sample) sampleExport {
+	return sampleExport{
+		ID:          s.ID,
+		Name:        s.Name,
+		Description: s.Description,
+		Keywords:    s.Keywords,
+		Owner:       s.Owner,
+		Prep:        s.Sample_prep,
+		Created:     s.CreatedAt.Format(exportTimeLayout),
+	}
+}
+
+func (e sampleExport) csvRecord() []string {
+	return []string{strconv.Itoa(e.ID), e.Name, e.Description, e.Keywords, e.Owner, e.Prep, e.Created}
+}
+
+// prepPageTemplate wraps the rendered preparation notes of a sample in a
+// stand-alone page for archives.
+var prepPageTemplate = template.Must(template.New("prep").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} – preparation notes</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{.SamplePrepHTML}}
+</body>
+</html>
+`))
+
+// handleSampleExport downloads the samples matching the filters of the main
+// page as CSV, JSON, or a ZIP archive with each sample's metadata, rendered
+// preparation notes and attachments. Samples are read in batches and written
+// as they arrive, so the response is streamed whatever the size of the
+// result.
+func handleSampleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	opts := SampleSearch{
+		Query:    params.Get("query"),
+		DateFrom: params.Get("date_from"),
+		DateTo:   params.Get("date_to"),
+		Sort:     params.Get("sort"),
+		Viewer:   sampleViewerFor(r),
+	}
+	if params.Get("mine") == "1" {
+		opts.OwnerID = opts.Viewer.UserID
+	}
+	if validStatus(params.Get("status")) {
+		opts.Status = params.Get("status")
+	}
+	opts.LocationID, _ = strconv.Atoi(params.Get("location"))
+	if _, err := samplequery.Parse(opts.Query); err != nil {
+		http.Error(w, "Search syntax error at "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, date := range []string{opts.DateFrom, opts.DateTo} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			http.Error(w, "Invalid date filter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := "samples-" + time.Now().In(loc).Format("20060102-150405")
+	format := params.Get("format")
+	var export func(ctx context.Context, w io.Writer, opts SampleSearch) error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		export = exportSamplesCSV
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		export = exportSamplesJSON
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		export = func(ctx context.Context, w io.Writer, opts SampleSearch) error {
+			return exportSamplesZIP(ctx, w, opts, name)
+		}
+	default:
+		http.Error(w, "Unknown export format", http.StatusBadRequest)
+		return
+	}
+	setDownloadHeaders(w, name+"."+format)
+
+	session := auth.MustSessionFromContext(r.Context())
+	out := &deadlineWriter{w: w, rc: http.NewResponseController(w), timeout: exportWriteTimeout}
+	if err := export(r.Context(), out, opts); err != nil {
+		// The headers are gone by now; the client sees a truncated file.
+		log.Printf("export: %s export for %s failed: %v", format, session.Username, err)
+	}
+}
+
+// deadlineWriter pushes the write deadline of a response back by timeout
+// before each write, so that a streamed response may take as long as it
+// needs while it makes progress.
+type deadlineWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	if err := d.rc.SetWriteDeadline(time.Now().Add(d.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return d.w.Write(p)
+}
+
+// eachSample calls fn for every sample matching opts, in the order of the
+// sample list, reading them a batch at a time.
+func eachSample(ctx context.Context, opts SampleSearch, fn func(Sample) error) error {
+	opts.Limit = exportBatchSize
+	opts.Count = false
+	for {
+		page, err := searchSamples(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, sample := range page.Samples {
+			if err := fn(sample); err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		opts.Sort, opts.After = page.Sort, page.Next
+	}
+}
+
+func exportSamplesCSV(ctx context.Context, w io.Writer, opts SampleSearch) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return err
+	}
+	err := eachSample(ctx, opts, func(s Sample) error {
+		return cw.Write(newSampleExport(s).csvRecord())
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportSamplesJSON(ctx context.Context, w io.Writer, opts SampleSearch) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	sep := "\n"
+	err := eachSample(ctx, opts, func(s Sample) error {
+		raw, err := json.Marshal(newSampleExport(s))
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ",\n"
+		_, err = w.Write(raw)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// exportSamplesZIP writes an archive with a directory per sample holding
+// sample.json, the preparation notes as prep.md and prep.html, and the
+// attachments, all under a top directory named base. samples.csv lists every
+// sample with its directory; it is written last, so only that index is held
+// in memory.
+func exportSamplesZIP(ctx context.Context, w io.Writer, opts SampleSearch, base string) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+
+	var index [][]string
+	err := eachSample(ctx, opts, func(s Sample) error {
+		dir := path.Join(base, exportDirName(s))
+		index = append(index, []string{strconv.Itoa(s.ID), s.Name, s.Owner, s.CreatedAt.Format(exportTimeLayout), path.Base(dir) + "/"})
+		return writeSampleToZIP(ctx, zw, dir, s, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: path.Join(base, "samples.csv"), Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.WriteAll(append([][]string{{"id", "name", "owner", "created", "directory"}}, index...)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeSampleToZIP(ctx context.Context, zw *zip.Writer, dir string, s Sample, modified time.Time) error {
+	attachments, err := getAttachments(strconv.Itoa(s.ID))
+	if err != nil {
+		return err
+	}
+
+	export := newSampleExport(s)
+	used := map[string]bool{}
+	for _, att := range attachments {
+		entry := attachmentExport{
+			Name:       att.OriginalName,
+			UploadedAt: att.UploadedAt.Format(exportTimeLayout),
+		}
+		if _, err := os.Stat(resolveAppPath(att.Address)); err != nil {
+			log.Printf("export: attachment %d of sample %d is missing: %v", att.ID, s.ID, err)
+			entry.Missing = true
+		} else {
+			entry.File = "attachments/" + uniqueName(sanitizeFilename(att.OriginalName), used)
+		}
+		export.Attachments = append(export.Attachments, entry)
+	}
+
+	meta, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeZIPFile(zw, path.Join(dir, "sample.json"), modified, strings.NewReader(string(meta)+"\n")); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(s.Sample_prep) != "" {
+		if err := writeZIPFile(zw, path.Join(dir, "prep.md"), modified, strings.NewReader(s.Sample_prep)); err != nil {
+			return err
+		}
+		s.SamplePrepHTML = renderMarkdown(s.Sample_prep)
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: path.Join(dir, "prep.html"), Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		if err := prepPageTemplate.Execute(f, s); err != nil {
+			return err
+		}
+	}
+
+	for i, att := range attachments {
+		entry := export.Attachments[i]
+		if entry.Missing {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file, err := os.Open(resolveAppPath(att.Address))
+		if err != nil {
+			return err
+		}
+		err = writeZIPFile(zw, path.Join(dir, entry.File), att.UploadedAt, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeZIPFile(zw *zip.Writer, name string, modified time.Time, r io.Reader) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// exportDirName names the directory of a sample in an archive. The ID keeps
+// it unique when names repeat.
+func exportDirName(s Sample) string {
+	name := nonFileChars.ReplaceAllString(s.Name, "-")
+	name = strings.Trim(name, "-_. ")
+	if len(name) > 60 {
+		name = strings.TrimRight(name[:60], "-_. ")
+	}
+	if name == "" {
+		return strconv.Itoa(s.ID)
+	}
+	return fmt.Sprintf("%d-%s", s.ID, name)
+}
+
+// uniqueName returns name, or name with a counter before the extension when
+// it is already in used, and records the result in used.
+func uniqueName(name string, used map[string]bool) string {
+	candidate := name
+	ext := path.Ext(name)
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[candidate] = true
+	return candidate
+}
@@ -10,6 +10,8 @@ require (
 	github.com/gomarkdown/markdown v0.0.0-20241105142532-d03b89096d81
 	github.com/pashagolub/pgxmock/v3 v3.4.0
 	golang.org/x/crypto v0.27.0
+	golang.org/x/term v0.24.0
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -17,5 +19,6 @@ require (
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
 	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
 )
@@ -0,0 +1,243 @@
+// Package audit records security-relevant actions in the append-only
+// audit_log table and reads them back for the admin panel.
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"sampleDB/internal/dbiface"
+)
+
+// Actions written to the audit log.
+const (
+	ActionLogin          = "auth.login"
+	ActionLogout         = "auth.logout"
+	ActionLockout        = "auth.lockout"
+	ActionRegister       = "auth.register"
+	ActionVerifyEmail    = "auth.verify_email"
+	ActionPasswordReset  = "auth.password_reset"
+	ActionSetup          = "auth.setup"
+	ActionPasswordChange = "account.password_change"
+	ActionEmailChange    = "account.email_change"
+	ActionTwoFactorOn    = "account.2fa_enable"
+	ActionTwoFactorOff   = "account.2fa_disable"
+	ActionTokenCreate    = "account.token_create"
+	ActionTokenRevoke    = "account.token_revoke"
+	ActionSessionRevoke  = "account.session_revoke"
+
+	ActionUserAccess        = "admin.user_access"
+	ActionUserAdmin         = "admin.user_admin"
+	ActionUserDelete        = "admin.user_delete"
+	ActionUserPasswordReset = "admin.user_password_reset"
+	ActionUserTwoFactorOff  = "admin.user_2fa_reset"
+	ActionUserUnlock        = "admin.user_unlock"
+	ActionUserRoles         = "admin.user_roles"
+	ActionGroupRoles        = "admin.group_roles"
+	ActionGroupAdd          = "admin.group_add"
+	ActionGroupDelete       = "admin.group_delete"
+	ActionEquipmentAdd      = "admin.equipment_add"
+	ActionEquipmentDelete   = "admin.equipment_delete"
+	ActionTokenRevokeAdmin  = "admin.token_revoke"
+	ActionSessionRevokeAny  = "admin.session_revoke"
+	ActionInviteCreate      = "admin.invite_create"
+	ActionInviteRevoke      = "admin.invite_revoke"
+	ActionSamplePurge       = "admin.sample_purge"
+)
+
+// Target types.
+const (
+	TargetUser       = "user"
+	TargetGroup      = "group"
+	TargetEquipment  = "equipment"
+	TargetToken      = "api_token"
+	TargetSession    = "session"
+	TargetInvitation = "invitation"
+	TargetSample     = "sample"
+)
+
+// Entry is one audit log record. ActorID is zero for actions taken by
+// someone who is not signed in.
+type Entry struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   string
+	TargetName string
+	Before     json.RawMessage
+	After      json.RawMessage
+	IPAddress  string
+}
+
+// Filter narrows a listing. Empty fields match everything; Action matches
+// by prefix so "admin." selects every admin action.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+
+	// BeforeID pages backwards: only entries older than it are returned.
+	BeforeID int64
+	Limit    int
+}
+
+// Log writes and reads audit entries.
+type Log struct {
+	db dbiface.Pool
+}
+
+func New(db dbiface.Pool) *Log {
+	return &Log{db: db}
+}
+
+// State encodes v as the before or after value of an entry. It returns nil
+// when v is nil or cannot be encoded.
+func State(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// Record appends e to the log. CreatedAt is set by the database.
+func (l *Log) Record(ctx context.Context, e Entry) error {
+	var actorID *int
+	if e.ActorID != 0 {
+		actorID = &e.ActorID
+	}
+	_, err := l.db.Exec(ctx,
+		`INSERT INTO audit_log (actor_id, actor_name, action, target_type, target_id, target_name, before_state, after_state, ip_address)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		actorID, e.ActorName, e.Action, e.TargetType, e.TargetID, e.TargetName,
+		nullJSON(e.Before), nullJSON(e.After), e.IPAddress)
+	if err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the entries matching f, newest first.
+func (l *Log) List(ctx context.Context, f Filter) ([]Entry, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	var from, to *time.Time
+	if !f.From.IsZero() {
+		from = &f.From
+	}
+	if !f.To.IsZero() {
+		to = &f.To
+	}
+
+	rows, err := l.db.Query(ctx,
+		`SELECT audit_id, created_at, COALESCE(actor_id, 0), actor_name, action,
+                target_type, target_id, target_name,
+                COALESCE(before_state::text, ''), COALESCE(after_state::text, ''), ip_address
+         FROM audit_log
+         WHERE ($1 = '' OR actor_name ILIKE $1)
+           AND ($2 = '' OR action LIKE $2 || '%')
+           AND ($3 = '' OR target_name ILIKE $3 OR target_id = $3)
+           AND ($4::timestamptz IS NULL OR created_at >= $4)
+           AND ($5::timestamptz IS NULL OR created_at < $5)
+           AND ($6 = 0 OR audit_id < $6)
+         ORDER BY audit_id DESC
+         LIMIT $7`,
+		f.Actor, f.Action, f.Target, from, to, f.BeforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var (
+			e             Entry
+			before, after string
+		)
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.ActorID, &e.ActorName, &e.Action,
+			&e.TargetType, &e.TargetID, &e.TargetName, &before, &after, &e.IPAddress); err != nil {
+			return nil, err
+		}
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Actions lists the distinct actions present in the log, for filter menus.
+func (l *Log) Actions(ctx context.Context) ([]string, error) {
+	rows, err := l.db.Query(ctx, "SELECT DISTINCT action FROM audit_log ORDER BY action")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []string
+	for rows.Next() {
+		var action string
+		if err := rows.Scan(&action); err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+// WriteCSV writes entries as CSV with a header row.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "time", "actor_id", "actor", "action", "target_type", "target_id", "target", "before", "after", "ip_address"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		actorID := ""
+		if e.ActorID != 0 {
+			actorID = strconv.Itoa(e.ActorID)
+		}
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			actorID,
+			e.ActorName,
+			e.Action,
+			e.TargetType,
+			e.TargetID,
+			e.TargetName,
+			string(e.Before),
+			string(e.After),
+			e.IPAddress,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
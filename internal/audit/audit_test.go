@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestRecordStoresAnonymousActorAsNull(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs((*int)(nil), "", ActionLockout, TargetUser, "7", "alice",
+			nil, `{"locked_until":"2024-03-01T08:00:00Z"}`, "192.0.2.1").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	err = New(mock).Record(context.Background(), Entry{
+		Action:     ActionLockout,
+		TargetType: TargetUser,
+		TargetID:   "7",
+		TargetName: "alice",
+		After:      State(map[string]time.Time{"locked_until": time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)}),
+		IPAddress:  "192.0.2.1",
+	})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestListPagesBackwardsWithFilters(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	mock.ExpectQuery(`FROM audit_log`).
+		WithArgs("bob", "admin.", "", (*time.Time)(nil), (*time.Time)(nil), int64(50), 100).
+		WillReturnRows(pgxmock.NewRows([]string{"audit_id", "created_at", "actor_id", "actor_name", "action",
+			"target_type", "target_id", "target_name", "before_state", "after_state", "ip_address"}).
+			AddRow(int64(49), created, 2, "bob", ActionUserAdmin, TargetUser, "7", "alice",
+				`{"admin": false}`, `{"admin": true}`, "192.0.2.9"))
+
+	entries, err := New(mock).List(context.Background(), Filter{Actor: "bob", Action: "admin.", BeforeID: 50})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != 49 || string(entries[0].After) != `{"admin": true}` {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []Entry{{
+		ID:         3,
+		CreatedAt:  time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		Action:     ActionRegister,
+		TargetType: TargetUser,
+		TargetName: "carol",
+		After:      State(map[string]bool{"approved": false}),
+	}})
+	if err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("exported CSV does not parse: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected a header and one row, got %d records", len(records))
+	}
+	row := records[1]
+	if row[0] != "3" || row[1] != "2024-03-01T09:30:00Z" || row[2] != "" || row[4] != ActionRegister {
+		t.Fatalf("unexpected row: %q", row)
+	}
+	if row[9] != `{"approved":false}` {
+		t.Fatalf("after state = %q", row[9])
+	}
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Scopes that can be granted to personal API tokens.
+const (
+	ScopeAttachmentsWrite = "attachments:write"
+	ScopeBookingsRead     = "bookings:read"
+)
+
+// APIScope is a permission that can be granted to an API token.
+type APIScope struct {
+	Name        string
+	Description string
+}
+
+// APIScopes lists every grantable scope, in the order they are shown to users.
+var APIScopes = []APIScope{
+	{ScopeAttachmentsWrite, "Upload attachments to samples"},
+	{ScopeBookingsRead, "Read equipment bookings"},
+}
+
+const apiTokenPrefix = "sdb_"
+
+const tokenScopeKey contextKey = "api-token-scope"
+
+var ErrUnknownScope = errors.New("unknown API token scope")
+
+// APIToken describes a personal access token. The secret itself is only
+// available once, when the token is created.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Username   string
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Active reports whether the token can still be used at now.
+func (t APIToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// Expired reports whether the token ran past its expiry date.
+func (t APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// AcceptToken marks next as reachable with an API token carrying scope.
+// It must wrap RequireAuth; routes without it only accept session cookies.
+func (m *Manager) AcceptToken(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), tokenScopeKey, scope)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// CreateAPIToken issues a new token for userID and returns the plaintext
+// secret. Only its SHA-256 digest is stored.
+func (m *Manager) CreateAPIToken(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (string, error) {
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate api token: %w", err)
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	_, err := m.db.Exec(ctx,
+		`INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, name, hashToken(secret), secret[:len(apiTokenPrefix)+6], scopes, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("insert api token: %w", err)
+	}
+	return secret, nil
+}
+
+// ListAPITokens returns the tokens of userID, or of every user when userID
+// is zero, newest first.
+func (m *Manager) ListAPITokens(ctx context.Context, userID int) ([]APIToken, error) {
+	rows, err := m.db.Query(ctx,
+		`SELECT t.token_id, t.user_id, u.username, t.name, t.token_prefix, t.scopes,
+                t.created_at, t.expires_at, t.last_used_at, t.revoked_at
+         FROM api_tokens t
+         JOIN users u ON u.user_id = t.user_id
+         WHERE $1 = 0 OR t.user_id = $1
+         ORDER BY t.created_at DESC, t.token_id DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &t.Scopes,
+			&t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes tokenID. A non-zero userID restricts the operation to
+// that user's tokens; pgx.ErrNoRows is returned when nothing matched.
+func (m *Manager) RevokeAPIToken(ctx context.Context, tokenID, userID int) error {
+	tag, err := m.db.Exec(ctx,
+		`UPDATE api_tokens
+         SET revoked_at = CURRENT_TIMESTAMP
+         WHERE token_id = $1
+           AND ($2 = 0 OR user_id = $2)
+           AND revoked_at IS NULL`,
+		tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke api token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// serveWithAPIToken authenticates r with a bearer token. The route must have
+// been wrapped with AcceptToken and the token must carry its scope.
+func (m *Manager) serveWithAPIToken(w http.ResponseWriter, r *http.Request, secret string, next http.HandlerFunc) {
+	scope, accepted := r.Context().Value(tokenScopeKey).(string)
+	if !accepted {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+		http.Error(w, "API tokens are not accepted for this endpoint", http.StatusUnauthorized)
+		return
+	}
+
+	var (
+		token    APIToken
+		approved bool
+	)
+	err := m.db.QueryRow(r.Context(),
+		`SELECT t.token_id, t.user_id, u.username, t.scopes, t.expires_at, t.revoked_at, COALESCE(u.is_approved, false)
+         FROM api_tokens t
+         JOIN users u ON u.user_id = t.user_id
+         WHERE t.token_hash = $1
+           AND COALESCE(u.deleted, false) = false`,
+		hashToken(secret)).Scan(&token.ID, &token.UserID, &token.Username, &token.Scopes, &token.ExpiresAt, &token.RevokedAt, &approved)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("auth: unable to load api token: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || !approved || !token.Active(time.Now()) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid API token", http.StatusUnauthorized)
+		return
+	}
+	if !hasScope(token.Scopes, scope) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+		http.Error(w, "API token lacks the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	if _, err := m.db.Exec(r.Context(),
+		"UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE token_id = $1",
+		token.ID); err != nil {
+		log.Printf("auth: unable to update api token %d: %v", token.ID, err)
+	}
+
+	session := Session{
+		UserID:     token.UserID,
+		Username:   token.Username,
+		APITokenID: token.ID,
+	}
+	if token.ExpiresAt != nil {
+		session.ExpiresAt = *token.ExpiresAt
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, session)
+	next(w, r.WithContext(ctx))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func validScope(scope string) bool {
+	for _, s := range APIScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
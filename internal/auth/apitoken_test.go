@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestCreateAPITokenStoresDigest(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+
+	if _, err := manager.CreateAPIToken(context.Background(), 3, "afm", []string{"samples:delete"}, nil); !errors.Is(err, ErrUnknownScope) {
+		t.Fatalf("expected ErrUnknownScope, got %v", err)
+	}
+
+	mock.ExpectExec(`INSERT INTO api_tokens`).
+		WithArgs(3, "afm", pgxmock.AnyArg(), pgxmock.AnyArg(), []string{ScopeAttachmentsWrite}, (*time.Time)(nil)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	secret, err := manager.CreateAPIToken(context.Background(), 3, "afm", []string{ScopeAttachmentsWrite}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken returned error: %v", err)
+	}
+	if !strings.HasPrefix(secret, apiTokenPrefix) || len(secret) < 40 {
+		t.Fatalf("unexpected token format %q", secret)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRequireAuthWithBearerToken(t *testing.T) {
+	const secret = "sdb_test-token"
+
+	tests := []struct {
+		name       string
+		scope      string
+		tokenScope []string
+		revoked    bool
+		wantStatus int
+	}{
+		{name: "route without token access", wantStatus: http.StatusUnauthorized},
+		{name: "matching scope", scope: ScopeBookingsRead, tokenScope: []string{ScopeBookingsRead}, wantStatus: http.StatusNoContent},
+		{name: "missing scope", scope: ScopeAttachmentsWrite, tokenScope: []string{ScopeBookingsRead}, wantStatus: http.StatusForbidden},
+		{name: "revoked token", scope: ScopeBookingsRead, tokenScope: []string{ScopeBookingsRead}, revoked: true, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("failed to create mock pool: %v", err)
+			}
+			defer mock.Close()
+
+			if tc.scope != "" {
+				var revokedAt *time.Time
+				if tc.revoked {
+					now := time.Now()
+					revokedAt = &now
+				}
+				mock.ExpectQuery(`SELECT t.token_id, t.user_id, u.username, t.scopes`).
+					WithArgs(hashToken(secret)).
+					WillReturnRows(pgxmock.NewRows([]string{"token_id", "user_id", "username", "scopes", "expires_at", "revoked_at", "is_approved"}).
+						AddRow(5, 8, "instrument", tc.tokenScope, (*time.Time)(nil), revokedAt, true))
+			}
+			if tc.wantStatus == http.StatusNoContent {
+				mock.ExpectExec(`UPDATE api_tokens SET last_used_at`).
+					WithArgs(5).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+			}
+
+			manager := NewManager(mock)
+
+			var got Session
+			handler := manager.RequireAuth(manager.VerifyCSRF(func(w http.ResponseWriter, r *http.Request) {
+				got = MustSessionFromContext(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			if tc.scope != "" {
+				handler = manager.AcceptToken(tc.scope, handler)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/bookings", nil)
+			req.Header.Set("Authorization", "Bearer "+secret)
+			rr := httptest.NewRecorder()
+
+			handler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d (%s)", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tc.wantStatus == http.StatusNoContent && (got.UserID != 8 || got.APITokenID != 5) {
+				t.Fatalf("unexpected session %+v", got)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
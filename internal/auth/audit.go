@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"sampleDB/internal/audit"
+)
+
+// SetAuditLog makes the manager record sign-ins, registrations and password
+// resets, and enables RecordAudit.
+func (m *Manager) SetAuditLog(l *audit.Log) {
+	m.auditLog = l
+}
+
+// RecordAudit appends e to the audit log with the client's address. When e
+// has no actor, the signed-in user of r is used. Failures are logged but do
+// not interrupt the request.
+func (m *Manager) RecordAudit(r *http.Request, e audit.Entry) {
+	if m.auditLog == nil {
+		return
+	}
+	if e.ActorID == 0 && e.ActorName == "" {
+		if session, ok := SessionFromContext(r.Context()); ok {
+			e.ActorID = session.UserID
+			e.ActorName = session.Username
+		}
+	}
+	e.IPAddress = m.ClientIP(r)
+	if err := m.auditLog.Record(r.Context(), e); err != nil {
+		log.Printf("auth: unable to write audit entry %s: %v", e.Action, err)
+	}
+}
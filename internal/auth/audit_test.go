@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v3"
+
+	"sampleDB/internal/audit"
+)
+
+func TestRecordAuditUsesSignedInUser(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+	manager.SetAuditLog(audit.New(mock))
+
+	actor := 4
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(&actor, "dana", audit.ActionUserUnlock, audit.TargetUser, "9", "", nil, nil, "198.51.100.7").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/unlock-user", nil)
+	req.RemoteAddr = "198.51.100.7:4242"
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, Session{UserID: 4, Username: "dana"}))
+
+	manager.RecordAudit(req, audit.Entry{
+		Action:     audit.ActionUserUnlock,
+		TargetType: audit.TargetUser,
+		TargetID:   "9",
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCSRF(t *testing.T) {
+	manager := NewManager(nil)
+	session := Session{Token: "session-token", UserID: 1, Username: "alice"}
+	token := session.CSRFToken()
+
+	if token == "" || token == (Session{Token: "other-token"}).CSRFToken() {
+		t.Fatalf("expected distinct per-session tokens")
+	}
+
+	handler := manager.VerifyCSRF(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	form := url.Values{CSRFFieldName: {token}}.Encode()
+	tests := []struct {
+		name   string
+		method string
+		header string
+		body   string
+		want   int
+	}{
+		{name: "safe method", method: http.MethodGet, want: http.StatusNoContent},
+		{name: "missing token", method: http.MethodPost, want: http.StatusForbidden},
+		{name: "wrong header", method: http.MethodPost, header: "nope", want: http.StatusForbidden},
+		{name: "header", method: http.MethodPost, header: token, want: http.StatusNoContent},
+		{name: "form field", method: http.MethodPost, body: form, want: http.StatusNoContent},
+		{name: "delete with header", method: http.MethodDelete, header: token, want: http.StatusNoContent},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/samples/edit/1", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.header != "" {
+				req.Header.Set(CSRFHeaderName, tc.header)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), userContextKey, session))
+			rr := httptest.NewRecorder()
+
+			handler(rr, req)
+
+			if rr.Code != tc.want {
+				t.Fatalf("expected status %d, got %d", tc.want, rr.Code)
+			}
+		})
+	}
+
+	// Multipart bodies are not parsed by the middleware: the token must come
+	// in the header, and the body reaches the handler unread.
+	var parsed bool
+	upload := manager.VerifyCSRF(func(w http.ResponseWriter, r *http.Request) {
+		parsed = r.MultipartForm != nil
+		w.WriteHeader(http.StatusNoContent)
+	})
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"" + CSRFFieldName + "\"\r\n\r\n" + token + "\r\n--xyz--\r\n"
+	for _, header := range []string{"", token} {
+		req := httptest.NewRequest(http.MethodPost, "/samples/1/upload", strings.NewReader(body))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=xyz")
+		if header != "" {
+			req.Header.Set(CSRFHeaderName, header)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, session))
+		rr := httptest.NewRecorder()
+		upload(rr, req)
+
+		want := http.StatusForbidden
+		if header != "" {
+			want = http.StatusNoContent
+		}
+		if rr.Code != want {
+			t.Errorf("multipart with header %q: expected status %d, got %d", header, want, rr.Code)
+		}
+		if parsed {
+			t.Error("multipart body was parsed before the handler")
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/mail"
+)
+
+// DefaultInvitationTTL is how long an invitation link stays valid when the
+// administrator does not choose otherwise.
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+var errInvitationInvalid = errors.New("auth: invitation is invalid or has expired")
+
+// Invitation is an administrator-issued registration link. Accounts created
+// with it are approved straight away and get the preset group and equipment
+// access.
+type Invitation struct {
+	ID           int
+	Email        string
+	GroupName    string
+	EquipmentIDs []int
+	CreatedBy    string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	UsedAt       *time.Time
+	UsedBy       string
+	RevokedAt    *time.Time
+}
+
+// Pending reports whether the invitation can still be used at now.
+func (i Invitation) Pending(now time.Time) bool {
+	return i.UsedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+// SetInviteOnly closes open registration: new accounts can then only be
+// created through an invitation link.
+func (m *Manager) SetInviteOnly(enable bool) {
+	m.inviteOnly = enable
+}
+
+// CreateInvitation stores inv on behalf of createdBy and returns the link to
+// hand to the invitee. Only a digest of the token in the link is kept.
+func (m *Manager) CreateInvitation(ctx context.Context, createdBy int, inv Invitation) (string, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	var email, group *string
+	if inv.Email != "" {
+		email = &inv.Email
+	}
+	if inv.GroupName != "" {
+		group = &inv.GroupName
+	}
+	equipment := inv.EquipmentIDs
+	if equipment == nil {
+		equipment = []int{}
+	}
+
+	_, err = m.db.Exec(ctx,
+		`INSERT INTO invitations (token_hash, email, group_name, equipment_ids, created_by, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		hashToken(token), email, group, equipment, createdBy, inv.ExpiresAt)
+	if err != nil {
+		return "", fmt.Errorf("insert invitation: %w", err)
+	}
+	return m.baseURL + "/register?invite=" + url.QueryEscape(token), nil
+}
+
+// SendInvitation emails link to inv.Email. It fails when email is not
+// configured.
+func (m *Manager) SendInvitation(ctx context.Context, inv Invitation, link, inviter string) error {
+	if m.mailer == nil {
+		return errors.New("auth: email is not configured")
+	}
+	msg := mail.Message{
+		To:      inv.Email,
+		Subject: "You have been invited to SampleDB",
+		Body: fmt.Sprintf("Hello,\n\n"+
+			"%s has invited you to create an account on SampleDB. "+
+			"Open the link below before %s to choose a username and password:\n\n%s\n\n"+
+			"The link works once. If you were not expecting this, you can ignore this email.\n",
+			inviter, inv.ExpiresAt.Format("2 January 2006"), link),
+	}
+	return m.mailer.Send(ctx, msg)
+}
+
+// ListInvitations returns every invitation, newest first.
+func (m *Manager) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	rows, err := m.db.Query(ctx,
+		`SELECT i.invitation_id, COALESCE(i.email, ''), COALESCE(i.group_name, ''), i.equipment_ids,
+                COALESCE(c.username, ''), i.created_at, i.expires_at, i.used_at,
+                COALESCE(u.username, ''), i.revoked_at
+         FROM invitations i
+         LEFT JOIN users c ON c.user_id = i.created_by
+         LEFT JOIN users u ON u.user_id = i.used_by
+         ORDER BY i.created_at DESC, i.invitation_id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.GroupName, &inv.EquipmentIDs,
+			&inv.CreatedBy, &inv.CreatedAt, &inv.ExpiresAt, &inv.UsedAt,
+			&inv.UsedBy, &inv.RevokedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation invalidates an unused invitation. pgx.ErrNoRows is
+// returned when nothing matched.
+func (m *Manager) RevokeInvitation(ctx context.Context, id int) error {
+	tag, err := m.db.Exec(ctx,
+		`UPDATE invitations
+         SET revoked_at = CURRENT_TIMESTAMP
+         WHERE invitation_id = $1
+           AND used_at IS NULL
+           AND revoked_at IS NULL`,
+		id)
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// lookupInvitation returns the still-usable invitation behind token.
+func (m *Manager) lookupInvitation(ctx context.Context, token string) (Invitation, error) {
+	if token == "" {
+		return Invitation{}, errInvitationInvalid
+	}
+	var inv Invitation
+	err := m.db.QueryRow(ctx,
+		`SELECT invitation_id, COALESCE(email, ''), COALESCE(group_name, ''), expires_at
+         FROM invitations
+         WHERE token_hash = $1
+           AND used_at IS NULL
+           AND revoked_at IS NULL
+           AND expires_at > $2`,
+		hashToken(token), time.Now()).Scan(&inv.ID, &inv.Email, &inv.GroupName, &inv.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Invitation{}, errInvitationInvalid
+	}
+	return inv, err
+}
+
+// registerInvited consumes token and creates an approved account with the
+// invitation's group and equipment access. email is only used when the
+// invitation does not name an address itself.
+func (m *Manager) registerInvited(ctx context.Context, token, username, passwordHash, email string) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		inv   Invitation
+		group *string
+	)
+	err = tx.QueryRow(ctx,
+		`UPDATE invitations
+         SET used_at = $2
+         WHERE token_hash = $1
+           AND used_at IS NULL
+           AND revoked_at IS NULL
+           AND expires_at > $2
+         RETURNING invitation_id, COALESCE(email, ''), group_name`,
+		hashToken(token), time.Now()).Scan(&inv.ID, &inv.Email, &group)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errInvitationInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if inv.Email != "" {
+		email = inv.Email
+	}
+	var emailParam *string
+	if email != "" {
+		emailParam = &email
+	}
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`INSERT INTO users (username, password_hash, is_approved, email, "group")
+         VALUES ($1, $2, true, $3, $4)
+         RETURNING user_id`,
+		username, passwordHash, emailParam, group).Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	// Equipment removed since the invitation was issued is skipped.
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO user_equipment_permissions (user_id, equipment_id, granted_by)
+         SELECT $1, e.equipment_id, i.created_by
+         FROM invitations i
+         JOIN equipment e ON e.equipment_id = ANY(i.equipment_ids)
+         WHERE i.invitation_id = $2`,
+		userID, inv.ID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE invitations SET used_by = $1 WHERE invitation_id = $2",
+		userID, inv.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("auth: user %d registered with invitation %d", userID, inv.ID)
+	return nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestRegisterHandlerWithInvitationApprovesUser(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+	manager.SetInviteOnly(true)
+
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE username = \$1\)`).
+		WithArgs("erin").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE invitations\s+SET used_at = \$2\s+WHERE token_hash = \$1`).
+		WithArgs(hashToken("invite-token"), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"invitation_id", "email", "group_name"}).
+			AddRow(4, "erin@lab.example", "Thin Films"))
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, is_approved, email, "group"\)\s+VALUES \(\$1, \$2, true, \$3, \$4\)`).
+		WithArgs("erin", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(9))
+	mock.ExpectExec(`INSERT INTO user_equipment_permissions \(user_id, equipment_id, granted_by\)`).
+		WithArgs(9, 4).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	mock.ExpectExec(`UPDATE invitations SET used_by = \$1 WHERE invitation_id = \$2`).
+		WithArgs(9, 4).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+
+	rr := postForm(manager.RegisterHandler(), "/register", url.Values{
+		"invite":           {"invite-token"},
+		"username":         {"erin"},
+		"password":         {"long enough secret"},
+		"confirm_password": {"long enough secret"},
+	})
+
+	if loc := rr.Header().Get("Location"); rr.Code != http.StatusSeeOther || !strings.Contains(loc, "Account+created") {
+		t.Fatalf("expected redirect to login, got %d %q", rr.Code, loc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRegisterHandlerInviteOnlyRejectsOpenSignup(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+	manager.SetInviteOnly(true)
+
+	rr := postForm(manager.RegisterHandler(), "/register", url.Values{
+		"username":         {"mallory"},
+		"password":         {"long enough secret"},
+		"confirm_password": {"long enough secret"},
+	})
+
+	if loc := rr.Header().Get("Location"); !strings.Contains(loc, "invitation+only") {
+		t.Fatalf("expected invitation-only error, got %q", loc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unexpected DB calls: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimiterPolicy configures login throttling. Failures beyond the free
+// allowance double the wait before the next attempt is accepted, up to
+// MaxDelay. Accounts that reach LockoutThreshold are locked for
+// LockoutDuration; counters are forgotten after Window without failures.
+type LimiterPolicy struct {
+	AccountFreeAttempts int
+	IPFreeAttempts      int
+	BaseDelay           time.Duration
+	MaxDelay            time.Duration
+	LockoutThreshold    int
+	LockoutDuration     time.Duration
+	Window              time.Duration
+}
+
+func DefaultLimiterPolicy() LimiterPolicy {
+	return LimiterPolicy{
+		AccountFreeAttempts: 3,
+		IPFreeAttempts:      10,
+		BaseDelay:           time.Second,
+		MaxDelay:            15 * time.Minute,
+		LockoutThreshold:    10,
+		LockoutDuration:     30 * time.Minute,
+		Window:              time.Hour,
+	}
+}
+
+type attemptState struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginLimiter tracks failed logins per account and per client IP in memory.
+type LoginLimiter struct {
+	mu       sync.Mutex
+	policy   LimiterPolicy
+	now      func() time.Time
+	accounts map[string]*attemptState
+	ips      map[string]*attemptState
+}
+
+func NewLoginLimiter(policy LimiterPolicy) *LoginLimiter {
+	return &LoginLimiter{
+		policy:   policy,
+		now:      time.Now,
+		accounts: make(map[string]*attemptState),
+		ips:      make(map[string]*attemptState),
+	}
+}
+
+// SetClock replaces the time source, which lets tests advance time manually.
+func (l *LoginLimiter) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	l.now = now
+	l.mu.Unlock()
+}
+
+// Now returns the limiter's notion of the current time.
+func (l *LoginLimiter) Now() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.now()
+}
+
+// Allow reports whether a login attempt for username from ip may proceed. If
+// not, it returns how long the caller has to wait.
+func (l *LoginLimiter) Allow(username, ip string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	for _, state := range []*attemptState{l.lookup(l.accounts, accountKey(username), now), l.lookup(l.ips, ip, now)} {
+		if state != nil && now.Before(state.blockedUntil) {
+			if d := state.blockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, wait == 0
+}
+
+// Failure records a failed attempt and reports whether the account has now
+// reached the lockout threshold.
+func (l *LoginLimiter) Failure(username, ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	account := l.record(l.accounts, accountKey(username), now, l.policy.AccountFreeAttempts)
+	if ip != "" {
+		l.record(l.ips, ip, now, l.policy.IPFreeAttempts)
+	}
+	return l.policy.LockoutThreshold > 0 && account.failures >= l.policy.LockoutThreshold
+}
+
+// Success clears the account counter after a successful login. The IP
+// counter is left alone so one valid account cannot launder guesses.
+func (l *LoginLimiter) Success(username string) {
+	l.Reset(username)
+}
+
+// Reset forgets all failures recorded for username.
+func (l *LoginLimiter) Reset(username string) {
+	l.mu.Lock()
+	delete(l.accounts, accountKey(username))
+	l.mu.Unlock()
+}
+
+// Prune drops counters that have been quiet for longer than the window.
+func (l *LoginLimiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, m := range []map[string]*attemptState{l.accounts, l.ips} {
+		for key := range m {
+			l.lookup(m, key, now)
+		}
+	}
+}
+
+func (l *LoginLimiter) lookup(m map[string]*attemptState, key string, now time.Time) *attemptState {
+	state, ok := m[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(state.lastFailure) > l.policy.Window && !now.Before(state.blockedUntil) {
+		delete(m, key)
+		return nil
+	}
+	return state
+}
+
+func (l *LoginLimiter) record(m map[string]*attemptState, key string, now time.Time, free int) *attemptState {
+	state := l.lookup(m, key, now)
+	if state == nil {
+		state = &attemptState{}
+		m[key] = state
+	}
+
+	state.failures++
+	state.lastFailure = now
+
+	if excess := state.failures - free; excess > 0 {
+		delay := l.policy.BaseDelay
+		for i := 1; i < excess && delay < l.policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > l.policy.MaxDelay {
+			delay = l.policy.MaxDelay
+		}
+		state.blockedUntil = now.Add(delay)
+	}
+	return state
+}
+
+func accountKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
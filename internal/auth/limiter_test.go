@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(policy LimiterPolicy) (*LoginLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewLoginLimiter(policy)
+	l.SetClock(clock.Now)
+	return l, clock
+}
+
+func TestLoginLimiterBackoffDoubles(t *testing.T) {
+	l, clock := newTestLimiter(LimiterPolicy{
+		AccountFreeAttempts: 2,
+		IPFreeAttempts:      100,
+		BaseDelay:           time.Second,
+		MaxDelay:            time.Minute,
+		Window:              time.Hour,
+	})
+
+	for i := 0; i < 2; i++ {
+		l.Failure("alice", "10.0.0.1")
+		if _, ok := l.Allow("alice", "10.0.0.1"); !ok {
+			t.Fatalf("attempt %d should be free", i+1)
+		}
+	}
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		l.Failure("alice", "10.0.0.1")
+		wait, ok := l.Allow("alice", "10.0.0.1")
+		if ok || wait != want {
+			t.Fatalf("expected wait %s, got %s (ok=%v)", want, wait, ok)
+		}
+		clock.Advance(want)
+		if _, ok := l.Allow("alice", "10.0.0.1"); !ok {
+			t.Fatalf("expected attempt to be allowed after waiting %s", want)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		l.Failure("alice", "10.0.0.1")
+	}
+	if wait, _ := l.Allow("alice", "10.0.0.1"); wait != time.Minute {
+		t.Fatalf("expected backoff to be capped at 1m, got %s", wait)
+	}
+}
+
+func TestLoginLimiterLockoutThresholdAndReset(t *testing.T) {
+	l, clock := newTestLimiter(LimiterPolicy{
+		AccountFreeAttempts: 100,
+		IPFreeAttempts:      100,
+		BaseDelay:           time.Second,
+		MaxDelay:            time.Minute,
+		LockoutThreshold:    3,
+		Window:              time.Hour,
+	})
+
+	if l.Failure("Bob", "10.0.0.2") || l.Failure("bob ", "10.0.0.2") {
+		t.Fatalf("lockout reported before the threshold")
+	}
+	if !l.Failure("bob", "10.0.0.2") {
+		t.Fatalf("expected third failure to reach the lockout threshold")
+	}
+
+	l.Reset("BOB")
+	if l.Failure("bob", "10.0.0.2") {
+		t.Fatalf("expected counter to restart after Reset")
+	}
+
+	clock.Advance(2 * time.Hour)
+	l.Failure("bob", "10.0.0.2")
+	if l.Failure("bob", "10.0.0.2") {
+		t.Fatalf("expected failures outside the window to be forgotten")
+	}
+}
+
+func TestLoginLimiterThrottlesIPAcrossAccounts(t *testing.T) {
+	l, clock := newTestLimiter(LimiterPolicy{
+		AccountFreeAttempts: 100,
+		IPFreeAttempts:      3,
+		BaseDelay:           time.Second,
+		MaxDelay:            time.Minute,
+		Window:              time.Hour,
+	})
+
+	for _, user := range []string{"a", "b", "c", "d"} {
+		l.Failure(user, "192.0.2.7")
+	}
+
+	if _, ok := l.Allow("fresh-user", "192.0.2.7"); ok {
+		t.Fatalf("expected IP to be throttled regardless of username")
+	}
+	if _, ok := l.Allow("fresh-user", "192.0.2.8"); !ok {
+		t.Fatalf("expected other IPs to be unaffected")
+	}
+
+	clock.Advance(2 * time.Hour)
+	l.Prune()
+	if len(l.ips) != 0 || len(l.accounts) != 0 {
+		t.Fatalf("expected Prune to drop stale counters, have %d ips and %d accounts", len(l.ips), len(l.accounts))
+	}
+}
+
+func TestClientIPIgnoresForgedForwardedEntries(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = "10.0.0.2:41234"
+	req.Header.Add("X-Forwarded-For", "203.0.113.7, 198.51.100.1")
+	req.Header.Add("X-Forwarded-For", "192.0.2.44")
+
+	m := &Manager{}
+	if ip := m.ClientIP(req); ip != "10.0.0.2" {
+		t.Errorf("ClientIP without trusted proxy = %q, want 10.0.0.2", ip)
+	}
+	m.SetTrustProxyHeaders(true)
+	if ip := m.ClientIP(req); ip != "192.0.2.44" {
+		t.Errorf("ClientIP behind proxy = %q, want the entry added by the proxy", ip)
+	}
+}
+
+func TestLoginHandlerRejectsLockedAccount(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	limiter, clock := newTestLimiter(DefaultLimiterPolicy())
+	lockedUntil := clock.Now().Add(10 * time.Minute)
+
+	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved`).
+		WithArgs("dave").
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted", "totp_enabled", "locked_until"}).
+			AddRow(4, "unused", true, false, false, &lockedUntil))
+	mock.ExpectExec(`INSERT INTO failed_logins`).
+		WithArgs("dave", pgxmock.AnyArg(), "192.0.2.1", "", failureLocked).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	manager := NewManager(mock)
+	manager.SetLoginLimiter(limiter)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=dave&password=whatever"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Del("User-Agent")
+	req.RemoteAddr = "192.0.2.1:5555"
+	rr := httptest.NewRecorder()
+
+	manager.LoginHandler()(rr, req)
+
+	if loc := rr.Header().Get("Location"); !strings.Contains(loc, "temporarily+locked") {
+		t.Fatalf("expected locked account error, got %q", loc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
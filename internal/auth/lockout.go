@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sampleDB/internal/audit"
+)
+
+// Reasons recorded in the failed_logins table.
+const (
+	failureUnknownUser = "unknown_user"
+	failureBadPassword = "bad_password"
+	failureBadCode     = "bad_2fa_code"
+	failureThrottled   = "throttled"
+	failureLocked      = "locked"
+)
+
+// SetLoginLimiter replaces the default login limiter.
+func (m *Manager) SetLoginLimiter(l *LoginLimiter) {
+	m.limiter = l
+}
+
+// SetTrustProxyHeaders makes client IP detection honour X-Forwarded-For. Only
+// enable it when the app runs behind a reverse proxy that appends the address
+// of its client to the header.
+func (m *Manager) SetTrustProxyHeaders(enable bool) {
+	m.trustProxy = enable
+}
+
+// UnlockAccount clears a lockout and forgets recorded failures for userID.
+func (m *Manager) UnlockAccount(ctx context.Context, userID int) error {
+	var username string
+	err := m.db.QueryRow(ctx,
+		"UPDATE users SET locked_until = NULL WHERE user_id = $1 RETURNING username",
+		userID).Scan(&username)
+	if err != nil {
+		return err
+	}
+	m.limiter.Reset(username)
+	return nil
+}
+
+// ClientIP returns the address of the client that sent r. Behind a trusted
+// proxy this is the last X-Forwarded-For entry, the one the proxy added; the
+// entries before it come from the client and may be forged.
+func (m *Manager) ClientIP(r *http.Request) string {
+	if m.trustProxy {
+		forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+		if i := strings.LastIndexByte(forwarded, ','); i >= 0 {
+			forwarded = forwarded[i+1:]
+		}
+		if ip := strings.TrimSpace(forwarded); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginFailed records a failed attempt against the limiter and the audit
+// trail. It locks the account once the limiter reports the threshold was hit
+// and returns true in that case.
+func (m *Manager) loginFailed(r *http.Request, username string, userID int, reason string) bool {
+	ip := m.ClientIP(r)
+	locked := m.limiter.Failure(username, ip)
+	m.recordFailedLogin(r, username, userID, reason)
+
+	if !locked || userID == 0 {
+		return false
+	}
+
+	until := m.limiter.Now().Add(m.limiter.policy.LockoutDuration)
+	if _, err := m.db.Exec(context.Background(),
+		"UPDATE users SET locked_until = $1 WHERE user_id = $2",
+		until, userID); err != nil {
+		log.Printf("auth: unable to lock account %d: %v", userID, err)
+		return false
+	}
+	log.Printf("auth: locked account %q until %s after repeated failed logins", username, until.Format(time.RFC3339))
+	m.RecordAudit(r, audit.Entry{
+		Action:     audit.ActionLockout,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.Itoa(userID),
+		TargetName: username,
+		After:      audit.State(map[string]interface{}{"locked_until": until}),
+	})
+	return true
+}
+
+func (m *Manager) recordFailedLogin(r *http.Request, username string, userID int, reason string) {
+	username = truncateRunes(username, 50)
+	var uid *int
+	if userID != 0 {
+		uid = &userID
+	}
+	if _, err := m.db.Exec(context.Background(),
+		"INSERT INTO failed_logins (username, user_id, ip_address, user_agent, reason) VALUES ($1, $2, $3, $4, $5)",
+		username, uid, m.ClientIP(r), r.UserAgent(), reason); err != nil {
+		log.Printf("auth: unable to record failed login for %q: %v", username, err)
+	}
+}
+
+func retryAfterMessage(wait time.Duration) string {
+	wait = wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if wait < time.Minute {
+		return fmt.Sprintf("Too many failed attempts. Try again in %d seconds", int(wait.Seconds()))
+	}
+	return fmt.Sprintf("Too many failed attempts. Try again in %d minutes", int((wait+time.Minute-1)/time.Minute))
+}
@@ -4,17 +4,20 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
+	"sampleDB/internal/audit"
 	"sampleDB/internal/dbiface"
+	"sampleDB/internal/mail"
+	"sampleDB/internal/passhash"
 )
 
 type Session struct {
@@ -22,14 +25,39 @@ type Session struct {
 	UserID    int
 	Username  string
 	ExpiresAt time.Time
+
+	// ID identifies the session in listings without revealing Token.
+	ID         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IPAddress  string
+	UserAgent  string
+
+	// APITokenID is set when the request was authenticated with a personal
+	// API token instead of a session cookie.
+	APITokenID int
 }
 
 type Manager struct {
 	db           dbiface.Pool
-	sessions     map[string]Session
-	mu           sync.RWMutex
+	store        SessionStore
 	cookieSecure bool
 	templateDir  string
+	limiter      *LoginLimiter
+	trustProxy   bool
+	oidc         *oidcProvider
+	mailer       mail.Sender
+	baseURL      string
+	policy       PasswordPolicy
+	hasher       passhash.Hasher
+	idleTimeout  time.Duration
+	maxLifetime  time.Duration
+	inviteOnly   bool
+	auditLog     *audit.Log
+	setup        setupState
+
+	requireAdmin2FA bool
+	adminCheck      AdminCheck
 }
 
 type contextKey string
@@ -38,28 +66,49 @@ const userContextKey contextKey = "auth-user"
 
 func NewManager(db dbiface.Pool) *Manager {
 	return &Manager{
-		db:       db,
-		sessions: make(map[string]Session),
+		db:      db,
+		store:   NewMemoryStore(),
+		limiter: NewLoginLimiter(DefaultLimiterPolicy()),
+		policy:  DefaultPasswordPolicy(),
+		hasher:  passhash.Default(),
+
+		idleTimeout: defaultSessionIdleTimeout,
+		maxLifetime: defaultSessionMaxLifetime,
 	}
 }
 
 func (m *Manager) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			m.serveWithAPIToken(w, r, token, next)
+			return
+		}
+
 		cookie, err := r.Cookie("session_token")
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		session, exists := m.getSession(cookie.Value)
+		session, exists, err := m.store.Get(r.Context(), cookie.Value)
+		if err != nil {
+			log.Printf("auth: unable to load session: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
 		if !exists || time.Now().After(session.ExpiresAt) {
 			if exists {
-				m.deleteSession(cookie.Value)
+				m.deleteSession(r.Context(), cookie.Value)
 			}
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
+		if m.requirePasswordChange(w, r, session.UserID) {
+			return
+		}
+		m.touchSession(r, &session)
+
 		ctx := context.WithValue(r.Context(), userContextKey, session)
 		next(w, r.WithContext(ctx))
 	}
@@ -72,6 +121,9 @@ func (m *Manager) LoginHandler() http.HandlerFunc {
 				Error:      r.URL.Query().Get("error"),
 				Success:    r.URL.Query().Get("success"),
 				IsRegister: false,
+				OIDCName:   m.OIDCProviderName(),
+				CanReset:   m.PasswordResetEnabled(),
+				InviteOnly: m.inviteOnly,
 			}
 
 			tmpl, err := template.ParseFiles(m.templatePath("auth_base.html"), m.templatePath("login.html"))
@@ -86,21 +138,36 @@ func (m *Manager) LoginHandler() http.HandlerFunc {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
+		if wait, ok := m.limiter.Allow(username, m.ClientIP(r)); !ok {
+			m.recordFailedLogin(r, username, 0, failureThrottled)
+			http.Redirect(w, r, "/login?error="+url.QueryEscape(retryAfterMessage(wait)), http.StatusSeeOther)
+			return
+		}
+
 		var (
-			userID     int
-			passwdHash string
-			isApproved bool
-			deleted    bool
+			userID      int
+			passwdHash  string
+			isApproved  bool
+			deleted     bool
+			totpEnabled bool
+			lockedUntil *time.Time
 		)
 
 		err := m.db.QueryRow(context.Background(),
-			"SELECT user_id, password_hash, is_approved, COALESCE(deleted, false) FROM users WHERE username = $1",
-			username).Scan(&userID, &passwdHash, &isApproved, &deleted)
+			"SELECT user_id, password_hash, is_approved, COALESCE(deleted, false), COALESCE(totp_enabled, false), locked_until FROM users WHERE username = $1",
+			username).Scan(&userID, &passwdHash, &isApproved, &deleted, &totpEnabled, &lockedUntil)
 		if err != nil {
+			m.loginFailed(r, username, 0, failureUnknownUser)
 			http.Redirect(w, r, "/login?error=Invalid+username+or+password", http.StatusSeeOther)
 			return
 		}
 
+		if lockedUntil != nil && m.limiter.Now().Before(*lockedUntil) {
+			m.recordFailedLogin(r, username, userID, failureLocked)
+			http.Redirect(w, r, "/login?error=Account+is+temporarily+locked.+Try+again+later+or+contact+an+administrator", http.StatusSeeOther)
+			return
+		}
+
 		if deleted {
 			http.Redirect(w, r, "/login?error=Account+is+disabled", http.StatusSeeOther)
 			return
@@ -111,39 +178,117 @@ func (m *Manager) LoginHandler() http.HandlerFunc {
 			return
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(passwdHash), []byte(password))
-		if err != nil {
+		match, err := passhash.Verify(passwdHash, password)
+		if err != nil && !errors.Is(err, passhash.ErrUnknownFormat) {
+			log.Printf("auth: unable to check password of user %d: %v", userID, err)
+		}
+		if !match {
+			if m.loginFailed(r, username, userID, failureBadPassword) {
+				http.Redirect(w, r, "/login?error=Too+many+failed+attempts.+Account+is+temporarily+locked", http.StatusSeeOther)
+				return
+			}
 			http.Redirect(w, r, "/login?error=Invalid+username+or+password", http.StatusSeeOther)
 			return
 		}
+		m.limiter.Success(username)
+		m.upgradePasswordHash(r.Context(), userID, passwdHash, password)
+
+		m.finishLogin(w, r, username, userID, totpEnabled)
+	}
+}
 
-		session, err := m.newSession(username, userID)
+// finishLogin completes a sign-in once the primary credential has been
+// checked: it either hands over to the second-factor step or starts the
+// session.
+func (m *Manager) finishLogin(w http.ResponseWriter, r *http.Request, username string, userID int, totpEnabled bool) {
+	next, err := m.completeLogin(w, r, username, userID, totpEnabled)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// completeLogin sets the cookie of the second-factor challenge or of the new
+// session and returns the page to continue on.
+func (m *Manager) completeLogin(w http.ResponseWriter, r *http.Request, username string, userID int, totpEnabled bool) (string, error) {
+	purpose, next := challengeVerify, "/login/2fa"
+	if !totpEnabled {
+		enrol, err := m.TwoFactorRequired(r.Context(), userID)
 		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			return
+			return "", err
 		}
+		if !enrol {
+			if err := m.startSession(w, r, username, userID); err != nil {
+				return "", err
+			}
+			return "/", nil
+		}
+		purpose, next = challengeEnroll, "/login/2fa/setup"
+	}
+	if err := m.beginChallenge(w, r, username, userID, purpose); err != nil {
+		return "", err
+	}
+	return next, nil
+}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_token",
-			Value:    session.Token,
-			Path:     "/",
-			Expires:  session.ExpiresAt,
-			HttpOnly: true,
-			Secure:   m.cookieSecure,
-			SameSite: http.SameSiteStrictMode,
-		})
-
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+// startSession creates a session for a fully authenticated user and sets the
+// session cookie.
+func (m *Manager) startSession(w http.ResponseWriter, r *http.Request, username string, userID int) error {
+	session, err := m.newSession(r, username, userID)
+	if err != nil {
+		return err
 	}
+
+	// The cookie lives as long as the session could; idle expiry is enforced
+	// server-side.
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.CreatedAt.Add(m.maxLifetime),
+		HttpOnly: true,
+		Secure:   m.cookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	m.RecordAudit(r, audit.Entry{
+		ActorID:    userID,
+		ActorName:  username,
+		Action:     audit.ActionLogin,
+		TargetType: audit.TargetSession,
+		TargetID:   session.ID,
+		TargetName: session.Device(),
+	})
+	return nil
 }
 
 func (m *Manager) RegisterHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		invite := r.FormValue("invite")
+
 		if r.Method == http.MethodGet {
 			data := authPageData{
-				Error:      r.URL.Query().Get("error"),
-				Success:    r.URL.Query().Get("success"),
-				IsRegister: true,
+				Error:        r.URL.Query().Get("error"),
+				Success:      r.URL.Query().Get("success"),
+				IsRegister:   true,
+				InviteOnly:   m.inviteOnly,
+				RequireEmail: m.mailer != nil,
+				MinLength:    m.policy.MinLength,
+			}
+			if invite != "" {
+				inv, err := m.lookupInvitation(r.Context(), invite)
+				switch {
+				case errors.Is(err, errInvitationInvalid):
+					data.Error = "This invitation is invalid, has already been used, or has expired."
+				case err != nil:
+					log.Printf("auth: unable to check invitation: %v", err)
+					http.Error(w, "Server error", http.StatusInternalServerError)
+					return
+				default:
+					data.InviteToken = invite
+					data.InviteEmail = inv.Email
+				}
 			}
 
 			tmpl, err := template.ParseFiles(m.templatePath("auth_base.html"), m.templatePath("login.html"))
@@ -155,17 +300,49 @@ func (m *Manager) RegisterHandler() http.HandlerFunc {
 			return
 		}
 
+		retry := "/register?error="
+		if invite != "" {
+			retry = "/register?invite=" + url.QueryEscape(invite) + "&error="
+		}
+
+		if invite == "" && m.inviteOnly {
+			http.Redirect(w, r, retry+"Registration+is+by+invitation+only", http.StatusSeeOther)
+			return
+		}
+
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 		confirmPassword := r.FormValue("confirm_password")
 
 		if username == "" || password == "" {
-			http.Redirect(w, r, "/register?error=Username+and+password+are+required", http.StatusSeeOther)
+			http.Redirect(w, r, retry+"Username+and+password+are+required", http.StatusSeeOther)
 			return
 		}
 
 		if password != confirmPassword {
-			http.Redirect(w, r, "/register?error=Passwords+do+not+match", http.StatusSeeOther)
+			http.Redirect(w, r, retry+"Passwords+do+not+match", http.StatusSeeOther)
+			return
+		}
+
+		if err := m.policy.Check(username, password); err != nil {
+			http.Redirect(w, r, retry+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+
+		// Open registrations must confirm an address when mail is set up;
+		// invited users may leave it empty.
+		var email string
+		if raw := strings.TrimSpace(r.FormValue("email")); raw != "" {
+			normalized, err := mail.NormalizeAddress(raw)
+			if err != nil {
+				http.Redirect(w, r, retry+"Enter+a+valid+email+address", http.StatusSeeOther)
+				return
+			}
+			email = normalized
+		}
+		verify := invite == "" && m.mailer != nil
+		if verify && email == "" {
+			http.Redirect(w, r, retry+"Email+address+is+required", http.StatusSeeOther)
 			return
 		}
 
@@ -179,24 +356,82 @@ func (m *Manager) RegisterHandler() http.HandlerFunc {
 		}
 
 		if exists {
-			http.Redirect(w, r, "/register?error=Username+already+taken", http.StatusSeeOther)
+			http.Redirect(w, r, retry+"Username+already+taken", http.StatusSeeOther)
 			return
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if email != "" {
+			err := m.db.QueryRow(r.Context(),
+				"SELECT EXISTS(SELECT 1 FROM users WHERE lower(email) = lower($1))",
+				email).Scan(&exists)
+			if err != nil {
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			if exists {
+				http.Redirect(w, r, retry+"That+email+address+is+already+registered", http.StatusSeeOther)
+				return
+			}
+		}
+
+		hashedPassword, err := m.hasher.Hash(password)
 		if err != nil {
 			http.Error(w, "Server error", http.StatusInternalServerError)
 			return
 		}
 
+		switch {
+		case invite != "":
+			err := m.registerInvited(r.Context(), invite, username, hashedPassword, email)
+			if errors.Is(err, errInvitationInvalid) {
+				http.Redirect(w, r, "/register?error="+url.QueryEscape("This invitation is invalid, has already been used, or has expired."), http.StatusSeeOther)
+				return
+			}
+			if err != nil {
+				log.Printf("auth: invited registration failed: %v", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			m.RecordAudit(r, audit.Entry{
+				ActorName:  username,
+				Action:     audit.ActionRegister,
+				TargetType: audit.TargetUser,
+				TargetName: username,
+				After:      audit.State(map[string]interface{}{"invited": true, "approved": true}),
+			})
+			http.Redirect(w, r, "/login?success=Account+created.+You+can+sign+in+now", http.StatusSeeOther)
+			return
+		case verify:
+			if err := m.registerUnverified(r, username, hashedPassword, email); err != nil {
+				log.Printf("auth: registration failed: %v", err)
+				http.Redirect(w, r, retry+url.QueryEscape("We could not send the confirmation email. Please try again later."), http.StatusSeeOther)
+				return
+			}
+			m.RecordAudit(r, audit.Entry{
+				ActorName:  username,
+				Action:     audit.ActionRegister,
+				TargetType: audit.TargetUser,
+				TargetName: username,
+				After:      audit.State(map[string]interface{}{"email": email, "email_verified": false}),
+			})
+			http.Redirect(w, r, "/login?success="+url.QueryEscape(verificationSent), http.StatusSeeOther)
+			return
+		}
+
 		_, err = m.db.Exec(context.Background(),
 			"INSERT INTO users (username, password_hash, is_approved) VALUES ($1, $2, false)",
-			username, string(hashedPassword))
+			username, hashedPassword)
 		if err != nil {
 			http.Error(w, "Server error", http.StatusInternalServerError)
 			return
 		}
 
+		m.RecordAudit(r, audit.Entry{
+			ActorName:  username,
+			Action:     audit.ActionRegister,
+			TargetType: audit.TargetUser,
+			TargetName: username,
+		})
 		http.Redirect(w, r, "/login?success=Registration+successful.+Please+wait+for+admin+approval", http.StatusSeeOther)
 	}
 }
@@ -205,7 +440,16 @@ func (m *Manager) LogoutHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("session_token")
 		if err == nil {
-			m.deleteSession(cookie.Value)
+			if session, ok, _ := m.store.Get(r.Context(), cookie.Value); ok {
+				m.RecordAudit(r, audit.Entry{
+					ActorID:    session.UserID,
+					ActorName:  session.Username,
+					Action:     audit.ActionLogout,
+					TargetType: audit.TargetSession,
+					TargetID:   session.ID,
+				})
+			}
+			m.deleteSession(r.Context(), cookie.Value)
 		}
 
 		http.SetCookie(w, &http.Cookie{
@@ -225,14 +469,54 @@ func (m *Manager) SetCookieSecure(enable bool) {
 	m.cookieSecure = enable
 }
 
+// SetSessionStore replaces the default in-memory session store.
+func (m *Manager) SetSessionStore(store SessionStore) {
+	m.store = store
+}
+
 func (m *Manager) RevokeUserSessions(userID int) {
-	m.mu.Lock()
-	for token, session := range m.sessions {
-		if session.UserID == userID {
-			delete(m.sessions, token)
-		}
+	if err := m.store.DeleteUser(context.Background(), userID); err != nil {
+		log.Printf("auth: unable to revoke sessions for user %d: %v", userID, err)
 	}
-	m.mu.Unlock()
+}
+
+// StartSessionPurge removes expired sessions from the store every interval
+// until ctx is cancelled.
+func (m *Manager) StartSessionPurge(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _, err := m.store.DeleteExpired(ctx, now); err != nil {
+					log.Printf("auth: session purge failed: %v", err)
+				}
+				m.purgeChallenges(ctx, now)
+				m.purgeUnverifiedAccounts(ctx, now)
+				m.limiter.Prune()
+			}
+		}
+	}()
+}
+
+// SetMailer enables the features that send email: password reset links and
+// address confirmation for open registrations.
+func (m *Manager) SetMailer(sender mail.Sender) {
+	m.mailer = sender
+}
+
+// MailEnabled reports whether a mailer has been configured.
+func (m *Manager) MailEnabled() bool {
+	return m.mailer != nil
+}
+
+// SetBaseURL sets the externally visible address of the app, used in emailed
+// and invitation links.
+func (m *Manager) SetBaseURL(baseURL string) {
+	m.baseURL = strings.TrimRight(baseURL, "/")
 }
 
 func (m *Manager) SetTemplateDir(dir string) {
@@ -268,37 +552,36 @@ func MustSessionFromContext(ctx context.Context) Session {
 	return session
 }
 
-func (m *Manager) newSession(username string, userID int) (Session, error) {
+func (m *Manager) newSession(r *http.Request, username string, userID int) (Session, error) {
 	token, err := generateSessionToken()
 	if err != nil {
 		return Session{}, err
 	}
 
+	now := time.Now()
 	session := Session{
-		Token:     token,
-		UserID:    userID,
-		Username:  username,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		Token:      token,
+		ID:         hashToken(token),
+		UserID:     userID,
+		Username:   username,
+		ExpiresAt:  m.sessionExpiry(now, now),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IPAddress:  m.ClientIP(r),
+		UserAgent:  truncateRunes(r.UserAgent(), 512),
 	}
 
-	m.mu.Lock()
-	m.sessions[token] = session
-	m.mu.Unlock()
+	if err := m.store.Create(r.Context(), session); err != nil {
+		return Session{}, err
+	}
 
 	return session, nil
 }
 
-func (m *Manager) getSession(token string) (Session, bool) {
-	m.mu.RLock()
-	session, ok := m.sessions[token]
-	m.mu.RUnlock()
-	return session, ok
-}
-
-func (m *Manager) deleteSession(token string) {
-	m.mu.Lock()
-	delete(m.sessions, token)
-	m.mu.Unlock()
+func (m *Manager) deleteSession(ctx context.Context, token string) {
+	if err := m.store.Delete(ctx, token); err != nil {
+		log.Printf("auth: unable to delete session: %v", err)
+	}
 }
 
 func generateSessionToken() (string, error) {
@@ -316,4 +599,25 @@ type authPageData struct {
 	IsRegister bool
 	Username   string
 	IsAdmin    bool
+	OIDCName   string
+	CanReset   bool
+
+	// Registration.
+	InviteOnly   bool
+	InviteToken  string
+	InviteEmail  string
+	RequireEmail bool
+
+	// Password reset step.
+	ResetToken string
+	MinLength  int
+
+	// First-run setup.
+	SetupToken string
+
+	// Two-factor login step.
+	Enroll        bool
+	Secret        string
+	QRCode        template.URL
+	RecoveryCodes []string
 }
@@ -22,10 +22,15 @@ func TestLoginHandlerSuccess(t *testing.T) {
 		t.Fatalf("failed to hash password: %v", err)
 	}
 
-	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved, COALESCE\(deleted, false\) FROM users WHERE username = \$1`).
+	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved, COALESCE\(deleted, false\), COALESCE\(totp_enabled, false\), locked_until FROM users WHERE username = \$1`).
 		WithArgs("alice").
-		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted"}).
-			AddRow(7, string(hashed), true, false))
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted", "totp_enabled", "locked_until"}).
+			AddRow(7, string(hashed), true, false, false, nil))
+	// The legacy bcrypt hash is replaced with an Argon2id one.
+	upgraded := &captureArg{}
+	mock.ExpectExec(`UPDATE users SET password_hash = \$1 WHERE user_id = \$2 AND password_hash = \$3`).
+		WithArgs(upgraded, 7, string(hashed)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
 
 	manager := NewManager(mock)
 
@@ -47,8 +52,12 @@ func TestLoginHandlerSuccess(t *testing.T) {
 		t.Fatalf("expected session cookie to be set")
 	}
 
-	if len(manager.sessions) != 1 {
-		t.Fatalf("expected session to be stored, got %d", len(manager.sessions))
+	if n := manager.store.(*MemoryStore).Len(); n != 1 {
+		t.Fatalf("expected session to be stored, got %d", n)
+	}
+
+	if hash, _ := upgraded.value.(string); !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("expected an argon2id hash, got %q", hash)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -68,10 +77,10 @@ func TestLoginHandlerBlocksUnapprovedUser(t *testing.T) {
 		t.Fatalf("failed to hash password: %v", err)
 	}
 
-	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved, COALESCE\(deleted, false\) FROM users WHERE username = \$1`).
+	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved, COALESCE\(deleted, false\), COALESCE\(totp_enabled, false\), locked_until FROM users WHERE username = \$1`).
 		WithArgs("bob").
-		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted"}).
-			AddRow(9, string(hashed), false, false))
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted", "totp_enabled", "locked_until"}).
+			AddRow(9, string(hashed), false, false, false, nil))
 
 	manager := NewManager(mock)
 
@@ -111,7 +120,7 @@ func TestRegisterHandlerCreatesUser(t *testing.T) {
 
 	manager := NewManager(mock)
 
-	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader("username=carol&password=secret&confirm_password=secret"))
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader("username=carol&password=long+enough+secret&confirm_password=long+enough+secret"))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
 
@@ -0,0 +1,732 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	oidcCookieName = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+	oidcClockSkew  = 2 * time.Minute
+
+	// oidcPasswordHash is stored for accounts created through single sign-on.
+	// It is not a valid password hash, so password login always fails for them.
+	oidcPasswordHash = "!"
+)
+
+var (
+	errOIDCTokenInvalid = errors.New("oidc: invalid id token")
+	errOIDCUnknownKey   = errors.New("oidc: unknown signing key")
+)
+
+// OIDCConfig configures single sign-on against an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// ProviderName is shown on the login button.
+	ProviderName string
+
+	// GroupsClaim names the ID token claim that lists the user's groups.
+	// GroupMap optionally translates claim values to SampleDB group names;
+	// unmapped values are matched against the groups table as-is.
+	GroupsClaim string
+	GroupMap    map[string]string
+
+	// LinkExisting attaches a first-time SSO login to an existing local
+	// account with the same username instead of creating a new one.
+	LinkExisting bool
+
+	HTTPClient *http.Client
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider caches discovery metadata and signing keys. Discovery happens
+// lazily so an unreachable provider does not prevent the app from starting.
+type oidcProvider struct {
+	cfg OIDCConfig
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+// oidcFlow is the per-login state kept in a short-lived cookie between the
+// redirect to the provider and the callback.
+type oidcFlow struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+}
+
+type oidcClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	AuthorizedParty   string          `json:"azp"`
+	Expiry            int64           `json:"exp"`
+	IssuedAt          int64           `json:"iat"`
+	Nonce             string          `json:"nonce"`
+	PreferredUsername string          `json:"preferred_username"`
+	Email             string          `json:"email"`
+
+	raw map[string]json.RawMessage
+}
+
+// EnableOIDC turns on single sign-on with the given provider.
+func (m *Manager) EnableOIDC(cfg OIDCConfig) {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.ProviderName == "" {
+		cfg.ProviderName = "Single Sign-On"
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	m.oidc = &oidcProvider{cfg: cfg}
+}
+
+// OIDCLoginHandler redirects the browser to the provider's authorization
+// endpoint using the authorization code flow with PKCE.
+func (m *Manager) OIDCLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.oidc == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		meta, err := m.oidc.metadata(r.Context())
+		if err != nil {
+			log.Printf("auth: oidc discovery failed: %v", err)
+			http.Redirect(w, r, "/login?error=Single+sign-on+is+currently+unavailable", http.StatusSeeOther)
+			return
+		}
+
+		var flow oidcFlow
+		for _, dst := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+			if *dst, err = randomURLString(32); err != nil {
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		encoded, err := json.Marshal(flow)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		// SameSite=Lax is required: the callback is a top-level navigation
+		// initiated by the provider's site.
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcCookieName,
+			Value:    base64.RawURLEncoding.EncodeToString(encoded),
+			Path:     "/login/oidc",
+			MaxAge:   int(oidcFlowTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   m.cookieSecure,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		challenge := sha256.Sum256([]byte(flow.Verifier))
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {m.oidc.cfg.ClientID},
+			"redirect_uri":          {m.oidc.cfg.RedirectURL},
+			"scope":                 {strings.Join(m.oidc.cfg.Scopes, " ")},
+			"state":                 {flow.State},
+			"nonce":                 {flow.Nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+
+		target := meta.AuthorizationEndpoint
+		if strings.Contains(target, "?") {
+			target += "&" + query.Encode()
+		} else {
+			target += "?" + query.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler completes the login: it checks the state, redeems the
+// code, verifies the ID token, and signs in (or provisions) the local user.
+func (m *Manager) OIDCCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.oidc == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		flow, ok := m.takeOIDCFlow(w, r)
+		if !ok || r.URL.Query().Get("state") != flow.State {
+			http.Redirect(w, r, "/login?error=Your+sign-in+attempt+expired", http.StatusSeeOther)
+			return
+		}
+
+		if errCode := r.URL.Query().Get("error"); errCode != "" {
+			log.Printf("auth: oidc provider returned error %q: %s", errCode, r.URL.Query().Get("error_description"))
+			http.Redirect(w, r, "/login?error=Single+sign-on+was+cancelled+or+denied", http.StatusSeeOther)
+			return
+		}
+
+		claims, err := m.oidc.exchange(r.Context(), r.URL.Query().Get("code"), flow)
+		if err != nil {
+			log.Printf("auth: oidc login failed: %v", err)
+			http.Redirect(w, r, "/login?error=Single+sign-on+failed", http.StatusSeeOther)
+			return
+		}
+
+		user, created, err := m.oidcUser(r.Context(), claims)
+		if err != nil {
+			log.Printf("auth: unable to provision oidc user %q: %v", claims.Subject, err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := m.syncOIDCGroup(r.Context(), user.id, claims.groups(m.oidc.cfg.GroupsClaim)); err != nil {
+			log.Printf("auth: unable to map oidc groups for user %d: %v", user.id, err)
+		}
+
+		switch {
+		case user.deleted:
+			http.Redirect(w, r, "/login?error=Account+is+disabled", http.StatusSeeOther)
+		case created && !user.approved:
+			http.Redirect(w, r, "/login?success=Account+created.+Please+wait+for+admin+approval", http.StatusSeeOther)
+		case !user.approved:
+			http.Redirect(w, r, "/login?error=Your+account+is+pending+approval", http.StatusSeeOther)
+		case user.lockedUntil != nil && m.limiter.Now().Before(*user.lockedUntil):
+			http.Redirect(w, r, "/login?error=Account+is+temporarily+locked.+Try+again+later+or+contact+an+administrator", http.StatusSeeOther)
+		default:
+			next, err := m.completeLogin(w, r, user.username, user.id, user.totpEnabled)
+			if err != nil {
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			sameSiteRedirect(w, next)
+		}
+	}
+}
+
+// sameSiteRedirectPage moves on to a page of this site from the page itself.
+var sameSiteRedirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0;url={{.}}">
+<title>Signing in…</title>
+</head>
+<body>
+<p>Signing in… <a href="{{.}}">Continue</a></p>
+</body>
+</html>
+`))
+
+// sameSiteRedirect sends the browser on to target through a page instead of
+// a redirect. The callback is reached by a navigation started on the
+// provider's site, and browsers count redirects that follow it as
+// cross-site, so they would withhold the SameSite=Strict session and
+// challenge cookies just set. A navigation started by this page is
+// same-site.
+func sameSiteRedirect(w http.ResponseWriter, target string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := sameSiteRedirectPage.Execute(w, target); err != nil {
+		log.Printf("auth: unable to render redirect page: %v", err)
+	}
+}
+
+// OIDCProviderName returns the label for the SSO button, or "" when SSO is off.
+func (m *Manager) OIDCProviderName() string {
+	if m.oidc == nil {
+		return ""
+	}
+	return m.oidc.cfg.ProviderName
+}
+
+type oidcLocalUser struct {
+	id          int
+	username    string
+	approved    bool
+	deleted     bool
+	totpEnabled bool
+	lockedUntil *time.Time
+}
+
+const oidcUserColumns = `user_id, username, COALESCE(is_approved, false), COALESCE(deleted, false),
+        COALESCE(totp_enabled, false), locked_until`
+
+func scanOIDCUser(row pgx.Row) (oidcLocalUser, error) {
+	var u oidcLocalUser
+	err := row.Scan(&u.id, &u.username, &u.approved, &u.deleted, &u.totpEnabled, &u.lockedUntil)
+	return u, err
+}
+
+// oidcUser finds the local account bound to the token's subject, creating an
+// unapproved one on first login.
+func (m *Manager) oidcUser(ctx context.Context, claims *oidcClaims) (oidcLocalUser, bool, error) {
+	user, err := scanOIDCUser(m.db.QueryRow(ctx,
+		"SELECT "+oidcUserColumns+" FROM users WHERE oidc_subject = $1", claims.Subject))
+	if err == nil {
+		return user, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return oidcLocalUser{}, false, err
+	}
+
+	base := claims.username()
+
+	if m.oidc.cfg.LinkExisting {
+		user, err := scanOIDCUser(m.db.QueryRow(ctx,
+			"SELECT "+oidcUserColumns+" FROM users WHERE username = $1 AND oidc_subject IS NULL", base))
+		if err == nil {
+			_, err = m.db.Exec(ctx, "UPDATE users SET oidc_subject = $1 WHERE user_id = $2", claims.Subject, user.id)
+			return user, false, err
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return oidcLocalUser{}, false, err
+		}
+	}
+
+	username, err := m.availableUsername(ctx, base)
+	if err != nil {
+		return oidcLocalUser{}, false, err
+	}
+
+	user = oidcLocalUser{username: username}
+	err = m.db.QueryRow(ctx,
+		`INSERT INTO users (username, password_hash, is_approved, oidc_subject)
+         VALUES ($1, $2, false, $3)
+         RETURNING user_id`,
+		username, oidcPasswordHash, claims.Subject).Scan(&user.id)
+	if err != nil {
+		return oidcLocalUser{}, false, fmt.Errorf("create user: %w", err)
+	}
+	log.Printf("auth: created account %q for oidc subject %q; awaiting approval", username, claims.Subject)
+	return user, true, nil
+}
+
+func (m *Manager) availableUsername(ctx context.Context, base string) (string, error) {
+	candidate := base
+	for i := 2; i < 100; i++ {
+		var exists bool
+		if err := m.db.QueryRow(ctx,
+			"SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", candidate).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		suffix := fmt.Sprintf("-%d", i)
+		candidate = truncateRunes(base, 50-len(suffix)) + suffix
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+// syncOIDCGroup assigns the first claimed group that exists in the groups
+// table. Users whose claims match no group keep their current group.
+func (m *Manager) syncOIDCGroup(ctx context.Context, userID int, claimed []string) error {
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	wanted := make([]string, 0, len(claimed))
+	for _, g := range claimed {
+		if mapped, ok := m.oidc.cfg.GroupMap[g]; ok {
+			g = mapped
+		}
+		if g = strings.TrimSpace(g); g != "" {
+			wanted = append(wanted, strings.ToLower(g))
+		}
+	}
+
+	rows, err := m.db.Query(ctx, "SELECT name FROM groups WHERE lower(name) = ANY($1)", wanted)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]string)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		known[strings.ToLower(name)] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, g := range wanted {
+		if name, ok := known[g]; ok {
+			_, err := m.db.Exec(ctx, `UPDATE users SET "group" = $1 WHERE user_id = $2`, name, userID)
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) takeOIDCFlow(w http.ResponseWriter, r *http.Request) (oidcFlow, bool) {
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return oidcFlow{}, false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    "",
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	raw, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return oidcFlow{}, false
+	}
+	var flow oidcFlow
+	if err := json.Unmarshal(raw, &flow); err != nil || flow.State == "" || flow.Verifier == "" {
+		return oidcFlow{}, false
+	}
+	return flow, true
+}
+
+func (p *oidcProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta oidcMetadata
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// exchange redeems the authorization code and returns the verified claims of
+// the ID token.
+func (p *oidcProvider) exchange(ctx context.Context, code string, flow oidcFlow) (*oidcClaims, error) {
+	if code == "" {
+		return nil, errors.New("oidc: missing authorization code")
+	}
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {flow.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("oidc: decode token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokens.IDToken, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != flow.Nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", errOIDCTokenInvalid)
+	}
+	return claims, nil
+}
+
+// verifyIDToken checks the RS256 signature and the standard claims.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, token string, now time.Time) (*oidcClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", errOIDCTokenInvalid)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", errOIDCTokenInvalid, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", errOIDCTokenInvalid)
+	}
+
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: signature mismatch", errOIDCTokenInvalid)
+	}
+
+	claims := &oidcClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if err := decodeSegment(parts[1], &claims.raw); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", errOIDCTokenInvalid, claims.Issuer)
+	case !claims.hasAudience(p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: token not issued for this client", errOIDCTokenInvalid)
+	case claims.AuthorizedParty != "" && claims.AuthorizedParty != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party", errOIDCTokenInvalid)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: token expired", errOIDCTokenInvalid)
+	case claims.IssuedAt > 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: token issued in the future", errOIDCTokenInvalid)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", errOIDCTokenInvalid)
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider key with the given ID, refreshing the key
+// set at most once a minute when an unknown key is requested.
+func (p *oidcProvider) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysFetched) > time.Minute
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, errOIDCUnknownKey
+	}
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, errOIDCUnknownKey
+}
+
+// lookupKey must be called with p.mu held. Tokens without a key ID are
+// accepted only when the provider publishes a single key.
+func (p *oidcProvider) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, target string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: fetch %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: fetch %s: %s", target, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst); err != nil {
+		return fmt.Errorf("oidc: decode %s: %w", target, err)
+	}
+	return nil
+}
+
+func (c *oidcClaims) hasAudience(clientID string) bool {
+	var single string
+	if err := json.Unmarshal(c.Audience, &single); err == nil {
+		return single == clientID
+	}
+	var many []string
+	if err := json.Unmarshal(c.Audience, &many); err == nil {
+		for _, aud := range many {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groups returns the values of the configured groups claim, which providers
+// send either as a list or as a single string.
+func (c *oidcClaims) groups(claim string) []string {
+	raw, ok := c.raw[claim]
+	if !ok {
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		return many
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil && single != "" {
+		return []string{single}
+	}
+	return nil
+}
+
+// username picks a local username for a new account.
+func (c *oidcClaims) username() string {
+	name := strings.TrimSpace(c.PreferredUsername)
+	if name == "" {
+		name, _, _ = strings.Cut(strings.TrimSpace(c.Email), "@")
+	}
+	if name == "" {
+		name = "user"
+	}
+	return truncateRunes(name, 50)
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: bad segment encoding", errOIDCTokenInvalid)
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("%w: bad segment: %v", errOIDCTokenInvalid, err)
+	}
+	return nil
+}
+
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func truncateRunes(s string, max int) string {
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max])
+	}
+	return s
+}
@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+// fakeOIDCProvider is a minimal OpenID provider that issues RS256 ID tokens
+// for a single pre-arranged authorization code.
+type fakeOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu        sync.Mutex
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	p := &fakeOIDCProvider{t: t, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.handleToken)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "sampledb" || pass != "s3cret" {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") != "good-code" {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+		http.Error(w, `{"error":"invalid_grant","error_description":"PKCE verification failed"}`, http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     p.sign(p.claims),
+	})
+}
+
+func (p *fakeOIDCProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatalf("failed to sign token: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (p *fakeOIDCProvider) baseClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": p.server.URL,
+		"aud": "sampledb",
+		"sub": "subject-123",
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// startLogin runs the redirect step and arranges for the provider to issue a
+// token with claims for the resulting authorization request.
+func (p *fakeOIDCProvider) startLogin(t *testing.T, manager *Manager, claims map[string]interface{}) (state string, cookie *http.Cookie) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	manager.OIDCLoginHandler()(rr, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected redirect to provider, got %d", rr.Code)
+	}
+
+	target, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(target.String(), p.server.URL+"/authorize?") {
+		t.Fatalf("unexpected authorization URL %q", rr.Header().Get("Location"))
+	}
+	q := target.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "sampledb" || q.Get("response_type") != "code" {
+		t.Fatalf("authorization request missing PKCE or client parameters: %v", q)
+	}
+
+	claims["nonce"] = q.Get("nonce")
+	p.mu.Lock()
+	p.challenge = q.Get("code_challenge")
+	p.claims = claims
+	p.mu.Unlock()
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == oidcCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatalf("expected flow cookie to be set")
+	}
+	return q.Get("state"), cookie
+}
+
+func newOIDCTestManager(t *testing.T, p *fakeOIDCProvider) (*Manager, pgxmock.PgxPoolIface) {
+	t.Helper()
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	t.Cleanup(mock.Close)
+
+	manager := NewManager(mock)
+	manager.EnableOIDC(OIDCConfig{
+		Issuer:       p.server.URL,
+		ClientID:     "sampledb",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://sampledb.test/login/oidc/callback",
+		GroupMap:     map[string]string{"cn=afm,ou=labs": "AFM Team"},
+		HTTPClient:   p.server.Client(),
+	})
+	return manager, mock
+}
+
+func callback(manager *Manager, state, code string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	manager.OIDCCallbackHandler()(rr, req)
+	return rr
+}
+
+var oidcUserRowColumns = []string{"user_id", "username", "is_approved", "deleted", "totp_enabled", "locked_until"}
+
+func TestOIDCLoginProvisionsUnapprovedUser(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	manager, mock := newOIDCTestManager(t, provider)
+
+	claims := provider.baseClaims()
+	claims["preferred_username"] = "carol"
+	claims["groups"] = []string{"staff", "cn=afm,ou=labs"}
+	state, cookie := provider.startLogin(t, manager, claims)
+
+	mock.ExpectQuery(`FROM users WHERE oidc_subject = \$1`).
+		WithArgs("subject-123").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE username = \$1\)`).
+		WithArgs("carol").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE username = \$1\)`).
+		WithArgs("carol-2").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs("carol-2", oidcPasswordHash, "subject-123").
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(21))
+	mock.ExpectQuery(`SELECT name FROM groups WHERE lower\(name\) = ANY\(\$1\)`).
+		WithArgs([]string{"staff", "afm team"}).
+		WillReturnRows(pgxmock.NewRows([]string{"name"}).AddRow("AFM Team"))
+	mock.ExpectExec(`UPDATE users SET "group" = \$1 WHERE user_id = \$2`).
+		WithArgs("AFM Team", 21).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	rr := callback(manager, state, "good-code", cookie)
+
+	if loc := rr.Header().Get("Location"); !strings.Contains(loc, "wait+for+admin+approval") {
+		t.Fatalf("expected pending approval notice, got %q", loc)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session_token" {
+			t.Fatalf("unapproved user must not receive a session")
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestOIDCLoginStartsSessionForApprovedUser(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	manager, mock := newOIDCTestManager(t, provider)
+
+	state, cookie := provider.startLogin(t, manager, provider.baseClaims())
+
+	mock.ExpectQuery(`FROM users WHERE oidc_subject = \$1`).
+		WithArgs("subject-123").
+		WillReturnRows(pgxmock.NewRows(oidcUserRowColumns).
+			AddRow(4, "dana", true, false, false, nil))
+
+	rr := callback(manager, state, "good-code", cookie)
+
+	// The callback ends a navigation from the provider's site, so it moves on
+	// through a page: a redirect would not carry the Strict session cookie.
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `content="0;url=/"`) {
+		t.Fatalf("expected a same-site redirect page to /, got %d %q", rr.Code, rr.Body.String())
+	}
+	session := responseCookie(rr, "session_token")
+	if session == nil || session.Value == "" {
+		t.Fatalf("expected session cookie")
+	}
+	if !session.HttpOnly || session.SameSite != http.SameSiteStrictMode || session.Path != "/" {
+		t.Errorf("unexpected session cookie attributes: %+v", session)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestOIDCLoginHandsOverToSecondFactor(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	manager, mock := newOIDCTestManager(t, provider)
+
+	state, cookie := provider.startLogin(t, manager, provider.baseClaims())
+
+	mock.ExpectQuery(`FROM users WHERE oidc_subject = \$1`).
+		WithArgs("subject-123").
+		WillReturnRows(pgxmock.NewRows(oidcUserRowColumns).
+			AddRow(4, "dana", true, false, true, nil))
+	mock.ExpectExec(`INSERT INTO login_challenges`).
+		WithArgs(pgxmock.AnyArg(), 4, "dana", challengeVerify, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	rr := callback(manager, state, "good-code", cookie)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `content="0;url=/login/2fa"`) {
+		t.Fatalf("expected a same-site redirect page to /login/2fa, got %d %q", rr.Code, rr.Body.String())
+	}
+	challenge := responseCookie(rr, challengeCookieName)
+	if challenge == nil || challenge.Value == "" {
+		t.Fatalf("expected challenge cookie")
+	}
+	if !challenge.HttpOnly || challenge.SameSite != http.SameSiteStrictMode || challenge.Path != "/login" {
+		t.Errorf("unexpected challenge cookie attributes: %+v", challenge)
+	}
+	if responseCookie(rr, "session_token") != nil {
+		t.Errorf("no session before the second factor")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func responseCookie(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	manager, mock := newOIDCTestManager(t, provider)
+
+	_, cookie := provider.startLogin(t, manager, provider.baseClaims())
+	rr := callback(manager, "forged-state", "good-code", cookie)
+
+	if loc := rr.Header().Get("Location"); !strings.Contains(loc, "expired") {
+		t.Fatalf("expected expired attempt error, got %q", loc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unexpected database access: %v", err)
+	}
+}
+
+func TestOIDCVerifyIDTokenRejectsBadTokens(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	manager, _ := newOIDCTestManager(t, provider)
+	ctx := context.Background()
+
+	if _, err := manager.oidc.verifyIDToken(ctx, provider.sign(provider.baseClaims()), time.Now()); err != nil {
+		t.Fatalf("expected valid token to verify, got %v", err)
+	}
+
+	cases := map[string]func(map[string]interface{}){
+		"wrong audience": func(c map[string]interface{}) { c["aud"] = []string{"someone-else"} },
+		"wrong issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no subject":     func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, mutate := range cases {
+		claims := provider.baseClaims()
+		mutate(claims)
+		if _, err := manager.oidc.verifyIDToken(ctx, provider.sign(claims), time.Now()); !errors.Is(err, errOIDCTokenInvalid) {
+			t.Fatalf("%s: expected errOIDCTokenInvalid, got %v", name, err)
+		}
+	}
+
+	token := provider.sign(provider.baseClaims())
+	tampered := token[:len(token)-4] + "AAAA"
+	if _, err := manager.oidc.verifyIDToken(ctx, tampered, time.Now()); !errors.Is(err, errOIDCTokenInvalid) {
+		t.Fatalf("expected tampered signature to be rejected, got %v", err)
+	}
+}
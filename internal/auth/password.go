@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/passhash"
+)
+
+// changePasswordPath is where users are sent while a password change is
+// pending.
+const changePasswordPath = "/change-password"
+
+// bcryptMaxLength is the longest password bcrypt can hash. It is enforced
+// whatever the configured algorithm so that switching back to bcrypt never
+// locks anyone out.
+const bcryptMaxLength = 72
+
+// PasswordPolicyError explains why a new password was rejected. Its message
+// is safe to show to the user.
+type PasswordPolicyError struct {
+	msg string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.msg
+}
+
+// ErrPasswordReused is returned when a new password matches a recent one.
+var ErrPasswordReused = &PasswordPolicyError{"Choose a password you have not used recently"}
+
+// PasswordPolicy describes what a new password must satisfy.
+type PasswordPolicy struct {
+	MinLength int
+
+	// History is how many recent passwords, the current one included, may
+	// not be reused. Zero only forbids keeping the current password.
+	History int
+
+	banned map[string]struct{}
+}
+
+// DefaultPasswordPolicy returns the policy used when none is configured.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: 8,
+		History:   5,
+	}
+}
+
+// LoadBannedPasswords adds the passwords listed in path, one per line, to
+// the banned list. Blank lines and lines starting with # are ignored.
+// Matching is case-insensitive.
+func (p *PasswordPolicy) LoadBannedPasswords(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if p.banned == nil {
+		p.banned = make(map[string]struct{})
+	}
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.banned[strings.ToLower(line)] = struct{}{}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read banned passwords: %w", err)
+	}
+	return count, nil
+}
+
+// Check validates password for username against everything except the
+// reuse rule, which needs the stored history.
+func (p PasswordPolicy) Check(username, password string) error {
+	minLength := p.MinLength
+	if minLength < 1 {
+		minLength = 1
+	}
+	if len([]rune(password)) < minLength {
+		return &PasswordPolicyError{fmt.Sprintf("Password must be at least %d characters long", minLength)}
+	}
+	if len(password) > bcryptMaxLength {
+		return &PasswordPolicyError{fmt.Sprintf("Password must be at most %d bytes long", bcryptMaxLength)}
+	}
+	lower := strings.ToLower(password)
+	if username != "" && lower == strings.ToLower(username) {
+		return &PasswordPolicyError{"Password must not be the same as your username"}
+	}
+	if _, ok := p.banned[lower]; ok {
+		return &PasswordPolicyError{"That password is too common. Choose a different one"}
+	}
+	return nil
+}
+
+// SetPasswordPolicy replaces the default password policy.
+func (m *Manager) SetPasswordPolicy(p PasswordPolicy) {
+	m.policy = p
+}
+
+// PasswordPolicy returns the policy new passwords are checked against.
+func (m *Manager) PasswordPolicy() PasswordPolicy {
+	return m.policy
+}
+
+// SetPasswordHasher replaces the default Argon2id hasher. Existing hashes
+// made differently are upgraded when their owner next signs in.
+func (m *Manager) SetPasswordHasher(h passhash.Hasher) {
+	m.hasher = h
+}
+
+// HashPassword hashes password with the configured algorithm.
+func (m *Manager) HashPassword(password string) (string, error) {
+	return m.hasher.Hash(password)
+}
+
+// upgradePasswordHash rehashes password when stored was made with an older
+// algorithm or weaker parameters than the current hasher uses. The update
+// is skipped if the hash changed since it was read.
+func (m *Manager) upgradePasswordHash(ctx context.Context, userID int, stored, password string) {
+	if !m.hasher.NeedsRehash(stored) {
+		return
+	}
+	hashed, err := m.hasher.Hash(password)
+	if err != nil {
+		log.Printf("auth: unable to rehash password of user %d: %v", userID, err)
+		return
+	}
+	if _, err := m.db.Exec(ctx,
+		"UPDATE users SET password_hash = $1 WHERE user_id = $2 AND password_hash = $3",
+		hashed, userID, stored); err != nil {
+		log.Printf("auth: unable to upgrade password hash of user %d: %v", userID, err)
+	}
+}
+
+// ChangePassword sets a password the user chose themselves after checking
+// it against the policy, and clears any pending forced change. It returns a
+// *PasswordPolicyError when the password is not acceptable and pgx.ErrNoRows
+// when the user does not exist.
+func (m *Manager) ChangePassword(ctx context.Context, userID int, password string) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := m.storePassword(ctx, tx, userID, password, false, true); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SetTemporaryPassword stores an administrator-issued password and makes
+// the user choose a new one at their next request.
+func (m *Manager) SetTemporaryPassword(ctx context.Context, userID int, password string) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := m.storePassword(ctx, tx, userID, password, true, false); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// PasswordChangeRequired reports whether userID must change their password
+// before doing anything else.
+func (m *Manager) PasswordChangeRequired(ctx context.Context, userID int) (bool, error) {
+	var required bool
+	err := m.db.QueryRow(ctx,
+		"SELECT COALESCE(must_change_password, false) FROM users WHERE user_id = $1",
+		userID).Scan(&required)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return required, err
+}
+
+// requirePasswordChange redirects to the change-password page while a
+// forced change is pending. It returns true when it has written a response.
+func (m *Manager) requirePasswordChange(w http.ResponseWriter, r *http.Request, userID int) bool {
+	if r.URL.Path == changePasswordPath || r.URL.Path == "/logout" {
+		return false
+	}
+	required, err := m.PasswordChangeRequired(r.Context(), userID)
+	if err != nil {
+		log.Printf("auth: unable to check password state for user %d: %v", userID, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return true
+	}
+	if !required {
+		return false
+	}
+	http.Redirect(w, r, changePasswordPath, http.StatusSeeOther)
+	return true
+}
+
+// storePassword hashes and saves password for userID inside tx, keeping
+// the previous hash in password_history. With enforce set the policy and
+// reuse rules are applied first. It returns the user's name.
+func (m *Manager) storePassword(ctx context.Context, tx pgx.Tx, userID int, password string, mustChange, enforce bool) (string, error) {
+	var username, currentHash string
+	err := tx.QueryRow(ctx,
+		`SELECT username, password_hash
+         FROM users
+         WHERE user_id = $1
+           AND COALESCE(deleted, false) = false
+         FOR UPDATE`,
+		userID).Scan(&username, &currentHash)
+	if err != nil {
+		return "", err
+	}
+
+	if enforce {
+		if err := m.policy.Check(username, password); err != nil {
+			return "", err
+		}
+		recent, err := recentPasswordHashes(ctx, tx, userID, m.policy.History-1)
+		if err != nil {
+			return "", err
+		}
+		for _, hash := range append([]string{currentHash}, recent...) {
+			if reused, _ := passhash.Verify(hash, password); reused {
+				return "", ErrPasswordReused
+			}
+		}
+	}
+
+	hashed, err := m.hasher.Hash(password)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE users SET password_hash = $1, must_change_password = $2 WHERE user_id = $3",
+		hashed, mustChange, userID); err != nil {
+		return "", err
+	}
+
+	// The current hash joins the history; SSO-only accounts have none.
+	keep := m.policy.History - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if keep > 0 && currentHash != oidcPasswordHash {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2)",
+			userID, currentHash); err != nil {
+			return "", err
+		}
+	}
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM password_history
+         WHERE user_id = $1
+           AND history_id NOT IN (
+               SELECT history_id FROM password_history
+               WHERE user_id = $1
+               ORDER BY history_id DESC
+               LIMIT $2)`,
+		userID, keep); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func recentPasswordHashes(ctx context.Context, tx pgx.Tx, userID, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	rows, err := tx.Query(ctx,
+		"SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY history_id DESC LIMIT $2",
+		userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func mustHash(t *testing.T, password string) string {
+	t.Helper()
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	return string(hashed)
+}
+
+// expectStorePassword sets up the queries storePassword runs with the
+// default policy when the new password passes every check.
+func expectStorePassword(t *testing.T, mock pgxmock.PgxPoolIface, userID int, username, oldPassword string) {
+	t.Helper()
+	oldHash := mustHash(t, oldPassword)
+
+	mock.ExpectQuery(`SELECT username, password_hash\s+FROM users\s+WHERE user_id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(pgxmock.NewRows([]string{"username", "password_hash"}).AddRow(username, oldHash))
+	mock.ExpectQuery(`SELECT password_hash FROM password_history WHERE user_id = \$1`).
+		WithArgs(userID, 4).
+		WillReturnRows(pgxmock.NewRows([]string{"password_hash"}))
+	mock.ExpectExec(`UPDATE users SET password_hash = \$1, must_change_password = \$2 WHERE user_id = \$3`).
+		WithArgs(pgxmock.AnyArg(), false, userID).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(`INSERT INTO password_history \(user_id, password_hash\)`).
+		WithArgs(userID, oldHash).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`DELETE FROM password_history\s+WHERE user_id = \$1\s+AND history_id NOT IN`).
+		WithArgs(userID, 4).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	if err := os.WriteFile(path, []byte("# common passwords\nPassword123\n\nletmein-please\n"), 0o600); err != nil {
+		t.Fatalf("write banned list: %v", err)
+	}
+
+	policy := DefaultPasswordPolicy()
+	policy.MinLength = 10
+	count, err := policy.LoadBannedPasswords(path)
+	if err != nil || count != 2 {
+		t.Fatalf("LoadBannedPasswords = %d, %v", count, err)
+	}
+
+	cases := []struct {
+		password string
+		ok       bool
+	}{
+		{"short", false},
+		{"password123", false},
+		{"LETMEIN-PLEASE", false},
+		{"Carol.Jones", false},
+		{string(make([]byte, 73)), false},
+		{"purple monkey dishwasher", true},
+	}
+	for _, c := range cases {
+		err := policy.Check("carol.jones", c.password)
+		var policyErr *PasswordPolicyError
+		if c.ok && err != nil {
+			t.Errorf("Check(%q) rejected: %v", c.password, err)
+		}
+		if !c.ok && !errors.As(err, &policyErr) {
+			t.Errorf("Check(%q) = %v, want a policy error", c.password, err)
+		}
+	}
+}
+
+func TestChangePasswordRejectsRecentPassword(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT username, password_hash\s+FROM users`).
+		WithArgs(3).
+		WillReturnRows(pgxmock.NewRows([]string{"username", "password_hash"}).
+			AddRow("bob", mustHash(t, "current password")))
+	mock.ExpectQuery(`SELECT password_hash FROM password_history WHERE user_id = \$1`).
+		WithArgs(3, 4).
+		WillReturnRows(pgxmock.NewRows([]string{"password_hash"}).
+			AddRow(mustHash(t, "previous password")).
+			AddRow(mustHash(t, "older password")))
+	mock.ExpectRollback()
+
+	err = NewManager(mock).ChangePassword(context.Background(), 3, "older password")
+	if !errors.Is(err, ErrPasswordReused) {
+		t.Fatalf("expected ErrPasswordReused, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRequireAuthForcesPasswordChange(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+	session, err := manager.newSession(httptest.NewRequest(http.MethodGet, "/login", nil), "bob", 3)
+	if err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT COALESCE\(must_change_password, false\) FROM users WHERE user_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(pgxmock.NewRows([]string{"must_change_password"}).AddRow(true))
+
+	reached := ""
+	handler := manager.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		reached = r.URL.Path
+	})
+
+	for _, path := range []string{"/samples/new", "/change-password"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: session.Token})
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if path == "/change-password" {
+			if reached != path {
+				t.Fatalf("the change-password page must stay reachable")
+			}
+			continue
+		}
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/change-password" {
+			t.Fatalf("expected redirect to /change-password, got %d %q", rr.Code, rr.Header().Get("Location"))
+		}
+		if reached != "" {
+			t.Fatalf("handler should not run while a password change is pending")
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
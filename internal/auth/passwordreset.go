@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/audit"
+	"sampleDB/internal/mail"
+)
+
+const (
+	// passwordResetTTL is how long an emailed reset link stays valid.
+	passwordResetTTL = time.Hour
+
+	// passwordResetCooldown limits how often one account can be sent a link.
+	passwordResetCooldown = 5 * time.Minute
+)
+
+const forgotPasswordSent = "If that account exists and has an email address on file, we have sent it a link to reset the password."
+
+var errResetTokenInvalid = errors.New("auth: password reset link is invalid or has expired")
+
+// PasswordResetEnabled reports whether users can reset their own password,
+// which needs a mailer.
+func (m *Manager) PasswordResetEnabled() bool {
+	return m.MailEnabled()
+}
+
+// ForgotPasswordHandler asks for a username or email address and mails a
+// single-use reset link. The response never reveals whether an account
+// matched.
+func (m *Manager) ForgotPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.PasswordResetEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			m.renderPasswordResetPage(w, authPageData{
+				Error:   r.URL.Query().Get("error"),
+				Success: r.URL.Query().Get("success"),
+			})
+		case http.MethodPost:
+			identifier := strings.TrimSpace(r.FormValue("identifier"))
+			if identifier == "" {
+				http.Redirect(w, r, "/forgot-password?error=Enter+your+username+or+email+address", http.StatusSeeOther)
+				return
+			}
+			if err := m.sendPasswordReset(r, identifier); err != nil {
+				log.Printf("auth: password reset request failed: %v", err)
+			}
+			http.Redirect(w, r, "/login?success="+url.QueryEscape(forgotPasswordSent), http.StatusSeeOther)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// sendPasswordReset issues and mails a reset token when identifier matches
+// an active account with an email address on file.
+func (m *Manager) sendPasswordReset(r *http.Request, identifier string) error {
+	ctx := r.Context()
+
+	var (
+		userID   int
+		username string
+		email    string
+	)
+	err := m.db.QueryRow(ctx,
+		`SELECT user_id, username, email
+         FROM users
+         WHERE (username = $1 OR lower(email) = lower($1))
+           AND email IS NOT NULL AND email <> ''
+           AND is_approved
+           AND COALESCE(deleted, false) = false
+           AND password_hash <> $2
+         ORDER BY (username = $1) DESC
+         LIMIT 1`,
+		identifier, oidcPasswordHash).Scan(&userID, &username, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("look up account: %w", err)
+	}
+
+	var recent bool
+	err = m.db.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM password_resets WHERE user_id = $1 AND created_at > $2)",
+		userID, time.Now().Add(-passwordResetCooldown)).Scan(&recent)
+	if err != nil {
+		return fmt.Errorf("check recent resets: %w", err)
+	}
+	if recent {
+		return nil
+	}
+
+	token, err := generateSessionToken()
+	if err != nil {
+		return err
+	}
+	_, err = m.db.Exec(ctx,
+		`INSERT INTO password_resets (user_id, token_hash, ip_address, expires_at)
+         VALUES ($1, $2, $3, $4)`,
+		userID, hashToken(token), m.ClientIP(r), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return fmt.Errorf("store reset token: %w", err)
+	}
+
+	link := m.baseURL + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      email,
+		Subject: "Reset your SampleDB password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Someone asked to reset the password for your SampleDB account. "+
+			"Open the link below within %d minutes to choose a new one:\n\n%s\n\n"+
+			"The link works once. If you did not ask for this, you can ignore this email; "+
+			"your password has not been changed.\n",
+			username, int(passwordResetTTL.Minutes()), link),
+	}
+	if err := m.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("send reset email to user %d: %w", userID, err)
+	}
+	log.Printf("auth: sent password reset link to user %d", userID)
+	return nil
+}
+
+// ResetPasswordHandler serves the page behind an emailed reset link and
+// sets the new password. Every session of the user is revoked afterwards.
+func (m *Manager) ResetPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.PasswordResetEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+		// Keep the token out of Referer headers sent to linked resources.
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		token := r.FormValue("token")
+		expired := "/forgot-password?error=" + url.QueryEscape("That reset link is invalid or has expired. Request a new one.")
+
+		switch r.Method {
+		case http.MethodGet:
+			username, err := m.lookupResetToken(r.Context(), token)
+			if errors.Is(err, errResetTokenInvalid) {
+				http.Redirect(w, r, expired, http.StatusSeeOther)
+				return
+			}
+			if err != nil {
+				log.Printf("auth: unable to check reset token: %v", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			m.renderPasswordResetPage(w, authPageData{
+				Error:      r.URL.Query().Get("error"),
+				Username:   username,
+				ResetToken: token,
+				MinLength:  m.policy.MinLength,
+			})
+		case http.MethodPost:
+			password := r.FormValue("password")
+			retry := "/reset-password?token=" + url.QueryEscape(token) + "&error="
+			if password != r.FormValue("confirm_password") {
+				http.Redirect(w, r, retry+"Passwords+do+not+match", http.StatusSeeOther)
+				return
+			}
+
+			userID, username, err := m.resetPassword(r.Context(), token, password)
+			if errors.Is(err, errResetTokenInvalid) {
+				http.Redirect(w, r, expired, http.StatusSeeOther)
+				return
+			}
+			var policyErr *PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				http.Redirect(w, r, retry+url.QueryEscape(policyErr.Error()), http.StatusSeeOther)
+				return
+			}
+			if err != nil {
+				log.Printf("auth: password reset failed: %v", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+
+			m.RevokeUserSessions(userID)
+			m.limiter.Reset(username)
+			log.Printf("auth: user %d reset their password by email", userID)
+			m.RecordAudit(r, audit.Entry{
+				ActorID:    userID,
+				ActorName:  username,
+				Action:     audit.ActionPasswordReset,
+				TargetType: audit.TargetUser,
+				TargetID:   strconv.Itoa(userID),
+				TargetName: username,
+			})
+			http.Redirect(w, r, "/login?success=Your+password+has+been+reset.+Please+sign+in", http.StatusSeeOther)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// lookupResetToken returns the username a still-usable token belongs to.
+func (m *Manager) lookupResetToken(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", errResetTokenInvalid
+	}
+	var username string
+	err := m.db.QueryRow(ctx,
+		`SELECT u.username
+         FROM password_resets pr
+         JOIN users u ON u.user_id = pr.user_id
+         WHERE pr.token_hash = $1
+           AND pr.used_at IS NULL
+           AND pr.expires_at > $2
+           AND COALESCE(u.deleted, false) = false`,
+		hashToken(token), time.Now()).Scan(&username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errResetTokenInvalid
+	}
+	return username, err
+}
+
+// resetPassword consumes token and stores the new password. Other
+// outstanding links for the same user are invalidated and any lockout is
+// lifted. A password rejected by the policy leaves the token usable.
+func (m *Manager) resetPassword(ctx context.Context, token, password string) (int, string, error) {
+	if token == "" {
+		return 0, "", errResetTokenInvalid
+	}
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`UPDATE password_resets
+         SET used_at = $2
+         WHERE token_hash = $1
+           AND used_at IS NULL
+           AND expires_at > $2
+         RETURNING user_id`,
+		hashToken(token), time.Now()).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", errResetTokenInvalid
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	username, err := m.storePassword(ctx, tx, userID, password, false, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", errResetTokenInvalid
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET locked_until = NULL WHERE user_id = $1", userID); err != nil {
+		return 0, "", err
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL",
+		userID, time.Now()); err != nil {
+		return 0, "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", err
+	}
+	return userID, username, nil
+}
+
+func (m *Manager) renderPasswordResetPage(w http.ResponseWriter, data authPageData) {
+	tmpl, err := template.ParseFiles(m.templatePath("auth_base.html"), m.templatePath("login.html"), m.templatePath("password_reset.html"))
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	_ = tmpl.ExecuteTemplate(w, "auth_base", data)
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v3"
+
+	"sampleDB/internal/mail"
+)
+
+type fakeSender struct {
+	sent []mail.Message
+}
+
+func (s *fakeSender) Send(_ context.Context, msg mail.Message) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// captureArg matches any argument and remembers its value.
+type captureArg struct {
+	value interface{}
+}
+
+func (c *captureArg) Match(v interface{}) bool {
+	c.value = v
+	return true
+}
+
+func newResetTestManager(t *testing.T) (*Manager, pgxmock.PgxPoolIface, *fakeSender) {
+	t.Helper()
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	t.Cleanup(mock.Close)
+
+	sender := &fakeSender{}
+	manager := NewManager(mock)
+	manager.SetMailer(sender)
+	manager.SetBaseURL("https://samples.lab.example/")
+	return manager, mock, sender
+}
+
+func postForm(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestForgotPasswordEmailsSingleUseLink(t *testing.T) {
+	manager, mock, sender := newResetTestManager(t)
+
+	mock.ExpectQuery(`SELECT user_id, username, email\s+FROM users`).
+		WithArgs("alice@lab.example", oidcPasswordHash).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "username", "email"}).
+			AddRow(7, "alice", "alice@lab.example"))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM password_resets WHERE user_id = \$1`).
+		WithArgs(7, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	stored := &captureArg{}
+	mock.ExpectExec(`INSERT INTO password_resets`).
+		WithArgs(7, stored, pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	rr := postForm(manager.ForgotPasswordHandler(), "/forgot-password", url.Values{"identifier": {"alice@lab.example"}})
+
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/login?success=") {
+		t.Fatalf("expected redirect to login with notice, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("expected one email, got %d", len(sender.sent))
+	}
+	msg := sender.sent[0]
+	if msg.To != "alice@lab.example" {
+		t.Fatalf("email sent to %q", msg.To)
+	}
+
+	match := regexp.MustCompile(`https://samples\.lab\.example/reset-password\?token=(\S+)`).FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("email does not contain a reset link: %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("bad token in link: %v", err)
+	}
+	if stored.value != hashToken(token) {
+		t.Fatalf("database should hold the token digest, got %v", stored.value)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestForgotPasswordHidesUnknownAccounts(t *testing.T) {
+	manager, mock, sender := newResetTestManager(t)
+
+	mock.ExpectQuery(`SELECT user_id, username, email\s+FROM users`).
+		WithArgs("mallory", oidcPasswordHash).
+		WillReturnError(pgx.ErrNoRows)
+
+	rr := postForm(manager.ForgotPasswordHandler(), "/forgot-password", url.Values{"identifier": {"mallory"}})
+
+	if loc := rr.Header().Get("Location"); loc != "/login?success="+url.QueryEscape(forgotPasswordSent) {
+		t.Fatalf("unknown accounts must get the generic notice, got %q", loc)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("no email should be sent, got %d", len(sender.sent))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestResetPasswordRevokesSessions(t *testing.T) {
+	manager, mock, _ := newResetTestManager(t)
+
+	if _, err := manager.newSession(httptest.NewRequest(http.MethodGet, "/login", nil), "alice", 7); err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE password_resets\s+SET used_at = \$2\s+WHERE token_hash = \$1`).
+		WithArgs(hashToken("reset-token"), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(7))
+	expectStorePassword(t, mock, 7, "alice", "forgotten password")
+	mock.ExpectExec(`UPDATE users SET locked_until = NULL WHERE user_id = \$1`).
+		WithArgs(7).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(`UPDATE password_resets SET used_at = \$2 WHERE user_id = \$1 AND used_at IS NULL`).
+		WithArgs(7, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mock.ExpectCommit()
+
+	rr := postForm(manager.ResetPasswordHandler(), "/reset-password", url.Values{
+		"token":            {"reset-token"},
+		"password":         {"a much better password"},
+		"confirm_password": {"a much better password"},
+	})
+
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "/login?success=") {
+		t.Fatalf("expected redirect to login, got %d %q", rr.Code, loc)
+	}
+	if n := manager.store.(*MemoryStore).Len(); n != 0 {
+		t.Fatalf("expected sessions to be revoked, %d left", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestResetPasswordRejectsUsedOrExpiredToken(t *testing.T) {
+	manager, mock, _ := newResetTestManager(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE password_resets\s+SET used_at = \$2\s+WHERE token_hash = \$1`).
+		WithArgs(hashToken("stale-token"), pgxmock.AnyArg()).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectRollback()
+
+	rr := postForm(manager.ResetPasswordHandler(), "/reset-password", url.Values{
+		"token":            {"stale-token"},
+		"password":         {"a much better password"},
+		"confirm_password": {"a much better password"},
+	})
+
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "/forgot-password?error=") {
+		t.Fatalf("expected redirect back to the request form, got %q", loc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
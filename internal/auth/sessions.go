@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionIdleTimeout = 24 * time.Hour
+	defaultSessionMaxLifetime = 7 * 24 * time.Hour
+
+	// sessionTouchInterval limits how often activity is written back to the
+	// store for a busy session.
+	sessionTouchInterval = time.Minute
+)
+
+// SetSessionTimeouts configures sliding expiry: a session ends after idle
+// without requests, and never lives longer than max in total.
+func (m *Manager) SetSessionTimeouts(idle, max time.Duration) {
+	if idle > 0 {
+		m.idleTimeout = idle
+	}
+	if max > 0 {
+		m.maxLifetime = max
+	}
+}
+
+// sessionExpiry returns when a session created at created and last used at
+// seen expires.
+func (m *Manager) sessionExpiry(created, seen time.Time) time.Time {
+	expires := seen.Add(m.idleTimeout)
+	if limit := created.Add(m.maxLifetime); limit.Before(expires) {
+		return limit
+	}
+	return expires
+}
+
+// touchSession records activity on an authenticated request and slides the
+// session's expiry forward.
+func (m *Manager) touchSession(r *http.Request, session *Session) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = m.sessionExpiry(session.CreatedAt, now)
+	session.IPAddress = m.ClientIP(r)
+	if err := m.store.Touch(r.Context(), session.Token, now, session.ExpiresAt, session.IPAddress); err != nil {
+		log.Printf("auth: unable to record session activity: %v", err)
+	}
+}
+
+// ListSessions returns the active sessions of userID, or of every user when
+// userID is zero, most recently active first.
+func (m *Manager) ListSessions(ctx context.Context, userID int) ([]Session, error) {
+	return m.store.List(ctx, userID, time.Now())
+}
+
+// RevokeSession ends the session with the given ID. A non-zero userID
+// restricts the operation to that user's sessions. It reports whether a
+// session was removed.
+func (m *Manager) RevokeSession(ctx context.Context, id string, userID int) (bool, error) {
+	return m.store.DeleteID(ctx, id, userID)
+}
+
+// RevokeOtherSessions ends every session of the current user except current.
+func (m *Manager) RevokeOtherSessions(ctx context.Context, current Session) (int, error) {
+	sessions, err := m.store.List(ctx, current.UserID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, s := range sessions {
+		if s.ID == current.ID {
+			continue
+		}
+		ok, err := m.store.DeleteID(ctx, s.ID, current.UserID)
+		if err != nil {
+			return revoked, err
+		}
+		if ok {
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// Device summarises the session's user agent as "Browser on OS".
+func (s Session) Device() string {
+	ua := s.UserAgent
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"python-requests/", "Python"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
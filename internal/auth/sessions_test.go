@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestSessionExpirySlidesUntilMaxLifetime(t *testing.T) {
+	manager := NewManager(nil)
+	manager.SetSessionTimeouts(2*time.Hour, 10*time.Hour)
+
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	if got := manager.sessionExpiry(created, created.Add(time.Hour)); !got.Equal(created.Add(3 * time.Hour)) {
+		t.Fatalf("expiry should slide with activity, got %v", got)
+	}
+	if got := manager.sessionExpiry(created, created.Add(9*time.Hour)); !got.Equal(created.Add(10 * time.Hour)) {
+		t.Fatalf("expiry should be capped at the maximum lifetime, got %v", got)
+	}
+}
+
+func TestRequireAuthRecordsSessionActivity(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+	created := time.Now().Add(-3 * time.Hour)
+	store := manager.store.(*MemoryStore)
+	session := Session{
+		Token: "tok", UserID: 3, Username: "bob",
+		CreatedAt: created, LastSeenAt: created, ExpiresAt: manager.sessionExpiry(created, created),
+		IPAddress: "192.0.2.1",
+	}
+	if err := store.Create(context.Background(), session); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT COALESCE\(must_change_password, false\) FROM users WHERE user_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(pgxmock.NewRows([]string{"must_change_password"}).AddRow(false))
+
+	handler := manager.RequireAuth(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(http.MethodGet, "/samples", nil)
+	req.RemoteAddr = "198.51.100.7:4242"
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: "tok"})
+	handler(httptest.NewRecorder(), req)
+
+	got, ok, _ := store.Get(context.Background(), "tok")
+	if !ok {
+		t.Fatalf("session disappeared")
+	}
+	if !got.LastSeenAt.After(created) || !got.ExpiresAt.After(session.ExpiresAt) {
+		t.Fatalf("activity was not recorded: %+v", got)
+	}
+	if got.IPAddress != "198.51.100.7" {
+		t.Fatalf("expected the latest client IP, got %q", got.IPAddress)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRevokeSessionOnlyAffectsOwner(t *testing.T) {
+	manager := NewManager(nil)
+	ctx := context.Background()
+	newRequest := func(agent string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.Header.Set("User-Agent", agent)
+		return req
+	}
+
+	current, _ := manager.newSession(newRequest("Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0"), "alice", 1)
+	laptop, _ := manager.newSession(newRequest("Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15"), "alice", 1)
+	phone, _ := manager.newSession(newRequest("Mozilla/5.0 (iPhone; CPU iPhone OS 17_0) Safari/604.1"), "alice", 1)
+	other, _ := manager.newSession(newRequest("curl/8.5.0"), "bob", 2)
+
+	sessions, err := manager.ListSessions(ctx, 1)
+	if err != nil || len(sessions) != 3 {
+		t.Fatalf("ListSessions = %d sessions, %v", len(sessions), err)
+	}
+	for _, s := range sessions {
+		if s.Token != "" {
+			t.Fatalf("listed sessions must not expose the cookie value")
+		}
+	}
+	if device := laptop.Device(); device != "Safari on macOS" {
+		t.Fatalf("Device() = %q", device)
+	}
+
+	if ok, _ := manager.RevokeSession(ctx, other.ID, 1); ok {
+		t.Fatalf("a user must not revoke another user's session")
+	}
+	if ok, _ := manager.RevokeSession(ctx, phone.ID, 1); !ok {
+		t.Fatalf("expected the phone session to be revoked")
+	}
+
+	count, err := manager.RevokeOtherSessions(ctx, current)
+	if err != nil || count != 1 {
+		t.Fatalf("RevokeOtherSessions = %d, %v", count, err)
+	}
+	if _, ok, _ := manager.store.Get(ctx, current.Token); !ok {
+		t.Fatalf("the current session must survive")
+	}
+	if _, ok, _ := manager.store.Get(ctx, other.Token); !ok {
+		t.Fatalf("other users' sessions must survive")
+	}
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/audit"
+)
+
+const setupPath = "/setup"
+
+var (
+	// ErrUsernameTaken is returned by CreateAdmin for an existing username.
+	ErrUsernameTaken = errors.New("auth: username already taken")
+
+	// ErrInvalidUsername is returned by CreateAdmin for an empty or overlong
+	// username.
+	ErrInvalidUsername = errors.New("auth: username must be between 1 and 50 characters")
+
+	errSetupComplete = errors.New("auth: setup already completed")
+)
+
+// setupState holds the one-time token that lets the first administrator be
+// created while the installation has no accounts.
+type setupState struct {
+	mu        sync.Mutex
+	tokenHash string
+}
+
+// BeginSetup puts the manager into setup mode when no accounts exist and
+// returns the token to hand to the operator. It returns an empty token when
+// setup is not needed.
+func (m *Manager) BeginSetup(ctx context.Context) (string, error) {
+	var exists bool
+	if err := m.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users)").Scan(&exists); err != nil {
+		return "", fmt.Errorf("count users: %w", err)
+	}
+	if exists {
+		return "", nil
+	}
+
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	m.setup.mu.Lock()
+	m.setup.tokenHash = hashToken(token)
+	m.setup.mu.Unlock()
+	return token, nil
+}
+
+// SetupPending reports whether the first administrator still has to be
+// created. Setup mode ends as soon as any account exists, including one
+// created with the create-admin command while the server is running.
+func (m *Manager) SetupPending(ctx context.Context) bool {
+	m.setup.mu.Lock()
+	defer m.setup.mu.Unlock()
+	if m.setup.tokenHash == "" {
+		return false
+	}
+	var exists bool
+	if err := m.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users)").Scan(&exists); err != nil {
+		log.Printf("auth: unable to check for accounts: %v", err)
+		return true
+	}
+	if exists {
+		m.setup.tokenHash = ""
+	}
+	return !exists
+}
+
+// SetupGate sends every request to the setup page until the first
+// administrator exists. Static assets stay reachable for the page's styles.
+func (m *Manager) SetupGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == setupPath || strings.HasPrefix(r.URL.Path, "/static/") || !m.SetupPending(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, setupPath, http.StatusSeeOther)
+	})
+}
+
+// SetupHandler serves the first-run page where the operator, using the
+// token printed to the log, creates the first administrator.
+func (m *Manager) SetupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.SetupPending(r.Context()) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		// The token travels in the URL; keep it out of Referer headers.
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		switch r.Method {
+		case http.MethodGet:
+			m.renderSetupPage(w, authPageData{
+				Error:      r.URL.Query().Get("error"),
+				SetupToken: r.URL.Query().Get("token"),
+				MinLength:  m.policy.MinLength,
+			})
+		case http.MethodPost:
+			token := r.FormValue("token")
+			retry := setupPath + "?token=" + url.QueryEscape(token) + "&error="
+			if !m.checkSetupToken(token) {
+				http.Redirect(w, r, setupPath+"?error="+url.QueryEscape("The setup token is not valid. Copy it from the server log."), http.StatusSeeOther)
+				return
+			}
+
+			username := strings.TrimSpace(r.FormValue("username"))
+			password := r.FormValue("password")
+			if password != r.FormValue("confirm_password") {
+				http.Redirect(w, r, retry+"Passwords+do+not+match", http.StatusSeeOther)
+				return
+			}
+
+			userID, err := m.createAdmin(r.Context(), username, password, true)
+			var policyErr *PasswordPolicyError
+			switch {
+			case errors.As(err, &policyErr):
+				http.Redirect(w, r, retry+url.QueryEscape(policyErr.Error()), http.StatusSeeOther)
+				return
+			case errors.Is(err, ErrInvalidUsername):
+				http.Redirect(w, r, retry+"Choose+a+username+of+at+most+50+characters", http.StatusSeeOther)
+				return
+			case errors.Is(err, errSetupComplete):
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			case err != nil:
+				log.Printf("auth: setup failed: %v", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+
+			m.setup.mu.Lock()
+			m.setup.tokenHash = ""
+			m.setup.mu.Unlock()
+
+			log.Printf("auth: setup complete, created administrator %q", username)
+			m.RecordAudit(r, audit.Entry{
+				ActorID:    userID,
+				ActorName:  username,
+				Action:     audit.ActionSetup,
+				TargetType: audit.TargetUser,
+				TargetID:   strconv.Itoa(userID),
+				TargetName: username,
+			})
+			m.finishLogin(w, r, username, userID, false)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (m *Manager) checkSetupToken(token string) bool {
+	m.setup.mu.Lock()
+	defer m.setup.mu.Unlock()
+	if m.setup.tokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(m.setup.tokenHash)) == 1
+}
+
+// CreateAdmin creates an approved administrator account, for example from
+// the create-admin command. The password must satisfy the policy.
+func (m *Manager) CreateAdmin(ctx context.Context, username, password string) (int, error) {
+	return m.createAdmin(ctx, username, password, false)
+}
+
+// createAdmin inserts an administrator. With firstOnly set the insert only
+// happens while no accounts exist, so the setup page cannot be replayed.
+func (m *Manager) createAdmin(ctx context.Context, username, password string, firstOnly bool) (int, error) {
+	if username == "" || len(username) > 50 {
+		return 0, ErrInvalidUsername
+	}
+	if err := m.policy.Check(username, password); err != nil {
+		return 0, err
+	}
+	hashed, err := m.hasher.Hash(password)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = m.db.QueryRow(ctx,
+		`INSERT INTO users (username, password_hash, is_approved, admin)
+         SELECT $1, $2, true, true
+         WHERE NOT $3 OR NOT EXISTS (SELECT 1 FROM users)
+         ON CONFLICT (username) DO NOTHING
+         RETURNING user_id`,
+		username, hashed, firstOnly).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if firstOnly {
+			return 0, errSetupComplete
+		}
+		return 0, ErrUsernameTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func (m *Manager) renderSetupPage(w http.ResponseWriter, data authPageData) {
+	tmpl, err := template.ParseFiles(m.templatePath("auth_base.html"), m.templatePath("login.html"), m.templatePath("setup.html"))
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	_ = tmpl.ExecuteTemplate(w, "auth_base", data)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v3"
+	"golang.org/x/crypto/bcrypt"
+
+	"sampleDB/internal/passhash"
+)
+
+func TestSetupCreatesFirstAdministrator(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	manager := NewManager(mock)
+	manager.SetPasswordHasher(passhash.Hasher{Algorithm: passhash.Bcrypt, BcryptCost: bcrypt.MinCost})
+
+	expectNoUsers := func() {
+		mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users\)`).
+			WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	}
+
+	expectNoUsers()
+	token, err := manager.BeginSetup(context.Background())
+	if err != nil || token == "" {
+		t.Fatalf("BeginSetup = %q, %v", token, err)
+	}
+
+	gate := manager.SetupGate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	expectNoUsers()
+	rr := httptest.NewRecorder()
+	gate.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/samples", nil))
+	if loc := rr.Header().Get("Location"); loc != "/setup" {
+		t.Fatalf("expected other pages to redirect to setup, got %q", loc)
+	}
+
+	form := url.Values{
+		"token":            {"not-the-token"},
+		"username":         {"root"},
+		"password":         {"a long admin password"},
+		"confirm_password": {"a long admin password"},
+	}
+	expectNoUsers()
+	rr = postForm(manager.SetupHandler(), "/setup", form)
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "/setup?error=") {
+		t.Fatalf("expected a wrong token to be rejected, got %q", loc)
+	}
+
+	form.Set("token", token)
+	expectNoUsers()
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, is_approved, admin\)`).
+		WithArgs("root", pgxmock.AnyArg(), true).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(1))
+	rr = postForm(manager.SetupHandler(), "/setup", form)
+	if loc := rr.Header().Get("Location"); loc != "/" {
+		t.Fatalf("expected the new administrator to be signed in, got %q", loc)
+	}
+	if n := manager.store.(*MemoryStore).Len(); n != 1 {
+		t.Fatalf("expected a session for the new administrator, got %d", n)
+	}
+
+	// Setup mode has ended: the gate lets requests through without asking
+	// the database again, and the token no longer works.
+	rr = httptest.NewRecorder()
+	gate.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/samples", nil))
+	if rr.Header().Get("Location") != "" {
+		t.Fatalf("gate still redirects after setup")
+	}
+	if manager.checkSetupToken(token) {
+		t.Fatalf("the setup token must only work once")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/dbiface"
+)
+
+// SessionStore persists login sessions. Implementations must be safe for
+// concurrent use.
+type SessionStore interface {
+	Create(ctx context.Context, session Session) error
+	Get(ctx context.Context, token string) (Session, bool, error)
+	// Touch records activity on a session and moves its expiry.
+	Touch(ctx context.Context, token string, seenAt, expiresAt time.Time, ip string) error
+	// List returns the unexpired sessions of userID, or of every user when
+	// userID is zero, most recently active first.
+	List(ctx context.Context, userID int, now time.Time) ([]Session, error)
+	Delete(ctx context.Context, token string) error
+	// DeleteID removes the session with the given ID. A non-zero userID
+	// restricts the operation to that user's sessions.
+	DeleteID(ctx context.Context, id string, userID int) (bool, error)
+	DeleteUser(ctx context.Context, userID int) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on restart,
+// which makes it suitable for tests and single-instance development setups.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]Session)}
+}
+
+func (s *MemoryStore) Create(_ context.Context, session Session) error {
+	session.ID = hashToken(session.Token)
+	s.mu.Lock()
+	s.sessions[session.Token] = session
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, token string) (Session, bool, error) {
+	s.mu.RLock()
+	session, ok := s.sessions[token]
+	s.mu.RUnlock()
+	return session, ok, nil
+}
+
+func (s *MemoryStore) Touch(_ context.Context, token string, seenAt, expiresAt time.Time, ip string) error {
+	s.mu.Lock()
+	if session, ok := s.sessions[token]; ok {
+		session.LastSeenAt = seenAt
+		session.ExpiresAt = expiresAt
+		session.IPAddress = ip
+		s.sessions[token] = session
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context, userID int, now time.Time) ([]Session, error) {
+	s.mu.RLock()
+	var sessions []Session
+	for _, session := range s.sessions {
+		if (userID == 0 || session.UserID == userID) && now.Before(session.ExpiresAt) {
+			session.Token = ""
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, token string) error {
+	s.mu.Lock()
+	delete(s.sessions, token)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) DeleteID(_ context.Context, id string, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.sessions {
+		if session.ID == id && (userID == 0 || session.UserID == userID) {
+			delete(s.sessions, token)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) DeleteUser(_ context.Context, userID int) error {
+	s.mu.Lock()
+	for token, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, token)
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	var removed int64
+	s.mu.Lock()
+	for token, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, token)
+			removed++
+		}
+	}
+	s.mu.Unlock()
+	return removed, nil
+}
+
+// Len reports the number of stored sessions, expired or not.
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sessions)
+}
+
+// PostgresStore keeps sessions in the sessions table so they survive restarts
+// and can be shared by several application instances. Only a SHA-256 digest
+// of the session token is stored; it doubles as the session ID.
+type PostgresStore struct {
+	db dbiface.Pool
+}
+
+func NewPostgresStore(db dbiface.Pool) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// sessionColumns lists the columns scanned by scanSession. Rows written
+// before activity tracking existed have no last_seen_at.
+const sessionColumns = `token_hash, user_id, username, expires_at,
+                COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(last_seen_at, created_at, CURRENT_TIMESTAMP),
+                COALESCE(ip_address, ''), COALESCE(user_agent, '')`
+
+func scanSession(row pgx.Row, session *Session) error {
+	return row.Scan(&session.ID, &session.UserID, &session.Username, &session.ExpiresAt,
+		&session.CreatedAt, &session.LastSeenAt, &session.IPAddress, &session.UserAgent)
+}
+
+func (s *PostgresStore) Create(ctx context.Context, session Session) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO sessions (token_hash, user_id, username, expires_at, created_at, last_seen_at, ip_address, user_agent)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		hashToken(session.Token), session.UserID, session.Username, session.ExpiresAt,
+		session.CreatedAt, session.LastSeenAt, session.IPAddress, session.UserAgent)
+	if err != nil {
+		return fmt.Errorf("insert session: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, token string) (Session, bool, error) {
+	session := Session{Token: token}
+	err := scanSession(s.db.QueryRow(ctx,
+		`SELECT `+sessionColumns+`
+         FROM sessions
+         WHERE token_hash = $1`,
+		hashToken(token)), &session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Session{}, false, nil
+		}
+		return Session{}, false, fmt.Errorf("load session: %w", err)
+	}
+	return session, true, nil
+}
+
+func (s *PostgresStore) Touch(ctx context.Context, token string, seenAt, expiresAt time.Time, ip string) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE sessions
+         SET last_seen_at = $2, expires_at = $3, ip_address = $4
+         WHERE token_hash = $1`,
+		hashToken(token), seenAt, expiresAt, ip)
+	if err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) List(ctx context.Context, userID int, now time.Time) ([]Session, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+sessionColumns+`
+         FROM sessions
+         WHERE ($1 = 0 OR user_id = $1)
+           AND expires_at > $2
+         ORDER BY COALESCE(last_seen_at, created_at) DESC`,
+		userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, fmt.Errorf("list sessions: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, token string) error {
+	if _, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE token_hash = $1", hashToken(token)); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteID(ctx context.Context, id string, userID int) (bool, error) {
+	tag, err := s.db.Exec(ctx,
+		"DELETE FROM sessions WHERE token_hash = $1 AND ($2 = 0 OR user_id = $2)",
+		id, userID)
+	if err != nil {
+		return false, fmt.Errorf("delete session: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) DeleteUser(ctx context.Context, userID int) error {
+	if _, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("delete user sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE expires_at < $1", now)
+	if err != nil {
+		return 0, fmt.Errorf("purge sessions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestMemoryStoreLifecycle(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	sessions := []Session{
+		{Token: "a", UserID: 1, Username: "alice", ExpiresAt: now.Add(time.Hour)},
+		{Token: "b", UserID: 1, Username: "alice", ExpiresAt: now.Add(-time.Minute)},
+		{Token: "c", UserID: 2, Username: "bob", ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, s := range sessions {
+		if err := store.Create(ctx, s); err != nil {
+			t.Fatalf("Create(%q) returned error: %v", s.Token, err)
+		}
+	}
+
+	if got, ok, _ := store.Get(ctx, "a"); !ok || got.Username != "alice" {
+		t.Fatalf("expected session a for alice, got %+v (found=%v)", got, ok)
+	}
+
+	removed, err := store.DeleteExpired(ctx, now)
+	if err != nil || removed != 1 {
+		t.Fatalf("DeleteExpired removed %d sessions (err=%v), want 1", removed, err)
+	}
+
+	if err := store.DeleteUser(ctx, 1); err != nil {
+		t.Fatalf("DeleteUser returned error: %v", err)
+	}
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Fatalf("expected alice's sessions to be revoked")
+	}
+
+	if err := store.Delete(ctx, "c"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if store.Len() != 0 {
+		t.Fatalf("expected empty store, got %d sessions", store.Len())
+	}
+}
+
+func TestPostgresStoreHashesTokens(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	digest := hashToken("secret-token")
+
+	mock.ExpectExec(`INSERT INTO sessions \(token_hash, user_id, username, expires_at, created_at, last_seen_at, ip_address, user_agent\)`).
+		WithArgs(digest, 7, "alice", expires, now, now, "192.0.2.1", "Firefox/130.0").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery(`SELECT token_hash, user_id, username, expires_at,.+FROM sessions\s+WHERE token_hash = \$1`).
+		WithArgs(digest).
+		WillReturnRows(pgxmock.NewRows([]string{"token_hash", "user_id", "username", "expires_at", "created_at", "last_seen_at", "ip_address", "user_agent"}).
+			AddRow(digest, 7, "alice", expires, now, now, "192.0.2.1", "Firefox/130.0"))
+	mock.ExpectQuery(`SELECT token_hash, user_id, username, expires_at,.+FROM sessions\s+WHERE token_hash = \$1`).
+		WithArgs(hashToken("unknown")).
+		WillReturnError(pgx.ErrNoRows)
+
+	store := NewPostgresStore(mock)
+	ctx := context.Background()
+
+	created := Session{
+		Token: "secret-token", UserID: 7, Username: "alice", ExpiresAt: expires,
+		CreatedAt: now, LastSeenAt: now, IPAddress: "192.0.2.1", UserAgent: "Firefox/130.0",
+	}
+	if err := store.Create(ctx, created); err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	session, ok, err := store.Get(ctx, "secret-token")
+	if err != nil || !ok {
+		t.Fatalf("Get returned found=%v err=%v", ok, err)
+	}
+	if session.Token != "secret-token" || session.UserID != 7 || session.ID != digest {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+
+	if _, ok, err := store.Get(ctx, "unknown"); ok || err != nil {
+		t.Fatalf("expected missing session without error, got found=%v err=%v", ok, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
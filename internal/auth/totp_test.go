@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 variant, truncated to six digits.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) returned error: %v", unix, err)
+		}
+		if got != want {
+			t.Fatalf("TOTPCode(%d) = %q, want %q", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTPAllowsClockSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret returned error: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	previous, _ := TOTPCode(secret, now.Add(-totpPeriod*time.Second))
+	if _, ok := ValidateTOTP(secret, previous, now); !ok {
+		t.Fatalf("expected code from previous step to be accepted")
+	}
+
+	stale, _ := TOTPCode(secret, now.Add(-3*totpPeriod*time.Second))
+	if _, ok := ValidateTOTP(secret, stale, now); ok {
+		t.Fatalf("expected code from three steps ago to be rejected")
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Fatalf("expected short code to be rejected")
+	}
+}
+
+func TestTOTPURIContainsIssuerAndSecret(t *testing.T) {
+	uri := TOTPURI("Sample Tracker", "alice", "ABCDEF")
+	if !strings.HasPrefix(uri, "otpauth://totp/Sample%20Tracker:alice?") {
+		t.Fatalf("unexpected label in %q", uri)
+	}
+	if !strings.Contains(uri, "secret=ABCDEF") || !strings.Contains(uri, "issuer=Sample+Tracker") {
+		t.Fatalf("missing parameters in %q", uri)
+	}
+}
+
+func TestGenerateRecoveryCodesAreUnique(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes returned error: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("unexpected recovery code format %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("duplicate recovery code %q", code)
+		}
+		seen[code] = true
+		if normalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))) != code {
+			t.Fatalf("normalizeRecoveryCode did not round-trip %q", code)
+		}
+	}
+}
+
+func TestConfirmTOTPEnrollmentRollsBackWithoutRecoveryCodes(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT COALESCE\\(totp_secret").
+		WithArgs(11).
+		WillReturnRows(pgxmock.NewRows([]string{"totp_secret", "totp_enabled"}).AddRow(secret, false))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET totp_enabled = true").
+		WithArgs(pgxmock.AnyArg(), 11).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("DELETE FROM user_recovery_codes").
+		WithArgs(11).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectExec("INSERT INTO user_recovery_codes").
+		WithArgs(11, pgxmock.AnyArg()).
+		WillReturnError(errors.New("disk full"))
+	mock.ExpectRollback()
+
+	manager := NewManager(mock)
+	if codes, err := manager.ConfirmTOTPEnrollment(context.Background(), 11, code); err == nil || codes != nil {
+		t.Fatalf("ConfirmTOTPEnrollment = %v, %v; want the failure to store recovery codes", codes, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestLoginHandlerRequiresSecondFactor(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved`).
+		WithArgs("erin").
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted", "totp_enabled", "locked_until"}).
+			AddRow(11, string(hashed), true, false, true, nil))
+	mock.ExpectExec(`UPDATE users SET password_hash = \$1 WHERE user_id = \$2 AND password_hash = \$3`).
+		WithArgs(pgxmock.AnyArg(), 11, string(hashed)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(`INSERT INTO login_challenges`).
+		WithArgs(pgxmock.AnyArg(), 11, "erin", challengeVerify, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	manager := NewManager(mock)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=erin&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	manager.LoginHandler()(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/login/2fa" {
+		t.Fatalf("expected redirect to second factor step, got %q", loc)
+	}
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session_token" {
+			t.Fatalf("session cookie must not be issued before the second factor")
+		}
+	}
+
+	if n := manager.store.(*MemoryStore).Len(); n != 0 {
+		t.Fatalf("expected no session before second factor, got %d", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
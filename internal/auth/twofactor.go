@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	challengeCookieName = "login_challenge"
+	challengeTTL        = 5 * time.Minute
+	challengeMaxTries   = 5
+
+	challengeVerify = "verify"
+	challengeEnroll = "enroll"
+
+	totpIssuer = "Sample Tracker"
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotPending     = errors.New("no two-factor enrolment in progress")
+	ErrInvalidTOTPCode    = errors.New("invalid verification code")
+)
+
+// loginChallenge is the half-finished login of a user who passed the password
+// check but still has to present (or enrol) a second factor.
+type loginChallenge struct {
+	Token    string
+	UserID   int
+	Username string
+	Purpose  string
+}
+
+// SetRequireAdminTwoFactor forces administrators to enrol TOTP before they can
+// finish signing in.
+func (m *Manager) SetRequireAdminTwoFactor(enable bool) {
+	m.requireAdmin2FA = enable
+}
+
+// AdminCheck reports whether userID is an administrator.
+type AdminCheck func(ctx context.Context, userID int) (bool, error)
+
+// SetAdminCheck sets how administrators are recognised for the two-factor
+// policy. It should resolve roles the way permission checks do, so that the
+// admin role granted directly or through a group counts. Without it only the
+// legacy users.admin flag counts.
+func (m *Manager) SetAdminCheck(check AdminCheck) {
+	m.adminCheck = check
+}
+
+// TwoFactorRequired reports whether policy requires userID to use 2FA: such
+// a user must enrol before finishing a sign-in and may not disable it.
+func (m *Manager) TwoFactorRequired(ctx context.Context, userID int) (bool, error) {
+	if !m.requireAdmin2FA {
+		return false, nil
+	}
+	if m.adminCheck != nil {
+		return m.adminCheck(ctx, userID)
+	}
+	var isAdmin bool
+	err := m.db.QueryRow(ctx,
+		"SELECT COALESCE(admin, false) FROM users WHERE user_id = $1",
+		userID).Scan(&isAdmin)
+	if err != nil {
+		return false, err
+	}
+	return isAdmin, nil
+}
+
+// TwoFactorEnabled reports whether userID has a confirmed TOTP secret.
+func (m *Manager) TwoFactorEnabled(ctx context.Context, userID int) (bool, error) {
+	var enabled bool
+	err := m.db.QueryRow(ctx,
+		"SELECT COALESCE(totp_enabled, false) FROM users WHERE user_id = $1",
+		userID).Scan(&enabled)
+	return enabled, err
+}
+
+// BeginTOTPEnrollment returns the pending secret for userID, generating one if
+// none is stored yet. The secret is inactive until ConfirmTOTPEnrollment.
+func (m *Manager) BeginTOTPEnrollment(ctx context.Context, userID int) (string, error) {
+	var (
+		secret  string
+		enabled bool
+	)
+	err := m.db.QueryRow(ctx,
+		"SELECT COALESCE(totp_secret, ''), COALESCE(totp_enabled, false) FROM users WHERE user_id = $1",
+		userID).Scan(&secret, &enabled)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return "", ErrTOTPAlreadyEnabled
+	}
+	if secret != "" {
+		return secret, nil
+	}
+
+	secret, err = GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.db.Exec(ctx,
+		"UPDATE users SET totp_secret = $1, totp_enabled = false, totp_last_step = NULL WHERE user_id = $2",
+		secret, userID); err != nil {
+		return "", fmt.Errorf("store totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// ConfirmTOTPEnrollment activates the pending secret once the user proves
+// their authenticator produces matching codes, and returns fresh recovery codes.
+// Both are saved together, so 2FA is never left on without recovery codes.
+func (m *Manager) ConfirmTOTPEnrollment(ctx context.Context, userID int, code string) ([]string, error) {
+	var (
+		secret  string
+		enabled bool
+	)
+	err := m.db.QueryRow(ctx,
+		"SELECT COALESCE(totp_secret, ''), COALESCE(totp_enabled, false) FROM users WHERE user_id = $1",
+		userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if secret == "" {
+		return nil, ErrTOTPNotPending
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE user_id = $2",
+		int64(step), userID); err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes for userID.
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes stores new recovery codes for userID in tx in place
+// of the old ones and returns them.
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int) ([]string, error) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("clear recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(code)); err != nil {
+			return nil, fmt.Errorf("store recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes for userID. It is
+// also used by administrators to reset a user's second factor.
+func (m *Manager) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		"UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = NULL WHERE user_id = $1",
+		userID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("clear recovery codes: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Recovery codes are consumed on use and TOTP steps cannot be replayed.
+func (m *Manager) VerifySecondFactor(ctx context.Context, userID int, code string) (bool, error) {
+	var (
+		secret  string
+		enabled bool
+	)
+	err := m.db.QueryRow(ctx,
+		"SELECT COALESCE(totp_secret, ''), COALESCE(totp_enabled, false) FROM users WHERE user_id = $1",
+		userID).Scan(&secret, &enabled)
+	if err != nil {
+		return false, err
+	}
+	if !enabled || secret == "" {
+		return false, nil
+	}
+
+	if step, ok := ValidateTOTP(secret, code, time.Now()); ok {
+		tag, err := m.db.Exec(ctx,
+			`UPDATE users SET totp_last_step = $1
+             WHERE user_id = $2 AND COALESCE(totp_last_step, -1) < $1`,
+			int64(step), userID)
+		if err != nil {
+			return false, err
+		}
+		return tag.RowsAffected() == 1, nil
+	}
+
+	tag, err := m.db.Exec(ctx,
+		`UPDATE user_recovery_codes SET used_at = NOW()
+         WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// TwoFactorHandler serves the second login step for users with TOTP enabled.
+func (m *Manager) TwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		challenge, ok := m.loadChallenge(r, challengeVerify)
+		if !ok {
+			http.Redirect(w, r, "/login?error=Your+sign-in+attempt+expired", http.StatusSeeOther)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			m.renderTwoFactorPage(w, authPageData{
+				Username: challenge.Username,
+				Error:    r.URL.Query().Get("error"),
+			})
+			return
+		}
+
+		ok, err := m.VerifySecondFactor(r.Context(), challenge.UserID, r.FormValue("code"))
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			locked := m.loginFailed(r, challenge.Username, challenge.UserID, failureBadCode)
+			if m.recordChallengeFailure(r.Context(), challenge) || locked {
+				m.clearChallenge(w, r.Context(), challenge)
+				http.Redirect(w, r, "/login?error=Too+many+invalid+codes", http.StatusSeeOther)
+				return
+			}
+			http.Redirect(w, r, "/login/2fa?error=Invalid+verification+code", http.StatusSeeOther)
+			return
+		}
+
+		m.clearChallenge(w, r.Context(), challenge)
+		if err := m.startSession(w, r, challenge.Username, challenge.UserID); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// TwoFactorSetupHandler lets users who are required to use 2FA enrol during
+// sign-in, before a session is issued.
+func (m *Manager) TwoFactorSetupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		challenge, ok := m.loadChallenge(r, challengeEnroll)
+		if !ok {
+			http.Redirect(w, r, "/login?error=Your+sign-in+attempt+expired", http.StatusSeeOther)
+			return
+		}
+
+		secret, err := m.BeginTOTPEnrollment(r.Context(), challenge.UserID)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := authPageData{
+			Username: challenge.Username,
+			Enroll:   true,
+			Secret:   secret,
+			Error:    r.URL.Query().Get("error"),
+		}
+		data.QRCode, err = QRCodeDataURI(TOTPURI(totpIssuer, challenge.Username, secret))
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			m.renderTwoFactorPage(w, data)
+			return
+		}
+
+		codes, err := m.ConfirmTOTPEnrollment(r.Context(), challenge.UserID, r.FormValue("code"))
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			if m.recordChallengeFailure(r.Context(), challenge) {
+				m.clearChallenge(w, r.Context(), challenge)
+				http.Redirect(w, r, "/login?error=Too+many+invalid+codes", http.StatusSeeOther)
+				return
+			}
+			http.Redirect(w, r, "/login/2fa/setup?error=Invalid+verification+code", http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		m.clearChallenge(w, r.Context(), challenge)
+		if err := m.startSession(w, r, challenge.Username, challenge.UserID); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		m.renderTwoFactorPage(w, authPageData{
+			Username:      challenge.Username,
+			RecoveryCodes: codes,
+		})
+	}
+}
+
+func (m *Manager) renderTwoFactorPage(w http.ResponseWriter, data authPageData) {
+	// login.html supplies the shared auth styles; login_2fa.html overrides
+	// its title and content blocks.
+	tmpl, err := template.ParseFiles(
+		m.templatePath("auth_base.html"),
+		m.templatePath("login.html"),
+		m.templatePath("login_2fa.html"),
+	)
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	_ = tmpl.ExecuteTemplate(w, "auth_base", data)
+}
+
+func (m *Manager) beginChallenge(w http.ResponseWriter, r *http.Request, username string, userID int, purpose string) error {
+	token, err := generateSessionToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(challengeTTL)
+
+	if _, err := m.db.Exec(r.Context(),
+		`INSERT INTO login_challenges (token_hash, user_id, username, purpose, expires_at)
+         VALUES ($1, $2, $3, $4, $5)`,
+		hashToken(token), userID, username, purpose, expiresAt); err != nil {
+		return fmt.Errorf("store login challenge: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    token,
+		Path:     "/login",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   m.cookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+func (m *Manager) loadChallenge(r *http.Request, purpose string) (loginChallenge, bool) {
+	cookie, err := r.Cookie(challengeCookieName)
+	if err != nil {
+		return loginChallenge{}, false
+	}
+
+	challenge := loginChallenge{Token: cookie.Value}
+	err = m.db.QueryRow(r.Context(),
+		`SELECT user_id, username, purpose
+         FROM login_challenges
+         WHERE token_hash = $1 AND expires_at > NOW()`,
+		hashToken(cookie.Value)).Scan(&challenge.UserID, &challenge.Username, &challenge.Purpose)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("auth: unable to load login challenge: %v", err)
+		}
+		return loginChallenge{}, false
+	}
+	if challenge.Purpose != purpose {
+		return loginChallenge{}, false
+	}
+	return challenge, true
+}
+
+// recordChallengeFailure counts a wrong code and reports whether the
+// challenge has run out of attempts.
+func (m *Manager) recordChallengeFailure(ctx context.Context, challenge loginChallenge) bool {
+	var attempts int
+	err := m.db.QueryRow(ctx,
+		`UPDATE login_challenges SET attempts = attempts + 1
+         WHERE token_hash = $1
+         RETURNING attempts`,
+		hashToken(challenge.Token)).Scan(&attempts)
+	if err != nil {
+		return true
+	}
+	return attempts >= challengeMaxTries
+}
+
+func (m *Manager) clearChallenge(w http.ResponseWriter, ctx context.Context, challenge loginChallenge) {
+	if _, err := m.db.Exec(ctx,
+		"DELETE FROM login_challenges WHERE token_hash = $1",
+		hashToken(challenge.Token)); err != nil {
+		log.Printf("auth: unable to delete login challenge: %v", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    "",
+		Path:     "/login",
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: true,
+		Secure:   m.cookieSecure,
+	})
+}
+
+func (m *Manager) purgeChallenges(ctx context.Context, now time.Time) {
+	if _, err := m.db.Exec(ctx, "DELETE FROM login_challenges WHERE expires_at < $1", now); err != nil {
+		log.Printf("auth: login challenge purge failed: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/audit"
+	"sampleDB/internal/mail"
+)
+
+// emailVerificationTTL is how long a self-registered account has to confirm
+// its address. Accounts that miss it are purged so the name can be reused.
+const emailVerificationTTL = 24 * time.Hour
+
+const verificationSent = "Check your inbox: we have sent you a link to confirm your email address. Your request reaches the administrators once it is confirmed."
+
+// registerUnverified creates an unapproved account that stays hidden from
+// the approval list until the emailed link is opened.
+func (m *Manager) registerUnverified(r *http.Request, username, passwordHash, email string) error {
+	ctx := r.Context()
+	token, err := generateSessionToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`INSERT INTO users (username, password_hash, is_approved, email, email_verified)
+         VALUES ($1, $2, false, $3, false)
+         RETURNING user_id`,
+		username, passwordHash, email).Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO email_verifications (token_hash, user_id, expires_at)
+         VALUES ($1, $2, $3)`,
+		hashToken(token), userID, time.Now().Add(emailVerificationTTL)); err != nil {
+		return err
+	}
+
+	link := m.baseURL + "/verify-email?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      email,
+		Subject: "Confirm your SampleDB email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Thanks for requesting a SampleDB account. Open the link below within %d hours "+
+			"to confirm your email address:\n\n%s\n\n"+
+			"An administrator will then review your request. "+
+			"If you did not sign up, you can ignore this email and the request will be discarded.\n",
+			username, int(emailVerificationTTL.Hours()), link),
+	}
+	// Only keep the account when the link could be sent.
+	if err := m.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("send verification email to user %d: %w", userID, err)
+	}
+	return tx.Commit(ctx)
+}
+
+// VerifyEmailHandler confirms the address of a self-registered account,
+// which puts it on the administrators' approval list.
+func (m *Manager) VerifyEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		userID, err := m.verifyEmail(r.Context(), r.URL.Query().Get("token"))
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/login?error="+url.QueryEscape("That confirmation link is invalid or has expired. Please register again."), http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			log.Printf("auth: email verification failed: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("auth: user %d confirmed their email address", userID)
+		m.RecordAudit(r, audit.Entry{
+			ActorID:    userID,
+			Action:     audit.ActionVerifyEmail,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.Itoa(userID),
+		})
+		http.Redirect(w, r, "/login?success="+url.QueryEscape("Email address confirmed. Please wait for an administrator to approve your account."), http.StatusSeeOther)
+	}
+}
+
+// verifyEmail consumes token and marks its account's address as confirmed.
+func (m *Manager) verifyEmail(ctx context.Context, token string) (int, error) {
+	if token == "" {
+		return 0, pgx.ErrNoRows
+	}
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`DELETE FROM email_verifications
+         WHERE token_hash = $1
+           AND expires_at > $2
+         RETURNING user_id`,
+		hashToken(token), time.Now()).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE users SET email_verified = true WHERE user_id = $1",
+		userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+// purgeUnverifiedAccounts removes self-registered accounts whose
+// confirmation link expired unused.
+func (m *Manager) purgeUnverifiedAccounts(ctx context.Context, now time.Time) {
+	_, err := m.db.Exec(ctx,
+		`DELETE FROM users u
+         WHERE NOT COALESCE(u.email_verified, true)
+           AND NOT COALESCE(u.is_approved, false)
+           AND NOT EXISTS (
+               SELECT 1 FROM email_verifications v
+               WHERE v.user_id = u.user_id AND v.expires_at > $1)`,
+		now)
+	if err != nil {
+		log.Printf("auth: unverified account purge failed: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestRegisterHandlerSendsVerificationEmail(t *testing.T) {
+	manager, mock, sender := newResetTestManager(t)
+
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE username = \$1\)`).
+		WithArgs("frank").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE lower\(email\) = lower\(\$1\)\)`).
+		WithArgs("frank@lab.example").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, is_approved, email, email_verified\)`).
+		WithArgs("frank", pgxmock.AnyArg(), "frank@lab.example").
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(12))
+	stored := &captureArg{}
+	mock.ExpectExec(`INSERT INTO email_verifications`).
+		WithArgs(stored, 12, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	rr := postForm(manager.RegisterHandler(), "/register", url.Values{
+		"username":         {"frank"},
+		"email":            {"Frank <frank@lab.example>"},
+		"password":         {"long enough secret"},
+		"confirm_password": {"long enough secret"},
+	})
+
+	if loc := rr.Header().Get("Location"); loc != "/login?success="+url.QueryEscape(verificationSent) {
+		t.Fatalf("expected verification notice, got %d %q", rr.Code, loc)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].To != "frank@lab.example" {
+		t.Fatalf("expected one email to frank, got %+v", sender.sent)
+	}
+	match := regexp.MustCompile(`https://samples\.lab\.example/verify-email\?token=(\S+)`).FindStringSubmatch(sender.sent[0].Body)
+	if match == nil {
+		t.Fatalf("email does not contain a confirmation link: %q", sender.sent[0].Body)
+	}
+	token, _ := url.QueryUnescape(match[1])
+	if stored.value != hashToken(token) {
+		t.Fatalf("database should hold the token digest, got %v", stored.value)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRegisterHandlerRequiresEmailWhenMailIsConfigured(t *testing.T) {
+	manager, mock, sender := newResetTestManager(t)
+
+	rr := postForm(manager.RegisterHandler(), "/register", url.Values{
+		"username":         {"frank"},
+		"password":         {"long enough secret"},
+		"confirm_password": {"long enough secret"},
+	})
+
+	if loc := rr.Header().Get("Location"); !strings.Contains(loc, "Email+address+is+required") {
+		t.Fatalf("expected missing email error, got %q", loc)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("no email should be sent")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unexpected DB calls: %v", err)
+	}
+}
+
+func TestVerifyEmailHandlerConfirmsAddress(t *testing.T) {
+	manager, mock, _ := newResetTestManager(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM email_verifications\s+WHERE token_hash = \$1`).
+		WithArgs(hashToken("verify-token"), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(12))
+	mock.ExpectExec(`UPDATE users SET email_verified = true WHERE user_id = \$1`).
+		WithArgs(12).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	manager.VerifyEmailHandler()(rr, httptest.NewRequest(http.MethodGet, "/verify-email?token=verify-token", nil))
+
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "/login?success=") {
+		t.Fatalf("expected redirect to login, got %d %q", rr.Code, loc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
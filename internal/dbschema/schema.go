@@ -19,10 +19,64 @@ var schemaStatements = []string{
 	createBookingsTable,
 	createBookingsIndex,
 	createGroupsTable,
+	createSessionsTable,
+	createSessionsUserIndex,
+	createSessionsExpiryIndex,
+	addTOTPColumns,
+	createRecoveryCodesTable,
+	createLoginChallengesTable,
+	addLockedUntilColumn,
+	createFailedLoginsTable,
+	createFailedLoginsIndex,
+	createAPITokensTable,
+	createAPITokensUserIndex,
+	addOIDCSubjectColumn,
+	createOIDCSubjectIndex,
+	createRolesTable,
+	createRolePermissionsTable,
+	createUserRolesTable,
+	createGroupRolesTable,
+	addEmailColumn,
+	createEmailIndex,
+	createPasswordResetsTable,
+	createPasswordResetsUserIndex,
+	addMustChangePasswordColumn,
+	createPasswordHistoryTable,
+	createPasswordHistoryUserIndex,
+	addSessionActivityColumns,
+	addEmailVerifiedColumn,
+	createEmailVerificationsTable,
+	createEmailVerificationsUserIndex,
+	createInvitationsTable,
+	createAuditLogTable,
+	createAuditLogCreatedIndex,
+	createAuditLogActorIndex,
+	createAuditLogGuardFunction,
+	createAuditLogGuardTrigger,
+	addSampleSearchVector,
+	createSampleSearchIndex,
+	createSamplePrefixQueryFunction,
+	createSamplesNameIndex,
+	createSamplesCreatedIndex,
+	createSampleRevisionsTable,
+	addSampleOwnership,
+	createSamplesOwnerIndex,
+	addSampleDeletion,
+	createSamplesDeletedIndex,
+	createStorageLocationsTable,
+	createStorageLocationsNameIndex,
+	addSampleStorage,
+	createSamplesLocationIndex,
+	createSamplesBoxPositionIndex,
+	createSampleRelationsTable,
+	createSampleRelationsChildIndex,
 }
 
-// Data seeding is disabled; keep statements for reference but do not execute.
-var dataStatements = []string{}
+// Only the built-in roles are seeded. The remaining data statements are kept
+// for reference but not executed.
+var dataStatements = []string{
+	seedBuiltinRoles,
+}
 var disabledDataStatements = []string{
 	backfillGroups,
 	seedEquipment,
@@ -131,6 +185,458 @@ CREATE TABLE IF NOT EXISTS groups (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );`
 
+const createSessionsTable = `
+CREATE TABLE IF NOT EXISTS sessions (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);`
+
+const createSessionsUserIndex = `
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id
+ON sessions (user_id);`
+
+const createSessionsExpiryIndex = `
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at
+ON sessions (expires_at);`
+
+const addTOTPColumns = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+    ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;`
+
+const createRecoveryCodesTable = `
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    code_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP WITH TIME ZONE
+);`
+
+const createLoginChallengesTable = `
+CREATE TABLE IF NOT EXISTS login_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    purpose VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);`
+
+const addLockedUntilColumn = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;`
+
+const createFailedLoginsTable = `
+CREATE TABLE IF NOT EXISTS failed_logins (
+    attempt_id SERIAL PRIMARY KEY,
+    username VARCHAR(50) NOT NULL,
+    user_id INT REFERENCES users(user_id) ON DELETE SET NULL,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    reason VARCHAR(32) NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);`
+
+const createFailedLoginsIndex = `
+CREATE INDEX IF NOT EXISTS idx_failed_logins_attempted_at
+ON failed_logins (attempted_at);`
+
+const createAPITokensTable = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+    token_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);`
+
+const createAPITokensUserIndex = `
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id
+ON api_tokens (user_id);`
+
+const addOIDCSubjectColumn = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS oidc_subject TEXT;`
+
+const createOIDCSubjectIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject
+ON users (oidc_subject)
+WHERE oidc_subject IS NOT NULL;`
+
+const createRolesTable = `
+CREATE TABLE IF NOT EXISTS roles (
+    role_id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);`
+
+const createRolePermissionsTable = `
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);`
+
+const createUserRolesTable = `
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    role_id INT NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);`
+
+const createGroupRolesTable = `
+CREATE TABLE IF NOT EXISTS group_roles (
+    group_id INT NOT NULL REFERENCES groups(group_id) ON DELETE CASCADE,
+    role_id INT NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, role_id)
+);`
+
+const addEmailColumn = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email VARCHAR(255);`
+
+const createEmailIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email
+ON users (lower(email))
+WHERE email IS NOT NULL;`
+
+const createPasswordResetsTable = `
+CREATE TABLE IF NOT EXISTS password_resets (
+    reset_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);`
+
+const createPasswordResetsUserIndex = `
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id
+ON password_resets (user_id);`
+
+const addMustChangePasswordColumn = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN DEFAULT false;`
+
+const createPasswordHistoryTable = `
+CREATE TABLE IF NOT EXISTS password_history (
+    history_id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    password_hash VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);`
+
+const createPasswordHistoryUserIndex = `
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id
+ON password_history (user_id);`
+
+const addSessionActivityColumns = `
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS ip_address VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS user_agent TEXT;`
+
+// Accounts that existed before email verification count as verified.
+const addEmailVerifiedColumn = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email_verified BOOLEAN DEFAULT true;`
+
+const createEmailVerificationsTable = `
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);`
+
+const createEmailVerificationsUserIndex = `
+CREATE INDEX IF NOT EXISTS idx_email_verifications_user_id
+ON email_verifications (user_id);`
+
+const createInvitationsTable = `
+CREATE TABLE IF NOT EXISTS invitations (
+    invitation_id SERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255),
+    group_name TEXT,
+    equipment_ids INT[] NOT NULL DEFAULT '{}',
+    created_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    used_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);`
+
+// audit_log keeps the actor's name alongside the ID so entries stay readable
+// after the account is gone; there is deliberately no foreign key.
+const createAuditLogTable = `
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor_id INT,
+    actor_name VARCHAR(50) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id TEXT NOT NULL DEFAULT '',
+    target_name TEXT NOT NULL DEFAULT '',
+    before_state JSONB,
+    after_state JSONB,
+    ip_address VARCHAR(64) NOT NULL DEFAULT ''
+);`
+
+const createAuditLogCreatedIndex = `
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at
+ON audit_log (created_at);`
+
+const createAuditLogActorIndex = `
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id
+ON audit_log (actor_id);`
+
+const createAuditLogGuardFunction = `
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;`
+
+// The trigger rejects UPDATE, DELETE and TRUNCATE so that entries cannot be
+// rewritten through the application's database role.
+const createAuditLogGuardTrigger = `
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_log_append_only') THEN
+        CREATE TRIGGER audit_log_append_only
+        BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+        FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+    END IF;
+END;
+$$;`
+
+// The search vector uses the simple configuration so sample codes, chemical
+// formulas and names are indexed as typed rather than stemmed as English.
+// Weights rank name matches above keywords and owner, then description, then
+// preparation notes.
+const addSampleSearchVector = `
+ALTER TABLE samples
+    ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(sample_name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(sample_keywords, '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(sample_owner, '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(sample_description, '')), 'C') ||
+        setweight(to_tsvector('simple', coalesce(sample_prep, '')), 'D')
+    ) STORED;`
+
+const createSampleSearchIndex = `
+CREATE INDEX IF NOT EXISTS idx_samples_search_vector
+ON samples USING GIN (search_vector);`
+
+// sample_prefix_query splits text the way the search vector was built and
+// matches every word as a prefix. Each lexeme is quoted, so the text cannot
+// inject tsquery operators. Text without any words yields NULL.
+const createSamplePrefixQueryFunction = `
+CREATE OR REPLACE FUNCTION sample_prefix_query(query TEXT) RETURNS tsquery AS $$
+    SELECT to_tsquery('simple', string_agg(
+        '''' || replace(replace(lexeme, '\', '\\'), '''', '''''') || ''':*', ' & '))
+    FROM unnest(to_tsvector('simple', query))
+$$ LANGUAGE SQL IMMUTABLE;`
+
+// The sample list pages with a keyset on (sort key, sample_id); these
+// indexes match the name and creation date keys.
+const createSamplesNameIndex = `
+CREATE INDEX IF NOT EXISTS idx_samples_name
+ON samples (sample_name, sample_id);`
+
+const createSamplesCreatedIndex = `
+CREATE INDEX IF NOT EXISTS idx_samples_created_at
+ON samples ((coalesce(created_at, timestamp '1970-01-01')), sample_id);`
+
+// createSampleRevisionsTable keeps every version of a sample's editable
+// fields. Revision 1 is the state before the first recorded change.
+const createSampleRevisionsTable = `
+CREATE TABLE IF NOT EXISTS sample_revisions (
+    revision_id SERIAL PRIMARY KEY,
+    sample_id INT NOT NULL REFERENCES samples(sample_id) ON DELETE CASCADE,
+    revision_number INT NOT NULL,
+    sample_name VARCHAR(100) NOT NULL,
+    sample_description TEXT NOT NULL DEFAULT '',
+    sample_keywords VARCHAR(255) NOT NULL DEFAULT '',
+    sample_owner VARCHAR(100) NOT NULL DEFAULT '',
+    sample_prep TEXT NOT NULL DEFAULT '',
+    changed_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    changed_by_name VARCHAR(50) NOT NULL DEFAULT '',
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    restored_from INT,
+    UNIQUE (sample_id, revision_number)
+);`
+
+// addSampleOwnership links samples to the account of their owner and adds
+// their visibility. When the columns are first added, existing owner names
+// are matched to approved, active accounts, ignoring case and surrounding
+// spaces but preferring an exact match; names without an account stay as
+// they are. The match runs only once, so that a
+// later account cannot claim samples by registering a matching name.
+const addSampleOwnership = `
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'samples' AND column_name = 'owner_id'
+    ) THEN
+        ALTER TABLE samples
+            ADD COLUMN owner_id INT REFERENCES users(user_id) ON DELETE SET NULL,
+            ADD COLUMN visibility VARCHAR(10) NOT NULL DEFAULT 'lab'
+                CHECK (visibility IN ('private', 'group', 'lab'));
+
+        UPDATE samples s
+        SET owner_id = u.user_id, sample_owner = u.username
+        FROM users u
+        WHERE u.user_id = (
+            SELECT o.user_id FROM users o
+            WHERE lower(o.username) = lower(btrim(s.sample_owner))
+              AND o.is_approved AND NOT coalesce(o.deleted, false)
+            ORDER BY o.username = btrim(s.sample_owner) DESC, o.user_id
+            LIMIT 1);
+    END IF;
+END
+$$;`
+
+const createSamplesOwnerIndex = `
+CREATE INDEX IF NOT EXISTS idx_samples_owner_id
+ON samples (owner_id);`
+
+// addSampleDeletion adds the trash columns and lets members delete their own samples.
+const addSampleDeletion = `
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'samples' AND column_name = 'deleted_at'
+    ) THEN
+        ALTER TABLE samples
+            ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+            ADD COLUMN deleted_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+            ADD COLUMN deleted_by_name VARCHAR(50) NOT NULL DEFAULT '';
+
+        INSERT INTO role_permissions (role_id, permission)
+        SELECT role_id, 'samples.delete.own' FROM roles
+        WHERE name IN ('member', 'equipment_manager')
+        ON CONFLICT DO NOTHING;
+    END IF;
+END
+$$;`
+
+const createSamplesDeletedIndex = `
+CREATE INDEX IF NOT EXISTS idx_samples_deleted_at
+ON samples (deleted_at) WHERE deleted_at IS NOT NULL;`
+
+// createStorageLocationsTable holds the tree of places samples are kept in,
+// from buildings down to boxes. Only boxes have a grid of positions.
+const createStorageLocationsTable = `
+CREATE TABLE IF NOT EXISTS storage_locations (
+    location_id SERIAL PRIMARY KEY,
+    parent_id INT REFERENCES storage_locations(location_id) ON DELETE RESTRICT,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('building', 'room', 'freezer', 'cabinet', 'rack', 'box')),
+    name VARCHAR(100) NOT NULL,
+    box_rows INT NOT NULL DEFAULT 0,
+    box_columns INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`
+
+const createStorageLocationsNameIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_storage_locations_name
+ON storage_locations ((coalesce(parent_id, 0)), lower(name));`
+
+// addSampleStorage adds sample status and location and grants locations.manage.
+const addSampleStorage = `
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'samples' AND column_name = 'status'
+    ) THEN
+        ALTER TABLE samples
+            ADD COLUMN status VARCHAR(10) NOT NULL DEFAULT 'active'
+                CHECK (status IN ('active', 'in-use', 'consumed', 'shipped', 'discarded')),
+            ADD COLUMN location_id INT REFERENCES storage_locations(location_id) ON DELETE SET NULL,
+            ADD COLUMN box_position VARCHAR(4);
+
+        INSERT INTO role_permissions (role_id, permission)
+        SELECT role_id, 'locations.manage' FROM roles
+        WHERE name = 'equipment_manager'
+        ON CONFLICT DO NOTHING;
+    END IF;
+END
+$$;`
+
+const createSamplesLocationIndex = `
+CREATE INDEX IF NOT EXISTS idx_samples_location_id
+ON samples (location_id);`
+
+// createSamplesBoxPositionIndex keeps two samples out of the same box
+// position. Samples in the trash do not hold on to their position.
+const createSamplesBoxPositionIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_samples_box_position
+ON samples (location_id, box_position) WHERE box_position IS NOT NULL AND deleted_at IS NULL;`
+
+// createSampleRelationsTable links samples to the samples they were made
+// from. The relation describes the child: derived from, split from, or a
+// piece of its parent.
+const createSampleRelationsTable = `
+CREATE TABLE IF NOT EXISTS sample_relations (
+    parent_id INT NOT NULL REFERENCES samples(sample_id) ON DELETE CASCADE,
+    child_id INT NOT NULL REFERENCES samples(sample_id) ON DELETE CASCADE,
+    relation VARCHAR(20) NOT NULL CHECK (relation IN ('derived-from', 'split-from', 'piece-of')),
+    created_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (parent_id, child_id),
+    CHECK (parent_id <> child_id)
+);`
+
+const createSampleRelationsChildIndex = `
+CREATE INDEX IF NOT EXISTS idx_sample_relations_child_id
+ON sample_relations (child_id);`
+
+// seedBuiltinRoles creates the built-in roles with their default permissions.
+// Permissions are only written when a role is first created, so later edits
+// made directly in role_permissions survive restarts.
+const seedBuiltinRoles = `
+WITH builtin (name, description, permissions) AS (
+    VALUES
+        ('viewer', 'Read-only access to samples, the wiki and the booking calendar',
+            ARRAY[]::TEXT[]),
+        ('member', 'Register and edit samples, write wiki articles, book permitted equipment',
+            ARRAY['samples.create', 'samples.edit', 'samples.delete.own', 'wiki.edit', 'wiki.delete.own', 'bookings.create']),
+        ('equipment_manager', 'Member rights plus managing equipment, equipment access and all bookings',
+            ARRAY['samples.create', 'samples.edit', 'samples.delete.own', 'wiki.edit', 'wiki.delete.own', 'bookings.create',
+                  'bookings.manage', 'equipment.manage', 'locations.manage']),
+        ('admin', 'Full access to every feature and the admin panel',
+            ARRAY['samples.create', 'samples.edit', 'samples.manage', 'samples.delete', 'samples.purge',
+                  'wiki.edit', 'wiki.delete', 'bookings.create', 'bookings.manage', 'equipment.manage',
+                  'locations.manage', 'users.manage', 'roles.manage'])
+), inserted AS (
+    INSERT INTO roles (name, description)
+    SELECT name, description FROM builtin
+    ON CONFLICT (name) DO NOTHING
+    RETURNING role_id, name
+)
+INSERT INTO role_permissions (role_id, permission)
+SELECT inserted.role_id, unnest(builtin.permissions)
+FROM inserted
+JOIN builtin ON builtin.name = inserted.name;`
+
 const backfillGroups = `
 INSERT INTO groups (name)
 SELECT DISTINCT btrim("group")
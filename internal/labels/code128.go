@@ -0,0 +1,77 @@
+package labels
+
+import (
+	"fmt"
+	"strings"
+)
+
+// code128Patterns holds the bar patterns of the Code 128 symbols by value,
+// one character per module. Values 103 to 105 start code sets A, B and C;
+// 106 is the stop pattern, which includes the final bar.
+var code128Patterns = [...]string{
+	"11011001100", "11001101100", "11001100110", "10010011000", "10010001100",
+	"10001001100", "10011001000", "10011000100", "10001100100", "11001001000",
+	"11001000100", "11000100100", "10110011100", "10011011100", "10011001110",
+	"10111001100", "10011101100", "10011100110", "11001110010", "11001011100",
+	"11001001110", "11011100100", "11001110100", "11101101110", "11101001100",
+	"11100101100", "11100100110", "11101100100", "11100110100", "11100110010",
+	"11011011000", "11011000110", "11000110110", "10100011000", "10001011000",
+	"10001000110", "10110001000", "10001101000", "10001100010", "11010001000",
+	"11000101000", "11000100010", "10110111000", "10110001110", "10001101110",
+	"10111011000", "10111000110", "10001110110", "11101110110", "11010001110",
+	"11000101110", "11011101000", "11011100010", "11011101110", "11101011000",
+	"11101000110", "11100010110", "11101101000", "11101100010", "11100011010",
+	"11101111010", "11001000010", "11110001010", "10100110000", "10100001100",
+	"10010110000", "10010000110", "10000101100", "10000100110", "10110010000",
+	"10110000100", "10011010000", "10011000010", "10000110100", "10000110010",
+	"11000010010", "11001010000", "11110111010", "11000010100", "10001111010",
+	"10100111100", "10010111100", "10010011110", "10111100100", "10011110100",
+	"10011110010", "11110100100", "11110010100", "11110010010", "11011011110",
+	"11011110110", "11110110110", "10101111000", "10100011110", "10001011110",
+	"10111101000", "10111100010", "11110101000", "11110100010", "10111011110",
+	"10111101110", "11101011110", "11110101110", "11010000100", "11010010000",
+	"11010011100", "1100011101011",
+}
+
+const (
+	code128StartB = 104
+	code128Stop   = 106
+)
+
+// code128Values returns the symbol values of data in code set B, which
+// covers printable ASCII, with the start symbol and the check symbol.
+func code128Values(data string) ([]int, error) {
+	values := []int{code128StartB}
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c < 32 || c > 126 {
+			return nil, fmt.Errorf("labels: Code 128 cannot encode %q", data)
+		}
+		values = append(values, int(c)-32)
+	}
+	sum := values[0]
+	for i, v := range values[1:] {
+		sum += (i + 1) * v
+	}
+	return append(values, sum%103), nil
+}
+
+// encodeCode128 returns the modules of data as a Code 128 barcode, without
+// the quiet zones.
+func encodeCode128(data string) ([]bool, error) {
+	values, err := code128Values(data)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(code128Patterns[v])
+	}
+	b.WriteString(code128Patterns[code128Stop])
+
+	bars := make([]bool, b.Len())
+	for i, c := range b.String() {
+		bars[i] = c == '1'
+	}
+	return bars, nil
+}
@@ -0,0 +1,187 @@
+// Package labels renders sample labels: QR codes and Code 128 barcodes as
+// PNG or SVG images, sheets of labels as PDF for common label stock, and
+// ZPL for thermal label printers.
+package labels
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// Symbology is the kind of code printed on a label.
+type Symbology string
+
+const (
+	QR      Symbology = "qr"
+	Code128 Symbology = "code128"
+)
+
+// SymbologyInfo describes a symbology for forms.
+type SymbologyInfo struct {
+	Symbology Symbology
+	Label     string
+}
+
+// Symbologies lists the supported symbologies, the default first.
+var Symbologies = []SymbologyInfo{
+	{QR, "QR code"},
+	{Code128, "Code 128 barcode"},
+}
+
+// Valid reports whether s is a supported symbology.
+func (s Symbology) Valid() bool {
+	for _, info := range Symbologies {
+		if info.Symbology == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Quiet zones around the codes, in modules.
+const (
+	qrQuietZone     = 4
+	linearQuietZone = 10
+)
+
+// Symbol is an encoded code: a grid of modules, quiet zone included. A
+// linear symbol has a single row, stretched to any height when drawn.
+type Symbol struct {
+	Width  int
+	Height int
+	Linear bool
+	black  []bool
+}
+
+// Black reports whether the module at column x and row y is dark.
+func (s *Symbol) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= s.Width || y >= s.Height {
+		return false
+	}
+	return s.black[y*s.Width+x]
+}
+
+// Encode encodes data as a symbol of the given symbology.
+func Encode(sym Symbology, data string) (*Symbol, error) {
+	if data == "" {
+		return nil, errors.New("labels: nothing to encode")
+	}
+	switch sym {
+	case QR:
+		code, err := qr.Encode(data, qr.M)
+		if err != nil {
+			return nil, fmt.Errorf("labels: encode qr code: %w", err)
+		}
+		size := code.Size + 2*qrQuietZone
+		s := &Symbol{Width: size, Height: size, black: make([]bool, size*size)}
+		for y := 0; y < code.Size; y++ {
+			for x := 0; x < code.Size; x++ {
+				s.black[(y+qrQuietZone)*size+x+qrQuietZone] = code.Black(x, y)
+			}
+		}
+		return s, nil
+	case Code128:
+		bars, err := encodeCode128(data)
+		if err != nil {
+			return nil, err
+		}
+		s := &Symbol{Width: len(bars) + 2*linearQuietZone, Height: 1, Linear: true}
+		s.black = make([]bool, s.Width)
+		copy(s.black[linearQuietZone:], bars)
+		return s, nil
+	}
+	return nil, fmt.Errorf("labels: unknown symbology %q", sym)
+}
+
+// rect is a run of dark modules, in modules.
+type rect struct {
+	x, y, w, h int
+}
+
+// rects covers the dark modules of s with one rectangle per horizontal run.
+func (s *Symbol) rects() []rect {
+	var rs []rect
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; {
+			if !s.Black(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < s.Width && s.Black(x, y) {
+				x++
+			}
+			rs = append(rs, rect{start, y, x - start, 1})
+		}
+	}
+	return rs
+}
+
+// linearHeight is the height, in modules, of a linear symbol drawn on its
+// own: a quarter of its width, but no less than 40 modules.
+func linearHeight(s *Symbol) int {
+	if h := s.Width / 4; h > 40 {
+		return h
+	}
+	return 40
+}
+
+// PNG renders s with scale pixels per module.
+func PNG(s *Symbol, scale int) ([]byte, error) {
+	if scale < 1 {
+		scale = 1
+	}
+	height := s.Height
+	if s.Linear {
+		height = linearHeight(s)
+	}
+	img := image.NewPaletted(image.Rect(0, 0, s.Width*scale, height*scale), color.Palette{color.White, color.Black})
+	for y := 0; y < height*scale; y++ {
+		row := y / scale
+		if s.Linear {
+			row = 0
+		}
+		for x := 0; x < s.Width*scale; x++ {
+			if s.Black(x/scale, row) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders s with one user unit per module, scaled to scale pixels per
+// module by default.
+func SVG(s *Symbol, scale int) []byte {
+	if scale < 1 {
+		scale = 1
+	}
+	height := s.Height
+	if s.Linear {
+		height = linearHeight(s)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		s.Width, height, s.Width*scale, height*scale)
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for _, r := range s.rects() {
+		h := r.h
+		if s.Linear {
+			h = height
+		}
+		fmt.Fprintf(&b, "M%d %dh%dv%dh-%dz", r.x, r.y, r.w, h, r.w)
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
@@ -0,0 +1,150 @@
+package labels
+
+import (
+	"bytes"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCode128(t *testing.T) {
+	values, err := code128Values("SDB-000042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Start B, the characters, then (104 + Σ position × value) mod 103.
+	want := []int{104, 51, 36, 34, 13, 16, 16, 16, 16, 20, 18, 24}
+	if len(values) != len(want) {
+		t.Fatalf("values = %v, want %v", values, want)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Fatalf("values = %v, want %v", values, want)
+		}
+	}
+
+	s, err := Encode(Code128, "SDB-000042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Linear || s.Height != 1 || s.Width != 12*11+13+2*linearQuietZone {
+		t.Errorf("symbol is %dx%d, linear %v", s.Width, s.Height, s.Linear)
+	}
+	if s.Black(linearQuietZone-1, 0) || !s.Black(linearQuietZone, 0) || !s.Black(s.Width-linearQuietZone-1, 0) {
+		t.Error("bars do not start and end at the quiet zones")
+	}
+
+	if _, err := Encode(Code128, "Größe"); err == nil {
+		t.Error("Code 128 accepted characters outside ASCII")
+	}
+}
+
+func TestQRImages(t *testing.T) {
+	s, err := Encode(QR, "https://lab.example.org/samples/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Linear || s.Width != s.Height || !s.Black(qrQuietZone, qrQuietZone) || s.Black(0, 0) {
+		t.Fatalf("unexpected QR symbol %dx%d", s.Width, s.Height)
+	}
+
+	data, err := PNG(s, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("PNG does not decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 3*s.Width || b.Dy() != 3*s.Height {
+		t.Errorf("PNG is %v, want %d pixels square", b, 3*s.Width)
+	}
+
+	svg := string(SVG(s, 2))
+	if !strings.HasPrefix(svg, "<svg ") || !strings.Contains(svg, `viewBox="0 0 `) || !strings.Contains(svg, "M4 4h7v1h-7z") {
+		t.Errorf("unexpected SVG: %.200s", svg)
+	}
+}
+
+func TestPDF(t *testing.T) {
+	layout, ok := FindLayout("l7160")
+	if !ok {
+		t.Fatal("layout l7160 missing")
+	}
+	var labels []Label
+	for i := 0; i < 20; i++ {
+		labels = append(labels, Label{Data: "SDB-000042", Title: "GaAs (annealed) 400 °C", Lines: []string{"alice"}})
+	}
+
+	var buf bytes.Buffer
+	if err := PDF(&buf, layout, Code128, labels, 5); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+	if !strings.HasPrefix(doc, "%PDF-1.4") || !strings.HasSuffix(doc, "%%EOF\n") {
+		t.Fatal("not a PDF document")
+	}
+	// 5 skipped and 20 printed positions take two sheets of 21.
+	if !strings.Contains(doc, "/Count 2 ") {
+		t.Errorf("want two pages: %.300s", doc)
+	}
+	if !strings.Contains(doc, `(GaAs \(annealed\) 400 \260C) Tj`) {
+		t.Error("title not escaped for WinAnsiEncoding")
+	}
+
+	// The cross-reference table points at the objects.
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(doc)
+	if m == nil || !strings.HasPrefix(doc[atoi(m[1]):], "xref\n") {
+		t.Error("startxref does not point at the xref table")
+	}
+	for _, off := range regexp.MustCompile(`(\d{10}) 00000 n`).FindAllStringSubmatch(doc, -1) {
+		if !regexp.MustCompile(`^\d+ 0 obj`).MatchString(doc[atoi(off[1]):]) {
+			t.Errorf("xref offset %s does not start an object", off[1])
+		}
+	}
+
+	for _, l := range Layouts {
+		if right := l.Left + float64(l.Columns-1)*l.PitchX + l.LabelWidth; right > l.PageWidth {
+			t.Errorf("%s: labels run off the page to %.1f mm", l.ID, right)
+		}
+		if bottom := l.Top + float64(l.Rows-1)*l.PitchY + l.LabelHeight; bottom > l.PageHeight {
+			t.Errorf("%s: labels run off the page to %.1f mm", l.ID, bottom)
+		}
+	}
+}
+
+func TestZPL(t *testing.T) {
+	roll, _ := FindRoll("51x25")
+	var buf bytes.Buffer
+	labels := []Label{
+		{Data: "https://lab.example.org/samples/7", Title: "Si_wafer^2", Lines: []string{"SDB-000007"}},
+		{Data: "https://lab.example.org/samples/8", Title: "Si 3"},
+	}
+	if err := ZPL(&buf, roll, 203, QR, labels); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "^XA"); n != 2 || strings.Count(out, "^XZ") != 2 {
+		t.Errorf("want 2 labels, got %d", n)
+	}
+	for _, want := range []string{"^PW406\n", "^LL203\n", "^BQN,2,", "^FDMA,https://lab.example.org/samples/7^FS", "^FDSi_5Fwafer_5E2^FS"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("ZPL lacks %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	if err := ZPL(&buf, roll, 300, Code128, labels[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "^BCN,") {
+		t.Errorf("Code 128 label lacks ^BC:\n%s", buf.String())
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
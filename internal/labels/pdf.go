@@ -0,0 +1,258 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Label is the content of one label: the data encoded in its code, a title
+// printed in bold and further lines of text.
+type Label struct {
+	Data  string
+	Title string
+	Lines []string
+}
+
+// Layout describes a sheet of label stock. Lengths are in millimetres,
+// measured from the top left corner of the page; the pitch is the distance
+// from one label to the start of the next.
+type Layout struct {
+	ID          string
+	Name        string
+	PageWidth   float64
+	PageHeight  float64
+	Columns     int
+	Rows        int
+	LabelWidth  float64
+	LabelHeight float64
+	Left        float64
+	Top         float64
+	PitchX      float64
+	PitchY      float64
+}
+
+// PerSheet is the number of labels on a sheet.
+func (l Layout) PerSheet() int {
+	return l.Columns * l.Rows
+}
+
+// Layouts lists the supported label sheets, the default first.
+var Layouts = []Layout{
+	{
+		ID: "l7651", Name: "Avery L7651 — 65 per A4 sheet, 38.1 × 21.2 mm",
+		PageWidth: 210, PageHeight: 297, Columns: 5, Rows: 13,
+		LabelWidth: 38.1, LabelHeight: 21.2, Left: 4.75, Top: 10.7, PitchX: 40.64, PitchY: 21.2,
+	},
+	{
+		ID: "l7160", Name: "Avery L7160 — 21 per A4 sheet, 63.5 × 38.1 mm",
+		PageWidth: 210, PageHeight: 297, Columns: 3, Rows: 7,
+		LabelWidth: 63.5, LabelHeight: 38.1, Left: 7.2, Top: 15.1, PitchX: 66, PitchY: 38.1,
+	},
+	{
+		ID: "5160", Name: "Avery 5160 — 30 per Letter sheet, 2⅝ × 1 in",
+		PageWidth: 215.9, PageHeight: 279.4, Columns: 3, Rows: 10,
+		LabelWidth: 66.675, LabelHeight: 25.4, Left: 4.7625, Top: 12.7, PitchX: 69.85, PitchY: 25.4,
+	},
+}
+
+// FindLayout returns the layout with the given ID.
+func FindLayout(id string) (Layout, bool) {
+	for _, l := range Layouts {
+		if l.ID == id {
+			return l, true
+		}
+	}
+	return Layout{}, false
+}
+
+const (
+	ptPerMM    = 72 / 25.4
+	labelPad   = 1.5 // mm of margin inside a label
+	lineHeight = 1.2 // line height as a multiple of the font size
+)
+
+// PDF writes labels as a PDF document of sheets in layout. The first skip
+// positions of the first sheet are left blank, so that a partly used sheet
+// can be fed again.
+func PDF(w io.Writer, layout Layout, sym Symbology, labels []Label, skip int) error {
+	if skip < 0 || skip >= layout.PerSheet() {
+		skip = 0
+	}
+	symbols := make([]*Symbol, len(labels))
+	for i, l := range labels {
+		s, err := Encode(sym, l.Data)
+		if err != nil {
+			return err
+		}
+		symbols[i] = s
+	}
+
+	var pages []string
+	var page strings.Builder
+	for i := range labels {
+		slot := (skip + i) % layout.PerSheet()
+		if slot == 0 && page.Len() > 0 {
+			pages = append(pages, page.String())
+			page.Reset()
+		}
+		x := layout.Left + float64(slot%layout.Columns)*layout.PitchX
+		y := layout.Top + float64(slot/layout.Columns)*layout.PitchY
+		drawLabel(&page, layout, x, y, symbols[i], labels[i])
+	}
+	if page.Len() > 0 || len(pages) == 0 {
+		pages = append(pages, page.String())
+	}
+	return writePDF(w, layout.PageWidth*ptPerMM, layout.PageHeight*ptPerMM, pages)
+}
+
+// drawLabel adds the drawing operators of one label, whose top left corner
+// is at x, y millimetres from the top left of the page, to page. Everything
+// is clipped to the label.
+func drawLabel(page *strings.Builder, layout Layout, x, y float64, s *Symbol, l Label) {
+	pageH := layout.PageHeight
+	w, h := layout.LabelWidth, layout.LabelHeight
+	// pt converts a point given in millimetres from the top left of the
+	// page to PDF coordinates.
+	pt := func(mx, my float64) (float64, float64) { return mx * ptPerMM, (pageH - my) * ptPerMM }
+
+	lx, ly := pt(x, y+h)
+	fmt.Fprintf(page, "q %.2f %.2f %.2f %.2f re W n\n", lx, ly, w*ptPerMM, h*ptPerMM)
+
+	inner := h - 2*labelPad
+	var textX, textY, textW float64
+	if s.Linear {
+		// The bars span the width of the label above the text.
+		barH := inner * 0.55
+		module := (w - 2*labelPad) / float64(s.Width)
+		drawSymbol(page, s, pt, x+labelPad, y+labelPad, module, barH)
+		textX, textY, textW = x+labelPad, y+labelPad+barH+0.5, w-2*labelPad
+	} else {
+		// The code sits on the left with the text beside it.
+		size := inner
+		if size > w*0.5 {
+			size = w * 0.5
+		}
+		drawSymbol(page, s, pt, x+labelPad, y+(h-size)/2, size/float64(s.Width), size)
+		textX, textY, textW = x+labelPad+size+labelPad, y+labelPad, w-3*labelPad-size
+	}
+
+	lines := append([]string{l.Title}, l.Lines...)
+	room := y + h - labelPad - textY
+	size := room * ptPerMM / (float64(len(lines)) * lineHeight)
+	if size > 9 {
+		size = 9
+	}
+	if size >= 3 {
+		for i, text := range lines {
+			font, fsize := "F1", size*0.85
+			if i == 0 {
+				font, fsize = "F2", size
+			}
+			baseline := textY + (float64(i)*lineHeight+1)*size/ptPerMM
+			if baseline > y+h-labelPad+0.1 {
+				break
+			}
+			tx, ty := pt(textX, baseline)
+			fmt.Fprintf(page, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+				font, fsize, tx, ty, pdfString(fitText(text, textW*ptPerMM, fsize)))
+		}
+	}
+	page.WriteString("Q\n")
+}
+
+// drawSymbol fills the dark modules of s at x, y millimetres from the top
+// left of the page, with modules module millimetres wide. Square symbols
+// use square modules; linear ones are height millimetres tall.
+func drawSymbol(page *strings.Builder, s *Symbol, pt func(float64, float64) (float64, float64), x, y, module, height float64) {
+	for _, r := range s.rects() {
+		top, rh := y+float64(r.y)*module, float64(r.h)*module
+		if s.Linear {
+			top, rh = y, height
+		}
+		px, py := pt(x+float64(r.x)*module, top+rh)
+		fmt.Fprintf(page, "%.3f %.3f %.3f %.3f re\n", px, py, float64(r.w)*module*ptPerMM, rh*ptPerMM)
+	}
+	page.WriteString("f\n")
+}
+
+// fitText shortens text with an ellipsis to fit width points at the given
+// font size, estimating the width of Helvetica characters.
+func fitText(text string, width, size float64) string {
+	n := int(width / (size * 0.55))
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	if n < 2 {
+		return ""
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// pdfString escapes text for a PDF string in WinAnsiEncoding. Characters
+// the encoding lacks are replaced by question marks.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r == '…':
+			b.WriteString(`\205`)
+		case r == '–':
+			b.WriteString(`\226`)
+		case r == '—':
+			b.WriteString(`\227`)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// writePDF writes a PDF document with the given page contents. Every page
+// has the same size and uses Helvetica as F1 and Helvetica-Bold as F2.
+func writePDF(w io.Writer, width, height float64, pages []string) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(format string, args ...interface{}) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&buf, format, args...)
+		buf.WriteString("\nendobj\n")
+	}
+
+	// Objects 1 to 4 are the catalog, the page tree and the fonts; each
+	// page is followed by its content stream.
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range pages {
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			width, height, 6+2*i)
+		object("<< /Length %d >>\nstream\n%sendstream", len(content), content)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,97 @@
+package labels
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Roll is a size of label on a roll for thermal printers, in millimetres.
+type Roll struct {
+	ID     string
+	Name   string
+	Width  float64
+	Height float64
+}
+
+// Rolls lists the supported thermal label sizes, the default first.
+var Rolls = []Roll{
+	{ID: "51x25", Name: "2 × 1 in (51 × 25 mm)", Width: 50.8, Height: 25.4},
+	{ID: "38x13", Name: "1.5 × 0.5 in (38 × 13 mm), cryo vials", Width: 38.1, Height: 12.7},
+	{ID: "102x51", Name: "4 × 2 in (102 × 51 mm)", Width: 101.6, Height: 50.8},
+}
+
+// FindRoll returns the roll with the given ID.
+func FindRoll(id string) (Roll, bool) {
+	for _, r := range Rolls {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Roll{}, false
+}
+
+// Resolutions lists the print resolutions of common thermal printers, in
+// dots per inch.
+var Resolutions = []int{203, 300}
+
+// ZPL writes labels as ZPL II for a thermal printer with the given
+// resolution, one label of size roll each. The printer draws the codes
+// itself; the symbol is only encoded here to size them.
+func ZPL(w io.Writer, roll Roll, dpi int, sym Symbology, labels []Label) error {
+	dots := func(mm float64) int { return int(mm*float64(dpi)/25.4 + 0.5) }
+	width, height, pad := dots(roll.Width), dots(roll.Height), dots(labelPad)
+	inner := height - 2*pad
+
+	var b strings.Builder
+	for _, l := range labels {
+		s, err := Encode(sym, l.Data)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&b, "^XA\n^CI28\n^PW%d\n^LL%d\n", width, height)
+		var textX, textY, textW int
+		if s.Linear {
+			module := clamp((width-2*pad)/s.Width, 1, 10)
+			barH := inner * 55 / 100
+			fmt.Fprintf(&b, "^FO%d,%d^BY%d^BCN,%d,N,N,N^FH_^FD%s^FS\n", pad, pad, module, barH, zplEscape(l.Data))
+			textX, textY, textW = pad, pad+barH+dots(0.5), width-2*pad
+		} else {
+			size := inner
+			if size > width/2 {
+				size = width / 2
+			}
+			magnification := clamp(size/(s.Width-2*qrQuietZone), 1, 10)
+			fmt.Fprintf(&b, "^FO%d,%d^BQN,2,%d^FH_^FDMA,%s^FS\n", pad, pad, magnification, zplEscape(l.Data))
+			textX, textY, textW = 2*pad+size, pad, width-3*pad-size
+		}
+
+		lines := append([]string{l.Title}, l.Lines...)
+		font := clamp((height-pad-textY)*10/(len(lines)*12), 10, dots(3.2))
+		for i, text := range lines {
+			y := textY + i*font*12/10
+			if y+font > height {
+				break
+			}
+			fmt.Fprintf(&b, "^FO%d,%d^A0N,%d,%d^FB%d,1,0,L^FH_^FD%s^FS\n", textX, y, font, font, textW, zplEscape(text))
+		}
+		b.WriteString("^XZ\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// zplEscape hex-escapes the characters ZPL treats as commands in field
+// data written with ^FH_.
+var zplEscape = strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace
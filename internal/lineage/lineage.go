@@ -0,0 +1,131 @@
+// Package lineage describes how samples are made from one another: typed
+// links from a parent sample to the samples derived, split, or cut from it,
+// and the layout of a sample's ancestors and descendants for display.
+package lineage
+
+import "sort"
+
+// Kind is the type of a link, read from the child: the child is derived
+// from, split from, or a piece of its parent.
+type Kind string
+
+const (
+	DerivedFrom Kind = "derived-from"
+	SplitFrom   Kind = "split-from"
+	PieceOf     Kind = "piece-of"
+)
+
+// KindInfo describes a kind of link for display. Label reads from the
+// child towards the parent, ChildLabel names the child as seen from the
+// parent.
+type KindInfo struct {
+	Kind       Kind
+	Label      string
+	ChildLabel string
+}
+
+// Kinds lists the kinds of link, the default first.
+var Kinds = []KindInfo{
+	{DerivedFrom, "Derived from", "Derived"},
+	{SplitFrom, "Split from", "Split"},
+	{PieceOf, "Piece of", "Piece"},
+}
+
+// MaxDepth bounds how many generations a walk follows in each direction.
+const MaxDepth = 20
+
+func info(k Kind) (KindInfo, bool) {
+	for _, ki := range Kinds {
+		if ki.Kind == k {
+			return ki, true
+		}
+	}
+	return KindInfo{}, false
+}
+
+// Valid reports whether k is a known kind.
+func (k Kind) Valid() bool {
+	_, ok := info(k)
+	return ok
+}
+
+// Label returns the display name of k, such as "Split from".
+func (k Kind) Label() string {
+	if ki, ok := info(k); ok {
+		return ki.Label
+	}
+	return string(k)
+}
+
+// ChildLabel returns the name of a child linked by k, such as "Split".
+func (k Kind) ChildLabel() string {
+	if ki, ok := info(k); ok {
+		return ki.ChildLabel
+	}
+	return string(k)
+}
+
+// Link relates a child sample to the parent it was made from.
+type Link struct {
+	Parent int
+	Child  int
+	Kind   Kind
+}
+
+// Step is a sample reached by a walk from another one. Via is the sample
+// one step closer to the start, Kind the link between the two, and Depth
+// the number of links from the start.
+type Step struct {
+	ID    int
+	Via   int
+	Kind  Kind
+	Depth int
+}
+
+// Ancestors walks from the sample id up to the samples it was made from,
+// depth-first with the parents of each sample in the order of their IDs.
+// A sample reached along more than one path is listed once, where it is
+// first reached.
+func Ancestors(links []Link, id int) []Step {
+	next := map[int][]Link{}
+	for _, l := range links {
+		next[l.Child] = append(next[l.Child], l)
+	}
+	return walk(next, id, func(l Link) int { return l.Parent })
+}
+
+// Descendants walks from the sample id down to the samples made from it,
+// in the same order as Ancestors.
+func Descendants(links []Link, id int) []Step {
+	next := map[int][]Link{}
+	for _, l := range links {
+		next[l.Parent] = append(next[l.Parent], l)
+	}
+	return walk(next, id, func(l Link) int { return l.Child })
+}
+
+func walk(next map[int][]Link, start int, other func(Link) int) []Step {
+	for _, list := range next {
+		sort.Slice(list, func(i, j int) bool { return other(list[i]) < other(list[j]) })
+	}
+
+	var steps []Step
+	seen := map[int]bool{start: true}
+	var visit func(id, depth int)
+	visit = func(id, depth int) {
+		if depth > MaxDepth {
+			return
+		}
+		for _, l := range next[id] {
+			to := other(l)
+			if seen[to] {
+				continue
+			}
+			seen[to] = true
+			steps = append(steps, Step{ID: to, Via: id, Kind: l.Kind, Depth: depth})
+			visit(to, depth+1)
+		}
+	}
+	visit(start, 1)
+	return steps
+}
@@ -0,0 +1,55 @@
+package lineage
+
+import "testing"
+
+func TestWalk(t *testing.T) {
+	// 1 was split into 2 and 3; 4 was derived from both halves and cut into
+	// pieces 5 and 6. 7 and 8 link to each other, which the walk survives.
+	links := []Link{
+		{1, 3, SplitFrom},
+		{1, 2, SplitFrom},
+		{2, 4, DerivedFrom},
+		{3, 4, DerivedFrom},
+		{4, 6, PieceOf},
+		{4, 5, PieceOf},
+		{7, 8, DerivedFrom},
+		{8, 7, DerivedFrom},
+	}
+
+	want := []Step{
+		{ID: 2, Via: 1, Kind: SplitFrom, Depth: 1},
+		{ID: 4, Via: 2, Kind: DerivedFrom, Depth: 2},
+		{ID: 5, Via: 4, Kind: PieceOf, Depth: 3},
+		{ID: 6, Via: 4, Kind: PieceOf, Depth: 3},
+		{ID: 3, Via: 1, Kind: SplitFrom, Depth: 1},
+	}
+	got := Descendants(links, 1)
+	if len(got) != len(want) {
+		t.Fatalf("Descendants(1) = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Descendants(1)[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	up := Ancestors(links, 5)
+	if len(up) != 4 || up[0].ID != 4 || up[1].ID != 2 || up[2].ID != 1 || up[3].ID != 3 || up[3].Depth != 2 {
+		t.Errorf("Ancestors(5) = %+v", up)
+	}
+	if steps := Ancestors(links, 1); len(steps) != 0 {
+		t.Errorf("Ancestors of a root = %+v, want none", steps)
+	}
+	if steps := Descendants(links, 7); len(steps) != 1 || steps[0].ID != 8 {
+		t.Errorf("Descendants(7) in a loop = %+v, want only 8", steps)
+	}
+}
+
+func TestKinds(t *testing.T) {
+	if !PieceOf.Valid() || Kind("copy-of").Valid() {
+		t.Error("Valid does not match Kinds")
+	}
+	if PieceOf.Label() != "Piece of" || SplitFrom.ChildLabel() != "Split" {
+		t.Errorf("labels = %q, %q", PieceOf.Label(), SplitFrom.ChildLabel())
+	}
+}
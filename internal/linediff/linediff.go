@@ -0,0 +1,181 @@
+// Package linediff computes line-based differences between two texts and
+// groups them into hunks for display, like a unified diff.
+package linediff
+
+import "strings"
+
+// Op is the kind of a diff line.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// maxCells bounds the table used to find the longest common subsequence.
+// Larger changes are shown as the old lines replaced by the new ones.
+const maxCells = 1 << 21
+
+// Line is one line of a diff. OldNumber and NewNumber are 1-based line
+// numbers in the old and new text, zero when the line is not part of it.
+type Line struct {
+	Op        Op
+	Text      string
+	OldNumber int
+	NewNumber int
+}
+
+// Hunk is a run of changes with the unchanged lines around them.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// SplitLines splits text into lines, accepting \n and \r\n endings. An empty
+// text has no lines and a final newline does not start another one.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Diff returns the lines of a and b as a minimal sequence of unchanged,
+// deleted and inserted lines.
+func Diff(a, b string) []Line {
+	old, cur := SplitLines(a), SplitLines(b)
+
+	prefix := 0
+	for prefix < len(old) && prefix < len(cur) && old[prefix] == cur[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(cur)-prefix && old[len(old)-1-suffix] == cur[len(cur)-1-suffix] {
+		suffix++
+	}
+
+	var lines []Line
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, Line{Op: Equal, Text: old[i], OldNumber: i + 1, NewNumber: i + 1})
+	}
+	lines = append(lines, diffMiddle(old[prefix:len(old)-suffix], cur[prefix:len(cur)-suffix], prefix, prefix)...)
+	for i := 0; i < suffix; i++ {
+		o, n := len(old)-suffix+i, len(cur)-suffix+i
+		lines = append(lines, Line{Op: Equal, Text: old[o], OldNumber: o + 1, NewNumber: n + 1})
+	}
+	return lines
+}
+
+// diffMiddle diffs the part of the texts between their common prefix and
+// suffix, which start at line offsets oldOff and newOff.
+func diffMiddle(old, cur []string, oldOff, newOff int) []Line {
+	n, m := len(old), len(cur)
+	var lines []Line
+	if n == 0 || m == 0 || (n+1)*(m+1) > maxCells {
+		for i, text := range old {
+			lines = append(lines, Line{Op: Delete, Text: text, OldNumber: oldOff + i + 1})
+		}
+		for j, text := range cur {
+			lines = append(lines, Line{Op: Insert, Text: text, NewNumber: newOff + j + 1})
+		}
+		return lines
+	}
+
+	// lcs[i*(m+1)+j] is the length of the longest common subsequence of
+	// old[i:] and cur[j:].
+	lcs := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case old[i] == cur[j]:
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+			case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j]
+			default:
+				lcs[i*(m+1)+j] = lcs[i*(m+1)+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && old[i] == cur[j]:
+			lines = append(lines, Line{Op: Equal, Text: old[i], OldNumber: oldOff + i + 1, NewNumber: newOff + j + 1})
+			i++
+			j++
+		case j == m || (i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]):
+			lines = append(lines, Line{Op: Delete, Text: old[i], OldNumber: oldOff + i + 1})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: cur[j], NewNumber: newOff + j + 1})
+			j++
+		}
+	}
+	return lines
+}
+
+// Hunks groups the changes in lines with up to context unchanged lines on
+// each side. Changes closer than twice the context share a hunk. It returns
+// nil when nothing changed.
+func Hunks(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+	var cur *Hunk
+	lastChange := -1
+	for i, line := range lines {
+		if line.Op == Equal {
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		if cur != nil && start <= lastChange+context+1 {
+			// Extend the current hunk up to this change.
+			for k := lastChange + 1; k <= i; k++ {
+				cur.add(lines[k])
+			}
+		} else {
+			if cur != nil {
+				cur.close(lines, lastChange, context)
+				hunks = append(hunks, *cur)
+			}
+			cur = &Hunk{}
+			for k := start; k <= i; k++ {
+				cur.add(lines[k])
+			}
+		}
+		lastChange = i
+	}
+	if cur != nil {
+		cur.close(lines, lastChange, context)
+		hunks = append(hunks, *cur)
+	}
+	return hunks
+}
+
+func (h *Hunk) add(line Line) {
+	if line.Op != Insert {
+		if h.OldLines == 0 {
+			h.OldStart = line.OldNumber
+		}
+		h.OldLines++
+	}
+	if line.Op != Delete {
+		if h.NewLines == 0 {
+			h.NewStart = line.NewNumber
+		}
+		h.NewLines++
+	}
+	h.Lines = append(h.Lines, line)
+}
+
+// close appends the context after the last change of the hunk.
+func (h *Hunk) close(lines []Line, lastChange, context int) {
+	for k := lastChange + 1; k < len(lines) && k <= lastChange+context; k++ {
+		h.add(lines[k])
+	}
+}
@@ -0,0 +1,82 @@
+package linediff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// render writes lines in unified diff notation.
+func render(lines []Line) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString([]string{" ", "-", "+"}[l.Op])
+		b.WriteString(l.Text)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestDiff(t *testing.T) {
+	old := "Clean substrate\nSpin coat PMMA\nBake 180 C\nExpose\nDevelop\n"
+	cur := "Clean substrate\r\nSpin coat PMMA A4\r\nBake 180 C\r\nDevelop\r\nInspect\r\n"
+
+	got := render(Diff(old, cur))
+	want := " Clean substrate\n" +
+		"-Spin coat PMMA\n" +
+		"+Spin coat PMMA A4\n" +
+		" Bake 180 C\n" +
+		"-Expose\n" +
+		" Develop\n" +
+		"+Inspect\n"
+	if got != want {
+		t.Fatalf("Diff =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiffNumbersLines(t *testing.T) {
+	lines := Diff("a\nb\nc", "a\nx\nc")
+	want := []Line{
+		{Op: Equal, Text: "a", OldNumber: 1, NewNumber: 1},
+		{Op: Delete, Text: "b", OldNumber: 2},
+		{Op: Insert, Text: "x", NewNumber: 2},
+		{Op: Equal, Text: "c", OldNumber: 3, NewNumber: 3},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("Diff = %+v, want %+v", lines, want)
+	}
+
+	if lines := Diff("", "new"); len(lines) != 1 || lines[0].Op != Insert {
+		t.Fatalf("Diff from empty = %+v", lines)
+	}
+	if lines := Diff("same\n", "same"); len(lines) != 1 || lines[0].Op != Equal {
+		t.Fatalf("Diff ignoring the final newline = %+v", lines)
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var old []string
+	for i := 1; i <= 20; i++ {
+		old = append(old, strings.Repeat("x", i))
+	}
+	cur := append([]string(nil), old...)
+	cur[1] = "changed 2"
+	cur[4] = "changed 5"
+	cur[16] = "changed 17"
+
+	hunks := Hunks(Diff(strings.Join(old, "\n"), strings.Join(cur, "\n")), 2)
+	if len(hunks) != 2 {
+		t.Fatalf("got %d hunks, want 2: %+v", len(hunks), hunks)
+	}
+	first, second := hunks[0], hunks[1]
+	if first.OldStart != 1 || first.OldLines != 7 || first.NewStart != 1 || first.NewLines != 7 {
+		t.Errorf("first hunk covers -%d,%d +%d,%d", first.OldStart, first.OldLines, first.NewStart, first.NewLines)
+	}
+	if second.OldStart != 15 || second.OldLines != 5 || len(second.Lines) != 6 {
+		t.Errorf("second hunk covers -%d,%d with %d lines", second.OldStart, second.OldLines, len(second.Lines))
+	}
+
+	if hunks := Hunks(Diff("a\nb", "a\nb"), 3); hunks != nil {
+		t.Errorf("Hunks of equal texts = %+v, want nil", hunks)
+	}
+}
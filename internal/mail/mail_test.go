@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single session and records what the client sent.
+type fakeSMTPServer struct {
+	ln       net.Listener
+	auth     chan string
+	rcpt     chan string
+	messages chan string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeSMTPServer{
+		ln:       ln,
+		auth:     make(chan string, 1),
+		rcpt:     make(chan string, 1),
+		messages: make(chan string, 1),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(line string) { _ = tp.PrintfLine("%s", line) }
+
+	reply("220 fake.test ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO":
+			reply("250-fake.test")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth <- strings.TrimPrefix(line, "AUTH PLAIN ")
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			reply("250 OK")
+		case "RCPT":
+			s.rcpt <- line
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.messages <- string(data)
+			reply("250 OK queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPSenderDeliversMessage(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	sender := NewSMTPSender(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "mailer",
+		Password: "hunter2",
+		From:     "SampleDB <noreply@lab.example>",
+		TLS:      TLSNone,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := sender.Send(ctx, Message{
+		To:      "alice@lab.example",
+		Subject: "Reset your password",
+		Body:    "Open this link:\nhttps://samples.lab.example/reset-password?token=abc=def\n",
+	})
+	if err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	creds, err := base64.StdEncoding.DecodeString(<-server.auth)
+	if err != nil || string(creds) != "\x00mailer\x00hunter2" {
+		t.Fatalf("unexpected AUTH PLAIN payload %q (%v)", creds, err)
+	}
+	if rcpt := <-server.rcpt; rcpt != "RCPT TO:<alice@lab.example>" {
+		t.Fatalf("unexpected recipient line %q", rcpt)
+	}
+
+	raw := <-server.messages
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(raw))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("parse headers: %v", err)
+	}
+	if got := msg.Get("To"); got != "<alice@lab.example>" {
+		t.Errorf("To = %q", got)
+	}
+	if got := msg.Get("From"); got != `"SampleDB" <noreply@lab.example>` {
+		t.Errorf("From = %q", got)
+	}
+	if got := msg.Get("Subject"); got != "Reset your password" {
+		t.Errorf("Subject = %q", got)
+	}
+
+	_, encoded, _ := strings.Cut(raw, "\n\n")
+	body, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(encoded)))
+	if err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if !strings.Contains(string(body), "reset-password?token=abc=def") {
+		t.Errorf("body lost the reset link: %q", body)
+	}
+}
+
+func TestSMTPSenderRejectsHeaderInjection(t *testing.T) {
+	sender := NewSMTPSender(SMTPConfig{Host: "127.0.0.1", Port: 1, From: "noreply@lab.example"})
+
+	cases := []Message{
+		{To: "alice@lab.example\r\nBcc: mallory@evil.example", Subject: "hi"},
+		{To: "alice@lab.example", Subject: "hi\r\nBcc: mallory@evil.example"},
+		{To: "not an address", Subject: "hi"},
+	}
+	for _, msg := range cases {
+		if err := sender.Send(context.Background(), msg); err == nil {
+			t.Errorf("Send(%q, %q) should have failed", msg.To, msg.Subject)
+		}
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	for addr, want := range map[string]string{
+		"alice@lab.example":          "alice@lab.example",
+		" Alice <alice@lab.example>": "alice@lab.example",
+		"alice":                      "",
+		"":                           "",
+		"a@b.example\nBcc: x@y.test": "",
+	} {
+		got, err := NormalizeAddress(addr)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("NormalizeAddress(%q) = %q, %v; want %q", addr, got, err, want)
+		}
+	}
+}
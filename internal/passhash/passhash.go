@@ -0,0 +1,195 @@
+// Package passhash hashes and verifies passwords. Hashes carry their
+// algorithm and parameters, so stored hashes keep working when the
+// configured strength changes and can be upgraded at the next sign-in.
+//
+// Argon2id hashes use the PHC string format
+// ($argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>); bcrypt hashes
+// use the usual $2a$/$2b$ form.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported algorithms.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// ErrUnknownFormat is returned for a stored value that is not a hash this
+// package understands.
+var ErrUnknownFormat = errors.New("passhash: unrecognised hash format")
+
+// Argon2Params are the Argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2Params follows the second recommended option of RFC 9106:
+// 64 MiB, three passes, four lanes.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:  64 * 1024,
+		Time:    3,
+		Threads: 4,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+// Hasher produces new hashes with one algorithm and decides which stored
+// hashes are weaker than that and should be replaced.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+// Default returns a hasher using Argon2id with the default parameters.
+func Default() Hasher {
+	return Hasher{
+		Algorithm:  Argon2id,
+		Argon2:     DefaultArgon2Params(),
+		BcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+// Validate reports configuration errors.
+func (h Hasher) Validate() error {
+	switch h.Algorithm {
+	case Argon2id:
+		p := h.Argon2
+		if p.Memory < 8*uint32(p.Threads) || p.Time < 1 || p.Threads < 1 || p.SaltLen < 8 || p.KeyLen < 16 {
+			return fmt.Errorf("passhash: invalid argon2id parameters m=%d t=%d p=%d", p.Memory, p.Time, p.Threads)
+		}
+	case Bcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("passhash: bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("passhash: unknown algorithm %q", h.Algorithm)
+	}
+	return nil
+}
+
+// Hash returns the encoded hash of password.
+func (h Hasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case Argon2id:
+		p := h.Argon2
+		salt := make([]byte, p.SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		return encodeArgon2(p, salt, key), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("passhash: unknown algorithm %q", h.Algorithm)
+	}
+}
+
+// NeedsRehash reports whether encoded was made with a different algorithm
+// or weaker parameters than h would use now. Unrecognised values never need
+// a rehash, since they cannot have been verified.
+func (h Hasher) NeedsRehash(encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		if h.Algorithm != Argon2id {
+			return true
+		}
+		p, _, _, err := decodeArgon2(encoded)
+		if err != nil {
+			return false
+		}
+		want := h.Argon2
+		return p.Memory < want.Memory || p.Time < want.Time || p.Threads < want.Threads || p.KeyLen < want.KeyLen
+	case isBcrypt(encoded):
+		if h.Algorithm != Bcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err == nil && cost < h.BcryptCost
+	default:
+		return false
+	}
+}
+
+// Verify reports whether password matches encoded, whichever supported
+// algorithm produced it. A mismatch is not an error; ErrUnknownFormat is
+// returned for values that are not hashes, such as the placeholder stored
+// for single sign-on accounts.
+func Verify(encoded, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, err
+		}
+		got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		return subtle.ConstantTimeCompare(got, key) == 1, nil
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, ErrUnknownFormat
+	}
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+var b64 = base64.RawStdEncoding
+
+func encodeArgon2(p Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(key))
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("passhash: unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
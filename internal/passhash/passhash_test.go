@@ -0,0 +1,98 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast; the parameters are not meant for production.
+func cheap() Hasher {
+	h := Default()
+	h.Argon2 = Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	h.BcryptCost = bcrypt.MinCost
+	return h
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := cheap()
+	encoded, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+	if again, _ := h.Hash("correct horse"); again == encoded {
+		t.Fatalf("hashes must be salted")
+	}
+
+	if ok, err := Verify(encoded, "correct horse"); !ok || err != nil {
+		t.Fatalf("Verify(correct) = %v, %v", ok, err)
+	}
+	if ok, err := Verify(encoded, "wrong horse"); ok || err != nil {
+		t.Fatalf("Verify(wrong) = %v, %v", ok, err)
+	}
+	if h.NeedsRehash(encoded) {
+		t.Fatalf("a hash made with the current parameters needs no rehash")
+	}
+
+	stronger := h
+	stronger.Argon2.Time = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Fatalf("a hash with fewer passes should be rehashed")
+	}
+}
+
+func TestLegacyBcryptHashes(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	if ok, err := Verify(string(legacy), "secret"); !ok || err != nil {
+		t.Fatalf("Verify(bcrypt) = %v, %v", ok, err)
+	}
+	if ok, err := Verify(string(legacy), "Secret"); ok || err != nil {
+		t.Fatalf("Verify(bcrypt, wrong) = %v, %v", ok, err)
+	}
+
+	if !cheap().NeedsRehash(string(legacy)) {
+		t.Fatalf("bcrypt hashes should be upgraded to argon2id")
+	}
+
+	costlier := cheap()
+	costlier.Algorithm = Bcrypt
+	if costlier.NeedsRehash(string(legacy)) {
+		t.Fatalf("bcrypt at the configured cost needs no rehash")
+	}
+	costlier.BcryptCost = bcrypt.MinCost + 1
+	if !costlier.NeedsRehash(string(legacy)) {
+		t.Fatalf("bcrypt below the configured cost should be rehashed")
+	}
+}
+
+func TestVerifyRejectsUnknownFormats(t *testing.T) {
+	for _, encoded := range []string{"", "!", "plaintext", "$argon2id$v=19$m=1024$bad"} {
+		if ok, err := Verify(encoded, "plaintext"); ok || err == nil {
+			t.Fatalf("Verify(%q) = %v, %v; want a failure", encoded, ok, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default hasher: %v", err)
+	}
+	h := Default()
+	h.Algorithm = "md5"
+	if err := h.Validate(); err == nil {
+		t.Fatalf("unknown algorithms must be rejected")
+	}
+	h = Default()
+	h.Argon2.Time = 0
+	if err := h.Validate(); err == nil {
+		t.Fatalf("zero passes must be rejected")
+	}
+}
@@ -0,0 +1,206 @@
+// Package rbac implements role-based access control. Roles and the
+// permissions they grant are stored in the database; users receive roles
+// directly (user_roles) or through their group (group_roles).
+package rbac
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/dbiface"
+)
+
+// Permission names an action. A role holding "<permission>.own" may perform
+// the action only on resources the user owns.
+type Permission string
+
+const (
+	SamplesCreate   Permission = "samples.create"
+	SamplesEdit     Permission = "samples.edit"
+	SamplesManage   Permission = "samples.manage"
+	SamplesDelete   Permission = "samples.delete"
+	SamplesPurge    Permission = "samples.purge"
+	WikiEdit        Permission = "wiki.edit"
+	WikiDelete      Permission = "wiki.delete"
+	BookingsCreate  Permission = "bookings.create"
+	BookingsManage  Permission = "bookings.manage"
+	EquipmentManage Permission = "equipment.manage"
+	LocationsManage Permission = "locations.manage"
+	UsersManage     Permission = "users.manage"
+	RolesManage     Permission = "roles.manage"
+)
+
+// Built-in role names.
+const (
+	RoleViewer           = "viewer"
+	RoleMember           = "member"
+	RoleEquipmentManager = "equipment_manager"
+	RoleAdmin            = "admin"
+)
+
+// DefaultRole applies to users who have no role of their own or via their group.
+const DefaultRole = RoleMember
+
+const ownSuffix = ".own"
+
+// Own returns the owner-restricted form of p.
+func (p Permission) Own() Permission {
+	return p + ownSuffix
+}
+
+// PermissionInfo describes a permission for the admin UI.
+type PermissionInfo struct {
+	Name        Permission
+	Description string
+}
+
+// Permissions lists every permission the application checks.
+var Permissions = []PermissionInfo{
+	{SamplesCreate, "Register new samples"},
+	{SamplesEdit, "Edit sample details, preparation notes and attachments"},
+	{SamplesManage, "See every sample whatever its visibility; transfer any sample to another owner"},
+	{SamplesDelete, "Move samples to the trash and restore them"},
+	{SamplesPurge, "Permanently remove samples from the trash, with their attachment files"},
+	{WikiEdit, "Create and edit wiki articles and their attachments"},
+	{WikiDelete, "Delete wiki articles"},
+	{BookingsCreate, "Book equipment the user has been granted access to"},
+	{BookingsManage, "Book any equipment and cancel other users' bookings"},
+	{EquipmentManage, "Add and remove equipment, grant equipment access, export reports"},
+	{LocationsManage, "Add and remove storage locations"},
+	{UsersManage, "Approve, lock, reset and remove user accounts; revoke API tokens"},
+	{RolesManage, "Assign roles to users and groups"},
+}
+
+// Resource is the object an action targets. The zero value means the check
+// is not about a particular object, so only unrestricted grants apply.
+type Resource struct {
+	OwnerID int
+}
+
+// OwnedBy returns a resource owned by userID.
+func OwnedBy(userID int) Resource {
+	return Resource{OwnerID: userID}
+}
+
+// Grants is the resolved set of roles and permissions for one user.
+type Grants struct {
+	UserID int
+	Roles  []string
+	perms  map[Permission]bool
+}
+
+// Can reports whether the grants allow perm on res.
+func (g Grants) Can(perm Permission, res Resource) bool {
+	if g.IsAdmin() || g.perms[perm] {
+		return true
+	}
+	return res.OwnerID != 0 && res.OwnerID == g.UserID && g.perms[perm.Own()]
+}
+
+// Has reports whether any role grants perm, counting owner-restricted grants.
+func (g Grants) Has(perm Permission) bool {
+	return g.IsAdmin() || g.perms[perm] || g.perms[perm.Own()]
+}
+
+// IsAdmin reports whether the user holds the admin role, which implies every
+// permission including ones added after the role was seeded.
+func (g Grants) IsAdmin() bool {
+	for _, role := range g.Roles {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizer resolves grants from the database.
+type Authorizer struct {
+	pool dbiface.Pool
+}
+
+// New creates an Authorizer backed by pool.
+func New(pool dbiface.Pool) *Authorizer {
+	return &Authorizer{pool: pool}
+}
+
+// Can reports whether the session's user may perform perm on res. Lookup
+// failures deny access.
+func (a *Authorizer) Can(session auth.Session, perm Permission, res Resource) bool {
+	grants, err := a.Grants(context.Background(), session.UserID)
+	if err != nil {
+		log.Printf("rbac: unable to load grants for user %d: %v", session.UserID, err)
+		return false
+	}
+	return grants.Can(perm, res)
+}
+
+// Grants loads the roles and permissions of userID. The legacy users.admin
+// flag counts as the admin role, and users without any role get DefaultRole.
+// Deleted or unknown users receive no grants.
+func (a *Authorizer) Grants(ctx context.Context, userID int) (Grants, error) {
+	grants := Grants{UserID: userID, perms: map[Permission]bool{}}
+
+	var (
+		admin bool
+		roles []string
+	)
+	err := a.pool.QueryRow(ctx, `
+        SELECT COALESCE(u.admin, false),
+               COALESCE(array_agg(DISTINCT r.name) FILTER (WHERE r.name IS NOT NULL), '{}')
+        FROM users u
+        LEFT JOIN groups g ON g.name = btrim(u."group")
+        LEFT JOIN roles r ON r.role_id IN (
+                SELECT role_id FROM user_roles WHERE user_id = u.user_id
+                UNION
+                SELECT role_id FROM group_roles WHERE group_id = g.group_id)
+        WHERE u.user_id = $1 AND COALESCE(u.deleted, false) = false
+        GROUP BY u.admin`, userID).Scan(&admin, &roles)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return grants, nil
+	}
+	if err != nil {
+		return grants, err
+	}
+
+	if len(roles) == 0 {
+		roles = []string{DefaultRole}
+	}
+	if admin && !contains(roles, RoleAdmin) {
+		roles = append(roles, RoleAdmin)
+	}
+	sort.Strings(roles)
+	grants.Roles = roles
+
+	rows, err := a.pool.Query(ctx, `
+        SELECT DISTINCT rp.permission
+        FROM role_permissions rp
+        JOIN roles r ON r.role_id = rp.role_id
+        WHERE r.name = ANY($1)`, roles)
+	if err != nil {
+		return grants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return grants, err
+		}
+		grants.perms[Permission(perm)] = true
+	}
+	return grants, rows.Err()
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v3"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/passhash"
+)
+
+func expectGrants(mock pgxmock.PgxPoolIface, userID int, admin bool, roles, resolved, perms []string) {
+	mock.ExpectQuery(`SELECT COALESCE\(u.admin, false\)`).
+		WithArgs(userID).
+		WillReturnRows(pgxmock.NewRows([]string{"admin", "roles"}).AddRow(admin, roles))
+
+	rows := pgxmock.NewRows([]string{"permission"})
+	for _, p := range perms {
+		rows.AddRow(p)
+	}
+	mock.ExpectQuery(`SELECT DISTINCT rp.permission`).
+		WithArgs(resolved).
+		WillReturnRows(rows)
+}
+
+func TestGrantsDefaultsToMember(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	expectGrants(mock, 7, false, []string{}, []string{RoleMember},
+		[]string{"samples.edit", "wiki.edit", "wiki.delete.own"})
+
+	grants, err := New(mock).Grants(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("Grants returned error: %v", err)
+	}
+
+	checks := []struct {
+		perm Permission
+		res  Resource
+		want bool
+	}{
+		{SamplesEdit, Resource{}, true},
+		{WikiDelete, OwnedBy(7), true},
+		{WikiDelete, OwnedBy(8), false},
+		{WikiDelete, Resource{}, false},
+		{UsersManage, Resource{}, false},
+	}
+	for _, c := range checks {
+		if got := grants.Can(c.perm, c.res); got != c.want {
+			t.Errorf("Can(%s, %+v) = %v, want %v", c.perm, c.res, got, c.want)
+		}
+	}
+	if !grants.Has(WikiDelete) {
+		t.Errorf("Has should count owner-restricted grants")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGrantsAdminFlagImpliesEveryPermission(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	expectGrants(mock, 1, true, []string{RoleViewer}, []string{RoleAdmin, RoleViewer}, nil)
+
+	authorizer := New(mock)
+	if !authorizer.Can(auth.Session{UserID: 1}, RolesManage, Resource{}) {
+		t.Fatalf("expected legacy admin flag to grant roles.manage")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// A user who holds the admin role only through their group is an
+// administrator for the two-factor policy too: they cannot sign in without
+// enrolling, nor turn 2FA off.
+func TestGroupAdminMustUseTwoFactor(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	hashed, err := passhash.Default().Hash("secret")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	authorizer := New(mock)
+	manager := auth.NewManager(mock)
+	manager.SetRequireAdminTwoFactor(true)
+	manager.SetAdminCheck(func(ctx context.Context, userID int) (bool, error) {
+		grants, err := authorizer.Grants(ctx, userID)
+		return grants.IsAdmin(), err
+	})
+
+	mock.ExpectQuery(`SELECT user_id, password_hash, is_approved`).
+		WithArgs("frank").
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "password_hash", "is_approved", "deleted", "totp_enabled", "locked_until"}).
+			AddRow(12, hashed, true, false, false, nil))
+	// users.admin is false; the role comes from group_roles.
+	expectGrants(mock, 12, false, []string{RoleAdmin}, []string{RoleAdmin}, nil)
+	mock.ExpectExec(`INSERT INTO login_challenges`).
+		WithArgs(pgxmock.AnyArg(), 12, "frank", pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=frank&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	manager.LoginHandler()(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/login/2fa/setup" {
+		t.Fatalf("expected the group admin to be sent to 2FA enrolment, got %q", loc)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session_token" {
+			t.Fatalf("session cookie must not be issued before enrolment")
+		}
+	}
+
+	expectGrants(mock, 12, false, []string{RoleAdmin}, []string{RoleAdmin}, nil)
+	required, err := manager.TwoFactorRequired(context.Background(), 12)
+	if err != nil || !required {
+		t.Fatalf("TwoFactorRequired = %v, %v; want true", required, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGrantsDeletedUserHasNoRoles(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT COALESCE\(u.admin, false\)`).
+		WithArgs(9).
+		WillReturnError(pgx.ErrNoRows)
+
+	grants, err := New(mock).Grants(context.Background(), 9)
+	if err != nil {
+		t.Fatalf("Grants returned error: %v", err)
+	}
+	if len(grants.Roles) != 0 || grants.Can(SamplesCreate, Resource{}) {
+		t.Fatalf("deleted user should have no grants, got %+v", grants)
+	}
+}
+
+func TestSetUserRolesRejectsUnknownRole(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM user_roles WHERE user_id = \$1`).
+		WithArgs(4).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectExec(`INSERT INTO user_roles \(user_id, role_id\)`).
+		WithArgs(4, []int{2, 99, 2}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectRollback()
+
+	err = New(mock).SetUserRoles(context.Background(), 4, []int{2, 99, 2})
+	if !errors.Is(err, ErrUnknownRole) {
+		t.Fatalf("expected ErrUnknownRole, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
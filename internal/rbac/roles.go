@@ -0,0 +1,120 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnknownRole is returned when an assignment names a role that does not exist.
+var ErrUnknownRole = errors.New("rbac: unknown role")
+
+// Role is a named set of permissions.
+type Role struct {
+	ID          int
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// ListRoles returns every role with its permissions, ordered by name.
+func (a *Authorizer) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := a.pool.Query(ctx, `
+        SELECT r.role_id, r.name, COALESCE(r.description, ''),
+               COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+        FROM roles r
+        LEFT JOIN role_permissions rp ON rp.role_id = r.role_id
+        GROUP BY r.role_id, r.name, r.description
+        ORDER BY r.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// UserRoleIDs maps user IDs to the roles assigned to them directly.
+func (a *Authorizer) UserRoleIDs(ctx context.Context) (map[int][]int, error) {
+	return a.assignments(ctx, "SELECT user_id, role_id FROM user_roles ORDER BY role_id")
+}
+
+// GroupRoleIDs maps group IDs to the roles their members inherit.
+func (a *Authorizer) GroupRoleIDs(ctx context.Context) (map[int][]int, error) {
+	return a.assignments(ctx, "SELECT group_id, role_id FROM group_roles ORDER BY role_id")
+}
+
+// SetUserRoles replaces the roles assigned directly to userID.
+func (a *Authorizer) SetUserRoles(ctx context.Context, userID int, roleIDs []int) error {
+	return a.replace(ctx, "user_roles", "user_id", userID, roleIDs)
+}
+
+// SetGroupRoles replaces the roles assigned to groupID.
+func (a *Authorizer) SetGroupRoles(ctx context.Context, groupID int, roleIDs []int) error {
+	return a.replace(ctx, "group_roles", "group_id", groupID, roleIDs)
+}
+
+func (a *Authorizer) assignments(ctx context.Context, query string) (map[int][]int, error) {
+	rows, err := a.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int][]int)
+	for rows.Next() {
+		var ownerID, roleID int
+		if err := rows.Scan(&ownerID, &roleID); err != nil {
+			return nil, err
+		}
+		result[ownerID] = append(result[ownerID], roleID)
+	}
+	return result, rows.Err()
+}
+
+// replace swaps the assignments in table for one owner inside a transaction.
+// table and column are always package constants, never user input.
+func (a *Authorizer) replace(ctx context.Context, table, column string, ownerID int, roleIDs []int) error {
+	tx, err := a.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE "+column+" = $1", ownerID); err != nil {
+		return err
+	}
+
+	if len(roleIDs) > 0 {
+		tag, err := tx.Exec(ctx,
+			"INSERT INTO "+table+" ("+column+", role_id) SELECT $1, role_id FROM roles WHERE role_id = ANY($2)",
+			ownerID, roleIDs)
+		if err != nil {
+			return err
+		}
+		if int(tag.RowsAffected()) != len(uniqueInts(roleIDs)) {
+			return ErrUnknownRole
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	out := values[:0:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,474 @@
+// Package sampleimport reads sample records from CSV or JSON files, maps
+// their columns to sample fields and validates them against the limits of
+// the samples table, so that a whole file can be checked before anything is
+// inserted.
+package sampleimport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Supported file formats.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// MaxRows is the largest number of records accepted in one file.
+const MaxRows = 5000
+
+// Sample fields that columns can be mapped to.
+const (
+	FieldName        = "name"
+	FieldDescription = "description"
+	FieldKeywords    = "keywords"
+	FieldOwner       = "owner"
+	FieldPrep        = "prep"
+	FieldCreated     = "created"
+)
+
+// Field describes a sample field for the mapping form.
+type Field struct {
+	Key   string
+	Label string
+}
+
+// Fields lists the sample fields in form order.
+var Fields = []Field{
+	{FieldName, "Name"},
+	{FieldDescription, "Description"},
+	{FieldKeywords, "Keywords"},
+	{FieldOwner, "Owner"},
+	{FieldPrep, "Preparation notes"},
+	{FieldCreated, "Created date"},
+}
+
+// Column lengths of the samples table, in characters.
+const (
+	maxNameLen     = 100
+	maxOwnerLen    = 100
+	maxKeywordsLen = 255
+)
+
+// Table is the content of an import file: a header and the rows below it.
+// Lines holds the source line of each row for CSV and the 1-based element
+// index for JSON.
+type Table struct {
+	Format  string
+	Columns []string
+	Rows    [][]string
+	Lines   []int
+}
+
+// Parse reads data as CSV or JSON. With an empty format it is detected from
+// the first character. CSV files need a header row and may be separated by
+// commas, semicolons or tabs; JSON files hold an array of objects.
+func Parse(data []byte, format string) (*Table, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if format == "" {
+		format = FormatCSV
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+			format = FormatJSON
+		}
+	}
+
+	var t *Table
+	var err error
+	switch format {
+	case FormatCSV:
+		t, err = parseCSV(data)
+	case FormatJSON:
+		t, err = parseJSON(data)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Rows) == 0 {
+		return nil, errors.New("the file contains no samples")
+	}
+	if len(t.Rows) > MaxRows {
+		return nil, fmt.Errorf("the file contains more than %d samples; split it into smaller files", MaxRows)
+	}
+	return t, nil
+}
+
+// csvDelimiter picks the separator that occurs most often in the header.
+func csvDelimiter(data []byte) rune {
+	header := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		header = data[:i]
+	}
+	best, count := ',', bytes.Count(header, []byte{','})
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(header, []byte{byte(d)}); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+func parseCSV(data []byte) (*Table, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = csvDelimiter(data)
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	t := &Table{Format: FormatCSV, Columns: trimAll(header)}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if blank(record) {
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		t.Rows = append(t.Rows, record)
+		t.Lines = append(t.Lines, line)
+		if len(t.Rows) > MaxRows {
+			break
+		}
+	}
+	return t, nil
+}
+
+func parseJSON(data []byte) (*Table, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("invalid JSON: expected an array of objects: %w", err)
+	}
+
+	// Keys become columns in the order they are first seen.
+	t := &Table{Format: FormatJSON}
+	index := map[string]int{}
+	for i, raw := range items {
+		keys, err := objectKeys(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON in item %d: %w", i+1, err)
+		}
+		var item map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("invalid JSON in item %d: %w", i+1, err)
+		}
+		for _, k := range keys {
+			if _, ok := index[k]; !ok {
+				index[k] = len(t.Columns)
+				t.Columns = append(t.Columns, k)
+			}
+		}
+		if len(item) == 0 {
+			continue
+		}
+		row := make([]string, len(t.Columns))
+		for k, v := range item {
+			s, err := jsonValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("item %d, %q: %w", i+1, k, err)
+			}
+			row[index[k]] = s
+		}
+		t.Rows = append(t.Rows, row)
+		t.Lines = append(t.Lines, i+1)
+		if len(t.Rows) > MaxRows {
+			break
+		}
+	}
+	return t, nil
+}
+
+// objectKeys returns the keys of a JSON object in document order.
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("expected an object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// jsonValue flattens a JSON value into a cell. Arrays of scalars, as used
+// for keyword lists, are joined with commas.
+func jsonValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			if _, nested := e.([]interface{}); nested {
+				return "", errors.New("nested arrays are not supported")
+			}
+			s, err := jsonValue(e)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ", "), nil
+	default:
+		return "", errors.New("nested objects are not supported")
+	}
+}
+
+func trimAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+func blank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Mapping assigns a sample field to each column of a table; an empty entry
+// ignores the column.
+type Mapping []string
+
+// headerAliases maps normalised column names to fields.
+var headerAliases = map[string]string{
+	"name":                  FieldName,
+	"samplename":            FieldName,
+	"sample":                FieldName,
+	"title":                 FieldName,
+	"description":           FieldDescription,
+	"sampledescription":     FieldDescription,
+	"desc":                  FieldDescription,
+	"keywords":              FieldKeywords,
+	"samplekeywords":        FieldKeywords,
+	"keyword":               FieldKeywords,
+	"kw":                    FieldKeywords,
+	"tags":                  FieldKeywords,
+	"owner":                 FieldOwner,
+	"sampleowner":           FieldOwner,
+	"prep":                  FieldPrep,
+	"sampleprep":            FieldPrep,
+	"preparation":           FieldPrep,
+	"preparationnotes":      FieldPrep,
+	"preparationtechnology": FieldPrep,
+	"created":               FieldCreated,
+	"createdat":             FieldCreated,
+	"createddate":           FieldCreated,
+	"date":                  FieldCreated,
+}
+
+func normaliseHeader(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// GuessMapping maps columns whose header names a sample field, such as
+// "Sample name" or "sample_owner". Each field is used at most once.
+func GuessMapping(columns []string) Mapping {
+	m := make(Mapping, len(columns))
+	used := map[string]bool{}
+	for i, c := range columns {
+		if field, ok := headerAliases[normaliseHeader(c)]; ok && !used[field] {
+			m[i] = field
+			used[field] = true
+		}
+	}
+	return m
+}
+
+// Check reports mapping errors: an unknown field, a field used twice, or no
+// column for the name.
+func (m Mapping) Check() error {
+	used := map[string]bool{}
+	for _, field := range m {
+		if field == "" {
+			continue
+		}
+		if !isField(field) {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if used[field] {
+			return fmt.Errorf("more than one column is mapped to %s", label(field))
+		}
+		used[field] = true
+	}
+	if !used[FieldName] {
+		return errors.New("map a column to Name")
+	}
+	return nil
+}
+
+func isField(key string) bool {
+	for _, f := range Fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func label(key string) string {
+	for _, f := range Fields {
+		if f.Key == key {
+			return f.Label
+		}
+	}
+	return key
+}
+
+// Record is one sample read from a table. Errors lists the problems that
+// keep it from being imported.
+type Record struct {
+	Line        int
+	Name        string
+	Description string
+	Keywords    string
+	Owner       string
+	Prep        string
+	Created     *time.Time
+	Errors      []string
+}
+
+// dateLayouts are the accepted formats of the created column.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"02.01.2006",
+}
+
+// Records applies m to every row of t and validates the result. Dates
+// without a zone are read in loc, and dates with one are converted to it.
+func Records(t *Table, m Mapping, loc *time.Location) []Record {
+	records := make([]Record, len(t.Rows))
+	for i, row := range t.Rows {
+		rec := &records[i]
+		rec.Line = t.Lines[i]
+		for col, field := range m {
+			if field == "" || col >= len(row) {
+				continue
+			}
+			value := strings.TrimSpace(row[col])
+			switch field {
+			case FieldName:
+				rec.Name = value
+			case FieldDescription:
+				rec.Description = value
+			case FieldKeywords:
+				rec.Keywords = normaliseKeywords(value)
+			case FieldOwner:
+				rec.Owner = value
+			case FieldPrep:
+				rec.Prep = value
+			case FieldCreated:
+				if value == "" {
+					continue
+				}
+				created, err := parseDate(value, loc)
+				if err != nil {
+					rec.Errors = append(rec.Errors, err.Error())
+					continue
+				}
+				rec.Created = &created
+			}
+		}
+		rec.validate()
+	}
+	return records
+}
+
+func (r *Record) validate() {
+	if r.Name == "" {
+		r.Errors = append(r.Errors, "name is missing")
+	}
+	r.checkLength("name", r.Name, maxNameLen)
+	r.checkLength("owner", r.Owner, maxOwnerLen)
+	r.checkLength("keywords", r.Keywords, maxKeywordsLen)
+}
+
+func (r *Record) checkLength(field, value string, max int) {
+	if n := utf8.RuneCountInString(value); n > max {
+		r.Errors = append(r.Errors, fmt.Sprintf("%s is %d characters long; the limit is %d", field, n, max))
+	}
+}
+
+// normaliseKeywords accepts keywords separated by commas or semicolons and
+// stores them comma-separated, as the sample form does.
+func normaliseKeywords(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' })
+	keywords := parts[:0]
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			keywords = append(keywords, p)
+		}
+	}
+	return strings.Join(keywords, ", ")
+}
+
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t.In(loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("created date %q is not a date such as 2025-03-14", s)
+}
+
+// ErrorCount returns the number of records that cannot be imported.
+func ErrorCount(records []Record) int {
+	n := 0
+	for _, r := range records {
+		if len(r.Errors) > 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+package sampleimport
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSVDetectsSemicolonsAndSkipsBlankRows(t *testing.T) {
+	data := "\xef\xbb\xbfSample name;Owner;Keywords\n" +
+		"GaAs-01;alice;\"MBE; anneal\"\n" +
+		";;\n" +
+		"\"Si\nwafer\";bob;\n"
+
+	table, err := Parse([]byte(data), "")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if table.Format != FormatCSV || !reflect.DeepEqual(table.Columns, []string{"Sample name", "Owner", "Keywords"}) {
+		t.Fatalf("unexpected table header: %+v", table)
+	}
+	if len(table.Rows) != 2 || !reflect.DeepEqual(table.Lines, []int{2, 4}) {
+		t.Fatalf("rows = %q, lines = %v", table.Rows, table.Lines)
+	}
+}
+
+func TestParseJSONKeepsKeyOrderAndJoinsArrays(t *testing.T) {
+	data := `[
+		{"name": "GaAs-01", "keywords": ["MBE", "anneal"], "thickness": 120.5},
+		{"owner": "bob", "name": "Si-02", "keywords": null}
+	]`
+
+	table, err := Parse([]byte(data), "")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(table.Columns, []string{"name", "keywords", "thickness", "owner"}) {
+		t.Fatalf("columns = %q", table.Columns)
+	}
+	if !reflect.DeepEqual(table.Rows[0], []string{"GaAs-01", "MBE, anneal", "120.5"}) {
+		t.Fatalf("first row = %q", table.Rows[0])
+	}
+
+	if _, err := Parse([]byte(`[{"name": {"first": "x"}}]`), FormatJSON); err == nil {
+		t.Fatal("expected an error for a nested object")
+	}
+}
+
+func TestParseRejectsTooManyRows(t *testing.T) {
+	data := "name\n" + strings.Repeat("x\n", MaxRows+1)
+	if _, err := Parse([]byte(data), FormatCSV); err == nil {
+		t.Fatal("expected an error for too many rows")
+	}
+}
+
+func TestGuessMappingAndCheck(t *testing.T) {
+	m := GuessMapping([]string{"Sample_Name", "Notes", "owner", "Name", "Created at"})
+	want := Mapping{FieldName, "", FieldOwner, "", FieldCreated}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("GuessMapping = %q, want %q", m, want)
+	}
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if err := (Mapping{"", FieldOwner}).Check(); err == nil {
+		t.Fatal("expected an error without a name column")
+	}
+	if err := (Mapping{FieldName, FieldOwner, FieldOwner}).Check(); err == nil {
+		t.Fatal("expected an error for a field mapped twice")
+	}
+}
+
+func TestRecordsValidateRows(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	table := &Table{
+		Columns: []string{"name", "keywords", "created", "owner"},
+		Rows: [][]string{
+			{" GaAs-01 ", "MBE; anneal,, ", "2025-03-14", "alice"},
+			{"", "", "14/03/2025", strings.Repeat("o", 101)},
+		},
+		Lines: []int{2, 3},
+	}
+
+	records := Records(table, GuessMapping(table.Columns), loc)
+
+	first := records[0]
+	if first.Name != "GaAs-01" || first.Keywords != "MBE, anneal" || first.Owner != "alice" || len(first.Errors) != 0 {
+		t.Fatalf("unexpected first record: %+v", first)
+	}
+	if first.Created == nil || !first.Created.Equal(time.Date(2025, 3, 14, 0, 0, 0, 0, loc)) {
+		t.Fatalf("created = %v", first.Created)
+	}
+
+	if got := len(records[1].Errors); got != 3 {
+		t.Fatalf("second record errors = %q, want 3", records[1].Errors)
+	}
+	if ErrorCount(records) != 1 {
+		t.Fatalf("ErrorCount = %d, want 1", ErrorCount(records))
+	}
+}
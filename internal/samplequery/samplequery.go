@@ -0,0 +1,400 @@
+// Package samplequery parses the search language of the sample list and
+// compiles it to parameterised SQL over the samples table.
+//
+// A query is a list of terms separated by spaces, commas or semicolons. All
+// terms must match.
+//
+//	anneal gaas               words, matched as prefixes by the full-text index
+//	"spin coated"             an exact phrase
+//	owner:alice               a substring of a field; quote values with spaces
+//	kw:graphene               a keyword of the sample (exact, ignoring case)
+//	created:>2025-01-01       a creation date comparison
+//	created:2025-03           a day, month or year
+//	created:2025-01..2025-06  a range of dates, both ends included
+//	-kw:failed                a leading minus excludes matching samples
+//
+// Fields are name, owner, kw (or keyword), desc (or description), prep and
+// created. Date comparisons are >, >=, < and <=.
+package samplequery
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fields that can be used as field:value.
+const (
+	FieldName        = "name"
+	FieldOwner       = "owner"
+	FieldKeyword     = "kw"
+	FieldDescription = "desc"
+	FieldPrep        = "prep"
+	FieldCreated     = "created"
+)
+
+var fieldAliases = map[string]string{
+	"name":        FieldName,
+	"owner":       FieldOwner,
+	"kw":          FieldKeyword,
+	"keyword":     FieldKeyword,
+	"desc":        FieldDescription,
+	"description": FieldDescription,
+	"prep":        FieldPrep,
+	"created":     FieldCreated,
+}
+
+// SyntaxError describes a query that cannot be parsed. Pos is the
+// zero-based character offset of the problem.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// Term is one condition of a query. Field is empty for free text; Phrase is
+// set for quoted free text. Op is only used by date terms.
+type Term struct {
+	Field  string
+	Op     string
+	Value  string
+	Negate bool
+	Phrase bool
+
+	// Date terms cover the half-open range [from, to), as YYYY-MM-DD.
+	from, to string
+}
+
+// Query is a parsed search expression.
+type Query struct {
+	Terms []Term
+}
+
+// Empty reports whether the query has no terms.
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0
+}
+
+// Parse parses a search expression.
+func Parse(input string) (Query, error) {
+	p := parser{input: []rune(input)}
+	var q Query
+	for {
+		p.skipSeparators()
+		if p.done() {
+			return q, nil
+		}
+		term, err := p.term()
+		if err != nil {
+			return Query{}, err
+		}
+		q.Terms = append(q.Terms, term)
+	}
+}
+
+type parser struct {
+	input []rune
+	pos   int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == ',' || r == ';'
+}
+
+func (p *parser) skipSeparators() {
+	for !p.done() && isSeparator(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) term() (Term, error) {
+	var t Term
+	start := p.pos
+	if p.input[p.pos] == '-' {
+		t.Negate = true
+		p.pos++
+		if p.done() || isSeparator(p.input[p.pos]) {
+			return t, p.errorf(start, "nothing to exclude after -")
+		}
+	}
+
+	if p.input[p.pos] == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return t, err
+		}
+		t.Value, t.Phrase = value, true
+		return t, nil
+	}
+
+	wordStart := p.pos
+	word := p.bare()
+	colon := strings.IndexRune(word, ':')
+	if colon < 0 {
+		t.Value = word
+		return t, nil
+	}
+
+	name := strings.ToLower(word[:colon])
+	field, ok := fieldAliases[name]
+	if !ok {
+		return t, p.errorf(wordStart, "unknown field %q; put text containing a colon in quotes", word[:colon])
+	}
+	t.Field = field
+
+	// The value is the rest of the bare word, or a quoted string right
+	// after the colon.
+	valueStart := wordStart + utf8.RuneCountInString(word[:colon+1])
+	t.Value = word[colon+1:]
+	if t.Value == "" && !p.done() && p.input[p.pos] == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return t, err
+		}
+		t.Value = value
+	}
+	if strings.TrimSpace(t.Value) == "" {
+		return t, p.errorf(valueStart, "missing value after %s:", name)
+	}
+
+	if field == FieldCreated {
+		if err := t.parseDate(); err != nil {
+			return t, p.errorf(valueStart, "%v", err)
+		}
+	}
+	return t, nil
+}
+
+// bare reads up to the next separator or quote.
+func (p *parser) bare() string {
+	start := p.pos
+	for !p.done() && !isSeparator(p.input[p.pos]) && p.input[p.pos] != '"' {
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+// quoted reads a double-quoted string. A backslash escapes the next
+// character.
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+	var b strings.Builder
+	for !p.done() {
+		r := p.input[p.pos]
+		p.pos++
+		switch {
+		case r == '"':
+			if strings.TrimSpace(b.String()) == "" {
+				return "", p.errorf(start, "empty quotes")
+			}
+			return b.String(), nil
+		case r == '\\' && !p.done():
+			b.WriteRune(p.input[p.pos])
+			p.pos++
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return "", p.errorf(start, "missing closing quote")
+}
+
+var dateLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// parsePeriod parses a day, month or year into the range it covers.
+func parsePeriod(s string) (from, to time.Time, err error) {
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l.layout, s); err == nil {
+			return t, l.next(t), nil
+		}
+	}
+	return from, to, fmt.Errorf("invalid date %q; use YYYY-MM-DD, YYYY-MM or YYYY", s)
+}
+
+// parseDate turns the value of a created: term into a date range.
+func (t *Term) parseDate() error {
+	const day = "2006-01-02"
+	value := t.Value
+
+	if lo, hi, ok := strings.Cut(value, ".."); ok {
+		from, _, err := parsePeriod(lo)
+		if err != nil {
+			return err
+		}
+		_, to, err := parsePeriod(hi)
+		if err != nil {
+			return err
+		}
+		if !to.After(from) {
+			return fmt.Errorf("date range %q ends before it starts", value)
+		}
+		t.Op, t.from, t.to = "..", from.Format(day), to.Format(day)
+		return nil
+	}
+
+	t.Op = "="
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			t.Op, value = op, value[len(op):]
+			break
+		}
+	}
+	from, to, err := parsePeriod(value)
+	if err != nil {
+		return err
+	}
+	// Comparisons are against the whole period: >2025-01 means from
+	// February onwards, <=2025-01 includes all of January.
+	switch t.Op {
+	case ">":
+		t.from = to.Format(day)
+	case ">=":
+		t.from = from.Format(day)
+	case "<":
+		t.to = from.Format(day)
+	case "<=":
+		t.to = to.Format(day)
+	default:
+		t.from, t.to = from.Format(day), to.Format(day)
+	}
+	return nil
+}
+
+// TextTerms returns the values of the terms that must match, for
+// highlighting them in results. Dates are left out.
+func (q Query) TextTerms() []string {
+	var terms []string
+	for _, t := range q.Terms {
+		if !t.Negate && t.Field != FieldCreated {
+			terms = append(terms, t.Value)
+		}
+	}
+	return terms
+}
+
+// SQL is a compiled query.
+type SQL struct {
+	// Where is a boolean expression, "TRUE" for an empty query.
+	Where string
+	// Rank is a relevance expression for ORDER BY (higher is better), empty
+	// when the query has no free text.
+	Rank string
+	// Args holds the arguments passed to Compile followed by the query's own.
+	Args []interface{}
+}
+
+// Compile turns q into SQL over the samples table. Placeholders are numbered
+// after args, so the result can be combined with conditions that are
+// already bound.
+func (q Query) Compile(args []interface{}) SQL {
+	c := compiler{args: args}
+	var conds []string
+	var words []string
+
+	for _, t := range q.Terms {
+		if t.Field == "" && !t.Phrase && !t.Negate {
+			words = append(words, t.Value)
+			continue
+		}
+		cond := c.condition(t)
+		if t.Negate {
+			// A NULL date or an empty tsquery does not match, so it must
+			// not be excluded either.
+			cond = "NOT coalesce(" + cond + ", false)"
+		}
+		conds = append(conds, cond)
+	}
+
+	// Free-text words are matched together so they share one tsquery; the
+	// name substring match keeps finding fragments inside longer names.
+	var ranked []string
+	if len(words) > 0 {
+		text := strings.Join(words, " ")
+		tsq := "sample_prefix_query(" + c.bind(text) + ")"
+		ranked = append(ranked, tsq)
+		conds = append([]string{fmt.Sprintf("(search_vector @@ %s OR sample_name ILIKE %s)",
+			tsq, c.bind("%"+escapeLike(text)+"%"))}, conds...)
+	}
+	ranked = append(ranked, c.phrases...)
+
+	out := SQL{Where: "TRUE", Args: c.args}
+	if len(conds) > 0 {
+		out.Where = strings.Join(conds, " AND ")
+	}
+	if len(ranked) > 0 {
+		out.Rank = fmt.Sprintf("coalesce(ts_rank(search_vector, %s), 0)", strings.Join(ranked, " && "))
+	}
+	return out
+}
+
+type compiler struct {
+	args    []interface{}
+	phrases []string
+}
+
+func (c *compiler) bind(v interface{}) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+var likeColumns = map[string]string{
+	FieldName:        "sample_name",
+	FieldOwner:       "sample_owner",
+	FieldDescription: "sample_description",
+	FieldPrep:        "sample_prep",
+}
+
+func (c *compiler) condition(t Term) string {
+	switch t.Field {
+	case "":
+		if t.Phrase {
+			tsq := "phraseto_tsquery('simple', " + c.bind(t.Value) + ")"
+			if !t.Negate {
+				c.phrases = append(c.phrases, tsq)
+			}
+			return "(search_vector @@ " + tsq + ")"
+		}
+		return "(search_vector @@ sample_prefix_query(" + c.bind(t.Value) + "))"
+	case FieldKeyword:
+		return "EXISTS (SELECT 1 FROM unnest(string_to_array(lower(sample_keywords), ',')) AS kw WHERE btrim(kw) = " +
+			c.bind(strings.ToLower(strings.TrimSpace(t.Value))) + ")"
+	case FieldCreated:
+		var parts []string
+		if t.from != "" {
+			parts = append(parts, "created_at >= "+c.bind(t.from))
+		}
+		if t.to != "" {
+			parts = append(parts, "created_at < "+c.bind(t.to))
+		}
+		return "(" + strings.Join(parts, " AND ") + ")"
+	default:
+		return "(coalesce(" + likeColumns[t.Field] + ", '') ILIKE " + c.bind("%"+escapeLike(t.Value)+"%") + ")"
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
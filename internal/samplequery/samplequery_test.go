@@ -0,0 +1,109 @@
+package samplequery
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTerms(t *testing.T) {
+	q, err := Parse(`owner:alice kw:graphene created:>2025-01-01 -kw:failed "exact phrase", anneal; owner:"Bob B"`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	want := []Term{
+		{Field: FieldOwner, Value: "alice"},
+		{Field: FieldKeyword, Value: "graphene"},
+		{Field: FieldCreated, Op: ">", Value: ">2025-01-01", from: "2025-01-02"},
+		{Field: FieldKeyword, Value: "failed", Negate: true},
+		{Value: "exact phrase", Phrase: true},
+		{Value: "anneal"},
+		{Field: FieldOwner, Value: "Bob B"},
+	}
+	if !reflect.DeepEqual(q.Terms, want) {
+		t.Fatalf("Parse terms =\n%+v\nwant\n%+v", q.Terms, want)
+	}
+}
+
+func TestParseDates(t *testing.T) {
+	tests := map[string][2]string{
+		"created:2025-03-04":          {"2025-03-04", "2025-03-05"},
+		"created:2025-03":             {"2025-03-01", "2025-04-01"},
+		"created:>2025-01":            {"2025-02-01", ""},
+		"created:>=2025":              {"2025-01-01", ""},
+		"created:<2025-06-01":         {"", "2025-06-01"},
+		"created:<=2024":              {"", "2025-01-01"},
+		"created:2025-01..2025-06":    {"2025-01-01", "2025-07-01"},
+		"created:2024-12-30..2025-01": {"2024-12-30", "2025-02-01"},
+	}
+	for input, want := range tests {
+		q, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		got := [2]string{q.Terms[0].from, q.Terms[0].to}
+		if got != want {
+			t.Errorf("Parse(%q) range = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := map[string]int{
+		`anneal "open phrase`: 7,
+		`colour:red`:          0,
+		`kw: graphene`:        3,
+		`graphene -`:          9,
+		`created:>yesterday`:  8,
+		`created:2025..2024`:  8,
+		`owner:""`:            6,
+		`owner:ali "b`:        10,
+	}
+	for input, pos := range tests {
+		_, err := Parse(input)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) error = %v, want a SyntaxError", input, err)
+			continue
+		}
+		if syntaxErr.Pos != pos {
+			t.Errorf("Parse(%q) error at %d (%v), want %d", input, syntaxErr.Pos, syntaxErr, pos)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	q, err := Parse(`anneal GaAs "spin coated" owner:100%_al -kw:Failed created:<2025`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	got := q.Compile([]interface{}{"2024-01-01"})
+
+	wantWhere := `(search_vector @@ sample_prefix_query($6) OR sample_name ILIKE $7)` +
+		` AND (search_vector @@ phraseto_tsquery('simple', $2))` +
+		` AND (coalesce(sample_owner, '') ILIKE $3)` +
+		` AND NOT coalesce(EXISTS (SELECT 1 FROM unnest(string_to_array(lower(sample_keywords), ',')) AS kw WHERE btrim(kw) = $4), false)` +
+		` AND (created_at < $5)`
+	if got.Where != wantWhere {
+		t.Errorf("Where =\n%s\nwant\n%s", got.Where, wantWhere)
+	}
+
+	wantRank := `coalesce(ts_rank(search_vector, sample_prefix_query($6) && phraseto_tsquery('simple', $2)), 0)`
+	if got.Rank != wantRank {
+		t.Errorf("Rank =\n%s\nwant\n%s", got.Rank, wantRank)
+	}
+
+	wantArgs := []interface{}{"2024-01-01", "spin coated", `%100\%\_al%`, "failed", "2025-01-01", "anneal GaAs", "%anneal GaAs%"}
+	if !reflect.DeepEqual(got.Args, wantArgs) {
+		t.Errorf("Args = %q, want %q", got.Args, wantArgs)
+	}
+}
+
+func TestCompileEmpty(t *testing.T) {
+	got := Query{}.Compile(nil)
+	if got.Where != "TRUE" || got.Rank != "" || len(got.Args) != 0 {
+		t.Fatalf("Compile of an empty query = %+v", got)
+	}
+}
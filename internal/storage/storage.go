@@ -0,0 +1,213 @@
+// Package storage models where samples are kept: a tree of storage
+// locations from buildings down to boxes. Boxes are grids whose positions
+// are named by a row letter and a column number, such as A1 or H12.
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a storage location.
+type Kind string
+
+const (
+	Building Kind = "building"
+	Room     Kind = "room"
+	Freezer  Kind = "freezer"
+	Cabinet  Kind = "cabinet"
+	Rack     Kind = "rack"
+	Box      Kind = "box"
+)
+
+// KindInfo describes a kind of location for forms.
+type KindInfo struct {
+	Kind  Kind
+	Label string
+	level int
+}
+
+// Kinds lists the kinds of location from the outermost to the innermost.
+// Freezers and cabinets are alternatives on the same level.
+var Kinds = []KindInfo{
+	{Building, "Building", 0},
+	{Room, "Room", 1},
+	{Freezer, "Freezer", 2},
+	{Cabinet, "Cabinet", 2},
+	{Rack, "Rack", 3},
+	{Box, "Box", 4},
+}
+
+// MaxRows and MaxColumns bound the size of a box.
+const (
+	MaxRows    = 26
+	MaxColumns = 30
+)
+
+func info(k Kind) (KindInfo, bool) {
+	for _, ki := range Kinds {
+		if ki.Kind == k {
+			return ki, true
+		}
+	}
+	return KindInfo{}, false
+}
+
+// Valid reports whether k is a known kind.
+func (k Kind) Valid() bool {
+	_, ok := info(k)
+	return ok
+}
+
+// Label returns the display name of k.
+func (k Kind) Label() string {
+	if ki, ok := info(k); ok {
+		return ki.Label
+	}
+	return string(k)
+}
+
+// CanContain reports whether a location of kind parent may hold one of kind
+// child. Levels may be skipped, so a box can sit directly in a freezer, but
+// a location never holds one of its own level or an outer one.
+func CanContain(parent, child Kind) bool {
+	p, ok := info(parent)
+	if !ok {
+		return false
+	}
+	c, ok := info(child)
+	return ok && c.level > p.level
+}
+
+// ChildKinds lists the kinds a location of kind parent may hold. An empty
+// parent means the top of the tree, which may hold any kind.
+func ChildKinds(parent Kind) []KindInfo {
+	var kinds []KindInfo
+	for _, ki := range Kinds {
+		if parent == "" || CanContain(parent, ki.Kind) {
+			kinds = append(kinds, ki)
+		}
+	}
+	return kinds
+}
+
+// Location is a place where samples are stored. ParentID is zero at the top
+// of the tree; Rows and Columns are only set for boxes.
+type Location struct {
+	ID       int
+	ParentID int
+	Kind     Kind
+	Name     string
+	Rows     int
+	Columns  int
+}
+
+// Entry is a location placed in the tree: Path names it with its ancestors,
+// and Depth is the number of ancestors.
+type Entry struct {
+	Location
+	Path  string
+	Depth int
+}
+
+// PathSeparator joins the names in a location path.
+const PathSeparator = " / "
+
+// Flatten orders locations depth-first, with siblings sorted by name, and
+// fills in their paths. Locations whose parent is missing are treated as top
+// level, so that a broken tree is still shown in full.
+func Flatten(locations []Location) []Entry {
+	byID := make(map[int]bool, len(locations))
+	for _, l := range locations {
+		byID[l.ID] = true
+	}
+	children := map[int][]Location{}
+	for _, l := range locations {
+		parent := l.ParentID
+		if !byID[parent] {
+			parent = 0
+		}
+		children[parent] = append(children[parent], l)
+	}
+	for _, list := range children {
+		sort.Slice(list, func(i, j int) bool {
+			a, b := strings.ToLower(list[i].Name), strings.ToLower(list[j].Name)
+			if a != b {
+				return a < b
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+
+	var entries []Entry
+	seen := map[int]bool{}
+	var walk func(parent int, path string, depth int)
+	walk = func(parent int, path string, depth int) {
+		for _, l := range children[parent] {
+			if seen[l.ID] {
+				continue
+			}
+			seen[l.ID] = true
+			e := Entry{Location: l, Path: l.Name, Depth: depth}
+			if path != "" {
+				e.Path = path + PathSeparator + l.Name
+			}
+			entries = append(entries, e)
+			walk(l.ID, e.Path, depth+1)
+		}
+	}
+	walk(0, "", 0)
+	return entries
+}
+
+// Ancestors returns the entries from the top of the tree down to the
+// location id, inclusive, or nil when id is not in entries.
+func Ancestors(entries []Entry, id int) []Entry {
+	byID := make(map[int]Entry, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e
+	}
+	var chain []Entry
+	for e, ok := byID[id]; ok && len(chain) <= len(entries); e, ok = byID[e.ParentID] {
+		chain = append([]Entry{e}, chain...)
+	}
+	return chain
+}
+
+// ErrInvalidPosition is returned for a position outside a box.
+var ErrInvalidPosition = errors.New("not a position in this box")
+
+// PositionName names the zero-based row and column of a box position.
+func PositionName(row, column int) string {
+	return string(rune('A'+row)) + strconv.Itoa(column+1)
+}
+
+// ParsePosition reads a position like "B7", ignoring case and spaces, and
+// returns its zero-based row and column in a box of the given size.
+func ParsePosition(s string, rows, columns int) (row, column int, err error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 || s[0] < 'A' || s[0] > 'Z' {
+		return 0, 0, ErrInvalidPosition
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s[1:]))
+	if err != nil {
+		return 0, 0, ErrInvalidPosition
+	}
+	row, column = int(s[0]-'A'), n-1
+	if row >= rows || column < 0 || column >= columns {
+		return 0, 0, ErrInvalidPosition
+	}
+	return row, column, nil
+}
+
+// NormalizePosition returns the canonical name of a position in a box of
+// the given size, such as "B7" for " b07".
+func NormalizePosition(s string, rows, columns int) (string, error) {
+	row, column, err := ParsePosition(s, rows, columns)
+	if err != nil {
+		return "", err
+	}
+	return PositionName(row, column), nil
+}
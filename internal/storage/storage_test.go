@@ -0,0 +1,86 @@
+package storage
+
+import "testing"
+
+func TestCanContain(t *testing.T) {
+	cases := []struct {
+		parent, child Kind
+		want          bool
+	}{
+		{Building, Room, true},
+		{Room, Freezer, true},
+		{Freezer, Box, true},
+		{Cabinet, Rack, true},
+		{Freezer, Cabinet, false},
+		{Box, Box, false},
+		{Rack, Room, false},
+		{Kind("shelf"), Box, false},
+	}
+	for _, tc := range cases {
+		if got := CanContain(tc.parent, tc.child); got != tc.want {
+			t.Errorf("CanContain(%s, %s) = %v, want %v", tc.parent, tc.child, got, tc.want)
+		}
+	}
+	if kinds := ChildKinds(Rack); len(kinds) != 1 || kinds[0].Kind != Box {
+		t.Errorf("ChildKinds(rack) = %+v, want only boxes", kinds)
+	}
+	if kinds := ChildKinds(""); len(kinds) != len(Kinds) {
+		t.Errorf("ChildKinds at the top = %d kinds, want all %d", len(kinds), len(Kinds))
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	entries := Flatten([]Location{
+		{ID: 4, ParentID: 2, Kind: Freezer, Name: "-80 freezer"},
+		{ID: 1, Kind: Building, Name: "Physics"},
+		{ID: 2, ParentID: 1, Kind: Room, Name: "B12"},
+		{ID: 3, ParentID: 1, Kind: Room, Name: "A07"},
+		{ID: 5, ParentID: 4, Kind: Box, Name: "Box 1", Rows: 9, Columns: 9},
+		{ID: 6, ParentID: 99, Kind: Box, Name: "Orphan"},
+	})
+
+	want := []struct {
+		id    int
+		path  string
+		depth int
+	}{
+		{6, "Orphan", 0},
+		{1, "Physics", 0},
+		{3, "Physics / A07", 1},
+		{2, "Physics / B12", 1},
+		{4, "Physics / B12 / -80 freezer", 2},
+		{5, "Physics / B12 / -80 freezer / Box 1", 3},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Flatten returned %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		if e := entries[i]; e.ID != w.id || e.Path != w.path || e.Depth != w.depth {
+			t.Errorf("entry %d = %d %q depth %d, want %d %q depth %d", i, e.ID, e.Path, e.Depth, w.id, w.path, w.depth)
+		}
+	}
+
+	chain := Ancestors(entries, 5)
+	if len(chain) != 4 || chain[0].ID != 1 || chain[3].ID != 5 {
+		t.Errorf("Ancestors(5) = %+v", chain)
+	}
+	if chain := Ancestors(entries, 42); chain != nil {
+		t.Errorf("Ancestors of a missing location = %+v, want nil", chain)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	if got := PositionName(1, 6); got != "B7" {
+		t.Errorf("PositionName(1, 6) = %q, want B7", got)
+	}
+	for in, want := range map[string]string{"b07": "B7", " A1 ": "A1", "I9": "I9"} {
+		if got, err := NormalizePosition(in, 9, 9); err != nil || got != want {
+			t.Errorf("NormalizePosition(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "A", "J1", "A0", "A10", "11", "A-1"} {
+		if _, err := NormalizePosition(in, 9, 9); err != ErrInvalidPosition {
+			t.Errorf("NormalizePosition(%q) err = %v, want ErrInvalidPosition", in, err)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
+	"sampleDB/internal/storage"
+)
+
+// LocationNode is a storage location with the number of samples stored
+// directly in it.
+type LocationNode struct {
+	storage.Entry
+	Samples int
+}
+
+// LocationSample is a sample stored in a location. Samples the user may not
+// see only show that their position is taken.
+type LocationSample struct {
+	ID       int
+	Name     string
+	Owner    string
+	Status   string
+	Position string
+	Visible  bool
+}
+
+// StatusLabel returns the display name of the status of s.
+func (s LocationSample) StatusLabel() string {
+	return Sample{Status: s.Status}.StatusLabel()
+}
+
+// BoxGrid lays out the positions of a box, row by row.
+type BoxGrid struct {
+	Columns []int
+	Rows    []BoxRow
+	Free    int
+}
+
+// BoxRow is a row of a box, labelled with its letter.
+type BoxRow struct {
+	Label string
+	Cells []BoxCell
+}
+
+// BoxCell is a position of a box; Sample is nil when it is free.
+type BoxCell struct {
+	Position string
+	Sample   *LocationSample
+}
+
+// LocationsPageData is the data of the location browser. The top-level page
+// shows the whole tree; a location page shows its children, its samples and,
+// for boxes, the grid of positions.
+type LocationsPageData struct {
+	BasePageData
+	Tree       []LocationNode
+	Current    *LocationNode
+	Path       []storage.Entry // from the top of the tree down to Current
+	Children   []LocationNode
+	Samples    []LocationSample
+	Unplaced   []LocationSample // samples in a box without a position
+	Grid       *BoxGrid
+	Kinds      []storage.KindInfo // kinds that can be added here
+	MaxRows    int
+	MaxColumns int
+	Success    string
+	Error      string
+}
+
+// countLocationSamples returns the number of samples in each location,
+// leaving out the trash.
+func countLocationSamples(ctx context.Context) (map[int]int, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT location_id, count(*) FROM samples
+         WHERE location_id IS NOT NULL AND deleted_at IS NULL
+         GROUP BY location_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// getLocationSamples lists the samples stored directly in a location,
+// leaving out the trash.
+func getLocationSamples(ctx context.Context, v sampleViewer, locationID int) ([]LocationSample, error) {
+	clause, args := v.visibleClause([]interface{}{locationID})
+	rows, err := dbPool.Query(ctx,
+		`SELECT sample_id, sample_name, coalesce(sample_owner, ''), status, coalesce(box_position, ''), `+clause+`
+         FROM samples
+         WHERE location_id = $1 AND deleted_at IS NULL
+         ORDER BY box_position NULLS LAST, lower(sample_name), sample_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []LocationSample
+	for rows.Next() {
+		var s LocationSample
+		if err := rows.Scan(&s.ID, &s.Name, &s.Owner, &s.Status, &s.Position, &s.Visible); err != nil {
+			return nil, err
+		}
+		if !s.Visible {
+			s = LocationSample{Position: s.Position}
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// boxGrid places samples on the positions of a box. Samples without a
+// valid position are returned separately.
+func boxGrid(box storage.Location, samples []LocationSample) (*BoxGrid, []LocationSample) {
+	grid := &BoxGrid{Rows: make([]BoxRow, box.Rows)}
+	for c := 0; c < box.Columns; c++ {
+		grid.Columns = append(grid.Columns, c+1)
+	}
+	for r := range grid.Rows {
+		grid.Rows[r].Label = string(rune('A' + r))
+		grid.Rows[r].Cells = make([]BoxCell, box.Columns)
+		for c := range grid.Rows[r].Cells {
+			grid.Rows[r].Cells[c].Position = storage.PositionName(r, c)
+		}
+	}
+
+	var unplaced []LocationSample
+	for i := range samples {
+		row, col, err := storage.ParsePosition(samples[i].Position, box.Rows, box.Columns)
+		if err != nil || grid.Rows[row].Cells[col].Sample != nil {
+			unplaced = append(unplaced, samples[i])
+			continue
+		}
+		grid.Rows[row].Cells[col].Sample = &samples[i]
+	}
+	grid.Free = box.Rows*box.Columns - (len(samples) - len(unplaced))
+	return grid, unplaced
+}
+
+// handleLocations serves the location browser at /locations and
+// /locations/{id}, adds a location on POST to /locations and removes an
+// empty one on POST to /locations/{id}/delete.
+func handleLocations(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/locations"), "/")
+	parts := strings.Split(rest, "/")
+	id := 0
+	if rest != "" {
+		var err error
+		if id, err = strconv.Atoi(parts[0]); err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		renderLocations(w, r, id, r.URL.Query().Get("success"), "")
+	case rest == "" && r.Method == http.MethodPost:
+		createLocation(w, r)
+	case len(parts) == 2 && parts[1] == "delete" && r.Method == http.MethodPost:
+		deleteLocation(w, r, id)
+	case len(parts) <= 2:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func createLocation(w http.ResponseWriter, r *http.Request) {
+	if !can(r, rbac.LocationsManage, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
+	parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
+	kind := storage.Kind(r.FormValue("kind"))
+	name := strings.TrimSpace(r.FormValue("name"))
+	rows, _ := strconv.Atoi(r.FormValue("rows"))
+	columns, _ := strconv.Atoi(r.FormValue("columns"))
+
+	tree, err := getStorageTree(r.Context())
+	if err != nil {
+		log.Printf("locations: unable to load locations: %v", err)
+		http.Error(w, "Error loading storage locations", http.StatusInternalServerError)
+		return
+	}
+
+	var parentKind storage.Kind
+	if parentID != 0 {
+		parent, ok := findLocation(tree, parentID)
+		if !ok {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		parentKind = parent.Kind
+	}
+
+	problem := ""
+	switch {
+	case name == "":
+		problem = "Enter a name for the new location."
+	case utf8.RuneCountInString(name) > 100:
+		problem = "The name can be at most 100 characters long."
+	case !kind.Valid():
+		problem = "Choose what kind of location to add."
+	case parentKind != "" && !storage.CanContain(parentKind, kind):
+		problem = fmt.Sprintf("A %s cannot hold a %s.", strings.ToLower(parentKind.Label()), strings.ToLower(kind.Label()))
+	case kind == storage.Box && (rows < 1 || rows > storage.MaxRows || columns < 1 || columns > storage.MaxColumns):
+		problem = fmt.Sprintf("A box has 1 to %d rows and 1 to %d columns.", storage.MaxRows, storage.MaxColumns)
+	}
+	for _, e := range tree {
+		if problem == "" && e.ParentID == parentID && strings.EqualFold(e.Name, name) {
+			problem = "There is already a location called " + e.Name + " here."
+		}
+	}
+	if problem != "" {
+		renderLocations(w, r, parentID, "", problem)
+		return
+	}
+	if kind != storage.Box {
+		rows, columns = 0, 0
+	}
+
+	var id int
+	err = dbPool.QueryRow(r.Context(),
+		`INSERT INTO storage_locations (parent_id, kind, name, box_rows, box_columns)
+         VALUES (nullif($1, 0), $2, $3, $4, $5)
+         RETURNING location_id`, parentID, string(kind), name, rows, columns).Scan(&id)
+	if err != nil {
+		log.Printf("locations: unable to add %s %q: %v", kind, name, err)
+		renderLocations(w, r, parentID, "", "Unable to add the location. Try again.")
+		return
+	}
+	session := auth.MustSessionFromContext(r.Context())
+	log.Printf("locations: %s added %s %q (%d)", session.Username, kind, name, id)
+
+	http.Redirect(w, r, locationURL(parentID)+"?success="+url.QueryEscape(name+" added"), http.StatusSeeOther)
+}
+
+func deleteLocation(w http.ResponseWriter, r *http.Request, id int) {
+	if !can(r, rbac.LocationsManage, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
+	tree, err := getStorageTree(r.Context())
+	if err != nil {
+		log.Printf("locations: unable to load locations: %v", err)
+		http.Error(w, "Error loading storage locations", http.StatusInternalServerError)
+		return
+	}
+	loc, ok := findLocation(tree, id)
+	if !ok {
+		http.Error(w, "Location not found", http.StatusNotFound)
+		return
+	}
+	for _, e := range tree {
+		if e.ParentID == id {
+			renderLocations(w, r, id, "", "Remove the locations inside "+loc.Name+" first.")
+			return
+		}
+	}
+	counts, err := countLocationSamples(r.Context())
+	if err != nil {
+		log.Printf("locations: unable to count samples: %v", err)
+		http.Error(w, "Error loading storage locations", http.StatusInternalServerError)
+		return
+	}
+	if counts[id] > 0 {
+		renderLocations(w, r, id, "", fmt.Sprintf("%s still holds %d sample(s). Move them elsewhere first.", loc.Name, counts[id]))
+		return
+	}
+
+	// Samples in the trash lose their location with it.
+	if _, err := dbPool.Exec(r.Context(), "DELETE FROM storage_locations WHERE location_id = $1", id); err != nil {
+		log.Printf("locations: unable to remove location %d: %v", id, err)
+		renderLocations(w, r, id, "", "Unable to remove the location. Try again.")
+		return
+	}
+	session := auth.MustSessionFromContext(r.Context())
+	log.Printf("locations: %s removed %s %q (%d)", session.Username, loc.Kind, loc.Name, id)
+
+	http.Redirect(w, r, locationURL(loc.ParentID)+"?success="+url.QueryEscape(loc.Name+" removed"), http.StatusSeeOther)
+}
+
+func locationURL(id int) string {
+	if id == 0 {
+		return "/locations"
+	}
+	return "/locations/" + strconv.Itoa(id)
+}
+
+func renderLocations(w http.ResponseWriter, r *http.Request, id int, success, errMsg string) {
+	session := auth.MustSessionFromContext(r.Context())
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Error loading user information", http.StatusInternalServerError)
+		return
+	}
+
+	tree, err := getStorageTree(r.Context())
+	if err != nil {
+		log.Printf("locations: unable to load locations: %v", err)
+		http.Error(w, "Error loading storage locations", http.StatusInternalServerError)
+		return
+	}
+	counts, err := countLocationSamples(r.Context())
+	if err != nil {
+		log.Printf("locations: unable to count samples: %v", err)
+		http.Error(w, "Error loading storage locations", http.StatusInternalServerError)
+		return
+	}
+
+	data := LocationsPageData{
+		BasePageData: baseData,
+		MaxRows:      storage.MaxRows,
+		MaxColumns:   storage.MaxColumns,
+		Success:      success,
+		Error:        errMsg,
+	}
+	if id == 0 {
+		for _, e := range tree {
+			data.Tree = append(data.Tree, LocationNode{Entry: e, Samples: counts[e.ID]})
+		}
+		data.Kinds = storage.ChildKinds("")
+	} else {
+		current, ok := findLocation(tree, id)
+		if !ok {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		data.Current = &LocationNode{Entry: current, Samples: counts[id]}
+		data.Path = storage.Ancestors(tree, id)
+		for _, e := range tree {
+			if e.ParentID == id {
+				data.Children = append(data.Children, LocationNode{Entry: e, Samples: counts[e.ID]})
+			}
+		}
+		data.Kinds = storage.ChildKinds(current.Kind)
+
+		data.Samples, err = getLocationSamples(r.Context(), viewerFromBase(baseData), id)
+		if err != nil {
+			log.Printf("locations: unable to list the samples in location %d: %v", id, err)
+			http.Error(w, "Error loading samples", http.StatusInternalServerError)
+			return
+		}
+		if current.Kind == storage.Box {
+			data.Grid, data.Unplaced = boxGrid(current.Location, data.Samples)
+		}
+	}
+
+	tmpl, err := parseTemplates(r, "templates/locations.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		log.Printf("locations: unable to render page: %v", err)
+	}
+}
@@ -17,16 +17,23 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 
+	"sampleDB/internal/audit"
 	"sampleDB/internal/auth"
 	"sampleDB/internal/dbiface"
 	"sampleDB/internal/dbschema"
+	"sampleDB/internal/lineage"
+	"sampleDB/internal/mail"
+	"sampleDB/internal/passhash"
+	"sampleDB/internal/rbac"
+	"sampleDB/internal/samplequery"
+	"sampleDB/internal/storage"
 )
 
 type Attachment struct {
@@ -42,7 +49,18 @@ type Attachment struct {
 type BasePageData struct {
 	Username string
 	UserID   int
-	IsAdmin  bool
+	IsAdmin  bool // may open the admin panel
+	grants   rbac.Grants
+}
+
+// Can reports whether the current user holds perm. Pass the owner's user ID
+// to also honour owner-restricted grants such as "wiki.delete.own".
+func (d BasePageData) Can(perm string, ownerID ...int) bool {
+	var res rbac.Resource
+	if len(ownerID) > 0 {
+		res = rbac.OwnedBy(ownerID[0])
+	}
+	return d.grants.Can(rbac.Permission(perm), res)
 }
 
 // Sample represents a sample record in the database
@@ -56,6 +74,13 @@ type Sample struct {
 	SamplePrepHTML template.HTML
 	Attachments    []Attachment
 	CreatedAt      time.Time
+	OwnerID        int // zero when the owner has no account
+	Visibility     string
+	Status         string
+	LocationID     int              // zero when the storage location is unknown
+	Position       string           // position in a box, such as B7
+	LocationPath   string           // set where the location tree is loaded
+	Highlight      *SampleHighlight // set on search results
 }
 
 type User struct {
@@ -68,25 +93,68 @@ type User struct {
 
 type MainPageData struct {
 	BasePageData
-	Samples  []Sample
-	Query    string
-	DateFrom string
-	DateTo   string
+	Samples    []Sample
+	Total      int // number of matching samples
+	Query      string
+	QueryError string // syntax error in Query, shown in the samples panel
+	DateFrom   string
+	DateTo     string
+	Mine       bool // only the user's own samples
+	Status     string
+	LocationID int // only samples stored in this location or inside it
+	Locations  []storage.Entry
+	Statuses   []StatusOption
+	Sort       string
+	Limit      int
+	PageSizes  []int
+	FirstURL   string // first page, set when showing a later one on its own
+	NextURL    string // next page, with the same filters
+}
+
+// ExportURL links to an export of the whole result in format.
+func (d MainPageData) ExportURL(format string) string {
+	params := url.Values{"format": {format}}
+	for key, value := range map[string]string{"query": d.Query, "date_from": d.DateFrom, "date_to": d.DateTo, "sort": d.Sort} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if d.Mine {
+		params.Set("mine", "1")
+	}
+	if d.Status != "" {
+		params.Set("status", d.Status)
+	}
+	if d.LocationID != 0 {
+		params.Set("location", strconv.Itoa(d.LocationID))
+	}
+	return "/samples/export?" + params.Encode()
 }
 
 type SampleDetailPageData struct {
 	BasePageData
-	Sample      Sample
-	Flash       string
-	Error       string
-	IsPartial   bool
-	EditingPrep bool
+	Sample       Sample
+	History      []SampleRevision // only on the full page and the history section
+	Lineage      *SampleLineage   // only on the full page and the lineage section
+	CanManage    bool             // may transfer the sample and change its visibility
+	Owners       []SampleUser     // accounts the sample can be transferred to
+	Visibilities []VisibilityOption
+	Locations    []storage.Entry
+	Statuses     []StatusOption
+	LineageKinds []lineage.KindInfo
+	Flash        string
+	Error        string
+	IsPartial    bool
+	EditingPrep  bool
 }
 
 type ChangePasswordPageData struct {
 	BasePageData
-	Error   string
-	Success string
+	Required  bool // an administrator reset the password
+	MinLength int
+	Email     string
+	Error     string
+	Success   string
 }
 
 type AIAgentExamplesPageData struct {
@@ -106,6 +174,10 @@ var appConfig AppConfig
 
 var authManagerInstance *auth.Manager
 
+var authorizer *rbac.Authorizer
+
+var auditLog *audit.Log
+
 type AppConfig struct {
 	Addr         string
 	RedirectAddr string
@@ -119,6 +191,38 @@ type AppConfig struct {
 	StaticDir    string
 	UploadsDir   string
 	UseTLS       bool
+
+	RequireAdmin2FA   bool
+	TrustProxyHeaders bool
+	LoginMaxFailures  int
+	RegistrationMode  string
+
+	SessionIdleTimeout time.Duration
+	SessionMaxLifetime time.Duration
+
+	PasswordMinLength  int
+	PasswordHistory    int
+	PasswordBannedFile string
+	PasswordHasher     passhash.Hasher
+
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCProviderName string
+	OIDCGroupsClaim  string
+	OIDCGroupMap     map[string]string
+	OIDCLinkExisting bool
+
+	// BaseURL is the externally visible address used in emailed links.
+	BaseURL      string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	SMTPTLS      string
 }
 
 func loadConfig() AppConfig {
@@ -129,6 +233,37 @@ func loadConfig() AppConfig {
 		TLSCertFile:  os.Getenv("TLS_CERT_FILE"),
 		TLSKeyFile:   os.Getenv("TLS_KEY_FILE"),
 		PublicHost:   os.Getenv("PUBLIC_HOST"),
+
+		RequireAdmin2FA:   getEnvBool("REQUIRE_ADMIN_2FA", false),
+		TrustProxyHeaders: getEnvBool("TRUST_PROXY_HEADERS", false),
+		LoginMaxFailures:  getEnvInt("LOGIN_MAX_FAILURES", 10),
+		RegistrationMode:  strings.ToLower(getEnv("REGISTRATION_MODE", "open")),
+
+		SessionIdleTimeout: getEnvDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour),
+		SessionMaxLifetime: getEnvDuration("SESSION_MAX_LIFETIME", 7*24*time.Hour),
+
+		PasswordMinLength:  getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordHistory:    getEnvInt("PASSWORD_HISTORY", 5),
+		PasswordBannedFile: os.Getenv("PASSWORD_BANNED_FILE"),
+		PasswordHasher:     loadPasswordHasher(),
+
+		OIDCIssuer:       os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		OIDCProviderName: os.Getenv("OIDC_PROVIDER_NAME"),
+		OIDCGroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		OIDCGroupMap:     parseGroupMap(os.Getenv("OIDC_GROUP_MAP")),
+		OIDCLinkExisting: getEnvBool("OIDC_LINK_EXISTING", false),
+
+		BaseURL:      strings.TrimRight(os.Getenv("APP_BASE_URL"), "/"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     getEnvInt("SMTP_PORT", 587),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:     os.Getenv("SMTP_FROM"),
+		SMTPTLS:      getEnv("SMTP_TLS", mail.TLSStartTLS),
 	}
 
 	cfg.UseTLS = cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
@@ -151,9 +286,73 @@ func loadConfig() AppConfig {
 		}
 	}
 
+	if cfg.BaseURL == "" {
+		scheme, host := "http", cfg.PublicHost
+		if cfg.UseTLS {
+			scheme = "https"
+		} else if !strings.Contains(host, ":") && cfg.TLSPort != "" && cfg.TLSPort != "80" {
+			host = host + ":" + cfg.TLSPort
+		}
+		cfg.BaseURL = scheme + "://" + host
+	}
+
+	if cfg.OIDCIssuer != "" && cfg.OIDCRedirectURL == "" {
+		cfg.OIDCRedirectURL = cfg.BaseURL + "/login/oidc/callback"
+	}
+
 	return cfg
 }
 
+// loadPasswordHasher reads the hashing algorithm and its cost parameters.
+// Non-positive values fall back to the defaults.
+func loadPasswordHasher() passhash.Hasher {
+	h := passhash.Default()
+	h.Algorithm = strings.ToLower(getEnv("PASSWORD_HASH", h.Algorithm))
+	if v := getEnvInt("ARGON2_MEMORY_KIB", 0); v > 0 {
+		h.Argon2.Memory = uint32(v)
+	}
+	if v := getEnvInt("ARGON2_ITERATIONS", 0); v > 0 {
+		h.Argon2.Time = uint32(v)
+	}
+	if v := getEnvInt("ARGON2_PARALLELISM", 0); v > 0 && v <= 255 {
+		h.Argon2.Threads = uint8(v)
+	}
+	if v := getEnvInt("BCRYPT_COST", 0); v > 0 {
+		h.BcryptCost = v
+	}
+	return h
+}
+
+// loadPasswordPolicy builds the password policy from cfg, loading the
+// banned password list if one is configured.
+func loadPasswordPolicy(cfg AppConfig) auth.PasswordPolicy {
+	policy := auth.DefaultPasswordPolicy()
+	policy.MinLength = cfg.PasswordMinLength
+	policy.History = cfg.PasswordHistory
+	if cfg.PasswordBannedFile != "" {
+		count, err := policy.LoadBannedPasswords(cfg.PasswordBannedFile)
+		if err != nil {
+			log.Fatalf("Unable to load banned passwords: %v\n", err)
+		}
+		log.Printf("Loaded %d banned passwords from %s", count, cfg.PasswordBannedFile)
+	}
+	return policy
+}
+
+// parseGroupMap reads "claim=group" pairs separated by commas.
+func parseGroupMap(value string) map[string]string {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		claim, group, ok := strings.Cut(pair, "=")
+		claim, group = strings.TrimSpace(claim), strings.TrimSpace(group)
+		if !ok || claim == "" || group == "" {
+			continue
+		}
+		mapping[claim] = group
+	}
+	return mapping
+}
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -161,6 +360,45 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvBool(key string, fallback bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("config: ignoring invalid boolean %s=%q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("config: ignoring invalid integer %s=%q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("config: ignoring invalid duration %s=%q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
 func determineBaseDir() string {
 	if base := os.Getenv("APP_BASE_DIR"); base != "" {
 		if abs, err := filepath.Abs(base); err == nil {
@@ -209,8 +447,8 @@ func hxTargetIs(r *http.Request, target string) bool {
 	return isHTMXRequest(r) && r.Header.Get("HX-Target") == target
 }
 
-func renderTemplateSection(w http.ResponseWriter, templatePath, section string, data interface{}) error {
-	tmpl, err := parseTemplates(templatePath)
+func renderTemplateSection(w http.ResponseWriter, r *http.Request, templatePath, section string, data interface{}) error {
+	tmpl, err := parseTemplates(r, templatePath)
 	if err != nil {
 		return err
 	}
@@ -354,16 +592,25 @@ func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 
 	data := ChangePasswordPageData{
 		BasePageData: baseData,
+		MinLength:    authManagerInstance.PasswordPolicy().MinLength,
+	}
+	if data.Email, err = loadAccountEmail(session.UserID); err != nil {
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
+	}
+	if data.Required, err = authManagerInstance.PasswordChangeRequired(r.Context(), session.UserID); err != nil {
+		http.Error(w, "Unable to load account information", http.StatusInternalServerError)
+		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		renderChangePasswordTemplate(w, data)
+		renderChangePasswordTemplate(w, r, data)
 	case http.MethodPost:
 		if err := r.ParseForm(); err != nil {
 			data.Error = "Invalid form submission"
 			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
@@ -374,21 +621,14 @@ func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 		if currentPassword == "" || newPassword == "" || confirmPassword == "" {
 			data.Error = "All fields are required"
 			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
-			return
-		}
-
-		if len(newPassword) < 8 {
-			data.Error = "New password must be at least 8 characters long"
-			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
 		if newPassword != confirmPassword {
 			data.Error = "New passwords do not match"
 			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
@@ -406,54 +646,59 @@ func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 			}
 			data.Error = "Unable to verify current password"
 			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(currentPassword)); err != nil {
+		if match, _ := passhash.Verify(storedHash, currentPassword); !match {
 			data.Error = "Current password is incorrect"
 			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
 		if currentPassword == newPassword {
 			data.Error = "New password must be different from the current password"
 			w.WriteHeader(http.StatusBadRequest)
-			renderChangePasswordTemplate(w, data)
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
-		newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-		if err != nil {
-			data.Error = "Failed to update password"
-			w.WriteHeader(http.StatusInternalServerError)
-			renderChangePasswordTemplate(w, data)
+		if err := authManagerInstance.ChangePassword(r.Context(), session.UserID, newPassword); err != nil {
+			var policyErr *auth.PasswordPolicyError
+			switch {
+			case errors.As(err, &policyErr):
+				data.Error = policyErr.Error()
+				w.WriteHeader(http.StatusBadRequest)
+			case errors.Is(err, pgx.ErrNoRows):
+				http.Redirect(w, r, "/logout", http.StatusSeeOther)
+				return
+			default:
+				log.Printf("account: unable to change password for user %d: %v", session.UserID, err)
+				data.Error = "Failed to update password"
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			renderChangePasswordTemplate(w, r, data)
 			return
 		}
 
-		cmdTag, err := dbPool.Exec(context.Background(),
-			`UPDATE users
-             SET password_hash = $1
-             WHERE user_id = $2
-               AND COALESCE(deleted, false) = false`,
-			string(newHash), session.UserID)
-		if err != nil || cmdTag.RowsAffected() == 0 {
-			data.Error = "Failed to update password"
-			w.WriteHeader(http.StatusInternalServerError)
-			renderChangePasswordTemplate(w, data)
-			return
-		}
+		recordAudit(r, audit.Entry{
+			Action:     audit.ActionPasswordChange,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.Itoa(session.UserID),
+			TargetName: session.Username,
+		})
 
+		data.Required = false
 		data.Success = "Password updated successfully"
-		renderChangePasswordTemplate(w, data)
+		renderChangePasswordTemplate(w, r, data)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func renderChangePasswordTemplate(w http.ResponseWriter, data ChangePasswordPageData) {
-	tmpl, err := parseTemplates("templates/change_password.html")
+func renderChangePasswordTemplate(w http.ResponseWriter, r *http.Request, data ChangePasswordPageData) {
+	tmpl, err := parseTemplates(r, "templates/change_password.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
@@ -464,34 +709,6 @@ func renderChangePasswordTemplate(w http.ResponseWriter, data ChangePasswordPage
 	}
 }
 
-// ensureDefaultAdmin seeds a single administrator account when the installation is brand new.
-// It leaves existing data untouched once any user records exist.
-func ensureDefaultAdmin(ctx context.Context, pool dbiface.Pool) (bool, error) {
-	var userCount int
-	if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&userCount); err != nil {
-		return false, fmt.Errorf("count users: %w", err)
-	}
-
-	if userCount > 0 {
-		return false, nil
-	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
-	if err != nil {
-		return false, fmt.Errorf("hash default admin password: %w", err)
-	}
-
-	if _, err := pool.Exec(ctx, `
-		INSERT INTO users (username, password_hash, is_approved, admin)
-		VALUES ($1, $2, true, true)
-		ON CONFLICT (username) DO NOTHING`,
-		"admin", string(hash)); err != nil {
-		return false, fmt.Errorf("insert default admin: %w", err)
-	}
-
-	return true, nil
-}
-
 func handleAIAgents(w http.ResponseWriter, r *http.Request) {
 	tmplPath := resolveTemplatePath("templates/ai_agents.html")
 	tmpl, err := template.ParseFiles(tmplPath)
@@ -606,19 +823,85 @@ func main() {
 		log.Fatalf("Unable to ensure database schema: %v\n", err)
 	}
 
-	createdAdmin, err := ensureDefaultAdmin(ctx, dbPool)
-	if err != nil {
-		log.Fatalf("Unable to bootstrap default admin user: %v\n", err)
+	if err = cfg.PasswordHasher.Validate(); err != nil {
+		log.Fatalf("Invalid password hashing settings: %v\n", err)
 	}
-	if createdAdmin {
-		log.Println("Created default admin user 'admin' with password 'admin'. Please change this password after first login.")
+
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		runCreateAdmin(ctx, cfg, os.Args[2:])
+		return
 	}
 
+	auditLog = audit.New(dbPool)
+
 	authManagerInstance = auth.NewManager(dbPool)
+	authManagerInstance.SetAuditLog(auditLog)
+	authManagerInstance.SetSessionStore(auth.NewPostgresStore(dbPool))
+	authManagerInstance.StartSessionPurge(ctx, 15*time.Minute)
 	if cfg.UseTLS {
 		authManagerInstance.SetCookieSecure(true)
 	}
 	authManagerInstance.SetTemplateDir(cfg.TemplatesDir)
+	authManagerInstance.SetRequireAdminTwoFactor(cfg.RequireAdmin2FA)
+	authManagerInstance.SetTrustProxyHeaders(cfg.TrustProxyHeaders)
+	authManagerInstance.SetSessionTimeouts(cfg.SessionIdleTimeout, cfg.SessionMaxLifetime)
+	switch cfg.RegistrationMode {
+	case "open":
+	case "invite":
+		authManagerInstance.SetInviteOnly(true)
+	default:
+		log.Printf("config: ignoring invalid REGISTRATION_MODE=%q", cfg.RegistrationMode)
+	}
+
+	limiterPolicy := auth.DefaultLimiterPolicy()
+	limiterPolicy.LockoutThreshold = cfg.LoginMaxFailures
+	authManagerInstance.SetLoginLimiter(auth.NewLoginLimiter(limiterPolicy))
+
+	authManagerInstance.SetPasswordPolicy(loadPasswordPolicy(cfg))
+	authManagerInstance.SetPasswordHasher(cfg.PasswordHasher)
+
+	if cfg.OIDCIssuer != "" {
+		authManagerInstance.EnableOIDC(auth.OIDCConfig{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			ProviderName: cfg.OIDCProviderName,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+			GroupMap:     cfg.OIDCGroupMap,
+			LinkExisting: cfg.OIDCLinkExisting,
+		})
+		log.Printf("OIDC single sign-on enabled for issuer %s", cfg.OIDCIssuer)
+	}
+
+	authManagerInstance.SetBaseURL(cfg.BaseURL)
+	if cfg.SMTPHost != "" && cfg.SMTPFrom != "" {
+		authManagerInstance.SetMailer(mail.NewSMTPSender(mail.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			TLS:      cfg.SMTPTLS,
+		}))
+		log.Printf("Outgoing email enabled via %s:%d", cfg.SMTPHost, cfg.SMTPPort)
+	}
+
+	setupToken, err := authManagerInstance.BeginSetup(ctx)
+	if err != nil {
+		log.Fatalf("Unable to check for existing accounts: %v\n", err)
+	}
+	if setupToken != "" {
+		log.Printf("No accounts exist yet. Open %s/setup?token=%s to create the first administrator, or run `%s create-admin -username <name>`. Other pages are unavailable until then.",
+			cfg.BaseURL, setupToken, os.Args[0])
+	}
+
+	authorizer = rbac.New(dbPool)
+	authManagerInstance.SetAdminCheck(func(ctx context.Context, userID int) (bool, error) {
+		grants, err := authorizer.Grants(ctx, userID)
+		return grants.IsAdmin(), err
+	})
 
 	mux := http.NewServeMux()
 
@@ -627,20 +910,44 @@ func main() {
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	// Auth routes
+	mux.HandleFunc("/setup", authManagerInstance.SetupHandler())
 	mux.HandleFunc("/login", authManagerInstance.LoginHandler())
+	mux.HandleFunc("/login/2fa", authManagerInstance.TwoFactorHandler())
+	mux.HandleFunc("/login/2fa/setup", authManagerInstance.TwoFactorSetupHandler())
+	mux.HandleFunc("/login/oidc", authManagerInstance.OIDCLoginHandler())
+	mux.HandleFunc("/login/oidc/callback", authManagerInstance.OIDCCallbackHandler())
 	mux.HandleFunc("/register", authManagerInstance.RegisterHandler())
+	mux.HandleFunc("/verify-email", authManagerInstance.VerifyEmailHandler())
+	mux.HandleFunc("/forgot-password", authManagerInstance.ForgotPasswordHandler())
+	mux.HandleFunc("/reset-password", authManagerInstance.ResetPasswordHandler())
 	mux.HandleFunc("/logout", authManagerInstance.RequireAuth(authManagerInstance.LogoutHandler()))
 
 	// Sample management routes
-	withAuth := authManagerInstance.RequireAuth
+	withAuth := func(next http.HandlerFunc) http.HandlerFunc {
+		return authManagerInstance.RequireAuth(authManagerInstance.VerifyCSRF(next))
+	}
 	mux.HandleFunc("/", withAuth(mainPageHandler))
 	mux.HandleFunc("/samples/new", withAuth(newSampleHandler))
+	mux.HandleFunc("/samples/import", withAuth(requirePermission(rbac.SamplesCreate, handleSampleImport)))
+	mux.HandleFunc("/samples/export", withAuth(handleSampleExport))
 	mux.HandleFunc("/samples/edit/", withAuth(editSampleHandler))
 	mux.HandleFunc("/samples/prep/", withAuth(samplePrepHandler))
+	mux.HandleFunc("/samples/history/", withAuth(handleSampleHistory))
+	mux.HandleFunc("/samples/ownership/", withAuth(handleSampleOwnership))
+	mux.HandleFunc("/samples/delete/", withAuth(deleteSampleHandler))
+	mux.HandleFunc("/samples/storage/", withAuth(handleSampleStorage))
+	mux.HandleFunc("/samples/lineage/", withAuth(handleSampleLineage))
+	mux.HandleFunc("/locations", withAuth(handleLocations))
+	mux.HandleFunc("/locations/", withAuth(handleLocations))
+	mux.HandleFunc("/samples/trash", withAuth(handleSampleTrash))
+	mux.HandleFunc("/samples/trash/", withAuth(handleSampleTrash))
+	mux.HandleFunc("/samples/labels", withAuth(handleSampleLabels))
+	mux.HandleFunc("/samples/labels/", withAuth(handleSampleLabels))
 	mux.HandleFunc("/samples/", withAuth(handleSample))
 	mux.HandleFunc("/attachment/", withAuth(handleAttachment))
 	mux.HandleFunc("/booking", withAuth(handleBooking))
-	mux.HandleFunc("/api/bookings", withAuth(handleGetBookings))
+	mux.HandleFunc("/api/bookings", authManagerInstance.AcceptToken(auth.ScopeBookingsRead, withAuth(handleGetBookings)))
+	mux.HandleFunc("/api/samples/", authManagerInstance.AcceptToken(auth.ScopeAttachmentsWrite, withAuth(handleAPISampleAttachments)))
 	mux.HandleFunc("/booking/delete", withAuth(handleDeleteBooking))
 
 	// Wiki routes
@@ -649,19 +956,32 @@ func main() {
 	mux.HandleFunc("/wiki/attachment/", withAuth(handleAttachmentWiki)) // Handles wiki attachments
 
 	// Admin routes
-	mux.HandleFunc("/admin", withAuth(requireAdmin(handleAdminPage)))
-	mux.HandleFunc("/admin/update-access", withAuth(requireAdmin(handleUpdateAccess)))
-	mux.HandleFunc("/admin/set-admin", withAuth(requireAdmin(handleSetAdmin)))
-	mux.HandleFunc("/admin/reset-password", withAuth(requireAdmin(handleResetPassword)))
-	mux.HandleFunc("/admin/add-equipment", withAuth(requireAdmin(handleAddEquipment)))
-	mux.HandleFunc("/admin/delete-equipment/", withAuth(requireAdmin(handleDeleteEquipment))) // Note trailing slash
-	mux.HandleFunc("/admin/add-group", withAuth(requireAdmin(handleAddGroup)))
-	mux.HandleFunc("/admin/delete-group/", withAuth(requireAdmin(handleDeleteGroup)))
-	mux.HandleFunc("/admin/equipment-report", withAuth(requireAdmin(handleEquipmentReport)))
-	mux.HandleFunc("/admin/delete-user", withAuth(requireAdmin(handleDeleteUser)))
+	mux.HandleFunc("/admin", withAuth(requireAdminPanel(handleAdminPage)))
+	mux.HandleFunc("/admin/update-access", withAuth(requireAdminPanel(handleUpdateAccess)))
+	mux.HandleFunc("/admin/set-admin", withAuth(requirePermission(rbac.RolesManage, handleSetAdmin)))
+	mux.HandleFunc("/admin/user-roles", withAuth(requirePermission(rbac.RolesManage, handleSetUserRoles)))
+	mux.HandleFunc("/admin/group-roles", withAuth(requirePermission(rbac.RolesManage, handleSetGroupRoles)))
+	mux.HandleFunc("/admin/reset-password", withAuth(requirePermission(rbac.UsersManage, handleResetPassword)))
+	mux.HandleFunc("/admin/reset-2fa", withAuth(requirePermission(rbac.UsersManage, handleResetTwoFactor)))
+	mux.HandleFunc("/admin/unlock-user", withAuth(requirePermission(rbac.UsersManage, handleUnlockUser)))
+	mux.HandleFunc("/admin/revoke-token", withAuth(requirePermission(rbac.UsersManage, handleRevokeAPIToken)))
+	mux.HandleFunc("/admin/create-invite", withAuth(requirePermission(rbac.UsersManage, handleCreateInvite)))
+	mux.HandleFunc("/admin/revoke-invite", withAuth(requirePermission(rbac.UsersManage, handleRevokeInvite)))
+	mux.HandleFunc("/admin/revoke-session", withAuth(requirePermission(rbac.UsersManage, handleRevokeSession)))
+	mux.HandleFunc("/admin/add-equipment", withAuth(requirePermission(rbac.EquipmentManage, handleAddEquipment)))
+	mux.HandleFunc("/admin/delete-equipment/", withAuth(requirePermission(rbac.EquipmentManage, handleDeleteEquipment))) // Note trailing slash
+	mux.HandleFunc("/admin/add-group", withAuth(requirePermission(rbac.UsersManage, handleAddGroup)))
+	mux.HandleFunc("/admin/delete-group/", withAuth(requirePermission(rbac.UsersManage, handleDeleteGroup)))
+	mux.HandleFunc("/admin/equipment-report", withAuth(requirePermission(rbac.EquipmentManage, handleEquipmentReport)))
+	mux.HandleFunc("/admin/delete-user", withAuth(requirePermission(rbac.UsersManage, handleDeleteUser)))
+	mux.HandleFunc("/admin/audit", withAuth(requirePermission(rbac.UsersManage, handleAuditLog)))
 
 	// Account management
 	mux.HandleFunc("/change-password", withAuth(handleChangePassword))
+	mux.HandleFunc("/account/email", withAuth(handleAccountEmail))
+	mux.HandleFunc("/account/2fa", withAuth(handleTwoFactorSettings))
+	mux.HandleFunc("/account/tokens", withAuth(handleAPITokens))
+	mux.HandleFunc("/account/sessions", withAuth(handleSessions))
 
 	// Public pages (no auth required)
 	mux.HandleFunc("/agents", handleAIAgents)
@@ -677,7 +997,7 @@ func main() {
 		log.Fatalf("Error creating static directories: %v\n", err)
 	}
 
-	handler := securityHeaders(mux, cfg.UseTLS)
+	handler := securityHeaders(authManagerInstance.SetupGate(mux), cfg.UseTLS)
 	server := &http.Server{
 		Addr:         cfg.Addr,
 		Handler:      handler,
@@ -716,7 +1036,23 @@ func main() {
 	log.Fatal(server.ListenAndServe())
 }
 
-func parseTemplates(files ...string) (*template.Template, error) {
+// csrfFuncs returns the csrfToken and csrfField template helpers bound to
+// the session of r.
+func csrfFuncs(r *http.Request) template.FuncMap {
+	var token string
+	if session, ok := auth.SessionFromContext(r.Context()); ok {
+		token = session.CSRFToken()
+	}
+
+	return template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + auth.CSRFFieldName + `" value="` + token + `">`)
+		},
+	}
+}
+
+func parseTemplates(r *http.Request, files ...string) (*template.Template, error) {
 	funcMap := template.FuncMap{
 		"markdown": renderMarkdown,
 		"split":    strings.Split,
@@ -733,61 +1069,124 @@ func parseTemplates(files ...string) (*template.Template, error) {
 		resolved = append(resolved, resolveTemplatePath(f))
 	}
 
-	return template.New("").Funcs(funcMap).ParseFiles(resolved...)
+	return template.New("").Funcs(funcMap).Funcs(csrfFuncs(r)).ParseFiles(resolved...)
 }
 
 // mainPageHandler serves the main page and handles search functionality
 func mainPageHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("query")
-	dateFrom := r.URL.Query().Get("date_from")
-	dateTo := r.URL.Query().Get("date_to")
-	var samples []Sample
-	var err error
+	params := r.URL.Query()
+	opts := SampleSearch{
+		Query:    params.Get("query"),
+		DateFrom: params.Get("date_from"),
+		DateTo:   params.Get("date_to"),
+		Sort:     params.Get("sort"),
+		Limit:    defaultSamplePageSize,
+		After:    params.Get("after"),
+	}
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil {
+		for _, size := range samplePageSizes {
+			if limit == size {
+				opts.Limit = limit
+			}
+		}
+	}
+	// "Load more" only appends cards to the grid and needs no total.
+	loadMore := hxTargetIs(r, "samples-grid")
+	opts.Count = !loadMore
 
 	// Get user info from context
 	session := auth.MustSessionFromContext(r.Context())
-
-	if query != "" {
-		samples, err = searchSamples(query, dateFrom, dateTo)
-		if err != nil {
-			http.Error(w, "Error retrieving search results", http.StatusInternalServerError)
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
 			return
 		}
-	} else {
-		samples, err = getAllSamples(dateFrom, dateTo)
-		if err != nil {
-			http.Error(w, "Error retrieving samples", http.StatusInternalServerError)
+		log.Printf("main: unable to load permissions for %s: %v", session.Username, err)
+	}
+	opts.Viewer = viewerFromBase(baseData)
+	mine := params.Get("mine") == "1"
+	if mine {
+		opts.OwnerID = session.UserID
+	}
+	if validStatus(params.Get("status")) {
+		opts.Status = params.Get("status")
+	}
+	opts.LocationID, _ = strconv.Atoi(params.Get("location"))
+
+	// A scanned label opens its sample instead of searching for it.
+	if id, ok := parseSampleCode(opts.Query); ok && !loadMore {
+		if err := checkSampleVisible(r.Context(), opts.Viewer, strconv.Itoa(id)); err == nil {
+			target := "/samples/" + strconv.Itoa(id)
+			if isHTMXRequest(r) {
+				w.Header().Set("HX-Redirect", target)
+				return
+			}
+			http.Redirect(w, r, target, http.StatusSeeOther)
 			return
 		}
 	}
 
-	// Execute the query
-	row := dbPool.QueryRow(context.Background(), `SELECT admin
-	FROM users
-	WHERE username = $1
-	  AND COALESCE(deleted, false) = false`, session.Username)
-
-	data := MainPageData{
-		BasePageData: BasePageData{Username: session.Username, UserID: session.UserID, IsAdmin: false},
-		Samples:      samples,
-		Query:        query,
-		DateFrom:     dateFrom,
-		DateTo:       dateTo,
+	var queryError string
+	page, err := searchSamples(r.Context(), opts)
+	var syntaxErr *samplequery.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		queryError = syntaxErr.Error()
+	case errors.Is(err, errInvalidCursor):
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("main: search for %q failed: %v", opts.Query, err)
+		http.Error(w, "Error retrieving samples", http.StatusInternalServerError)
+		return
 	}
 
-	if err := row.Scan(&data.BasePageData.IsAdmin); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			http.Redirect(w, r, "/logout", http.StatusSeeOther)
-			return
-		}
-		log.Printf("main: unable to load admin flag for %s: %v", session.Username, err)
+	tree, err := getStorageTree(r.Context())
+	if err != nil {
+		log.Printf("main: unable to load storage locations: %v", err)
 	}
-	tmpl, err := parseTemplates("templates/main.html")
+	setLocationPaths(page.Samples, tree)
+
+	data := MainPageData{
+		BasePageData: baseData,
+		Samples:      page.Samples,
+		Total:        page.Total,
+		Query:        opts.Query,
+		QueryError:   queryError,
+		DateFrom:     opts.DateFrom,
+		DateTo:       opts.DateTo,
+		Mine:         mine,
+		Status:       opts.Status,
+		LocationID:   opts.LocationID,
+		Locations:    tree,
+		Statuses:     sampleStatuses,
+		Sort:         page.Sort,
+		Limit:        opts.Limit,
+		PageSizes:    samplePageSizes,
+	}
+	if opts.After != "" {
+		params.Del("after")
+		data.FirstURL = "/?" + params.Encode()
+	}
+	if page.Next != "" {
+		params.Set("after", page.Next)
+		data.NextURL = "/?" + params.Encode()
+	}
+
+	tmpl, err := parseTemplates(r, "templates/main.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
 	}
 
+	if loadMore {
+		if err := tmpl.ExecuteTemplate(w, "samples_page", data); err != nil {
+			http.Error(w, "Error rendering samples", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	if hxTargetIs(r, "samples-panel") {
 		if err := tmpl.ExecuteTemplate(w, "samples_panel", data); err != nil {
 			http.Error(w, "Error rendering samples", http.StatusInternalServerError)
@@ -842,6 +1241,15 @@ func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	sampleID := pathParts[0]
 
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+	if err := checkSampleVisible(r.Context(), sampleViewerFor(r), sampleID); err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+
 	// Parse multipart form
 	err := r.ParseMultipartForm(10 << 20) // 10 MB max
 	if err != nil {
@@ -895,130 +1303,10 @@ func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/samples/"+sampleID, http.StatusSeeOther)
 }
 
-// searchSamples queries the database for samples by name, owner, or keywords
-func searchSamples(query string, dateFrom, dateTo string) ([]Sample, error) {
-	trimmedQuery := strings.TrimSpace(query)
-
-	rawKeywords := strings.FieldsFunc(query, func(r rune) bool {
-		return r == ' ' || r == ',' || r == ';'
-	})
-
-	var keywords []string
-	for _, keyword := range rawKeywords {
-		cleaned := strings.TrimSpace(keyword)
-		if cleaned == "" {
-			continue
-		}
-		keywords = append(keywords, strings.ToLower(cleaned))
-	}
-
-	// Construct the SQL query with `ANY` and `string_to_array`
-	var whereClauses []string
-	var args []interface{}
-	for i, keyword := range keywords {
-		whereClauses = append(whereClauses, fmt.Sprintf("$%d = ANY(string_to_array(lower(sample_keywords), ','))", i+1))
-		args = append(args, keyword)
-	}
-
-	// Also match the full query string in `sample_name`
-	nameParamIndex := len(args) + 1
-	args = append(args, "%"+trimmedQuery+"%")
-
-	queryText := fmt.Sprintf(`
-        SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, created_at
-        FROM samples
-        WHERE sample_name ILIKE $%d`, nameParamIndex)
-
-	// Match owner name as well
-	ownerParamIndex := len(args) + 1
-	args = append(args, "%"+trimmedQuery+"%")
-	queryText = fmt.Sprintf(`%s OR sample_owner ILIKE $%d`, queryText, ownerParamIndex)
-
-	if len(whereClauses) > 0 {
-		queryText = fmt.Sprintf(`%s OR %s`, queryText, strings.Join(whereClauses, " OR "))
-	}
-
-	// Wrap the search conditions in parentheses before adding date filters
-	queryText = fmt.Sprintf(`(%s)`, queryText)
-
-	// Add date filtering
-	if dateFrom != "" {
-		dateFromParamIndex := len(args) + 1
-		args = append(args, dateFrom)
-		queryText = fmt.Sprintf(`%s AND created_at >= $%d`, queryText, dateFromParamIndex)
-	}
-	if dateTo != "" {
-		dateToParamIndex := len(args) + 1
-		// Add one day to dateTo to include the entire end date
-		args = append(args, dateTo+" 23:59:59")
-		queryText = fmt.Sprintf(`%s AND created_at <= $%d`, queryText, dateToParamIndex)
-	}
-
-	// Execute the query
-	rows, err := dbPool.Query(context.Background(), queryText, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	// Collect the results
-	var samples []Sample
-	for rows.Next() {
-		var sample Sample
-		err := rows.Scan(&sample.ID, &sample.Name, &sample.Description, &sample.Keywords, &sample.Owner, &sample.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		samples = append(samples, sample)
-	}
-
-	return samples, nil
-}
-
-// getAllSamples retrieves all samples when there's no search query
-func getAllSamples(dateFrom, dateTo string) ([]Sample, error) {
-	queryText := `SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, coalesce(sample_prep, ''), created_at FROM samples`
-	var args []interface{}
-	var whereClauses []string
-
-	// Add date filtering
-	if dateFrom != "" {
-		whereClauses = append(whereClauses, fmt.Sprintf("created_at >= $%d", len(args)+1))
-		args = append(args, dateFrom)
-	}
-	if dateTo != "" {
-		whereClauses = append(whereClauses, fmt.Sprintf("created_at <= $%d", len(args)+1))
-		args = append(args, dateTo+" 23:59:59")
-	}
-
-	if len(whereClauses) > 0 {
-		queryText = queryText + " WHERE " + strings.Join(whereClauses, " AND ")
-	}
-
-	queryText = queryText + " ORDER BY sample_name ASC"
-
-	rows, err := dbPool.Query(context.Background(), queryText, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var samples []Sample
-	for rows.Next() {
-		var sample Sample
-		err := rows.Scan(&sample.ID, &sample.Name, &sample.Description, &sample.Keywords, &sample.Owner, &sample.Sample_prep, &sample.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		samples = append(samples, sample)
-	}
-
-	return samples, nil
-}
-
-// getSamples retrieves all samples from the database
-func getSamples() ([]Sample, error) {
-	rows, err := dbPool.Query(context.Background(), "SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, created_at FROM samples")
+// getSamples retrieves all samples the viewer may see from the database
+func getSamples(viewer sampleViewer) ([]Sample, error) {
+	clause, args := viewer.liveClause(nil)
+	rows, err := dbPool.Query(context.Background(), "SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, created_at FROM samples WHERE "+clause, args...)
 	if err != nil {
 		fmt.Printf("%s\n", err)
 		return nil, err
@@ -1057,8 +1345,21 @@ func sampleDetailHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	data.History, err = getSampleRevisions(r.Context(), sampleID)
+	if err != nil {
+		log.Printf("history: unable to load history of sample %s: %v", sampleID, err)
+	}
+	data.Lineage, err = getSampleLineage(r.Context(), viewerFromBase(data.BasePageData), data.Sample.ID)
+	if err != nil {
+		log.Printf("lineage: unable to load the lineage of sample %s: %v", sampleID, err)
+	}
+	if data.CanManage {
+		if data.Owners, err = getSampleOwners(r.Context()); err != nil {
+			log.Printf("samples: unable to list owners: %v", err)
+		}
+	}
 
-	tmpl, err := parseTemplates("templates/sample_detail.html")
+	tmpl, err := parseTemplates(r, "templates/sample_detail.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
@@ -1068,12 +1369,18 @@ func sampleDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func getSampleByID(sampleID string) (Sample, error) {
+// getSampleByID loads a sample with its attachments. A sample the viewer
+// may not see, or one in the trash, is reported as pgx.ErrNoRows, like a
+// missing one.
+func getSampleByID(ctx context.Context, sampleID string, viewer sampleViewer) (Sample, error) {
 	var sample Sample
-	err := dbPool.QueryRow(context.Background(),
-		`SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, coalesce(sample_prep, ''), created_at 
-         FROM samples WHERE sample_id=$1`, sampleID).Scan(
-		&sample.ID, &sample.Name, &sample.Description, &sample.Keywords, &sample.Owner, &sample.Sample_prep, &sample.CreatedAt)
+	clause, args := viewer.liveClause([]interface{}{sampleID})
+	err := dbPool.QueryRow(ctx,
+		`SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, coalesce(sample_prep, ''), created_at,
+                coalesce(owner_id, 0), visibility, status, coalesce(location_id, 0), coalesce(box_position, '')
+         FROM samples WHERE sample_id=$1 AND `+clause, args...).Scan(
+		&sample.ID, &sample.Name, &sample.Description, &sample.Keywords, &sample.Owner, &sample.Sample_prep, &sample.CreatedAt,
+		&sample.OwnerID, &sample.Visibility, &sample.Status, &sample.LocationID, &sample.Position)
 	if err != nil {
 		return sample, err
 	}
@@ -1089,26 +1396,32 @@ func getSampleByID(sampleID string) (Sample, error) {
 }
 
 func loadSampleDetailData(ctx context.Context, session auth.Session, sampleID string) (SampleDetailPageData, error) {
-	sample, err := getSampleByID(sampleID)
-	if err != nil {
+	baseData, err := getBasePageData(session)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		return SampleDetailPageData{}, err
 	}
 
-	data := SampleDetailPageData{
-		BasePageData: BasePageData{Username: session.Username, UserID: session.UserID},
-		Sample:       sample,
+	sample, err := getSampleByID(ctx, sampleID, viewerFromBase(baseData))
+	if err != nil {
+		return SampleDetailPageData{}, err
 	}
-
-	if err := dbPool.QueryRow(ctx,
-		"SELECT admin FROM users WHERE user_id = $1",
-		session.UserID,
-	).Scan(&data.BasePageData.IsAdmin); err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			return SampleDetailPageData{}, err
-		}
+	tree, err := getStorageTree(ctx)
+	if err != nil {
+		return SampleDetailPageData{}, err
+	}
+	if e, ok := findLocation(tree, sample.LocationID); ok {
+		sample.LocationPath = e.Path
 	}
 
-	return data, nil
+	return SampleDetailPageData{
+		BasePageData: baseData,
+		Sample:       sample,
+		CanManage:    canManageSample(baseData, sample.OwnerID),
+		Visibilities: visibilityOptions,
+		Locations:    tree,
+		Statuses:     sampleStatuses,
+		LineageKinds: lineage.Kinds,
+	}, nil
 }
 
 func renderSampleAttachmentsSection(w http.ResponseWriter, r *http.Request, session auth.Session, sampleID, flash, errMsg string) {
@@ -1121,7 +1434,7 @@ func renderSampleAttachmentsSection(w http.ResponseWriter, r *http.Request, sess
 	data.Error = errMsg
 	data.IsPartial = true
 
-	if err := renderTemplateSection(w, "templates/sample_detail.html", "sample_attachments", data); err != nil {
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_attachments", data); err != nil {
 		http.Error(w, "Error rendering attachments", http.StatusInternalServerError)
 	}
 }
@@ -1136,7 +1449,7 @@ func renderSampleEditSection(w http.ResponseWriter, r *http.Request, session aut
 	data.Error = errMsg
 	data.IsPartial = true
 
-	if err := renderTemplateSection(w, "templates/sample_detail.html", "sample_edit_form", data); err != nil {
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_edit_form", data); err != nil {
 		http.Error(w, "Error rendering form", http.StatusInternalServerError)
 	}
 }
@@ -1152,7 +1465,7 @@ func renderSamplePrepSection(w http.ResponseWriter, r *http.Request, session aut
 	data.IsPartial = true
 	data.EditingPrep = editing
 
-	if err := renderTemplateSection(w, "templates/sample_detail.html", "sample_prep_panel", data); err != nil {
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_prep_panel", data); err != nil {
 		http.Error(w, "Error rendering sample prep", http.StatusInternalServerError)
 	}
 }
@@ -1169,6 +1482,11 @@ func editSampleHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract sample ID from URL by removing "/samples/edit/"
 	sampleID := strings.TrimPrefix(r.URL.Path, "/samples/edit/")
 
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
 	// Parse form data
 	err := r.ParseForm()
 	if err != nil {
@@ -1180,38 +1498,31 @@ func editSampleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := r.FormValue("name")
-	description := r.FormValue("description")
-	keywords := r.FormValue("keywords")
-	owner := r.FormValue("owner")
 	hasSamplePrep := r.Form.Has("sample_prep")
-	sample_prep := r.FormValue("sample_prep")
-
-	var (
-		query string
-		args  []interface{}
-	)
-
-	if hasSamplePrep {
-		query = "UPDATE samples SET sample_name=$1, sample_description=$2, sample_keywords=$3, sample_owner=$4, sample_prep=$6 WHERE sample_id=$5"
-		args = []interface{}{name, description, keywords, owner, sampleID, sample_prep}
-	} else {
-		query = "UPDATE samples SET sample_name=$1, sample_description=$2, sample_keywords=$3, sample_owner=$4 WHERE sample_id=$5"
-		args = []interface{}{name, description, keywords, owner, sampleID}
-	}
-
-	_, err = dbPool.Exec(context.Background(), query, args...)
+	_, err = updateSample(r.Context(), sampleID, sampleViewerFor(r), 0, func(f *SampleFields) {
+		f.Name = r.FormValue("name")
+		f.Description = r.FormValue("description")
+		f.Keywords = r.FormValue("keywords")
+		// Linked owners change by transfer only.
+		if f.OwnerID == 0 && r.Form.Has("owner") {
+			f.Owner = r.FormValue("owner")
+		}
+		if hasSamplePrep {
+			f.Prep = r.FormValue("sample_prep")
+		}
+	})
 	if err != nil {
 		if isHTMXRequest(r) {
 			renderSampleEditSection(w, r, session, sampleID, "", "Failed to update the sample.")
 			return
 		}
-		fmt.Println(err)
+		log.Printf("samples: unable to update sample %s: %v", sampleID, err)
 		http.Error(w, "Error updating sample", http.StatusInternalServerError)
 		return
 	}
 
 	if isHTMXRequest(r) {
+		w.Header().Set("HX-Trigger", historyChangedEvent)
 		renderSampleEditSection(w, r, session, sampleID, "Changes saved", "")
 		return
 	}
@@ -1243,6 +1554,11 @@ func editSampleHandler(w http.ResponseWriter, r *http.Request) {
 
 // newSampleHandler handles both displaying the form and processing the submission
 func newSampleHandler(w http.ResponseWriter, r *http.Request) {
+	if !can(r, rbac.SamplesCreate, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
 	if r.Method == http.MethodGet {
 		session := auth.MustSessionFromContext(r.Context())
 
@@ -1252,13 +1568,54 @@ func newSampleHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		owners, err := getSampleOwners(r.Context())
+		if err != nil {
+			log.Printf("samples: unable to list owners: %v", err)
+		}
+
+		tree, err := getStorageTree(r.Context())
+		if err != nil {
+			log.Printf("samples: unable to load storage locations: %v", err)
+		}
+
+		// A derived sample starts as a copy of its parent.
+		form := Sample{Visibility: VisibilityLab, Status: StatusActive}
+		var parent *Sample
+		if ref := r.URL.Query().Get("parent"); ref != "" {
+			p, err := getSampleByID(r.Context(), ref, viewerFromBase(baseData))
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					http.Error(w, "Sample not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Error loading sample", http.StatusInternalServerError)
+				return
+			}
+			parent = &p
+			form = derivedSampleForm(r.Context(), p)
+		}
+
 		data := struct {
 			BasePageData
+			Sample       Sample
+			Parent       *Sample
+			LineageKinds []lineage.KindInfo
+			Owners       []SampleUser
+			Visibilities []VisibilityOption
+			Statuses     []StatusOption
+			Locations    []storage.Entry
 		}{
 			BasePageData: baseData,
+			Sample:       form,
+			Parent:       parent,
+			LineageKinds: lineage.Kinds,
+			Owners:       owners,
+			Visibilities: visibilityOptions,
+			Statuses:     sampleStatuses,
+			Locations:    tree,
 		}
 
-		tmpl, err := parseTemplates("templates/new_sample.html")
+		tmpl, err := parseTemplates(r, "templates/new_sample.html")
 		if err != nil {
 			http.Error(w, "Error loading template", http.StatusInternalServerError)
 			return
@@ -1272,19 +1629,90 @@ func newSampleHandler(w http.ResponseWriter, r *http.Request) {
 		name := r.FormValue("name")
 		description := r.FormValue("description")
 		keywords := r.FormValue("keywords")
-		owner := r.FormValue("owner")
 		prep := r.FormValue("sample_prep")
-		// Insert the new sample into the database
-		_, err := dbPool.Exec(context.Background(),
-			"INSERT INTO samples (sample_name, sample_description, sample_keywords, sample_prep, sample_owner) VALUES ($1, $2, $3, $4, $5)",
-			name, description, keywords, prep, owner)
+		visibility := r.FormValue("visibility")
+		if !validVisibility(visibility) {
+			visibility = VisibilityLab
+		}
+		status := r.FormValue("status")
+		if !validStatus(status) {
+			status = StatusActive
+		}
+
+		// The sample belongs to its creator unless another account is chosen.
+		session := auth.MustSessionFromContext(r.Context())
+		ownerID, owner := session.UserID, session.Username
+		if id, err := strconv.Atoi(r.FormValue("owner_id")); err == nil && id != ownerID {
+			username, err := lookupSampleOwner(r.Context(), id)
+			if err != nil {
+				http.Error(w, "Unknown owner", http.StatusBadRequest)
+				return
+			}
+			ownerID, owner = id, username
+		}
+
+		parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
+		kind := lineage.Kind(r.FormValue("relation"))
+		if parentID != 0 {
+			if !kind.Valid() {
+				http.Error(w, "Choose how the sample was made", http.StatusBadRequest)
+				return
+			}
+			if err := checkSampleVisible(r.Context(), sampleViewerFor(r), strconv.Itoa(parentID)); err != nil {
+				http.Error(w, "The parent sample no longer exists", http.StatusBadRequest)
+				return
+			}
+		}
+
+		locationID, _ := strconv.Atoi(r.FormValue("location_id"))
+		tree, err := getStorageTree(r.Context())
+		if err != nil {
+			http.Error(w, "Error loading storage locations", http.StatusInternalServerError)
+			return
+		}
+		position, problem, err := checkSampleStorage(r.Context(), sampleViewerFor(r), tree, 0, locationID, r.FormValue("position"))
+		if err != nil {
+			http.Error(w, "Error checking the storage location", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+
+		// Insert the new sample into the database, linked to its parent
+		tx, err := dbPool.Begin(r.Context())
+		if err != nil {
+			http.Error(w, "Error adding sample", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback(r.Context())
+
+		var id int
+		err = tx.QueryRow(r.Context(),
+			`INSERT INTO samples (sample_name, sample_description, sample_keywords, sample_prep, sample_owner, owner_id, visibility,
+                 status, location_id, box_position)
+             VALUES ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, 0), nullif($10, ''))
+             RETURNING sample_id`,
+			name, description, keywords, prep, owner, ownerID, visibility, status, locationID, position).Scan(&id)
+		if err == nil && parentID != 0 {
+			err = linkSamples(r.Context(), tx, parentID, id, kind, session.UserID)
+		}
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
 		if err != nil {
 			fmt.Printf("%v", err)
 			http.Error(w, "Error adding sample", http.StatusInternalServerError)
 			return
 		}
 
-		// Redirect to the main page to show the new sample
+		// A derived sample opens on its own page, with its lineage; other
+		// new samples are shown in the list.
+		if parentID != 0 {
+			http.Redirect(w, r, "/samples/"+strconv.Itoa(id), http.StatusSeeOther)
+			return
+		}
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	} else {
 		// Return 405 Method Not Allowed for other methods
@@ -1463,6 +1891,11 @@ func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	attachmentID := parts[2]
 
+	if err := checkAttachmentVisible(r.Context(), sampleViewerFor(r), attachmentID); err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
 	// Get file path from database
 	var filepath string
 	err := dbPool.QueryRow(context.Background(),
@@ -1496,6 +1929,15 @@ func deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	attachmentID := parts[2]
 
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+	if err := checkAttachmentVisible(r.Context(), sampleViewerFor(r), attachmentID); err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
 	// Get sample ID before deleting the attachment
 	var sampleID string
 	err := dbPool.QueryRow(context.Background(),
@@ -1562,7 +2004,7 @@ func handleAttachment(w http.ResponseWriter, r *http.Request) {
 // Helper function to create a new user (you'll need to run this manually or create an admin interface)
 func createUser(username, password string) error {
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := authManagerInstance.HashPassword(password)
 	if err != nil {
 		return err
 	}
@@ -1570,7 +2012,7 @@ func createUser(username, password string) error {
 	// Insert user
 	_, err = dbPool.Exec(context.Background(),
 		"INSERT INTO users (username, password_hash, is_approved) VALUES ($1, $2, false)",
-		username, string(hashedPassword))
+		username, hashedPassword)
 	return err
 }
 
@@ -1,11 +1,27 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v3"
+
+	"sampleDB/internal/lineage"
+	"sampleDB/internal/sampleimport"
+	"sampleDB/internal/storage"
 )
 
 func TestSanitizeFilename(t *testing.T) {
@@ -91,3 +107,580 @@ func TestSecurityHeaders(t *testing.T) {
 		t.Fatalf("security headers missing or incomplete: %+v", headers)
 	}
 }
+
+func TestHighlightTerms(t *testing.T) {
+	got := highlightTerms("Annealed <GaAs> wafer, anneal again", []string{"anneal", "gaas"})
+	want := "<mark>Annealed</mark> &lt;<mark>GaAs</mark>&gt; wafer, <mark>anneal</mark> again"
+	if string(got) != want {
+		t.Fatalf("highlightTerms = %q, want %q", got, want)
+	}
+}
+
+func TestMatchSnippet(t *testing.T) {
+	text := "Cleaned in acetone.\n\nSpin coated PMMA at 4000 rpm, then baked at 180 C for ten minutes before exposure."
+	got := string(matchSnippet(text, []string{"pmma"}, 20))
+	want := "…Spin coated <mark>PMMA</mark> at 4000 rpm, then…"
+	if got != want {
+		t.Fatalf("matchSnippet = %q, want %q", got, want)
+	}
+
+	if got := matchSnippet(text, []string{"silicon"}, 20); got != "" {
+		t.Fatalf("matchSnippet without a match = %q, want empty", got)
+	}
+}
+
+func TestSearchSamplesPagesWithKeyset(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	columns := []string{"sample_id", "sample_name", "sample_description", "sample_keywords", "sample_owner", "sample_prep", "created_at", "status", "location_id", "box_position", "sort_key"}
+	created := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM samples WHERE deleted_at IS NULL AND \(coalesce\(sample_owner, ''\) ILIKE \$1\)`).
+		WithArgs("%alice%").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`ORDER BY coalesce\(created_at, timestamp '1970-01-01'\) DESC, sample_id DESC\s+LIMIT \$2`).
+		WithArgs("%alice%", 3).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow(9, "S-9", "", "", "alice", "", created, "active", 0, "", "2025-03-01 09:00:00").
+			AddRow(7, "S-7", "", "", "alice", "", created, "active", 0, "", "2025-03-01 09:00:00").
+			AddRow(4, "S-4", "", "", "alice", "", created, "active", 0, "", "2025-02-01 09:00:00"))
+
+	page, err := searchSamples(context.Background(), SampleSearch{Query: "owner:alice", Sort: "-created", Limit: 2, Count: true, Viewer: sampleViewer{All: true}})
+	if err != nil {
+		t.Fatalf("searchSamples: %v", err)
+	}
+	if page.Total != 3 || len(page.Samples) != 2 || page.Next == "" || page.Sort != "-created" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+
+	mock.ExpectQuery(`WHERE deleted_at IS NULL AND \(coalesce\(sample_owner, ''\) ILIKE \$1\) AND \(coalesce\(created_at, timestamp '1970-01-01'\), sample_id\) < \(\$2::timestamp, \$3\)`).
+		WithArgs("%alice%", "2025-03-01 09:00:00", 7, 3).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow(4, "S-4", "", "", "alice", "", created, "active", 0, "", "2025-02-01 09:00:00"))
+
+	page, err = searchSamples(context.Background(), SampleSearch{Query: "owner:alice", Sort: "-created", Limit: 2, After: page.Next, Viewer: sampleViewer{All: true}})
+	if err != nil {
+		t.Fatalf("searchSamples second page: %v", err)
+	}
+	if len(page.Samples) != 1 || page.Samples[0].ID != 4 || page.Next != "" {
+		t.Fatalf("unexpected second page: %+v", page)
+	}
+
+	if _, err := searchSamples(context.Background(), SampleSearch{Sort: "name", After: page.Samples[0].Name}); err != errInvalidCursor {
+		t.Fatalf("searchSamples with a malformed cursor: err = %v, want errInvalidCursor", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSampleVisibility(t *testing.T) {
+	if clause, args := (sampleViewer{UserID: 5, All: true}).visibleClause([]interface{}{"7"}); clause != "TRUE" || len(args) != 1 {
+		t.Fatalf("visibleClause for a sample manager = %q %v, want TRUE", clause, args)
+	}
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	viewer := sampleViewer{UserID: 5, Username: "bob"}
+	mock.ExpectQuery(`FROM samples WHERE sample_id = \$1 AND deleted_at IS NULL AND \(owner_id IS NULL OR visibility = 'lab' OR owner_id = \$2`).
+		WithArgs("7", 5).
+		WillReturnError(pgx.ErrNoRows)
+	if err := checkSampleVisible(context.Background(), viewer, "7"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("checkSampleVisible for a hidden sample: err = %v, want pgx.ErrNoRows", err)
+	}
+
+	mock.ExpectQuery(`FROM samples WHERE deleted_at IS NULL AND \(owner_id IS NULL .*viewer.user_id = \$1 .*\) AND owner_id = \$2 AND TRUE ORDER BY`).
+		WithArgs(5, 5, 21).
+		WillReturnRows(pgxmock.NewRows([]string{"sample_id", "sample_name", "sample_description", "sample_keywords", "sample_owner", "sample_prep", "created_at", "status", "location_id", "box_position", "sort_key"}))
+	if _, err := searchSamples(context.Background(), SampleSearch{OwnerID: 5, Viewer: viewer, Limit: 20}); err != nil {
+		t.Fatalf("searchSamples: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestInsertImportedSamplesRollsBackOnFailure(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	created := time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)
+	records := []sampleimport.Record{
+		{Line: 2, Name: "GaAs-01", Owner: "alice", Created: &created},
+		{Line: 3, Name: "GaAs-02"},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`WITH owner AS \(.*is_approved.*LIMIT 1\s*\)\s*INSERT INTO samples`).
+		WithArgs("GaAs-01", "", "", "", "alice", &created).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`INSERT INTO samples`).
+		WithArgs("GaAs-02", "", "", "", "", (*time.Time)(nil)).
+		WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+
+	err = insertImportedSamples(context.Background(), records)
+	if err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Fatalf("insertImportedSamples error = %v, want a failure on row 3", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReadImportDataFromPreviewForm(t *testing.T) {
+	// Mostly characters that URL encoding triples, so that the file would
+	// not fit into a URL-encoded form.
+	row := strings.Repeat("é,", 30) + "\n"
+	csv := "name\n" + strings.Repeat(row, (sampleImportMaxBytes-100)/len(row))
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("mapped", "1")
+	form.WriteField("data", csv)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/samples/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if err := req.ParseMultipartForm(sampleImportMaxBytes); err != nil {
+		t.Fatalf("ParseMultipartForm: %v", err)
+	}
+
+	raw, problem := readImportData(req)
+	if problem != "" || string(raw) != csv {
+		t.Fatalf("readImportData = %d bytes, %q; want the %d bytes sent", len(raw), problem, len(csv))
+	}
+}
+
+func TestExportSamplesZIP(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	dir := t.TempDir()
+	attachment := filepath.Join(dir, "0a1b2c3d_spectrum.csv")
+	if err := os.WriteFile(attachment, []byte("nm,counts\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT sample_id, sample_name`).
+		WithArgs(exportBatchSize + 1).
+		WillReturnRows(pgxmock.NewRows([]string{"sample_id", "sample_name", "sample_description", "sample_keywords", "sample_owner", "sample_prep", "created_at", "status", "location_id", "box_position", "sort_key"}).
+			AddRow(7, "GaAs/01", "", "MBE", "alice", "# Anneal\n\n400 C", created, "active", 0, "", "GaAs/01"))
+	mock.ExpectQuery(`FROM attachments`).
+		WithArgs("7").
+		WillReturnRows(pgxmock.NewRows([]string{"attachment_id", "source_id", "attachment_address", "uploaded_at"}).
+			AddRow(1, 7, attachment, created).
+			AddRow(2, 7, filepath.Join(dir, "gone_spectrum.csv"), created))
+
+	var buf bytes.Buffer
+	if err := exportSamplesZIP(context.Background(), &buf, SampleSearch{Viewer: sampleViewer{All: true}}, "export"); err != nil {
+		t.Fatalf("exportSamplesZIP: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+
+	want := []string{
+		"export/7-GaAs-01/sample.json",
+		"export/7-GaAs-01/prep.md",
+		"export/7-GaAs-01/prep.html",
+		"export/7-GaAs-01/attachments/spectrum.csv",
+		"export/samples.csv",
+	}
+	for _, name := range want {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive has no %s; entries: %v", name, zr.File)
+		}
+	}
+	if len(files) != len(want) {
+		t.Errorf("archive has %d entries, want %d", len(files), len(want))
+	}
+	if !strings.Contains(files["export/7-GaAs-01/prep.html"], "<h1") {
+		t.Errorf("prep.html is not rendered: %q", files["export/7-GaAs-01/prep.html"])
+	}
+	if !strings.Contains(files["export/7-GaAs-01/sample.json"], `"missing": true`) {
+		t.Errorf("sample.json does not flag the missing attachment: %s", files["export/7-GaAs-01/sample.json"])
+	}
+	if files["export/samples.csv"] != "id,name,owner,created,directory\n7,GaAs/01,alice,2025-03-01 09:00:00,7-GaAs-01/\n" {
+		t.Errorf("samples.csv = %q", files["export/samples.csv"])
+	}
+}
+
+func TestDeadlineWriterOutlastsWriteTimeout(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := &deadlineWriter{w: w, rc: http.NewResponseController(w), timeout: time.Second}
+		for i := 0; i < 6; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := io.WriteString(out, strings.Repeat("x", 64<<10)); err != nil {
+				return
+			}
+		}
+	}))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || len(body) != 6*64<<10 {
+		t.Fatalf("read %d bytes, err %v; want the whole response", len(body), err)
+	}
+}
+
+func TestPurgeSampleRemovesAttachmentFiles(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	dir := t.TempDir()
+	attachment := filepath.Join(dir, "0a1b2c3d_spectrum.csv")
+	if err := os.WriteFile(attachment, []byte("nm,counts\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM samples WHERE sample_id = \$1 AND deleted_at IS NOT NULL FOR UPDATE`).
+		WithArgs("7").
+		WillReturnRows(pgxmock.NewRows([]string{"sample_id"}).AddRow(7))
+	mock.ExpectQuery(`DELETE FROM attachments WHERE source_type = 'sample' AND source_id = \$1`).
+		WithArgs(7).
+		WillReturnRows(pgxmock.NewRows([]string{"attachment_address"}).
+			AddRow(attachment).
+			AddRow(filepath.Join(dir, "gone_spectrum.csv")))
+	mock.ExpectExec(`DELETE FROM samples WHERE sample_id = \$1`).
+		WithArgs(7).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+
+	removed, err := purgeSample(context.Background(), "7")
+	if err != nil {
+		t.Fatalf("purgeSample: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("purgeSample removed %d files, want 1", removed)
+	}
+	if _, err := os.Stat(attachment); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("attachment file still present: %v", err)
+	}
+
+	// A sample that is not in the trash is left alone.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`deleted_at IS NOT NULL FOR UPDATE`).
+		WithArgs("8").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectRollback()
+	if _, err := purgeSample(context.Background(), "8"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("purgeSample of a live sample: err = %v, want pgx.ErrNoRows", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSampleStorage(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	box := storage.Location{ID: 3, ParentID: 2, Kind: storage.Box, Name: "Box 1", Rows: 2, Columns: 3}
+	tree := storage.Flatten([]storage.Location{
+		{ID: 1, Kind: storage.Building, Name: "Physics"},
+		{ID: 2, ParentID: 1, Kind: storage.Freezer, Name: "-80"},
+		box,
+	})
+
+	viewer := sampleViewer{UserID: 5, Username: "alice"}
+	mock.ExpectQuery(`WHERE location_id = \$1 AND box_position = \$2 AND deleted_at IS NULL AND sample_id <> \$3`).
+		WithArgs(3, "B2", 7, 5).
+		WillReturnError(pgx.ErrNoRows)
+	pos, problem, err := checkSampleStorage(context.Background(), viewer, tree, 7, 3, " b02")
+	if err != nil || problem != "" || pos != "B2" {
+		t.Fatalf("checkSampleStorage(free) = %q, %q, %v; want B2", pos, problem, err)
+	}
+
+	mock.ExpectQuery(`SELECT sample_name, \(owner_id IS NULL .* box_position = \$2`).
+		WithArgs(3, "A1", 0, 5).
+		WillReturnRows(pgxmock.NewRows([]string{"sample_name", "visible"}).AddRow("GaAs/01", true))
+	if _, problem, err := checkSampleStorage(context.Background(), viewer, tree, 0, 3, "A1"); err != nil || !strings.Contains(problem, "GaAs/01") {
+		t.Errorf("checkSampleStorage(taken) problem = %q, %v; want it to name the holder", problem, err)
+	}
+
+	// A sample the viewer may not see holds the position without being named.
+	mock.ExpectQuery(`box_position = \$2`).
+		WithArgs(3, "A2", 0, 5).
+		WillReturnRows(pgxmock.NewRows([]string{"sample_name", "visible"}).AddRow("Secret/07", false))
+	if _, problem, err := checkSampleStorage(context.Background(), viewer, tree, 0, 3, "A2"); err != nil || problem != "Position A2 is already taken." {
+		t.Errorf("checkSampleStorage(taken, hidden) problem = %q, %v; want the holder left unnamed", problem, err)
+	}
+
+	for _, tc := range []struct {
+		location int
+		position string
+	}{{3, "C1"}, {2, "A1"}, {0, "A1"}, {42, ""}} {
+		if _, problem, err := checkSampleStorage(context.Background(), viewer, tree, 7, tc.location, tc.position); err != nil || problem == "" {
+			t.Errorf("checkSampleStorage(%d, %q) accepted, want a problem", tc.location, tc.position)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	grid, unplaced := boxGrid(box, []LocationSample{
+		{ID: 1, Position: "A2", Visible: true},
+		{Position: "B3"},
+		{ID: 3, Position: "A2", Visible: true},
+		{ID: 4, Visible: true},
+	})
+	if len(grid.Rows) != 2 || len(grid.Columns) != 3 || grid.Rows[1].Label != "B" {
+		t.Fatalf("unexpected grid layout: %+v", grid)
+	}
+	if s := grid.Rows[0].Cells[1].Sample; s == nil || s.ID != 1 {
+		t.Errorf("A2 holds %+v, want sample 1", s)
+	}
+	if s := grid.Rows[1].Cells[2].Sample; s == nil || s.Visible {
+		t.Errorf("B3 holds %+v, want a hidden sample", s)
+	}
+	if grid.Free != 4 || len(unplaced) != 2 {
+		t.Errorf("free = %d, unplaced = %+v; want 4 free and 2 unplaced", grid.Free, unplaced)
+	}
+}
+
+func TestSampleLineage(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	// 1 was split into 4 and 5; 6 is a piece of 5, owned by someone else.
+	mock.ExpectQuery(`WITH RECURSIVE\s+up .*FROM sample_relations WHERE child_id = \$1`).
+		WithArgs(5, lineage.MaxDepth).
+		WillReturnRows(pgxmock.NewRows([]string{"parent_id", "child_id", "relation"}).
+			AddRow(1, 5, "split-from").
+			AddRow(5, 6, "piece-of"))
+	mock.ExpectQuery(`FROM samples WHERE sample_id = ANY\(\$1\)`).
+		WithArgs([]int{1, 6}, 3).
+		WillReturnRows(pgxmock.NewRows([]string{"sample_id", "sample_name", "status", "visible"}).
+			AddRow(1, "GaAs/01", "consumed", true).
+			AddRow(6, "GaAs/01-2a", "active", false))
+
+	got, err := getSampleLineage(context.Background(), sampleViewer{UserID: 3, Username: "alice"}, 5)
+	if err != nil {
+		t.Fatalf("getSampleLineage: %v", err)
+	}
+	if len(got.Ancestors) != 1 || got.Ancestors[0].Name != "GaAs/01" || got.Ancestors[0].Kind != lineage.SplitFrom {
+		t.Errorf("ancestors = %+v, want GaAs/01", got.Ancestors)
+	}
+	if len(got.Descendants) != 1 || got.Descendants[0].ID != 6 || got.Descendants[0].Visible || got.Descendants[0].Name != "" {
+		t.Errorf("descendants = %+v, want sample 6 without its name", got.Descendants)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+		WithArgs(lineageLockKey).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectExec(`INSERT INTO sample_relations .*WHERE NOT EXISTS`).
+		WithArgs(6, 1, "derived-from", 3).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	mock.ExpectRollback()
+	tx, err := mock.Begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := linkSamples(context.Background(), tx, 6, 1, lineage.DerivedFrom, 3); !errors.Is(err, errLineageCycle) {
+		t.Errorf("linkSamples closing a loop: err = %v, want errLineageCycle", err)
+	}
+	if err := linkSamples(context.Background(), tx, 4, 4, lineage.DerivedFrom, 3); !errors.Is(err, errLineageCycle) {
+		t.Errorf("linkSamples to itself: err = %v, want errLineageCycle", err)
+	}
+	tx.Rollback(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSampleLabels(t *testing.T) {
+	defer func(cfg AppConfig) { appConfig = cfg }(appConfig)
+	appConfig.BaseURL = "https://lab.example.org"
+
+	for code, want := range map[string]int{
+		"SDB-000042":                         42,
+		" sdb-000042\n":                      42,
+		"SDB-1234567":                        1234567,
+		"https://lab.example.org/samples/42": 42,
+		"SDB-00004":                          0,
+		"SDB-0000042":                        0,
+		"SDB-000000":                         0,
+		"https://lab.example.org/samples/42/upload": 0,
+		"https://other.example.org/samples/42":      0,
+		"GaAs-000042":                               0,
+	} {
+		id, ok := parseSampleCode(code)
+		if id != want || ok != (want != 0) {
+			t.Errorf("parseSampleCode(%q) = %d, %v; want %d", code, id, ok, want)
+		}
+	}
+
+	ids, err := labelSampleIDs(map[string][]string{"id": {"7", "3", "7"}})
+	if err != nil || len(ids) != 2 || ids[0] != 7 || ids[1] != 3 {
+		t.Errorf("labelSampleIDs = %v, %v; want [7 3]", ids, err)
+	}
+	if _, err := labelSampleIDs(map[string][]string{"id": {"7", "x"}}); err == nil {
+		t.Error("labelSampleIDs accepted an invalid ID")
+	}
+
+	opts := parseLabelOptions(map[string][]string{"symbology": {"code128"}, "layout": {"l7160"}, "start": {"30"}})
+	if opts.Content != "code" || opts.Layout.ID != "l7160" || opts.Start != 1 {
+		t.Errorf("parseLabelOptions = %+v; want code content from position 1 of l7160", opts)
+	}
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	created := time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`FROM samples WHERE sample_id = ANY\(\$1\) AND deleted_at IS NULL AND .*ORDER BY array_position`).
+		WithArgs([]int{7, 3}, 5).
+		WillReturnRows(pgxmock.NewRows([]string{"sample_id", "sample_name", "sample_owner", "created_at"}).
+			AddRow(7, "GaAs/01", "alice", created).
+			AddRow(3, "Si-3", "", created))
+
+	samples, err := getLabelSamples(context.Background(), sampleViewer{UserID: 5, Username: "alice"}, ids)
+	if err != nil {
+		t.Fatalf("getLabelSamples: %v", err)
+	}
+	if len(samples) != 2 || samples[0].Code() != "SDB-000007" {
+		t.Fatalf("samples = %+v", samples)
+	}
+	label := parseLabelOptions(nil).label(samples[0])
+	if label.Data != "https://lab.example.org/samples/7" || label.Title != "GaAs/01" || label.Lines[0] != "SDB-000007" || !strings.HasPrefix(label.Lines[1], "alice · ") {
+		t.Errorf("label = %+v", label)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateSampleRecordsRevisions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	dbPool = mock
+	defer func() { dbPool = nil }()
+
+	viewer := sampleViewer{UserID: 3, Username: "alice"}
+	columns := []string{"sample_id", "sample_name", "sample_description", "sample_keywords", "sample_owner", "sample_prep", "owner_id", "visibility"}
+
+	// The first change of a sample also keeps the state before it.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM samples WHERE sample_id = \$1 AND deleted_at IS NULL AND \(owner_id IS NULL .* FOR UPDATE`).WithArgs("7", 3).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(7, "GaAs-01", "", "MBE", "alice", "Anneal at 400 C", 3, "private"))
+	mock.ExpectQuery(`SELECT coalesce\(max\(revision_number\), 0\) FROM sample_revisions`).WithArgs(7).
+		WillReturnRows(pgxmock.NewRows([]string{"max"}).AddRow(0))
+	mock.ExpectExec(`INSERT INTO sample_revisions .* SELECT sample_id, 1,`).WithArgs(7).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`UPDATE samples SET`).
+		WithArgs("GaAs-01", "", "MBE", "alice", "Anneal at 450 C", 3, "private", 7).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(`INSERT INTO sample_revisions .* VALUES`).
+		WithArgs(7, 2, "GaAs-01", "", "MBE", "alice", "Anneal at 450 C", 3, "alice", 0).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	changed, err := updateSample(context.Background(), "7", viewer, 0, func(f *SampleFields) { f.Prep = "Anneal at 450 C" })
+	if err != nil || !changed {
+		t.Fatalf("updateSample = %v, %v; want a change", changed, err)
+	}
+
+	// Saving the same values writes nothing.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).WithArgs("7", 3).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(7, "GaAs-01", "", "MBE", "alice", "Anneal at 450 C", 3, "private"))
+	mock.ExpectRollback()
+
+	changed, err = updateSample(context.Background(), "7", viewer, 0, func(f *SampleFields) { f.Prep = "Anneal at 450 C" })
+	if err != nil || changed {
+		t.Fatalf("updateSample without changes = %v, %v; want no change", changed, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCompareRevisions(t *testing.T) {
+	rev := SampleRevision{SampleFields: SampleFields{Name: "GaAs-02", Owner: "bob", Prep: "a\nb\n"}}
+	compareRevisions(&rev, SampleFields{Name: "GaAs-01", Owner: "bob", Prep: "a\n"})
+
+	if rev.Summary != "changed name and preparation notes" {
+		t.Errorf("Summary = %q", rev.Summary)
+	}
+	if len(rev.Changes) != 1 || rev.Changes[0] != (FieldChange{Label: "Name", Old: "GaAs-01", New: "GaAs-02"}) {
+		t.Errorf("Changes = %+v", rev.Changes)
+	}
+	if !rev.PrepChanged || len(rev.PrepDiff) != 1 {
+		t.Errorf("PrepDiff = %+v", rev.PrepDiff)
+	}
+}
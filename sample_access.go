@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
+)
+
+// Sample visibility levels. Samples without a linked owner are always
+// visible to the whole lab.
+const (
+	VisibilityPrivate = "private"
+	VisibilityGroup   = "group"
+	VisibilityLab     = "lab"
+)
+
+// VisibilityOption is a choice in the visibility select.
+type VisibilityOption struct {
+	Value string
+	Label string
+}
+
+var visibilityOptions = []VisibilityOption{
+	{VisibilityLab, "Lab-wide: everyone can see it"},
+	{VisibilityGroup, "Group: the owner's group can see it"},
+	{VisibilityPrivate, "Private: only the owner can see it"},
+}
+
+func validVisibility(v string) bool {
+	for _, opt := range visibilityOptions {
+		if opt.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// VisibilityLabel describes who can see s.
+func (s Sample) VisibilityLabel() string {
+	if s.OwnerID == 0 {
+		return "Lab-wide: the sample has no owner account"
+	}
+	for _, opt := range visibilityOptions {
+		if opt.Value == s.Visibility {
+			return opt.Label
+		}
+	}
+	return s.Visibility
+}
+
+// sampleViewer is the user samples are read or changed for.
+type sampleViewer struct {
+	UserID   int
+	Username string
+	All      bool // may see every sample whatever its visibility
+}
+
+// sampleViewerFor returns the viewer of the request's session.
+func sampleViewerFor(r *http.Request) sampleViewer {
+	session := auth.MustSessionFromContext(r.Context())
+	return sampleViewer{
+		UserID:   session.UserID,
+		Username: session.Username,
+		All:      can(r, rbac.SamplesManage, rbac.Resource{}),
+	}
+}
+
+// viewerFromBase returns the viewer for a user whose page data is loaded.
+func viewerFromBase(base BasePageData) sampleViewer {
+	return sampleViewer{
+		UserID:   base.UserID,
+		Username: base.Username,
+		All:      base.grants.Can(rbac.SamplesManage, rbac.Resource{}),
+	}
+}
+
+// visibleClause returns a condition on the samples table that holds for the
+// samples v may see, with its parameters appended to args. Lab-wide and
+// unowned samples are visible to all, group samples to users in the owner's
+// group, and private samples to their owner only.
+func (v sampleViewer) visibleClause(args []interface{}) (string, []interface{}) {
+	if v.All {
+		return "TRUE", args
+	}
+	args = append(args, v.UserID)
+	n := len(args)
+	return fmt.Sprintf(`(owner_id IS NULL OR visibility = 'lab' OR owner_id = $%d
+            OR (visibility = 'group' AND owner_id IN (
+                SELECT member.user_id FROM users member
+                JOIN users viewer ON viewer."group" = member."group"
+                WHERE viewer.user_id = $%d AND viewer."group" <> '')))`, n, n), args
+}
+
+// liveClause is visibleClause for samples that are not in the trash.
+func (v sampleViewer) liveClause(args []interface{}) (string, []interface{}) {
+	if v.All {
+		return "deleted_at IS NULL", args
+	}
+	clause, args := v.visibleClause(args)
+	return "deleted_at IS NULL AND " + clause, args
+}
+
+// checkSampleVisible returns pgx.ErrNoRows when the sample does not exist, is
+// in the trash, or v may not see it, so that hidden samples look like
+// missing ones.
+func checkSampleVisible(ctx context.Context, v sampleViewer, sampleID string) error {
+	clause, args := v.liveClause([]interface{}{sampleID})
+	var id int
+	return dbPool.QueryRow(ctx, "SELECT sample_id FROM samples WHERE sample_id = $1 AND "+clause, args...).Scan(&id)
+}
+
+// checkAttachmentVisible is checkSampleVisible for the sample an attachment
+// belongs to.
+func checkAttachmentVisible(ctx context.Context, v sampleViewer, attachmentID string) error {
+	clause, args := v.liveClause([]interface{}{attachmentID})
+	var id int
+	return dbPool.QueryRow(ctx,
+		`SELECT a.attachment_id FROM attachments a
+         JOIN samples ON samples.sample_id = a.source_id
+         WHERE a.attachment_id = $1 AND a.source_type = 'sample' AND `+clause, args...).Scan(&id)
+}
+
+// canManageSample reports whether the user of base may transfer a sample
+// and change its visibility: its owner and holders of samples.manage may;
+// a sample not yet linked to an account may be claimed by anyone who can
+// edit samples.
+func canManageSample(base BasePageData, ownerID int) bool {
+	if ownerID == 0 {
+		return base.grants.Can(rbac.SamplesEdit, rbac.Resource{})
+	}
+	return ownerID == base.UserID || base.grants.Can(rbac.SamplesManage, rbac.Resource{})
+}
+
+// SampleUser is an account samples can be assigned to.
+type SampleUser struct {
+	ID       int
+	Username string
+}
+
+// getSampleOwners lists the approved accounts, for owner selects.
+func getSampleOwners(ctx context.Context) ([]SampleUser, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT user_id, username FROM users
+         WHERE is_approved AND NOT coalesce(deleted, false)
+         ORDER BY lower(username)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []SampleUser
+	for rows.Next() {
+		var u SampleUser
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// lookupSampleOwner returns the username of an approved, active account.
+func lookupSampleOwner(ctx context.Context, userID int) (string, error) {
+	var username string
+	err := dbPool.QueryRow(ctx,
+		`SELECT username FROM users
+         WHERE user_id = $1 AND is_approved AND NOT coalesce(deleted, false)`, userID).Scan(&username)
+	return username, err
+}
+
+// handleSampleOwnership changes the owner and visibility of a sample on
+// POST to /samples/ownership/{id}. A new owner is recorded in the history
+// like any other edit; visibility changes are not.
+func handleSampleOwnership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.MustSessionFromContext(r.Context())
+	sampleID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/ownership/"), "/")
+	if _, err := strconv.Atoi(sampleID); err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := loadSampleDetailData(r.Context(), session, sampleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+	if !canManageSample(data.BasePageData, data.Sample.OwnerID) {
+		forbidden(w, r)
+		return
+	}
+
+	fail := func(msg string) {
+		if isHTMXRequest(r) {
+			renderSampleOwnershipSection(w, r, session, sampleID, "", msg)
+			return
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+	}
+
+	ownerID, err := strconv.Atoi(r.FormValue("owner_id"))
+	if err != nil {
+		fail("Choose the new owner.")
+		return
+	}
+	visibility := r.FormValue("visibility")
+	if !validVisibility(visibility) {
+		fail("Choose who can see the sample.")
+		return
+	}
+
+	username := data.Sample.Owner
+	if ownerID != data.Sample.OwnerID {
+		username, err = lookupSampleOwner(r.Context(), ownerID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			fail("The new owner must be an active account.")
+			return
+		}
+		if err != nil {
+			log.Printf("samples: unable to look up user %d: %v", ownerID, err)
+			fail("Unable to save the ownership. Try again.")
+			return
+		}
+	}
+
+	viewer := viewerFromBase(data.BasePageData)
+	_, err = updateSample(r.Context(), sampleID, viewer, 0, func(f *SampleFields) {
+		f.OwnerID = ownerID
+		f.Owner = username
+		f.Visibility = visibility
+	})
+	if err != nil {
+		log.Printf("samples: unable to save ownership of sample %s: %v", sampleID, err)
+		fail("Unable to save the ownership. Try again.")
+		return
+	}
+	if ownerID != data.Sample.OwnerID {
+		log.Printf("samples: %s transferred sample %s to %s", session.Username, sampleID, username)
+	}
+
+	// A private sample handed to someone else is hidden from its previous
+	// owner from now on.
+	if err := checkSampleVisible(r.Context(), viewerFromBase(data.BasePageData), sampleID); err != nil {
+		if isHTMXRequest(r) {
+			w.Header().Set("HX-Redirect", "/")
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Trigger", historyChangedEvent)
+		renderSampleOwnershipSection(w, r, session, sampleID, "Ownership saved", "")
+		return
+	}
+	http.Redirect(w, r, "/samples/"+sampleID, http.StatusSeeOther)
+}
+
+func renderSampleOwnershipSection(w http.ResponseWriter, r *http.Request, session auth.Session, sampleID, flash, errMsg string) {
+	data, err := loadSampleDetailData(r.Context(), session, sampleID)
+	if err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+	data.Flash = flash
+	data.Error = errMsg
+	data.IsPartial = true
+	if data.Owners, err = getSampleOwners(r.Context()); err != nil {
+		log.Printf("samples: unable to list owners: %v", err)
+	}
+
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_ownership", data); err != nil {
+		http.Error(w, "Error rendering ownership", http.StatusInternalServerError)
+	}
+}
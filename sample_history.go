@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/linediff"
+	"sampleDB/internal/rbac"
+)
+
+// prepDiffContext is the number of unchanged lines shown around each change
+// in the preparation notes diff.
+const prepDiffContext = 3
+
+// historyChangedEvent is sent as HX-Trigger after a sample was changed, so
+// that the history on the detail page reloads.
+const historyChangedEvent = "sample-history-changed"
+
+// SampleFields are the editable fields of a sample. All but OwnerID and
+// Visibility are kept in its revision history.
+type SampleFields struct {
+	Name        string
+	Description string
+	Keywords    string
+	Owner       string
+	Prep        string
+	OwnerID     int    // zero when the owner has no account
+	Visibility  string // not kept in the history
+}
+
+// versioned returns f without the fields the history leaves out.
+func (f SampleFields) versioned() SampleFields {
+	f.OwnerID, f.Visibility = 0, ""
+	return f
+}
+
+// SampleRevision is one version of a sample. Changes and PrepDiff compare it
+// with the revision before; they are empty for the first one.
+type SampleRevision struct {
+	SampleFields
+	Number       int
+	ChangedBy    string // empty for the state before history was recorded
+	ChangedAt    time.Time
+	RestoredFrom int
+	Current      bool
+	Summary      string // e.g. "changed name and preparation notes"
+	Changes      []FieldChange
+	PrepChanged  bool
+	PrepDiff     []linediff.Hunk
+}
+
+// FieldChange is a field that differs from the previous revision.
+type FieldChange struct {
+	Label string
+	Old   string
+	New   string
+}
+
+// updateSample applies change to the current fields of a sample and saves
+// the result. A change to the versioned fields is recorded as a new
+// revision by v; the first one also records the state before it, so that the
+// original can be restored. Nothing is written, and false is returned, when
+// change leaves the fields as they are. Samples v may not see and samples in
+// the trash are reported as pgx.ErrNoRows.
+func updateSample(ctx context.Context, sampleID string, v sampleViewer, restoredFrom int, change func(*SampleFields)) (bool, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	var cur SampleFields
+	clause, args := v.liveClause([]interface{}{sampleID})
+	err = tx.QueryRow(ctx,
+		`SELECT sample_id, sample_name, coalesce(sample_description, ''), coalesce(sample_keywords, ''),
+                coalesce(sample_owner, ''), coalesce(sample_prep, ''), coalesce(owner_id, 0), visibility
+         FROM samples WHERE sample_id = $1 AND `+clause+` FOR UPDATE`, args...).
+		Scan(&id, &cur.Name, &cur.Description, &cur.Keywords, &cur.Owner, &cur.Prep, &cur.OwnerID, &cur.Visibility)
+	if err != nil {
+		return false, err
+	}
+
+	next := cur
+	change(&next)
+	if next == cur {
+		return false, nil
+	}
+	versionChanged := next.versioned() != cur.versioned()
+
+	var last int
+	if versionChanged {
+		if err := tx.QueryRow(ctx,
+			"SELECT coalesce(max(revision_number), 0) FROM sample_revisions WHERE sample_id = $1", id).
+			Scan(&last); err != nil {
+			return false, err
+		}
+		if last == 0 {
+			_, err := tx.Exec(ctx,
+				`INSERT INTO sample_revisions (sample_id, revision_number, sample_name, sample_description,
+                     sample_keywords, sample_owner, sample_prep, changed_at)
+                 SELECT sample_id, 1, sample_name, coalesce(sample_description, ''), coalesce(sample_keywords, ''),
+                     coalesce(sample_owner, ''), coalesce(sample_prep, ''), coalesce(created_at, CURRENT_TIMESTAMP)
+                 FROM samples WHERE sample_id = $1`, id)
+			if err != nil {
+				return false, err
+			}
+			last = 1
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE samples SET sample_name = $1, sample_description = $2, sample_keywords = $3,
+             sample_owner = $4, sample_prep = $5, owner_id = nullif($6, 0), visibility = $7
+         WHERE sample_id = $8`,
+		next.Name, next.Description, next.Keywords, next.Owner, next.Prep, next.OwnerID, next.Visibility, id); err != nil {
+		return false, err
+	}
+
+	if versionChanged {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO sample_revisions (sample_id, revision_number, sample_name, sample_description,
+                 sample_keywords, sample_owner, sample_prep, changed_by, changed_by_name, restored_from)
+             VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, nullif($10, 0))`,
+			id, last+1, next.Name, next.Description, next.Keywords, next.Owner, next.Prep,
+			v.UserID, v.Username, restoredFrom); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// getSampleRevisions returns the history of a sample, newest first, with
+// each revision compared to the one before it.
+func getSampleRevisions(ctx context.Context, sampleID string) ([]SampleRevision, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT revision_number, sample_name, sample_description, sample_keywords, sample_owner, sample_prep,
+                changed_by_name, changed_at, coalesce(restored_from, 0)
+         FROM sample_revisions
+         WHERE sample_id = $1
+         ORDER BY revision_number`, sampleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []SampleRevision
+	for rows.Next() {
+		var rev SampleRevision
+		err := rows.Scan(&rev.Number, &rev.Name, &rev.Description, &rev.Keywords, &rev.Owner, &rev.Prep,
+			&rev.ChangedBy, &rev.ChangedAt, &rev.RestoredFrom)
+		if err != nil {
+			return nil, err
+		}
+		rev.ChangedAt = rev.ChangedAt.In(loc)
+		if n := len(revisions); n > 0 {
+			compareRevisions(&rev, revisions[n-1].SampleFields)
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+		revisions[i], revisions[j] = revisions[j], revisions[i]
+	}
+	if len(revisions) > 0 {
+		revisions[0].Current = true
+	}
+	return revisions, nil
+}
+
+// compareRevisions fills in what changed in rev since prev.
+func compareRevisions(rev *SampleRevision, prev SampleFields) {
+	fields := []struct {
+		label     string
+		old, next string
+	}{
+		{"Name", prev.Name, rev.Name},
+		{"Description", prev.Description, rev.Description},
+		{"Keywords", prev.Keywords, rev.Keywords},
+		{"Owner", prev.Owner, rev.Owner},
+	}
+	var changed []string
+	for _, f := range fields {
+		if f.old != f.next {
+			rev.Changes = append(rev.Changes, FieldChange{Label: f.label, Old: f.old, New: f.next})
+			changed = append(changed, strings.ToLower(f.label))
+		}
+	}
+	if prev.Prep != rev.Prep {
+		rev.PrepChanged = true
+		rev.PrepDiff = linediff.Hunks(linediff.Diff(prev.Prep, rev.Prep), prepDiffContext)
+		changed = append(changed, "preparation notes")
+	}
+
+	switch n := len(changed); n {
+	case 0:
+		rev.Summary = "no changes"
+	case 1:
+		rev.Summary = "changed " + changed[0]
+	default:
+		rev.Summary = "changed " + strings.Join(changed[:n-1], ", ") + " and " + changed[n-1]
+	}
+}
+
+// handleSampleHistory serves the history section of a sample at
+// /samples/history/{id} and restores a revision on POST to
+// /samples/history/{id}/restore.
+func handleSampleHistory(w http.ResponseWriter, r *http.Request) {
+	session := auth.MustSessionFromContext(r.Context())
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/history/"), "/"), "/")
+	sampleID := parts[0]
+	if _, err := strconv.Atoi(sampleID); err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+
+	viewer := sampleViewerFor(r)
+	if err := checkSampleVisible(r.Context(), viewer, sampleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		renderSampleHistorySection(w, r, session, sampleID)
+	case len(parts) == 2 && parts[1] == "restore":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		restoreSampleRevision(w, r, viewer, sampleID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func restoreSampleRevision(w http.ResponseWriter, r *http.Request, viewer sampleViewer, sampleID string) {
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
+	number, err := strconv.Atoi(r.FormValue("revision"))
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	var fields SampleFields
+	err = dbPool.QueryRow(r.Context(),
+		`SELECT sample_name, sample_description, sample_keywords, sample_owner, sample_prep
+         FROM sample_revisions WHERE sample_id = $1 AND revision_number = $2`, sampleID, number).
+		Scan(&fields.Name, &fields.Description, &fields.Keywords, &fields.Owner, &fields.Prep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("history: unable to load revision %d of sample %s: %v", number, sampleID, err)
+		http.Error(w, "Error loading revision", http.StatusInternalServerError)
+		return
+	}
+
+	// Ownership and visibility are not part of the history and stay as
+	// they are.
+	changed, err := updateSample(r.Context(), sampleID, viewer, number, func(f *SampleFields) {
+		fields.Owner, fields.OwnerID, fields.Visibility = f.Owner, f.OwnerID, f.Visibility
+		*f = fields
+	})
+	if err != nil {
+		log.Printf("history: unable to restore revision %d of sample %s: %v", number, sampleID, err)
+		http.Error(w, "Error restoring revision", http.StatusInternalServerError)
+		return
+	}
+	if changed {
+		log.Printf("history: %s restored revision %d of sample %s", viewer.Username, number, sampleID)
+	}
+
+	// The restore touches every part of the page, so reload all of it.
+	http.Redirect(w, r, "/samples/"+sampleID+"#sample-history", http.StatusSeeOther)
+}
+
+func renderSampleHistorySection(w http.ResponseWriter, r *http.Request, session auth.Session, sampleID string) {
+	baseData, err := getBasePageData(session)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Error loading user information", http.StatusInternalServerError)
+		return
+	}
+	history, err := getSampleRevisions(r.Context(), sampleID)
+	if err != nil {
+		log.Printf("history: unable to load history of sample %s: %v", sampleID, err)
+		http.Error(w, "Error loading history", http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := strconv.Atoi(sampleID)
+	data := SampleDetailPageData{
+		BasePageData: baseData,
+		Sample:       Sample{ID: id},
+		History:      history,
+		IsPartial:    true,
+	}
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_history", data); err != nil {
+		http.Error(w, "Error rendering history", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/sampleimport"
+)
+
+// sampleImportMaxBytes caps the size of an import file; importPreviewRows is
+// the number of rows shown in the preview besides those with errors.
+const (
+	sampleImportMaxBytes = 5 << 20
+	importPreviewRows    = 100
+)
+
+type SampleImportPageData struct {
+	BasePageData
+	Error      string
+	Success    string
+	Format     string // "csv", "json" or empty to detect
+	Data       string // the file content, carried from the preview to the import
+	Columns    []string
+	Mapping    sampleimport.Mapping
+	Fields     []sampleimport.Field
+	Records    []sampleimport.Record // rows shown in the preview
+	RowLabel   string                // "Line" for CSV, "Item" for JSON
+	RowCount   int
+	ErrorCount int
+	Hidden     int // valid rows left out of the preview
+}
+
+// handleSampleImport imports many samples at once from a CSV or JSON file.
+// A posted file is first shown as a preview with its column mapping and the
+// problems of each row; the import itself only runs when every row is valid
+// and inserts all of them in one transaction.
+func handleSampleImport(w http.ResponseWriter, r *http.Request) {
+	session := auth.MustSessionFromContext(r.Context())
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Error loading user information", http.StatusInternalServerError)
+		return
+	}
+
+	data := SampleImportPageData{
+		BasePageData: baseData,
+		Fields:       sampleimport.Fields,
+		Success:      r.URL.Query().Get("success"),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderSampleImport(w, r, data)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data.Success = ""
+	// Both steps post multipart forms: the preview carries the file back in
+	// a field, and URL-encoded it could outgrow the 10 MB form limit.
+	if err := r.ParseMultipartForm(sampleImportMaxBytes); err != nil {
+		log.Printf("import: unable to parse the form of %s: %v", session.Username, err)
+		data.Error = "Unable to read the upload form."
+		w.WriteHeader(http.StatusBadRequest)
+		renderSampleImport(w, r, data)
+		return
+	}
+	data.Format = r.FormValue("format")
+	raw, problem := readImportData(r)
+	if problem != "" {
+		data.Error = problem
+		renderSampleImport(w, r, data)
+		return
+	}
+
+	table, err := sampleimport.Parse(raw, data.Format)
+	if err != nil {
+		data.Error = "Unable to read the file: " + err.Error()
+		renderSampleImport(w, r, data)
+		return
+	}
+	data.Data = string(raw)
+	data.Format = table.Format
+	data.Columns = table.Columns
+	data.RowLabel = "Line"
+	if table.Format == sampleimport.FormatJSON {
+		data.RowLabel = "Item"
+	}
+
+	// The mapping comes from the preview form once it has been shown, and
+	// is guessed from the headers for a new file.
+	data.Mapping = sampleimport.GuessMapping(table.Columns)
+	if r.FormValue("mapped") == "1" {
+		for i := range data.Mapping {
+			data.Mapping[i] = r.FormValue("map_" + strconv.Itoa(i))
+		}
+	}
+
+	var records []sampleimport.Record
+	if err := data.Mapping.Check(); err != nil {
+		data.Error = "Column mapping: " + err.Error() + "."
+	} else {
+		records = sampleimport.Records(table, data.Mapping, loc)
+		// Like a sample added by hand, a row without owner belongs to the
+		// user importing it.
+		for i := range records {
+			if records[i].Owner == "" {
+				records[i].Owner = session.Username
+			}
+		}
+		data.RowCount = len(records)
+		data.ErrorCount = sampleimport.ErrorCount(records)
+		for i, rec := range records {
+			if i < importPreviewRows || len(rec.Errors) > 0 {
+				data.Records = append(data.Records, rec)
+			}
+		}
+		data.Hidden = len(records) - len(data.Records)
+	}
+
+	if r.FormValue("action") != "import" {
+		renderSampleImport(w, r, data)
+		return
+	}
+	if data.Error != "" || data.ErrorCount > 0 {
+		if data.Error == "" {
+			data.Error = "Fix the problems below before importing. Nothing was imported."
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderSampleImport(w, r, data)
+		return
+	}
+
+	if err := insertImportedSamples(r.Context(), records); err != nil {
+		log.Printf("import: unable to import %d samples for %s: %v", len(records), session.Username, err)
+		data.Error = "The import failed and nothing was imported. Try again."
+		w.WriteHeader(http.StatusInternalServerError)
+		renderSampleImport(w, r, data)
+		return
+	}
+	log.Printf("import: %s imported %d samples", session.Username, len(records))
+
+	msg := fmt.Sprintf("Imported %d samples", len(records))
+	http.Redirect(w, r, "/samples/import?success="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// readImportData returns the uploaded file or, on the preview form, the
+// content carried over from the previous step. The form must already be
+// parsed. On failure it returns a message for the user.
+func readImportData(r *http.Request) ([]byte, string) {
+	tooLarge := fmt.Sprintf("The file is larger than %d MB.", sampleImportMaxBytes>>20)
+
+	var raw []byte
+	file, header, err := r.FormFile("file")
+	switch {
+	case err == nil:
+		defer file.Close()
+		if header.Size > sampleImportMaxBytes {
+			return nil, tooLarge
+		}
+		raw, err = io.ReadAll(io.LimitReader(file, sampleImportMaxBytes+1))
+		if err != nil {
+			return nil, "Unable to read the uploaded file."
+		}
+	case errors.Is(err, http.ErrMissingFile):
+		raw = []byte(r.FormValue("data"))
+	default:
+		return nil, "Unable to read the upload form."
+	}
+
+	switch {
+	case len(raw) == 0:
+		return nil, "Choose a CSV or JSON file to import."
+	case len(raw) > sampleImportMaxBytes:
+		return nil, tooLarge
+	case !utf8.Valid(raw):
+		return nil, "The file must be UTF-8 encoded."
+	}
+	return raw, ""
+}
+
+// insertImportedSamples adds records in a single transaction, so either all
+// of them are imported or none. Owners are linked like lookupSampleOwner
+// links them, to an approved, active account with the same username; case
+// is ignored, but an exact match is preferred.
+func insertImportedSamples(ctx context.Context, records []sampleimport.Record) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, rec := range records {
+		_, err := tx.Exec(ctx,
+			`WITH owner AS (
+                 SELECT user_id, username FROM users
+                 WHERE lower(username) = lower($5) AND is_approved AND NOT coalesce(deleted, false)
+                 ORDER BY username = $5 DESC, user_id
+                 LIMIT 1
+             )
+             INSERT INTO samples (sample_name, sample_description, sample_keywords, sample_prep, sample_owner, owner_id, created_at)
+             SELECT $1, $2, $3, $4, coalesce(o.username, $5), o.user_id, coalesce($6::timestamp, LOCALTIMESTAMP)
+             FROM (SELECT 1) AS one
+             LEFT JOIN owner o ON true`,
+			rec.Name, rec.Description, rec.Keywords, rec.Prep, rec.Owner, rec.Created)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", rec.Line, err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func renderSampleImport(w http.ResponseWriter, r *http.Request, data SampleImportPageData) {
+	tmpl, err := parseTemplates(r, "templates/sample_import.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		log.Printf("import: unable to render page: %v", err)
+	}
+}
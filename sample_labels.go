@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/labels"
+)
+
+// maxLabelSamples caps the number of samples labelled in one request.
+const maxLabelSamples = 500
+
+// sampleCodePrefix starts the code printed on sample labels.
+const sampleCodePrefix = "SDB-"
+
+// sampleLabelCode returns the stable code of a sample, such as SDB-000042.
+func sampleLabelCode(id int) string {
+	return fmt.Sprintf("%s%06d", sampleCodePrefix, id)
+}
+
+// sampleLabelURL returns the address of the page of a sample.
+func sampleLabelURL(id int) string {
+	return appConfig.BaseURL + "/samples/" + strconv.Itoa(id)
+}
+
+// parseSampleCode returns the sample a scanned label refers to: its code,
+// in any case, or the address of its page. Only the exact forms printed on
+// labels are accepted, so that a search being typed is not taken for one.
+func parseSampleCode(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	var digits string
+	switch {
+	case len(s) > len(sampleCodePrefix) && strings.EqualFold(s[:len(sampleCodePrefix)], sampleCodePrefix):
+		digits = s[len(sampleCodePrefix):]
+	case appConfig.BaseURL != "" && strings.HasPrefix(s, appConfig.BaseURL+"/samples/"):
+		digits = strings.TrimPrefix(s, appConfig.BaseURL+"/samples/")
+	default:
+		return 0, false
+	}
+	id, err := strconv.Atoi(digits)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	if strings.ToUpper(s) != sampleLabelCode(id) && s != sampleLabelURL(id) {
+		return 0, false
+	}
+	return id, true
+}
+
+// LabelSample is a sample to print a label for.
+type LabelSample struct {
+	ID        int
+	Name      string
+	Owner     string
+	CreatedAt time.Time
+}
+
+// Code returns the code printed on the label of s.
+func (s LabelSample) Code() string {
+	return sampleLabelCode(s.ID)
+}
+
+// labelOptions are the choices of the labels page, read from its query.
+type labelOptions struct {
+	Symbology labels.Symbology
+	Content   string // "url" to encode the address of the sample, "code" for its code
+	Layout    labels.Layout
+	Start     int // first position used on the first sheet, from 1
+	Roll      labels.Roll
+	DPI       int
+}
+
+func parseLabelOptions(q url.Values) labelOptions {
+	opts := labelOptions{
+		Symbology: labels.Symbology(q.Get("symbology")),
+		Content:   q.Get("content"),
+		Start:     1,
+		DPI:       labels.Resolutions[0],
+	}
+	if !opts.Symbology.Valid() {
+		opts.Symbology = labels.Symbologies[0].Symbology
+	}
+	if opts.Content != "url" && opts.Content != "code" {
+		// QR codes open the sample page; barcodes carry the shorter code.
+		opts.Content = "url"
+		if opts.Symbology == labels.Code128 {
+			opts.Content = "code"
+		}
+	}
+	var ok bool
+	if opts.Layout, ok = labels.FindLayout(q.Get("layout")); !ok {
+		opts.Layout = labels.Layouts[0]
+	}
+	if start, err := strconv.Atoi(q.Get("start")); err == nil && start >= 1 && start <= opts.Layout.PerSheet() {
+		opts.Start = start
+	}
+	if opts.Roll, ok = labels.FindRoll(q.Get("roll")); !ok {
+		opts.Roll = labels.Rolls[0]
+	}
+	if dpi, err := strconv.Atoi(q.Get("dpi")); err == nil {
+		for _, r := range labels.Resolutions {
+			if dpi == r {
+				opts.DPI = dpi
+			}
+		}
+	}
+	return opts
+}
+
+// data returns what the code on the label of sample id encodes.
+func (o labelOptions) data(id int) string {
+	if o.Content == "code" {
+		return sampleLabelCode(id)
+	}
+	return sampleLabelURL(id)
+}
+
+// label returns the content of the label of s.
+func (o labelOptions) label(s LabelSample) labels.Label {
+	details := s.CreatedAt.Format("2006-01-02")
+	if s.Owner != "" {
+		details = s.Owner + " · " + details
+	}
+	return labels.Label{
+		Data:  o.data(s.ID),
+		Title: s.Name,
+		Lines: []string{s.Code(), details},
+	}
+}
+
+// SampleLabelsPageData is the data of the labels page.
+type SampleLabelsPageData struct {
+	BasePageData
+	Samples     []LabelSample
+	Options     labelOptions
+	Symbologies []labels.SymbologyInfo
+	Layouts     []labels.Layout
+	Rolls       []labels.Roll
+	Resolutions []int
+	Error       string
+}
+
+// Positions lists the positions of the selected layout, for the choice of
+// the first free label on a partly used sheet.
+func (d SampleLabelsPageData) Positions() []int {
+	positions := make([]int, d.Options.Layout.PerSheet())
+	for i := range positions {
+		positions[i] = i + 1
+	}
+	return positions
+}
+
+// labelSampleIDs reads the id parameters of a query, in order and without
+// repeats.
+func labelSampleIDs(q url.Values) ([]int, error) {
+	seen := map[int]bool{}
+	var ids []int
+	for _, value := range q["id"] {
+		id, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid sample ID %q", value)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxLabelSamples {
+		return nil, fmt.Errorf("at most %d samples can be labelled at once", maxLabelSamples)
+	}
+	return ids, nil
+}
+
+// getLabelSamples loads the samples v may see among ids, in the order of
+// ids. Missing and hidden samples are left out.
+func getLabelSamples(ctx context.Context, v sampleViewer, ids []int) ([]LabelSample, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	clause, args := v.liveClause([]interface{}{ids})
+	rows, err := dbPool.Query(ctx,
+		`SELECT sample_id, sample_name, coalesce(sample_owner, ''), created_at
+         FROM samples WHERE sample_id = ANY($1) AND `+clause+`
+         ORDER BY array_position($1::int[], sample_id)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []LabelSample
+	for rows.Next() {
+		var s LabelSample
+		if err := rows.Scan(&s.ID, &s.Name, &s.Owner, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// handleSampleLabels serves /samples/labels?id=…, which previews the labels
+// of the given samples and downloads them as a PDF sheet with format=pdf or
+// as ZPL with format=zpl, and /samples/labels/{id}.png or .svg, the code of
+// a single sample as an image.
+func handleSampleLabels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/labels"), "/"); rest != "" {
+		serveSampleLabelImage(w, r, rest)
+		return
+	}
+
+	q := r.URL.Query()
+	opts := parseLabelOptions(q)
+	ids, err := labelSampleIDs(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session := auth.MustSessionFromContext(r.Context())
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Error loading user information", http.StatusInternalServerError)
+		return
+	}
+	samples, err := getLabelSamples(r.Context(), viewerFromBase(baseData), ids)
+	if err != nil {
+		log.Printf("labels: unable to load samples %v: %v", ids, err)
+		http.Error(w, "Error loading samples", http.StatusInternalServerError)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" || len(samples) == 0 {
+		data := SampleLabelsPageData{
+			BasePageData: baseData,
+			Samples:      samples,
+			Options:      opts,
+			Symbologies:  labels.Symbologies,
+			Layouts:      labels.Layouts,
+			Rolls:        labels.Rolls,
+			Resolutions:  labels.Resolutions,
+		}
+		if len(samples) == 0 {
+			data.Error = "Select the samples to label on the sample list or a sample page."
+		}
+		tmpl, err := parseTemplates(r, "templates/sample_labels.html")
+		if err != nil {
+			http.Error(w, "Error loading template", http.StatusInternalServerError)
+			return
+		}
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			log.Printf("labels: unable to render page: %v", err)
+		}
+		return
+	}
+
+	list := make([]labels.Label, len(samples))
+	for i, s := range samples {
+		list[i] = opts.label(s)
+	}
+	var buf bytes.Buffer
+	var contentType, filename string
+	switch format {
+	case "pdf":
+		err = labels.PDF(&buf, opts.Layout, opts.Symbology, list, opts.Start-1)
+		contentType, filename = "application/pdf", "sample-labels.pdf"
+	case "zpl":
+		err = labels.ZPL(&buf, opts.Roll, opts.DPI, opts.Symbology, list)
+		contentType, filename = "text/plain; charset=utf-8", "sample-labels.zpl"
+	default:
+		http.Error(w, "Unknown label format", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("labels: unable to render %s labels: %v", format, err)
+		http.Error(w, "Unable to encode the labels: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("labels: %s printed %d %s labels", session.Username, len(list), format)
+	w.Header().Set("Content-Type", contentType)
+	setDownloadHeaders(w, filename)
+	w.Write(buf.Bytes())
+}
+
+// serveSampleLabelImage writes the code of one sample as a PNG or SVG image
+// for name, such as 42.png.
+func serveSampleLabelImage(w http.ResponseWriter, r *http.Request, name string) {
+	sampleID, ext, _ := strings.Cut(name, ".")
+	id, err := strconv.Atoi(sampleID)
+	if err != nil || (ext != "png" && ext != "svg") {
+		http.NotFound(w, r)
+		return
+	}
+	if err := checkSampleVisible(r.Context(), sampleViewerFor(r), sampleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+
+	opts := parseLabelOptions(r.URL.Query())
+	symbol, err := labels.Encode(opts.Symbology, opts.data(id))
+	if err != nil {
+		http.Error(w, "Unable to encode the label: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var image []byte
+	if ext == "png" {
+		image, err = labels.PNG(symbol, 8)
+		if err != nil {
+			log.Printf("labels: unable to render sample %d as PNG: %v", id, err)
+			http.Error(w, "Error rendering label", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		image = labels.SVG(symbol, 4)
+		w.Header().Set("Content-Type", "image/svg+xml")
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(image)
+}
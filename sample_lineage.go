@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/lineage"
+	"sampleDB/internal/rbac"
+)
+
+// errLineageCycle is returned for a link that would make a sample its own
+// ancestor.
+var errLineageCycle = errors.New("the link would make a sample its own ancestor")
+
+// errAmbiguousSample is returned when a name matches several samples.
+var errAmbiguousSample = errors.New("more than one sample has this name")
+
+// lineageLockKey names the advisory lock that serializes new lineage links.
+const lineageLockKey = 0x5344424c // "SDBL"
+
+// LineageSample is a sample in the lineage of another one. Samples the user
+// may not see, including those in the trash, are shown without their name.
+type LineageSample struct {
+	lineage.Step
+	Name    string
+	Status  string
+	Visible bool
+}
+
+// StatusLabel returns the display name of the status of s.
+func (s LineageSample) StatusLabel() string {
+	return Sample{Status: s.Status}.StatusLabel()
+}
+
+// SampleLineage holds the samples a sample was made from and the samples
+// made from it, nearest first.
+type SampleLineage struct {
+	Ancestors   []LineageSample
+	Descendants []LineageSample
+}
+
+// getSampleLineage walks the ancestors and descendants of a sample,
+// following at most lineage.MaxDepth links in each direction.
+func getSampleLineage(ctx context.Context, v sampleViewer, sampleID int) (*SampleLineage, error) {
+	rows, err := dbPool.Query(ctx,
+		`WITH RECURSIVE
+             up (parent_id, child_id, relation, path) AS (
+                 SELECT parent_id, child_id, relation, ARRAY[child_id, parent_id]
+                 FROM sample_relations WHERE child_id = $1
+                 UNION ALL
+                 SELECT r.parent_id, r.child_id, r.relation, up.path || r.parent_id
+                 FROM sample_relations r JOIN up ON r.child_id = up.parent_id
+                 WHERE cardinality(up.path) <= $2 AND r.parent_id <> ALL (up.path)
+             ),
+             down (parent_id, child_id, relation, path) AS (
+                 SELECT parent_id, child_id, relation, ARRAY[parent_id, child_id]
+                 FROM sample_relations WHERE parent_id = $1
+                 UNION ALL
+                 SELECT r.parent_id, r.child_id, r.relation, down.path || r.child_id
+                 FROM sample_relations r JOIN down ON r.parent_id = down.child_id
+                 WHERE cardinality(down.path) <= $2 AND r.child_id <> ALL (down.path)
+             )
+         SELECT parent_id, child_id, relation FROM up
+         UNION
+         SELECT parent_id, child_id, relation FROM down`, sampleID, lineage.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []lineage.Link
+	for rows.Next() {
+		var l lineage.Link
+		var kind string
+		if err := rows.Scan(&l.Parent, &l.Child, &kind); err != nil {
+			return nil, err
+		}
+		l.Kind = lineage.Kind(kind)
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &SampleLineage{}
+	if len(links) == 0 {
+		return result, nil
+	}
+	up := lineage.Ancestors(links, sampleID)
+	down := lineage.Descendants(links, sampleID)
+
+	ids := make([]int, 0, len(up)+len(down))
+	for _, s := range append(append([]lineage.Step{}, up...), down...) {
+		ids = append(ids, s.ID)
+	}
+	clause, args := v.visibleClause([]interface{}{ids})
+	sampleRows, err := dbPool.Query(ctx,
+		`SELECT sample_id, sample_name, status, deleted_at IS NULL AND `+clause+`
+         FROM samples WHERE sample_id = ANY($1)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer sampleRows.Close()
+
+	samples := map[int]LineageSample{}
+	for sampleRows.Next() {
+		var s LineageSample
+		if err := sampleRows.Scan(&s.ID, &s.Name, &s.Status, &s.Visible); err != nil {
+			return nil, err
+		}
+		samples[s.ID] = s
+	}
+	if err := sampleRows.Err(); err != nil {
+		return nil, err
+	}
+
+	describe := func(steps []lineage.Step) []LineageSample {
+		list := make([]LineageSample, 0, len(steps))
+		for _, step := range steps {
+			s := LineageSample{Step: step}
+			if found := samples[step.ID]; found.Visible {
+				s.Name, s.Status, s.Visible = found.Name, found.Status, true
+			}
+			list = append(list, s)
+		}
+		return list
+	}
+	result.Ancestors = describe(up)
+	result.Descendants = describe(down)
+	return result, nil
+}
+
+// linkSamples records that child was made from parent, replacing the kind
+// of an existing link between the two. A link that would close a loop is
+// refused with errLineageCycle. Links are made one at a time, holding an
+// advisory lock until tx ends, since two links saved together could each
+// pass the check and still close a loop between them.
+func linkSamples(ctx context.Context, tx pgx.Tx, parentID, childID int, kind lineage.Kind, userID int) error {
+	if parentID == childID {
+		return errLineageCycle
+	}
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", lineageLockKey); err != nil {
+		return err
+	}
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO sample_relations (parent_id, child_id, relation, created_by)
+         SELECT $1::int, $2::int, $3::text, nullif($4::int, 0)
+         WHERE NOT EXISTS (
+             WITH RECURSIVE up (sample_id) AS (
+                 SELECT $1::int
+                 UNION
+                 SELECT r.parent_id FROM sample_relations r JOIN up ON r.child_id = up.sample_id
+             )
+             SELECT 1 FROM up WHERE sample_id = $2)
+         ON CONFLICT (parent_id, child_id) DO UPDATE SET relation = EXCLUDED.relation`,
+		parentID, childID, string(kind), userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errLineageCycle
+	}
+	return nil
+}
+
+// derivedSampleForm prefills the new-sample form for a sample made from
+// parent. Its fields and location are copied, and its name gets the number
+// of the new child as a suffix, such as GaAs/01-3 for the third one.
+func derivedSampleForm(ctx context.Context, parent Sample) Sample {
+	var children int
+	if err := dbPool.QueryRow(ctx,
+		"SELECT count(*) FROM sample_relations WHERE parent_id = $1", parent.ID).Scan(&children); err != nil {
+		log.Printf("lineage: unable to count the children of sample %d: %v", parent.ID, err)
+	}
+	return Sample{
+		Name:        fmt.Sprintf("%s-%d", parent.Name, children+1),
+		Description: parent.Description,
+		Keywords:    parent.Keywords,
+		Sample_prep: parent.Sample_prep,
+		Visibility:  parent.Visibility,
+		Status:      StatusActive,
+		LocationID:  parent.LocationID,
+	}
+}
+
+// findSampleRef resolves a sample typed into a form, by its ID or by its
+// exact name when only one sample v may see has that name.
+func findSampleRef(ctx context.Context, v sampleViewer, ref string) (int, error) {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "#")
+	if id, err := strconv.Atoi(ref); err == nil {
+		return id, checkSampleVisible(ctx, v, ref)
+	}
+
+	clause, args := v.liveClause([]interface{}{ref})
+	rows, err := dbPool.Query(ctx,
+		`SELECT sample_id FROM samples WHERE lower(sample_name) = lower($1) AND `+clause+`
+         ORDER BY sample_id LIMIT 2`, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	switch len(ids) {
+	case 0:
+		return 0, pgx.ErrNoRows
+	case 1:
+		return ids[0], nil
+	default:
+		return 0, errAmbiguousSample
+	}
+}
+
+// handleSampleLineage links a sample to the sample it was made from on POST
+// to /samples/lineage/{id} and removes a direct link on POST to
+// /samples/lineage/{id}/remove.
+func handleSampleLineage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
+	session := auth.MustSessionFromContext(r.Context())
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/lineage/"), "/"), "/")
+	sampleID := parts[0]
+	id, err := strconv.Atoi(sampleID)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "remove") {
+		http.NotFound(w, r)
+		return
+	}
+	viewer := sampleViewerFor(r)
+	if err := checkSampleVisible(r.Context(), viewer, sampleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+
+	fail := func(msg string) {
+		if isHTMXRequest(r) {
+			renderSampleLineageSection(w, r, session, sampleID, "", msg)
+			return
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+	}
+	done := func(flash string) {
+		if isHTMXRequest(r) {
+			renderSampleLineageSection(w, r, session, sampleID, flash, "")
+			return
+		}
+		http.Redirect(w, r, "/samples/"+sampleID, http.StatusSeeOther)
+	}
+
+	if len(parts) == 2 {
+		parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
+		childID, _ := strconv.Atoi(r.FormValue("child_id"))
+		if parentID != id && childID != id {
+			fail("Only links of this sample can be removed here.")
+			return
+		}
+		if _, err := dbPool.Exec(r.Context(),
+			"DELETE FROM sample_relations WHERE parent_id = $1 AND child_id = $2", parentID, childID); err != nil {
+			log.Printf("lineage: unable to unlink samples %d and %d: %v", parentID, childID, err)
+			fail("Unable to remove the link. Try again.")
+			return
+		}
+		log.Printf("lineage: %s unlinked sample %d from %d", session.Username, childID, parentID)
+		done("Link removed")
+		return
+	}
+
+	kind := lineage.Kind(r.FormValue("relation"))
+	if !kind.Valid() {
+		fail("Choose how the sample was made.")
+		return
+	}
+	parentID, err := findSampleRef(r.Context(), viewer, r.FormValue("parent"))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		fail("No sample matches " + strconv.Quote(strings.TrimSpace(r.FormValue("parent"))) + ".")
+		return
+	case errors.Is(err, errAmbiguousSample):
+		fail("More than one sample has this name. Enter its ID instead.")
+		return
+	case err != nil:
+		log.Printf("lineage: unable to look up %q: %v", r.FormValue("parent"), err)
+		fail("Unable to save the link. Try again.")
+		return
+	}
+
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		log.Printf("lineage: unable to start transaction: %v", err)
+		fail("Unable to save the link. Try again.")
+		return
+	}
+	defer tx.Rollback(r.Context())
+	err = linkSamples(r.Context(), tx, parentID, id, kind, session.UserID)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if errors.Is(err, errLineageCycle) {
+		fail("A sample cannot be made from itself or from a sample made from it.")
+		return
+	}
+	if err != nil {
+		log.Printf("lineage: unable to link sample %d to %d: %v", id, parentID, err)
+		fail("Unable to save the link. Try again.")
+		return
+	}
+	log.Printf("lineage: %s linked sample %d to %d (%s)", session.Username, id, parentID, kind)
+	done("Link saved")
+}
+
+func renderSampleLineageSection(w http.ResponseWriter, r *http.Request, session auth.Session, sampleID, flash, errMsg string) {
+	data, err := loadSampleDetailData(r.Context(), session, sampleID)
+	if err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+	data.Lineage, err = getSampleLineage(r.Context(), viewerFromBase(data.BasePageData), data.Sample.ID)
+	if err != nil {
+		log.Printf("lineage: unable to load the lineage of sample %s: %v", sampleID, err)
+	}
+	data.Flash = flash
+	data.Error = errMsg
+	data.IsPartial = true
+
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_lineage", data); err != nil {
+		http.Error(w, "Error rendering lineage", http.StatusInternalServerError)
+	}
+}
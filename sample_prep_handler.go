@@ -1,11 +1,11 @@
 package main
 
 import (
-	"context"
 	"net/http"
 	"strings"
 
 	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
 )
 
 func samplePrepHandler(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +25,11 @@ func samplePrepHandler(w http.ResponseWriter, r *http.Request) {
 		action = parts[1]
 	}
 
+	if (r.Method == http.MethodPost || action == "edit") && !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		renderSamplePrepSection(w, r, session, sampleID, "", "", action == "edit")
@@ -37,14 +42,15 @@ func samplePrepHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		prep := r.FormValue("sample_prep")
-		if _, err := dbPool.Exec(context.Background(),
-			"UPDATE samples SET sample_prep = $1 WHERE sample_id = $2",
-			prep, sampleID); err != nil {
+		if _, err := updateSample(r.Context(), sampleID, sampleViewerFor(r), 0, func(f *SampleFields) {
+			f.Prep = prep
+		}); err != nil {
 			renderSamplePrepSection(w, r, session, sampleID, "", "Failed to update sample preparation.", true)
 			return
 		}
 
 		if isHTMXRequest(r) {
+			w.Header().Set("HX-Trigger", historyChangedEvent)
 			renderSamplePrepSection(w, r, session, sampleID, "Preparation updated", "", false)
 			return
 		}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
+	"sampleDB/internal/storage"
+)
+
+// Lifecycle statuses of a sample.
+const (
+	StatusActive    = "active"
+	StatusInUse     = "in-use"
+	StatusConsumed  = "consumed"
+	StatusShipped   = "shipped"
+	StatusDiscarded = "discarded"
+)
+
+// StatusOption is a choice in the status select.
+type StatusOption struct {
+	Value string
+	Label string
+}
+
+var sampleStatuses = []StatusOption{
+	{StatusActive, "Active"},
+	{StatusInUse, "In use"},
+	{StatusConsumed, "Consumed"},
+	{StatusShipped, "Shipped"},
+	{StatusDiscarded, "Discarded"},
+}
+
+func validStatus(status string) bool {
+	for _, opt := range sampleStatuses {
+		if opt.Value == status {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusLabel returns the display name of the status of s.
+func (s Sample) StatusLabel() string {
+	for _, opt := range sampleStatuses {
+		if opt.Value == s.Status {
+			return opt.Label
+		}
+	}
+	return s.Status
+}
+
+// getStorageTree loads every storage location, ordered depth-first.
+func getStorageTree(ctx context.Context) ([]storage.Entry, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT location_id, coalesce(parent_id, 0), kind, name, box_rows, box_columns
+         FROM storage_locations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []storage.Location
+	for rows.Next() {
+		var l storage.Location
+		if err := rows.Scan(&l.ID, &l.ParentID, &l.Kind, &l.Name, &l.Rows, &l.Columns); err != nil {
+			return nil, err
+		}
+		locations = append(locations, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return storage.Flatten(locations), nil
+}
+
+// findLocation returns the entry of location id in tree.
+func findLocation(tree []storage.Entry, id int) (storage.Entry, bool) {
+	for _, e := range tree {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return storage.Entry{}, false
+}
+
+// setLocationPaths fills in the location path of samples from tree.
+func setLocationPaths(samples []Sample, tree []storage.Entry) {
+	for i := range samples {
+		if e, ok := findLocation(tree, samples[i].LocationID); ok {
+			samples[i].LocationPath = e.Path
+		}
+	}
+}
+
+// checkSampleStorage validates where a sample is to be stored and returns
+// the canonical name of its box position. A position is only kept for
+// boxes and must be free, apart from the sample itself (sampleID, zero for
+// a new sample). The returned message explains a rejected location; it
+// names the sample holding the position only if v may see it.
+func checkSampleStorage(ctx context.Context, v sampleViewer, tree []storage.Entry, sampleID, locationID int, position string) (string, string, error) {
+	position = strings.TrimSpace(position)
+	if locationID == 0 {
+		if position != "" {
+			return "", "Choose the box the position is in.", nil
+		}
+		return "", "", nil
+	}
+	loc, ok := findLocation(tree, locationID)
+	if !ok {
+		return "", "The storage location no longer exists.", nil
+	}
+	if position == "" {
+		return "", "", nil
+	}
+	if loc.Kind != storage.Box {
+		return "", "Positions can only be given inside a box.", nil
+	}
+	position, err := storage.NormalizePosition(position, loc.Rows, loc.Columns)
+	if err != nil {
+		return "", fmt.Sprintf("%s has positions A1 to %s.", loc.Name, storage.PositionName(loc.Rows-1, loc.Columns-1)), nil
+	}
+
+	var (
+		holder  string
+		visible bool
+	)
+	clause, args := v.visibleClause([]interface{}{locationID, position, sampleID})
+	err = dbPool.QueryRow(ctx,
+		`SELECT sample_name, `+clause+` FROM samples
+         WHERE location_id = $1 AND box_position = $2 AND deleted_at IS NULL AND sample_id <> $3`,
+		args...).Scan(&holder, &visible)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return position, "", nil
+	case err != nil:
+		return "", "", err
+	case !visible:
+		return "", fmt.Sprintf("Position %s is already taken.", position), nil
+	default:
+		return "", fmt.Sprintf("Position %s is already taken by %s.", position, holder), nil
+	}
+}
+
+// handleSampleStorage changes the status and storage location of a sample
+// on POST to /samples/storage/{id}. These are not kept in the history.
+func handleSampleStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !can(r, rbac.SamplesEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
+	session := auth.MustSessionFromContext(r.Context())
+	sampleID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/storage/"), "/")
+	id, err := strconv.Atoi(sampleID)
+	if err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+	viewer := sampleViewerFor(r)
+	if err := checkSampleVisible(r.Context(), viewer, sampleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+
+	fail := func(msg string) {
+		if isHTMXRequest(r) {
+			renderSampleStorageSection(w, r, session, sampleID, "", msg)
+			return
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+	}
+
+	status := r.FormValue("status")
+	if !validStatus(status) {
+		fail("Choose the status of the sample.")
+		return
+	}
+	locationID := 0
+	if v := r.FormValue("location_id"); v != "" {
+		if locationID, err = strconv.Atoi(v); err != nil {
+			fail("Choose a storage location.")
+			return
+		}
+	}
+
+	tree, err := getStorageTree(r.Context())
+	if err != nil {
+		log.Printf("storage: unable to load locations: %v", err)
+		fail("Unable to save the storage location. Try again.")
+		return
+	}
+	position, problem, err := checkSampleStorage(r.Context(), viewer, tree, id, locationID, r.FormValue("position"))
+	if err != nil {
+		log.Printf("storage: unable to check the position of sample %s: %v", sampleID, err)
+		fail("Unable to save the storage location. Try again.")
+		return
+	}
+	if problem != "" {
+		fail(problem)
+		return
+	}
+
+	clause, args := viewer.liveClause([]interface{}{sampleID, status, locationID, position})
+	_, err = dbPool.Exec(r.Context(),
+		`UPDATE samples SET status = $2, location_id = nullif($3, 0), box_position = nullif($4, '')
+         WHERE sample_id = $1 AND `+clause, args...)
+	if err != nil {
+		log.Printf("storage: unable to save storage of sample %s: %v", sampleID, err)
+		fail("Unable to save the storage location. The position may have just been taken.")
+		return
+	}
+
+	if isHTMXRequest(r) {
+		renderSampleStorageSection(w, r, session, sampleID, "Storage saved", "")
+		return
+	}
+	http.Redirect(w, r, "/samples/"+sampleID, http.StatusSeeOther)
+}
+
+func renderSampleStorageSection(w http.ResponseWriter, r *http.Request, session auth.Session, sampleID, flash, errMsg string) {
+	data, err := loadSampleDetailData(r.Context(), session, sampleID)
+	if err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+	data.Flash = flash
+	data.Error = errMsg
+	data.IsPartial = true
+
+	if err := renderTemplateSection(w, r, "templates/sample_detail.html", "sample_storage", data); err != nil {
+		http.Error(w, "Error rendering storage", http.StatusInternalServerError)
+	}
+}
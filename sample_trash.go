@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sampleDB/internal/audit"
+	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
+)
+
+// TrashedSample is a sample in the trash.
+type TrashedSample struct {
+	ID          int
+	Name        string
+	Owner       string
+	OwnerID     int
+	CreatedAt   time.Time
+	DeletedAt   time.Time
+	DeletedBy   string
+	Attachments int
+}
+
+// SampleTrashPageData is the data of the trash page.
+type SampleTrashPageData struct {
+	BasePageData
+	Samples []TrashedSample
+	Success string
+	Error   string
+}
+
+// trashSample moves a sample v may see to the trash. Its attachments stay
+// where they are until the sample is purged.
+func trashSample(ctx context.Context, v sampleViewer, sampleID string) error {
+	clause, args := v.liveClause([]interface{}{sampleID, v.UserID, v.Username})
+	tag, err := dbPool.Exec(ctx,
+		`UPDATE samples SET deleted_at = CURRENT_TIMESTAMP, deleted_by = nullif($2, 0), deleted_by_name = $3
+         WHERE sample_id = $1 AND `+clause, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// restoreTrashedSample takes a sample v may see out of the trash. Its box
+// position is dropped if another sample has taken it in the meantime.
+func restoreTrashedSample(ctx context.Context, v sampleViewer, sampleID string) error {
+	clause, args := v.visibleClause([]interface{}{sampleID})
+	tag, err := dbPool.Exec(ctx,
+		`UPDATE samples s SET deleted_at = NULL, deleted_by = NULL, deleted_by_name = '',
+                box_position = CASE WHEN EXISTS (
+                    SELECT 1 FROM samples other
+                    WHERE other.location_id = s.location_id AND other.box_position = s.box_position
+                      AND other.deleted_at IS NULL) THEN NULL ELSE s.box_position END
+         WHERE sample_id = $1 AND deleted_at IS NOT NULL AND `+clause, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// getTrashedSamples lists the samples in the trash that v may see, most
+// recently deleted first.
+func getTrashedSamples(ctx context.Context, v sampleViewer) ([]TrashedSample, error) {
+	clause, args := v.visibleClause(nil)
+	rows, err := dbPool.Query(ctx,
+		`SELECT s.sample_id, s.sample_name, coalesce(s.sample_owner, ''), coalesce(s.owner_id, 0),
+                coalesce(s.created_at, timestamp '1970-01-01'), s.deleted_at, s.deleted_by_name,
+                (SELECT count(*) FROM attachments a WHERE a.source_type = 'sample' AND a.source_id = s.sample_id)
+         FROM samples s
+         WHERE s.deleted_at IS NOT NULL AND `+clause+`
+         ORDER BY s.deleted_at DESC, s.sample_id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []TrashedSample
+	for rows.Next() {
+		var s TrashedSample
+		if err := rows.Scan(&s.ID, &s.Name, &s.Owner, &s.OwnerID, &s.CreatedAt, &s.DeletedAt, &s.DeletedBy, &s.Attachments); err != nil {
+			return nil, err
+		}
+		s.DeletedAt = s.DeletedAt.In(loc)
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// trashedSampleOwner returns the name and owner account of a sample in the
+// trash that v may see.
+func trashedSampleOwner(ctx context.Context, v sampleViewer, sampleID string) (string, int, error) {
+	clause, args := v.visibleClause([]interface{}{sampleID})
+	var name string
+	var ownerID int
+	err := dbPool.QueryRow(ctx,
+		`SELECT sample_name, coalesce(owner_id, 0) FROM samples
+         WHERE sample_id = $1 AND deleted_at IS NOT NULL AND `+clause, args...).Scan(&name, &ownerID)
+	return name, ownerID, err
+}
+
+// purgeSample permanently removes a sample in the trash with its history and
+// attachments. The attachment files are removed once the rows are gone; a
+// file that cannot be removed is logged and left behind. It returns the
+// number of files removed.
+func purgeSample(ctx context.Context, sampleID string) (int, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx,
+		"SELECT sample_id FROM samples WHERE sample_id = $1 AND deleted_at IS NOT NULL FOR UPDATE", sampleID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx,
+		`DELETE FROM attachments WHERE source_type = 'sample' AND source_id = $1
+         RETURNING attachment_address`, id)
+	if err != nil {
+		return 0, err
+	}
+	var files []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		files = append(files, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM samples WHERE sample_id = $1", id); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range files {
+		err := os.Remove(resolveAppPath(path))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("trash: unable to remove attachment file %s of sample %d: %v", path, id, err)
+			continue
+		}
+		if err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// deleteSampleHandler moves a sample to the trash on POST to
+// /samples/delete/{id}.
+func deleteSampleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sampleID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/delete/"), "/")
+	if _, err := strconv.Atoi(sampleID); err != nil {
+		http.Error(w, "Sample not found", http.StatusNotFound)
+		return
+	}
+
+	viewer := sampleViewerFor(r)
+	sample, err := getSampleByID(r.Context(), sampleID, viewer)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+	if !can(r, rbac.SamplesDelete, rbac.OwnedBy(sample.OwnerID)) {
+		forbidden(w, r)
+		return
+	}
+
+	if err := trashSample(r.Context(), viewer, sampleID); err != nil {
+		log.Printf("trash: unable to delete sample %s: %v", sampleID, err)
+		http.Error(w, "Error deleting sample", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("trash: %s moved sample %s to the trash", viewer.Username, sampleID)
+
+	http.Redirect(w, r, "/samples/trash?success="+url.QueryEscape(sample.Name+" moved to the trash"), http.StatusSeeOther)
+}
+
+// handleSampleTrash lists the trash at /samples/trash and restores or purges
+// a sample on POST to /samples/trash/{id}/restore or /samples/trash/{id}/purge.
+func handleSampleTrash(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/samples/trash"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		renderSampleTrash(w, r, r.URL.Query().Get("success"), "")
+		return
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	sampleID := parts[0]
+	if _, err := strconv.Atoi(sampleID); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	viewer := sampleViewerFor(r)
+	name, ownerID, err := trashedSampleOwner(r.Context(), viewer, sampleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Sample not found in the trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error loading sample", http.StatusInternalServerError)
+		return
+	}
+
+	switch parts[1] {
+	case "restore":
+		if !can(r, rbac.SamplesDelete, rbac.OwnedBy(ownerID)) {
+			forbidden(w, r)
+			return
+		}
+		if err := restoreTrashedSample(r.Context(), viewer, sampleID); err != nil {
+			log.Printf("trash: unable to restore sample %s: %v", sampleID, err)
+			renderSampleTrash(w, r, "", "Unable to restore "+name+". Try again.")
+			return
+		}
+		log.Printf("trash: %s restored sample %s", viewer.Username, sampleID)
+		http.Redirect(w, r, "/samples/"+sampleID, http.StatusSeeOther)
+	case "purge":
+		if !can(r, rbac.SamplesPurge, rbac.Resource{}) {
+			forbidden(w, r)
+			return
+		}
+		removed, err := purgeSample(r.Context(), sampleID)
+		if err != nil {
+			log.Printf("trash: unable to purge sample %s: %v", sampleID, err)
+			renderSampleTrash(w, r, "", "Unable to remove "+name+". Try again.")
+			return
+		}
+		recordAudit(r, audit.Entry{
+			Action:     audit.ActionSamplePurge,
+			TargetType: audit.TargetSample,
+			TargetID:   sampleID,
+			TargetName: name,
+			After:      audit.State(map[string]int{"files_removed": removed}),
+		})
+		http.Redirect(w, r, "/samples/trash?success="+url.QueryEscape(name+" permanently removed"), http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func renderSampleTrash(w http.ResponseWriter, r *http.Request, success, errMsg string) {
+	session := auth.MustSessionFromContext(r.Context())
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Redirect(w, r, "/logout", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Error loading user information", http.StatusInternalServerError)
+		return
+	}
+
+	samples, err := getTrashedSamples(r.Context(), viewerFromBase(baseData))
+	if err != nil {
+		log.Printf("trash: unable to list deleted samples: %v", err)
+		http.Error(w, "Error loading the trash", http.StatusInternalServerError)
+		return
+	}
+
+	data := SampleTrashPageData{
+		BasePageData: baseData,
+		Samples:      samples,
+		Success:      success,
+		Error:        errMsg,
+	}
+	tmpl, err := parseTemplates(r, "templates/sample_trash.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		log.Printf("trash: unable to render page: %v", err)
+	}
+}
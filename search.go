@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"unicode"
+
+	"sampleDB/internal/samplequery"
+)
+
+// snippetRadius is the number of characters of context kept on each side of
+// the first match when preparation notes are shortened to a snippet.
+const snippetRadius = 80
+
+// SampleHighlight holds the fields of a search result with the matching words
+// wrapped in <mark>. Prep is a short excerpt of the preparation notes, empty
+// when the notes did not match.
+type SampleHighlight struct {
+	Name        template.HTML
+	Owner       template.HTML
+	Description template.HTML
+	Keywords    template.HTML
+	Prep        template.HTML
+}
+
+// Page sizes offered on the sample list.
+var samplePageSizes = []int{25, 50, 100, 200}
+
+const defaultSamplePageSize = 50
+
+// errInvalidCursor is returned for a page cursor that was not produced by
+// the same sort order.
+var errInvalidCursor = errors.New("invalid page cursor")
+
+// sampleSortKeys maps the sort fields of the sample list to their key
+// expression and the type the cursor value is cast back to. Keys are never
+// NULL so that row comparisons work for the keyset.
+var sampleSortKeys = map[string][2]string{
+	"name":    {"sample_name", "text"},
+	"owner":   {"coalesce(sample_owner, '')", "text"},
+	"created": {"coalesce(created_at, timestamp '1970-01-01')", "timestamp"},
+}
+
+// SampleSearch selects a page of the sample list.
+type SampleSearch struct {
+	Query    string // search expression, see package samplequery
+	DateFrom string
+	DateTo   string
+	Sort     string // name, owner, created or relevance; "-" prefix for descending
+	Limit    int
+	After    string // cursor of the previous page
+	Count    bool   // also count all matches
+	OwnerID  int    // only samples owned by this account, if set
+	Status   string // only samples with this status, if set
+	// LocationID, if set, selects the samples stored in this location or
+	// anywhere inside it.
+	LocationID int
+	Viewer     sampleViewer
+}
+
+// SamplePage is one page of the sample list. Next is the cursor of the
+// following page, empty on the last page.
+type SamplePage struct {
+	Samples []Sample
+	Total   int
+	Next    string
+	Sort    string // the sort order that was applied
+}
+
+// sampleCursor marks the last row of a page: its sort key, as text, and ID.
+type sampleCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int    `json:"id"`
+}
+
+func (c sampleCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSampleCursor(s string) (sampleCursor, error) {
+	var c sampleCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, &c) != nil {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
+
+// sampleSortOrder resolves the requested sort order. Relevance is the
+// default while the query has free text to rank by, and falls back to name
+// otherwise.
+func sampleSortOrder(sort string, ranked bool) (field string, desc bool) {
+	desc = strings.HasPrefix(sort, "-")
+	field = strings.TrimPrefix(sort, "-")
+	if field == "relevance" || field == "" {
+		if ranked {
+			return "relevance", true
+		}
+		return "name", false
+	}
+	if _, ok := sampleSortKeys[field]; !ok {
+		return "name", false
+	}
+	return field, desc
+}
+
+// searchSamples returns a page of the samples matching a search expression
+// (see package samplequery). Free-text words are matched as prefixes against
+// names, keywords, owners, descriptions and preparation notes, so results
+// appear while the user is still typing, and are ranked by relevance with
+// name matches first. Only samples opts.Viewer may see and that are not in
+// the trash are included. Pages are read with a keyset on the sort key and
+// sample ID, so later pages cost no more than the first. A malformed
+// expression returns a *samplequery.SyntaxError.
+func searchSamples(ctx context.Context, opts SampleSearch) (SamplePage, error) {
+	var page SamplePage
+	parsed, err := samplequery.Parse(opts.Query)
+	if err != nil {
+		return page, err
+	}
+
+	visible, args := opts.Viewer.liveClause(nil)
+	whereClauses := []string{visible}
+	if opts.OwnerID != 0 {
+		args = append(args, opts.OwnerID)
+		whereClauses = append(whereClauses, fmt.Sprintf("owner_id = $%d", len(args)))
+	}
+	if opts.Status != "" {
+		args = append(args, opts.Status)
+		whereClauses = append(whereClauses, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if opts.LocationID != 0 {
+		args = append(args, opts.LocationID)
+		whereClauses = append(whereClauses, fmt.Sprintf(`location_id IN (
+            WITH RECURSIVE inside (location_id) AS (
+                SELECT $%d::int
+                UNION
+                SELECT l.location_id FROM storage_locations l JOIN inside ON l.parent_id = inside.location_id
+            )
+            SELECT location_id FROM inside)`, len(args)))
+	}
+
+	// Add date filtering
+	if opts.DateFrom != "" {
+		args = append(args, opts.DateFrom)
+		whereClauses = append(whereClauses, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if opts.DateTo != "" {
+		// Include the entire end date
+		args = append(args, opts.DateTo+" 23:59:59")
+		whereClauses = append(whereClauses, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+
+	compiled := parsed.Compile(args)
+	args = compiled.Args
+	whereClauses = append(whereClauses, compiled.Where)
+
+	if opts.Count {
+		err := dbPool.QueryRow(ctx,
+			"SELECT count(*) FROM samples WHERE "+strings.Join(whereClauses, " AND "),
+			args...).Scan(&page.Total)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	field, desc := sampleSortOrder(opts.Sort, compiled.Rank != "")
+	page.Sort = field
+	if desc {
+		page.Sort = "-" + field
+	}
+	sortKey, keyType := compiled.Rank, "real"
+	if field != "relevance" {
+		sortKey, keyType = sampleSortKeys[field][0], sampleSortKeys[field][1]
+	}
+	dir, cmp := "ASC", ">"
+	if desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if opts.After != "" {
+		cursor, err := decodeSampleCursor(opts.After)
+		if err != nil {
+			return page, err
+		}
+		if cursor.Sort != page.Sort {
+			return page, errInvalidCursor
+		}
+		args = append(args, cursor.Key, cursor.ID)
+		whereClauses = append(whereClauses, fmt.Sprintf("(%s, sample_id) %s ($%d::%s, $%d)",
+			sortKey, cmp, len(args)-1, keyType, len(args)))
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSamplePageSize
+	}
+	// One extra row tells whether there is a next page.
+	args = append(args, limit+1)
+	queryText := fmt.Sprintf(`
+        SELECT sample_id, sample_name, sample_description, sample_keywords, sample_owner, coalesce(sample_prep, ''), created_at,
+               status, coalesce(location_id, 0), coalesce(box_position, ''), (%s)::text
+        FROM samples
+        WHERE %s
+        ORDER BY %s %s, sample_id %s
+        LIMIT $%d`, sortKey, strings.Join(whereClauses, " AND "), sortKey, dir, dir, len(args))
+
+	rows, err := dbPool.Query(ctx, queryText, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	terms := searchTerms(strings.Join(parsed.TextTerms(), " "))
+	var key, lastKey string
+	for rows.Next() {
+		var sample Sample
+		err := rows.Scan(&sample.ID, &sample.Name, &sample.Description, &sample.Keywords, &sample.Owner, &sample.Sample_prep, &sample.CreatedAt,
+			&sample.Status, &sample.LocationID, &sample.Position, &key)
+		if err != nil {
+			return page, err
+		}
+		if len(page.Samples) == limit {
+			last := page.Samples[limit-1]
+			page.Next = sampleCursor{Sort: page.Sort, Key: lastKey, ID: last.ID}.encode()
+			break
+		}
+		sample.Highlight = highlightSample(sample, terms)
+		page.Samples = append(page.Samples, sample)
+		lastKey = key
+	}
+
+	return page, rows.Err()
+}
+
+// highlightSample marks the query terms in the fields shown on a sample card.
+func highlightSample(s Sample, terms []string) *SampleHighlight {
+	if len(terms) == 0 {
+		return nil
+	}
+	return &SampleHighlight{
+		Name:        highlightTerms(s.Name, terms),
+		Owner:       highlightTerms(s.Owner, terms),
+		Description: highlightTerms(s.Description, terms),
+		Keywords:    highlightTerms(s.Keywords, terms),
+		Prep:        matchSnippet(s.Sample_prep, terms, snippetRadius),
+	}
+}
+
+// searchTerms splits a query into the lower-case words used for
+// highlighting.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// matchesTerm reports whether word starts with one of terms, ignoring case.
+func matchesTerm(word string, terms []string) bool {
+	word = strings.ToLower(word)
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightTerms escapes text and wraps every word that starts with one of
+// terms in <mark>.
+func highlightTerms(text string, terms []string) template.HTML {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i
+		word := isWordRune(runes[i])
+		for j < len(runes) && isWordRune(runes[j]) == word {
+			j++
+		}
+		chunk := template.HTMLEscapeString(string(runes[i:j]))
+		if word && matchesTerm(string(runes[i:j]), terms) {
+			b.WriteString("<mark>" + chunk + "</mark>")
+		} else {
+			b.WriteString(chunk)
+		}
+		i = j
+	}
+	return template.HTML(b.String())
+}
+
+// matchSnippet returns an excerpt of text around the first word matching
+// terms, with about radius characters of context on each side and the
+// matches highlighted. It returns an empty string when nothing matches.
+func matchSnippet(text string, terms []string, radius int) template.HTML {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+
+	match, matchEnd := -1, -1
+	for i := 0; i < len(runes); i++ {
+		if !isWordRune(runes[i]) || (i > 0 && isWordRune(runes[i-1])) {
+			continue
+		}
+		j := i
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+		if matchesTerm(string(runes[i:j]), terms) {
+			match, matchEnd = i, j
+			break
+		}
+		i = j
+	}
+	if match < 0 {
+		return ""
+	}
+
+	start, end := match-radius, matchEnd+radius
+	if start <= 0 {
+		start = 0
+	} else {
+		// Do not start in the middle of a word.
+		for start < match && runes[start-1] != ' ' {
+			start++
+		}
+	}
+	if end >= len(runes) {
+		end = len(runes)
+	} else {
+		for end > matchEnd && runes[end] != ' ' {
+			end--
+		}
+	}
+
+	snippet := highlightTerms(string(runes[start:end]), terms)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/jackc/pgx/v5"
 
 	"sampleDB/internal/auth"
+	"sampleDB/internal/rbac"
 )
 
 type Article struct {
@@ -109,22 +110,20 @@ func listArticlesHandler(w http.ResponseWriter, r *http.Request) {
 		articles = append(articles, article)
 	}
 
+	baseData, err := getBasePageData(session)
+	if err != nil {
+		log.Printf("wiki: unable to load permissions for %s: %v", session.Username, err)
+	}
+
 	data := struct {
 		BasePageData
 		Articles []Article
 	}{
-		BasePageData: BasePageData{Username: session.Username, IsAdmin: false},
+		BasePageData: baseData,
 		Articles:     articles,
 	}
-	row := dbPool.QueryRow(context.Background(), `SELECT admin
-	FROM users
-	WHERE username = $1`, session.Username)
 
-	if err := row.Scan(&data.BasePageData.IsAdmin); err != nil {
-		log.Printf("wiki: unable to load admin flag for %s: %v", session.Username, err)
-	}
-
-	tmpl, err := parseTemplates("templates/wiki_list.html")
+	tmpl, err := parseTemplates(r, "templates/wiki_list.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
@@ -195,16 +194,13 @@ func viewArticleHandler(w http.ResponseWriter, r *http.Request, title string) {
 		return
 	}
 
-	isAdmin := false
-	if err := dbPool.QueryRow(r.Context(),
-		"SELECT admin FROM users WHERE user_id = $1 AND COALESCE(deleted, false) = false",
-		session.UserID).Scan(&isAdmin); err != nil {
+	baseData, err := getBasePageData(session)
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			http.Redirect(w, r, "/logout", http.StatusSeeOther)
 			return
 		}
-		log.Printf("wiki: unable to load admin status for user %d: %v", session.UserID, err)
-		isAdmin = false
+		log.Printf("wiki: unable to load permissions for user %d: %v", session.UserID, err)
 	}
 
 	data := struct {
@@ -214,12 +210,12 @@ func viewArticleHandler(w http.ResponseWriter, r *http.Request, title string) {
 		Flash          string
 		Error          string
 	}{
-		BasePageData:   BasePageData{Username: session.Username, UserID: session.UserID, IsAdmin: isAdmin},
+		BasePageData:   baseData,
 		Article:        article,
 		EditingContent: false,
 	}
 
-	tmpl, err := parseTemplates("templates/wiki_view.html")
+	tmpl, err := parseTemplates(r, "templates/wiki_view.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
@@ -238,13 +234,9 @@ func renderArticleContentPanel(w http.ResponseWriter, r *http.Request, session a
 		return
 	}
 
-	isAdmin := false
-	if err := dbPool.QueryRow(r.Context(),
-		"SELECT admin FROM users WHERE user_id = $1 AND COALESCE(deleted, false) = false",
-		session.UserID).Scan(&isAdmin); err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			log.Printf("wiki: unable to load admin status for user %d: %v", session.UserID, err)
-		}
+	baseData, err := getBasePageData(session)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("wiki: unable to load permissions for user %d: %v", session.UserID, err)
 	}
 
 	data := struct {
@@ -255,7 +247,7 @@ func renderArticleContentPanel(w http.ResponseWriter, r *http.Request, session a
 		Flash          string
 		Error          string
 	}{
-		BasePageData:   BasePageData{Username: session.Username, UserID: session.UserID, IsAdmin: isAdmin},
+		BasePageData:   baseData,
 		Article:        article,
 		EditingContent: editing,
 		IsPartial:      true,
@@ -263,7 +255,7 @@ func renderArticleContentPanel(w http.ResponseWriter, r *http.Request, session a
 		Error:          errMsg,
 	}
 
-	if err := renderTemplateSection(w, "templates/wiki_view.html", "article_content_panel", data); err != nil {
+	if err := renderTemplateSection(w, r, "templates/wiki_view.html", "article_content_panel", data); err != nil {
 		http.Error(w, "Error rendering article content", http.StatusInternalServerError)
 	}
 }
@@ -271,15 +263,26 @@ func renderArticleContentPanel(w http.ResponseWriter, r *http.Request, session a
 func newArticleHandler(w http.ResponseWriter, r *http.Request) {
 	session := auth.MustSessionFromContext(r.Context())
 
+	if !can(r, rbac.WikiEdit, rbac.Resource{}) {
+		forbidden(w, r)
+		return
+	}
+
 	if r.Method == http.MethodGet {
+		baseData, err := getBasePageData(session)
+		if err != nil {
+			http.Error(w, "Error loading user information", http.StatusInternalServerError)
+			return
+		}
+
 		data := struct {
 			BasePageData
 			Article *Article // nil for new article
 		}{
-			BasePageData: BasePageData{Username: session.Username},
+			BasePageData: baseData,
 		}
 
-		tmpl, err := parseTemplates("templates/wiki_edit.html")
+		tmpl, err := parseTemplates(r, "templates/wiki_edit.html")
 		if err != nil {
 			http.Error(w, "Error loading template", http.StatusInternalServerError)
 			return
@@ -315,6 +318,16 @@ func editArticleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	title := pathParts[0]
 
+	ownerID, err := articleOwner(title)
+	if err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	if !can(r, rbac.WikiEdit, rbac.OwnedBy(ownerID)) {
+		forbidden(w, r)
+		return
+	}
+
 	if r.Method == http.MethodGet {
 		// Check if this is an HTMX request for inline editing
 		if isHTMXRequest(r) {
@@ -334,15 +347,21 @@ func editArticleHandler(w http.ResponseWriter, r *http.Request) {
 
 		article.Content = ArticleContent{Raw: rawContent}
 
+		baseData, err := getBasePageData(session)
+		if err != nil {
+			http.Error(w, "Error loading user information", http.StatusInternalServerError)
+			return
+		}
+
 		data := struct {
 			BasePageData
 			Article *Article
 		}{
-			BasePageData: BasePageData{Username: session.Username},
+			BasePageData: baseData,
 			Article:      &article,
 		}
 
-		tmpl, err := parseTemplates("templates/wiki_edit.html")
+		tmpl, err := parseTemplates(r, "templates/wiki_edit.html")
 		if err != nil {
 			http.Error(w, "Error loading template", http.StatusInternalServerError)
 			return
@@ -363,7 +382,7 @@ func editArticleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	content := r.FormValue("content")
-	_, err := dbPool.Exec(context.Background(),
+	_, err = dbPool.Exec(context.Background(),
 		`UPDATE articles SET content = $1, last_modified_at = NOW(), 
          last_modified_by = $2 WHERE title = $3`,
 		content, session.UserID, title)
@@ -397,7 +416,17 @@ func deleteArticleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	title := parts[3]
 
-	_, err := dbPool.Exec(context.Background(),
+	ownerID, err := articleOwner(title)
+	if err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	if !can(r, rbac.WikiDelete, rbac.OwnedBy(ownerID)) {
+		forbidden(w, r)
+		return
+	}
+
+	_, err = dbPool.Exec(context.Background(),
 		"DELETE FROM articles WHERE title = $1", title)
 	if err != nil {
 		http.Error(w, "Error deleting article", http.StatusInternalServerError)
@@ -407,6 +436,15 @@ func deleteArticleHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/wiki", http.StatusSeeOther)
 }
 
+// articleOwner returns the user who created the article, or 0 if unknown.
+func articleOwner(title string) (int, error) {
+	var ownerID int
+	err := dbPool.QueryRow(context.Background(),
+		"SELECT COALESCE(created_by, 0) FROM articles WHERE title = $1",
+		title).Scan(&ownerID)
+	return ownerID, err
+}
+
 func uploadArticleAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -421,6 +459,18 @@ func uploadArticleAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	articleID := parts[3]
 
+	var ownerID int
+	if err := dbPool.QueryRow(context.Background(),
+		"SELECT COALESCE(created_by, 0) FROM articles WHERE article_id = $1",
+		articleID).Scan(&ownerID); err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	if !can(r, rbac.WikiEdit, rbac.OwnedBy(ownerID)) {
+		forbidden(w, r)
+		return
+	}
+
 	err := r.ParseMultipartForm(10 << 20) // 10 MB max
 	if err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
@@ -466,6 +516,21 @@ func handleAttachmentWiki(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case r.Method == http.MethodPost && strings.HasSuffix(trimmedPath, "/delete"):
+		var ownerID int
+		if err := dbPool.QueryRow(context.Background(),
+			`SELECT COALESCE(a.created_by, 0)
+             FROM article_attachments aa
+             JOIN articles a ON aa.article_id = a.article_id
+             WHERE aa.attachment_id = $1`,
+			attachmentID).Scan(&ownerID); err != nil {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		if !can(r, rbac.WikiEdit, rbac.OwnedBy(ownerID)) {
+			forbidden(w, r)
+			return
+		}
+
 		articleTitle, err := deleteAttachmentWiki(attachmentID)
 		if err != nil {
 			log.Printf("wiki: failed to delete attachment %s: %v", attachmentID, err)